@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// runBench writes synthetic points from concurrent workers, straight to the server or
+// through a relay's HTTP API, then reports the throughput, the latency of the batch
+// writes and how many failed
+func runBench(ctx context.Context, args []string, stdout io.Writer) error {
+	fs, conn := newFlagSet("bench", "")
+	keys := fs.Int("keys", 10, "number of keys written to")
+	prefix := fs.String("prefix", "bench.", "prefix of the keys written to")
+	points := fs.Int("points", 100000, "points to write, unless -duration is set")
+	duration := fs.Duration("duration", 0, "write for that long instead of a number of points")
+	rate := fs.Int("rate", 0, "points written per second across the workers (0 for as fast as possible)")
+	values := fs.String("values", "uniform", "distribution of the values: uniform (0 to 100), normal (mean 50, deviation 10), walk (a random walk per key) or sine (a wave per key with a period of a minute)")
+	workers := fs.Int("workers", 4, "concurrent writers, each with its own connection")
+	batchSize := fs.Int("batch", 100, "points per batch write")
+	ack := fs.Bool("sync", false, "wait for the server to acknowledge every batch")
+	relayURL := fs.String("relay", "", "base URL of a relay's HTTP API to POST the batches to, instead of writing to -addr")
+	token := fs.String("token", "", "token to authenticate with the relay")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *keys < 1 || *points < 1 || *workers < 1 || *batchSize < 1 {
+		return badUsage("-keys, -points, -workers and -batch must be at least 1")
+	}
+	if *rate < 0 {
+		return badUsage("-rate must not be negative")
+	}
+	value, err := newValueGenerator(*values, *keys)
+	if err != nil {
+		return err
+	}
+
+	var write func(ctx context.Context, batch []gtsdb.DataPoint) error
+	target := conn.addr
+	if *relayURL != "" {
+		if *ack {
+			return badUsage("-sync only applies to writes to the server; the relay answers once points are queued")
+		}
+		rt := &relayTarget{
+			url:    strings.TrimSuffix(*relayURL, "/") + "/write?format=line",
+			token:  *token,
+			client: &http.Client{Timeout: conn.timeout, Transport: &http.Transport{MaxIdleConnsPerHost: *workers}},
+		}
+		write, target = rt.write, *relayURL
+	} else {
+		client, err := conn.dial(ctx, gtsdb.WithPoolSize(*workers, *workers))
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		write = client.WriteBatchContext
+		if *ack {
+			write = client.WriteBatchSyncContext
+		}
+	}
+
+	names := make([]string, *keys)
+	for i := range names {
+		names[i] = *prefix + strconv.Itoa(i)
+	}
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+	// remaining hands out the batches when a number of points is written
+	var remaining atomic.Int64
+	remaining.Store(int64(*points))
+	take := func() int {
+		if *duration > 0 {
+			return *batchSize
+		}
+		n := remaining.Add(-int64(*batchSize))
+		return max(0, min(*batchSize, int(n)+*batchSize))
+	}
+	// interval paces each worker's batches to reach -rate together
+	var interval time.Duration
+	if *rate > 0 {
+		interval = time.Duration(float64(time.Second) * float64(*batchSize) * float64(*workers) / float64(*rate))
+	}
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		written   int
+		failed    = make(map[string]int)
+		lastErr   error
+	)
+	var wg sync.WaitGroup
+	started := time.Now()
+	for w := 0; w < *workers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			batch := make([]gtsdb.DataPoint, 0, *batchSize)
+			var local []time.Duration
+			ok := 0
+			bad := make(map[string]int)
+			var werr error
+			// Spread the workers' first batches over an interval
+			next := started.Add(time.Duration(rng.Int63n(int64(interval) + 1)))
+			for ctx.Err() == nil {
+				if interval > 0 {
+					select {
+					case <-time.After(time.Until(next)):
+					case <-ctx.Done():
+					}
+					if ctx.Err() != nil {
+						break
+					}
+					next = next.Add(interval)
+				}
+				n := take()
+				if n == 0 {
+					break
+				}
+				batch = batch[:0]
+				now := time.Now()
+				for i := 0; i < n; i++ {
+					k := rng.Intn(len(names))
+					batch = append(batch, gtsdb.DataPoint{Key: names[k], Timestamp: now, Value: value(rng, k, now)})
+				}
+				err := write(ctx, batch)
+				if ctx.Err() != nil {
+					// The deadline of -duration cut the write short
+					break
+				}
+				local = append(local, time.Since(now))
+				if err != nil {
+					bad[errorClass(err)]++
+					werr = err
+					continue
+				}
+				ok += n
+			}
+			mu.Lock()
+			latencies = append(latencies, local...)
+			written += ok
+			for class, n := range bad {
+				failed[class] += n
+			}
+			if werr != nil {
+				lastErr = werr
+			}
+			mu.Unlock()
+		}(started.UnixNano() + int64(w))
+	}
+	wg.Wait()
+	elapsed := time.Since(started)
+
+	slices.Sort(latencies)
+	percentile := func(p float64) time.Duration {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return latencies[min(len(latencies)-1, int(p*float64(len(latencies))))].Round(time.Microsecond)
+	}
+	errorCount := 0
+	classes := make([]string, 0, len(failed))
+	for class, n := range failed {
+		classes = append(classes, class)
+		errorCount += n
+	}
+	sort.Slice(classes, func(i, j int) bool { return failed[classes[i]] > failed[classes[j]] })
+
+	fmt.Fprintf(stdout, "target      %s\n", target)
+	fmt.Fprintf(stdout, "points      %d written to %d keys\n", written, *keys)
+	fmt.Fprintf(stdout, "elapsed     %s\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(stdout, "throughput  %.0f points/s", float64(written)/elapsed.Seconds())
+	if *rate > 0 {
+		fmt.Fprintf(stdout, " of %d targeted", *rate)
+	}
+	fmt.Fprintln(stdout)
+	fmt.Fprintf(stdout, "latency     p50 %s  p90 %s  p99 %s  max %s (per batch of %d)\n",
+		percentile(0.5), percentile(0.9), percentile(0.99), percentile(1), *batchSize)
+	fmt.Fprintf(stdout, "errors      %d of %d batches", errorCount, len(latencies))
+	if len(latencies) > 0 {
+		fmt.Fprintf(stdout, " (%.2f%%)", 100*float64(errorCount)/float64(len(latencies)))
+	}
+	fmt.Fprintln(stdout)
+	for _, class := range classes {
+		fmt.Fprintf(stdout, "            %d %s\n", failed[class], class)
+	}
+	if lastErr != nil {
+		return fmt.Errorf("%d batch writes failed, last: %w", errorCount, lastErr)
+	}
+	return nil
+}
+
+// newValueGenerator returns the generator of the values of keys in dist. The workers
+// share it, drawing from their own rng; a walk per key is kept across them.
+func newValueGenerator(dist string, keys int) (func(rng *rand.Rand, key int, t time.Time) float64, error) {
+	switch dist {
+	case "uniform":
+		return func(rng *rand.Rand, _ int, _ time.Time) float64 { return rng.Float64() * 100 }, nil
+	case "normal":
+		return func(rng *rand.Rand, _ int, _ time.Time) float64 { return 50 + rng.NormFloat64()*10 }, nil
+	case "walk":
+		var mu sync.Mutex
+		walks := make([]float64, keys)
+		for i := range walks {
+			walks[i] = 50
+		}
+		return func(rng *rand.Rand, key int, _ time.Time) float64 {
+			step := rng.NormFloat64()
+			mu.Lock()
+			defer mu.Unlock()
+			walks[key] += step
+			return walks[key]
+		}, nil
+	case "sine":
+		return func(_ *rand.Rand, key int, t time.Time) float64 {
+			// Each key is a phase apart
+			phase := 2 * math.Pi * float64(key) / float64(keys)
+			return 50 + 50*math.Sin(2*math.Pi*float64(t.UnixNano())/float64(time.Minute)+phase)
+		}, nil
+	}
+	return nil, badUsage("unknown -values distribution %q", dist)
+}
+
+// errorClass groups the errors of the report
+func errorClass(err error) string {
+	var status *relayStatusError
+	var netErr net.Error
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &status):
+		return fmt.Sprintf("HTTP %d %s", status.code, http.StatusText(status.code))
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeouts"
+	case errors.Is(err, gtsdb.ErrServerUnavailable), errors.As(err, &opErr):
+		return "connection failures"
+	}
+	return "other errors"
+}
+
+// relayTarget writes batches to a relay's POST /write in the line format
+type relayTarget struct {
+	url, token string
+	client     *http.Client
+}
+
+// relayStatusError is a relay's refusal of a batch
+type relayStatusError struct {
+	code int
+	msg  string
+}
+
+func (e *relayStatusError) Error() string {
+	return fmt.Sprintf("relay answered %d: %s", e.code, e.msg)
+}
+
+func (t *relayTarget) write(ctx context.Context, batch []gtsdb.DataPoint) error {
+	var body bytes.Buffer
+	for _, p := range batch {
+		fmt.Fprintf(&body, "%s,%d,%s\n", p.Key, p.Timestamp.Unix(), formatValue(p.Value))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	if t.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode != http.StatusAccepted {
+		return &relayStatusError{code: resp.StatusCode, msg: strings.TrimSpace(string(msg))}
+	}
+	return nil
+}
@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// importFlags are the flags of the import command
+type importFlags struct {
+	format                          string
+	keyColumn, timeColumn, valueCol string
+	key, keyPrefix                  string
+	wide                            bool
+	timeUnit                        time.Duration
+	batchSize                       int
+	sync                            bool
+	dryRun                          bool
+	skipInvalid                     bool
+	checkpoint                      string
+	progress                        time.Duration
+}
+
+// timeUnits maps the units of -time-unit to their duration
+var timeUnits = map[string]time.Duration{
+	"s":  time.Second,
+	"ms": time.Millisecond,
+	"us": time.Microsecond,
+	"ns": time.Nanosecond,
+}
+
+// runImport streams the points of CSV or line format files into the server in batches,
+// reporting progress and recording in a checkpoint how far it got, so an interrupted
+// import picks up where it stopped
+func runImport(ctx context.Context, args []string, stdout io.Writer) error {
+	fs, conn := newFlagSet("import", "file...")
+	var f importFlags
+	fs.StringVar(&f.format, "format", "", "format of the files: csv or line (key,timestamp,value or \"key value\"); guessed from the extension when empty")
+	fs.StringVar(&f.keyColumn, "key-column", "key", "CSV column holding the key")
+	fs.StringVar(&f.timeColumn, "time-column", "timestamp", "CSV column holding the timestamp")
+	fs.StringVar(&f.valueCol, "value-column", "value", "CSV column holding the value")
+	fs.StringVar(&f.key, "key", "", "key of every point, for CSV files of a single series without a key column")
+	fs.BoolVar(&f.wide, "wide", false, "read every CSV column but the time column as a series named after its header")
+	fs.StringVar(&f.keyPrefix, "key-prefix", "", "prefix prepended to every key")
+	unit := fs.String("time-unit", "s", "unit of numeric timestamps: s, ms, us or ns; RFC 3339 timestamps are recognized too")
+	fs.IntVar(&f.batchSize, "batch", 5000, "points written per batch")
+	fs.BoolVar(&f.sync, "sync", false, "wait for the server to acknowledge every batch")
+	fs.BoolVar(&f.dryRun, "dry-run", false, "parse and check the files without writing anything")
+	fs.BoolVar(&f.skipInvalid, "skip-invalid", false, "skip invalid lines, reporting them, instead of stopping at the first")
+	fs.StringVar(&f.checkpoint, "checkpoint", "", "file recording the progress, to resume an interrupted import from")
+	fs.DurationVar(&f.progress, "progress", 5*time.Second, "how often to report progress (0 to stay quiet until done)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return badUsage("expected at least one file, or - for stdin")
+	}
+	var ok bool
+	if f.timeUnit, ok = timeUnits[*unit]; !ok {
+		return badUsage("unknown -time-unit %q", *unit)
+	}
+	if f.batchSize < 1 {
+		return badUsage("-batch must be at least 1")
+	}
+	for _, file := range fs.Args() {
+		if file == "-" && f.checkpoint != "" {
+			return badUsage("stdin can't be resumed, so it can't be imported with -checkpoint")
+		}
+		if _, err := f.formatOf(file); err != nil {
+			return err
+		}
+	}
+
+	imp := &importer{flags: f, started: time.Now(), keys: make(map[string]struct{})}
+	if f.checkpoint != "" && !f.dryRun {
+		var err error
+		if imp.checkpoint, err = loadCheckpoint(f.checkpoint); err != nil {
+			return err
+		}
+	}
+	if !f.dryRun {
+		client, err := conn.dial(ctx)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		imp.write = client.WriteBatchContext
+		if f.sync {
+			imp.write = client.WriteBatchSyncContext
+		}
+	}
+
+	for _, file := range fs.Args() {
+		if err := imp.importFile(ctx, file); err != nil {
+			if ctx.Err() != nil && f.checkpoint != "" {
+				fmt.Fprintf(os.Stderr, "interrupted; run the same command again to resume from %s\n", f.checkpoint)
+			}
+			return err
+		}
+	}
+	imp.report(stdout)
+	if imp.skipped > 0 {
+		return fmt.Errorf("skipped %d invalid lines", imp.skipped)
+	}
+	return nil
+}
+
+// formatOf returns the format file is read in
+func (f *importFlags) formatOf(file string) (string, error) {
+	format := f.format
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".csv":
+			format = "csv"
+		case ".txt", ".line", ".lp":
+			format = "line"
+		default:
+			return "", badUsage("can't tell the format of %s from its extension, set -format", file)
+		}
+	}
+	if format != "csv" && format != "line" {
+		return "", badUsage("unknown format %q", format)
+	}
+	return format, nil
+}
+
+// importer writes the points read from the files in batches
+type importer struct {
+	flags importFlags
+	// write is nil in a dry run
+	write      func(ctx context.Context, points []gtsdb.DataPoint) error
+	checkpoint *checkpoint
+
+	batch   []gtsdb.DataPoint
+	started time.Time
+	// reported is when progress was last reported
+	reported time.Time
+	lines    int
+	points   int64
+	skipped  int
+	// keys and the time range are collected in a dry run
+	keys     map[string]struct{}
+	min, max time.Time
+}
+
+// pointSource reads the points of a file, record by record
+type pointSource interface {
+	// next returns the points of the next record, io.EOF at the end, or a *recordError
+	next() ([]gtsdb.DataPoint, error)
+	// position returns the offset just after the last record read, and its line
+	position() (offset int64, line int)
+}
+
+// recordError is an invalid record
+type recordError struct {
+	line int
+	err  error
+}
+
+func (e *recordError) Error() string { return fmt.Sprintf("line %d: %v", e.line, e.err) }
+func (e *recordError) Unwrap() error { return e.err }
+
+// importFile imports file from where the checkpoint says the last import stopped
+func (imp *importer) importFile(ctx context.Context, file string) error {
+	var progress *fileProgress
+	if imp.checkpoint != nil {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		progress = imp.checkpoint.file(abs)
+		if progress.Done {
+			fmt.Fprintf(os.Stderr, "%s: already imported, skipping\n", file)
+			return nil
+		}
+	}
+
+	var in io.Reader = os.Stdin
+	var size int64 = -1
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		size = info.Size()
+		if progress != nil && progress.Offset > size {
+			return fmt.Errorf("%s is smaller than when it was checkpointed; delete %s to start over", file, imp.flags.checkpoint)
+		}
+		in = f
+	}
+
+	var resume int64
+	var line int
+	if progress != nil {
+		resume, line = progress.Offset, progress.Line
+		if resume > 0 {
+			fmt.Fprintf(os.Stderr, "%s: resuming at line %d\n", file, line+1)
+		}
+	}
+	format, _ := imp.flags.formatOf(file)
+	var src pointSource
+	var err error
+	if format == "csv" {
+		src, err = newCSVSource(in, resume, line, &imp.flags)
+	} else {
+		src, err = newLineSource(in, resume, line, imp.flags.keyPrefix)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+
+	// written is how far the points written reach. Saving it after every batch would
+	// slow fast imports down, so it is saved every second, and when the import stops;
+	// at most a second of points is written again after a crash
+	written, writtenLine := resume, line
+	saved := time.Now()
+	save := func(done bool) error {
+		if progress == nil {
+			return nil
+		}
+		progress.Offset, progress.Line, progress.Done = written, writtenLine, done
+		saved = time.Now()
+		return imp.checkpoint.save()
+	}
+	fail := func(err error) error {
+		if serr := save(false); serr != nil {
+			fmt.Fprintf(os.Stderr, "saving the checkpoint: %v\n", serr)
+		}
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	for {
+		points, err := src.next()
+		if err == io.EOF {
+			break
+		}
+		var rerr *recordError
+		if errors.As(err, &rerr) && imp.flags.skipInvalid {
+			fmt.Fprintf(os.Stderr, "%s: skipping %v\n", file, err)
+			imp.skipped++
+			continue
+		}
+		if err != nil {
+			return fail(err)
+		}
+		imp.lines++
+		imp.batch = append(imp.batch, points...)
+		if len(imp.batch) >= imp.flags.batchSize {
+			if err := imp.flush(ctx); err != nil {
+				return fail(err)
+			}
+			written, writtenLine = src.position()
+			if time.Since(saved) >= time.Second {
+				if err := save(false); err != nil {
+					return err
+				}
+			}
+		}
+		imp.reportProgress(file, src, size)
+	}
+	if err := imp.flush(ctx); err != nil {
+		return fail(err)
+	}
+	written, writtenLine = src.position()
+	return save(true)
+}
+
+// flush writes the batch, or takes note of its keys and times in a dry run
+func (imp *importer) flush(ctx context.Context) error {
+	if len(imp.batch) == 0 {
+		return nil
+	}
+	if imp.write == nil {
+		for _, p := range imp.batch {
+			imp.keys[p.Key] = struct{}{}
+			if imp.min.IsZero() || p.Timestamp.Before(imp.min) {
+				imp.min = p.Timestamp
+			}
+			if p.Timestamp.After(imp.max) {
+				imp.max = p.Timestamp
+			}
+		}
+	} else if err := imp.write(ctx, imp.batch); err != nil {
+		return fmt.Errorf("writing batch after %d points: %w", imp.points, err)
+	}
+	imp.points += int64(len(imp.batch))
+	imp.batch = imp.batch[:0]
+	return nil
+}
+
+// reportProgress tells how far the import of file got, every -progress
+func (imp *importer) reportProgress(file string, src pointSource, size int64) {
+	if imp.flags.progress <= 0 || time.Since(imp.reported) < imp.flags.progress {
+		return
+	}
+	if imp.reported.IsZero() {
+		// Don't report right at the start
+		imp.reported = imp.started
+		return
+	}
+	imp.reported = time.Now()
+	offset, _ := src.position()
+	done := ""
+	if size > 0 {
+		done = fmt.Sprintf(" (%.1f%%)", 100*float64(offset)/float64(size))
+	}
+	rate := float64(imp.points) / time.Since(imp.started).Seconds()
+	fmt.Fprintf(os.Stderr, "%s: %d points from %d lines%s, %.0f points/s\n", file, imp.points, imp.lines, done, rate)
+}
+
+// report sums the import up
+func (imp *importer) report(w io.Writer) {
+	elapsed := time.Since(imp.started).Round(time.Millisecond)
+	if imp.write == nil {
+		fmt.Fprintf(w, "dry run: %d points of %d keys from %d lines", imp.points, len(imp.keys), imp.lines)
+		if imp.points > 0 {
+			fmt.Fprintf(w, ", from %s to %s", imp.min.Format(time.RFC3339), imp.max.Format(time.RFC3339))
+		}
+		fmt.Fprintln(w)
+	} else {
+		fmt.Fprintf(w, "imported %d points from %d lines in %s\n", imp.points, imp.lines, elapsed)
+	}
+	if imp.skipped > 0 {
+		fmt.Fprintf(w, "skipped %d invalid lines\n", imp.skipped)
+	}
+}
+
+// lineSource reads files in the line format
+type lineSource struct {
+	r      *bufio.Reader
+	prefix string
+	offset int64
+	line   int
+}
+
+// newLineSource reads in from offset, which is at line
+func newLineSource(in io.Reader, offset int64, line int, prefix string) (*lineSource, error) {
+	if err := seek(in, offset); err != nil {
+		return nil, err
+	}
+	return &lineSource{r: bufio.NewReaderSize(in, 64<<10), prefix: prefix, offset: offset, line: line}, nil
+}
+
+func (s *lineSource) next() ([]gtsdb.DataPoint, error) {
+	for {
+		text, err := s.r.ReadString('\n')
+		if text == "" && err != nil {
+			return nil, err
+		}
+		s.offset += int64(len(text))
+		s.line++
+		if line := strings.TrimSpace(text); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		point, err := parseLine(text, time.Now())
+		if err != nil {
+			return nil, &recordError{line: s.line, err: err}
+		}
+		point.Key = s.prefix + point.Key
+		return []gtsdb.DataPoint{point}, nil
+	}
+}
+
+func (s *lineSource) position() (int64, int) { return s.offset, s.line }
+
+// csvSource reads CSV files with a header naming the columns
+type csvSource struct {
+	r     *csv.Reader
+	flags *importFlags
+	// base is the offset the reader started at, and lines the line before it
+	base  int64
+	lines int
+	// line is the line of the last record read
+	line int
+	// key, time and value are the indexes of the columns, key -1 without one
+	key, time, value int
+	header           []string
+}
+
+// newCSVSource reads the header of in, then the records from offset, which is at line
+func newCSVSource(in io.Reader, offset int64, line int, f *importFlags) (*csvSource, error) {
+	br := bufio.NewReaderSize(in, 64<<10)
+	r := csv.NewReader(br)
+	header, err := r.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, errors.New("empty CSV file, expected a header")
+		}
+		return nil, err
+	}
+	s := &csvSource{flags: f, header: append([]string(nil), header...), key: -1, time: -1, value: -1, line: line}
+	for i, name := range s.header {
+		switch strings.TrimSpace(name) {
+		case f.keyColumn:
+			s.key = i
+		case f.timeColumn:
+			s.time = i
+		case f.valueCol:
+			s.value = i
+		}
+	}
+	switch {
+	case s.time < 0:
+		return nil, fmt.Errorf("no %q column in the header, set -time-column", f.timeColumn)
+	case f.wide:
+	case s.value < 0:
+		return nil, fmt.Errorf("no %q column in the header, set -value-column or -wide", f.valueCol)
+	case s.key < 0 && f.key == "":
+		return nil, fmt.Errorf("no %q column in the header, set -key-column or -key", f.keyColumn)
+	}
+
+	if offset > 0 {
+		// The header was read from the start; continue from the checkpoint
+		if err := seek(in, offset); err != nil {
+			return nil, err
+		}
+		br.Reset(in)
+		r = csv.NewReader(br)
+		s.base, s.lines = offset, line
+	}
+	r.FieldsPerRecord = len(s.header)
+	r.ReuseRecord = true
+	r.Comment = '#'
+	s.r = r
+	return s, nil
+}
+
+func (s *csvSource) next() ([]gtsdb.DataPoint, error) {
+	record, err := s.r.Read()
+	if err == io.EOF {
+		return nil, err
+	}
+	if err == nil {
+		line, _ := s.r.FieldPos(0)
+		s.line = s.lines + line
+	} else {
+		s.line++
+	}
+	line := s.line
+	if err != nil {
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			return nil, &recordError{line: line, err: perr.Err}
+		}
+		return nil, err
+	}
+
+	timestamp, err := parseTimestamp(record[s.time], s.flags.timeUnit)
+	if err != nil {
+		return nil, &recordError{line: line, err: err}
+	}
+	if s.flags.wide {
+		var points []gtsdb.DataPoint
+		for i, raw := range record {
+			if i == s.time || strings.TrimSpace(raw) == "" {
+				continue
+			}
+			point, err := s.point(strings.TrimSpace(s.header[i]), timestamp, raw)
+			if err != nil {
+				return nil, &recordError{line: line, err: err}
+			}
+			points = append(points, point)
+		}
+		return points, nil
+	}
+	key := s.flags.key
+	if s.key >= 0 {
+		key = record[s.key]
+	}
+	point, err := s.point(key, timestamp, record[s.value])
+	if err != nil {
+		return nil, &recordError{line: line, err: err}
+	}
+	return []gtsdb.DataPoint{point}, nil
+}
+
+// point builds the point of a cell
+func (s *csvSource) point(key string, timestamp time.Time, raw string) (gtsdb.DataPoint, error) {
+	key = s.flags.keyPrefix + strings.TrimSpace(key)
+	if key == "" || strings.ContainsAny(key, ", \t\r\n|") {
+		return gtsdb.DataPoint{}, fmt.Errorf("invalid key %q", key)
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return gtsdb.DataPoint{}, fmt.Errorf("invalid value %q", raw)
+	}
+	return gtsdb.DataPoint{Key: key, Timestamp: timestamp, Value: v}, nil
+}
+
+func (s *csvSource) position() (int64, int) { return s.base + s.r.InputOffset(), s.line }
+
+// parseTimestamp parses a timestamp in unit, or an RFC 3339 time
+func parseTimestamp(raw string, unit time.Duration) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if n, err := strconv.ParseInt(raw, 10, 64); err == nil && n >= 0 && n <= math.MaxInt64/int64(unit) {
+		return time.Unix(0, 0).Add(time.Duration(n) * unit), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", raw)
+}
+
+// seek moves in to offset, which only files support
+func seek(in io.Reader, offset int64) error {
+	if offset == 0 {
+		return nil
+	}
+	seeker, ok := in.(io.Seeker)
+	if !ok {
+		return errors.New("can't resume reading a pipe")
+	}
+	_, err := seeker.Seek(offset, io.SeekStart)
+	return err
+}
+
+// checkpoint records how far the import of each file got
+type checkpoint struct {
+	path  string
+	Files map[string]*fileProgress `json:"files"`
+}
+
+// fileProgress is how far the import of a file got: the points before Offset, which
+// ends Line, are written
+type fileProgress struct {
+	Offset int64 `json:"offset"`
+	Line   int   `json:"line"`
+	Done   bool  `json:"done"`
+}
+
+// loadCheckpoint reads the checkpoint at path, or starts one if there is none
+func loadCheckpoint(path string) (*checkpoint, error) {
+	c := &checkpoint{path: path, Files: make(map[string]*fileProgress)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("checkpoint %s: %w", path, err)
+	}
+	if c.Files == nil {
+		c.Files = make(map[string]*fileProgress)
+	}
+	return c, nil
+}
+
+// file returns the progress of the file at path
+func (c *checkpoint) file(path string) *fileProgress {
+	p, ok := c.Files[path]
+	if !ok {
+		p = &fileProgress{}
+		c.Files[path] = p
+	}
+	return p
+}
+
+// save replaces the checkpoint file, so a crash leaves either the old or the new one
+func (c *checkpoint) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
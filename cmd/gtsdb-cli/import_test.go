@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// readAll reads the points of src until the end or the first invalid record
+func readAll(src pointSource) ([]gtsdb.DataPoint, error) {
+	var points []gtsdb.DataPoint
+	for {
+		batch, err := src.next()
+		if err == io.EOF {
+			return points, nil
+		}
+		if err != nil {
+			return points, err
+		}
+		points = append(points, batch...)
+	}
+}
+
+func TestImportSources(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	csvFlags := func(change func(f *importFlags)) *importFlags {
+		f := &importFlags{keyColumn: "key", timeColumn: "timestamp", valueCol: "value", timeUnit: time.Second}
+		if change != nil {
+			change(f)
+		}
+		return f
+	}
+	tests := []struct {
+		name  string
+		csv   *importFlags // nil for the line format
+		input string
+		want  []gtsdb.DataPoint
+		// errLine is the line of the invalid record, 0 if there is none
+		errLine int
+	}{
+		{"line records", nil, "temp,1700000000,21.5\n# comment\n\nhum,1700000001,40\n",
+			[]gtsdb.DataPoint{{Key: "temp", Timestamp: at, Value: 21.5}, {Key: "hum", Timestamp: at.Add(time.Second), Value: 40}}, 0},
+		{"line without a trailing newline", nil, "temp,1700000000,21.5",
+			[]gtsdb.DataPoint{{Key: "temp", Timestamp: at, Value: 21.5}}, 0},
+		{"line missing a value", nil, "temp,1700000000,21.5\ntemp,1700000001\n",
+			[]gtsdb.DataPoint{{Key: "temp", Timestamp: at, Value: 21.5}}, 2},
+		{"line bad timestamp", nil, "temp,soon,21.5\n", nil, 1},
+		{"line negative timestamp", nil, "temp,-5,21.5\n", nil, 1},
+		{"line NaN", nil, "temp,1700000000,NaN\n", nil, 1},
+		{"line Inf", nil, "temp,1700000000,-Inf\n", nil, 1},
+		{"line key with a space", nil, "room temp 21.5\n", nil, 1},
+		{"line key with the record delimiter", nil, "temp|2,1700000000,21.5\n", nil, 1},
+
+		{"csv records", csvFlags(nil), "key,timestamp,value\ntemp,1700000000,21.5\n# comment\nhum,2023-11-14T22:13:21Z,40\n",
+			[]gtsdb.DataPoint{{Key: "temp", Timestamp: at, Value: 21.5}, {Key: "hum", Timestamp: at.Add(time.Second), Value: 40}}, 0},
+		{"csv milliseconds", csvFlags(func(f *importFlags) { f.timeUnit = time.Millisecond }), "key,timestamp,value\ntemp,1700000000500,21.5\n",
+			[]gtsdb.DataPoint{{Key: "temp", Timestamp: at.Add(500 * time.Millisecond), Value: 21.5}}, 0},
+		{"csv single series", csvFlags(func(f *importFlags) { f.key, f.keyPrefix = "temp", "site1." }), "timestamp,value\n1700000000,21.5\n",
+			[]gtsdb.DataPoint{{Key: "site1.temp", Timestamp: at, Value: 21.5}}, 0},
+		{"csv wide", csvFlags(func(f *importFlags) { f.wide = true }), "timestamp,temp,hum\n1700000000,21.5,\n1700000001,22,40\n",
+			[]gtsdb.DataPoint{
+				{Key: "temp", Timestamp: at, Value: 21.5},
+				{Key: "temp", Timestamp: at.Add(time.Second), Value: 22},
+				{Key: "hum", Timestamp: at.Add(time.Second), Value: 40},
+			}, 0},
+		{"csv missing field", csvFlags(nil), "key,timestamp,value\ntemp,1700000000,21.5\ntemp,1700000001\n",
+			[]gtsdb.DataPoint{{Key: "temp", Timestamp: at, Value: 21.5}}, 3},
+		{"csv empty value", csvFlags(nil), "key,timestamp,value\ntemp,1700000000,\n", nil, 2},
+		{"csv bad timestamp", csvFlags(nil), "key,timestamp,value\ntemp,14/11/2023,21.5\n", nil, 2},
+		{"csv negative timestamp", csvFlags(nil), "key,timestamp,value\ntemp,-1,21.5\n", nil, 2},
+		{"csv timestamp overflowing the unit", csvFlags(nil), "key,timestamp,value\ntemp,1700000000000,21.5\n", nil, 2},
+		{"csv NaN", csvFlags(nil), "key,timestamp,value\ntemp,1700000000,nan\n", nil, 2},
+		{"csv Inf", csvFlags(nil), "key,timestamp,value\ntemp,1700000000,+Inf\n", nil, 2},
+		{"csv empty key", csvFlags(nil), "key,timestamp,value\n,1700000000,21.5\n", nil, 2},
+		{"csv key with the record delimiter", csvFlags(nil), "key,timestamp,value\ntemp|2,1700000000,21.5\n", nil, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var src pointSource
+			var err error
+			if tt.csv != nil {
+				src, err = newCSVSource(strings.NewReader(tt.input), 0, 0, tt.csv)
+			} else {
+				src, err = newLineSource(strings.NewReader(tt.input), 0, 0, "")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			points, err := readAll(src)
+			if tt.errLine == 0 && err != nil {
+				t.Fatal(err)
+			}
+			if tt.errLine != 0 {
+				var rerr *recordError
+				if !errors.As(err, &rerr) {
+					t.Fatalf("got %v, want an invalid record", err)
+				}
+				if rerr.line != tt.errLine {
+					t.Errorf("invalid record at line %d, want %d", rerr.line, tt.errLine)
+				}
+			}
+			if len(points) != len(tt.want) {
+				t.Fatalf("got %+v, want %+v", points, tt.want)
+			}
+			for i := range points {
+				if points[i].Key != tt.want[i].Key || !points[i].Timestamp.Equal(tt.want[i].Timestamp) || points[i].Value != tt.want[i].Value {
+					t.Errorf("point %d = %+v, want %+v", i, points[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCSVHeader(t *testing.T) {
+	flags := &importFlags{keyColumn: "key", timeColumn: "timestamp", valueCol: "value", timeUnit: time.Second}
+	for _, input := range []string{"", "key,value\n", "key,timestamp\n", "timestamp,value\n"} {
+		if _, err := newCSVSource(strings.NewReader(input), 0, 0, flags); err == nil {
+			t.Errorf("accepted the header of %q", input)
+		}
+	}
+}
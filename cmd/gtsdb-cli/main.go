@@ -0,0 +1,213 @@
+// gtsdb-cli runs ad-hoc operations against a GTSDB server: writing points, reading,
+// tailing, exporting, importing and rolling up series, and benchmarking writes
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// command is a subcommand of the CLI
+type command struct {
+	name, args, summary string
+	run                 func(ctx context.Context, args []string, stdout io.Writer) error
+}
+
+var commands = []command{
+	{"write", "[key,timestamp,value | \"key value\"]...", "write the points given, or the lines read from stdin", runWrite},
+	{"read", "key", "read the points of a key in a time range", runRead},
+	{"tail", "key...", "print the updates pushed for keys until interrupted", runTail},
+	{"export", "[key...]", "dump the points of keys, or of those matching -prefix or -match", runExport},
+	{"import", "file...", "load points from CSV or line format files, resumably", runImport},
+	{"rollup", "pattern...", "keep rollups such as key:1h:avg of matching keys up to date", runRollup},
+	{"bench", "", "write synthetic points and report throughput and latency", runBench},
+}
+
+// usageError is a mistake in the command line, which exits with status 2
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string { return e.msg }
+
+// badUsage reports a mistake in the command line
+func badUsage(format string, args ...any) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run runs the command named by args[0], returning the exit code
+func run(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		usage(os.Stderr)
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == args[0] {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "gtsdb-cli: unknown command %q\n\n", args[0])
+		usage(os.Stderr)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err := cmd.run(ctx, args[1:], os.Stdout)
+	var usageErr *usageError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.As(err, &usageErr):
+		if usageErr.msg != "" {
+			fmt.Fprintf(os.Stderr, "gtsdb-cli %s: %v\n", cmd.name, err)
+		}
+		return 2
+	case errors.Is(err, context.Canceled) && ctx.Err() != nil:
+		// Interrupted
+		return 130
+	}
+	fmt.Fprintf(os.Stderr, "gtsdb-cli %s: %v\n", cmd.name, err)
+	return 1
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: gtsdb-cli <command> [flags] [args]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun gtsdb-cli <command> -h for the flags of a command. The server address defaults\nto $GTSDB_ADDR, or localhost:5555.\n")
+}
+
+// connFlags are the flags every command connecting to the server takes
+type connFlags struct {
+	addr          string
+	timeout       time.Duration
+	tls           bool
+	tlsCA         string
+	tlsSkipVerify bool
+	verbose       bool
+}
+
+// newFlagSet creates the flag set of cmd along with the connection flags
+func newFlagSet(name, args string) (*flag.FlagSet, *connFlags) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gtsdb-cli %s [flags] %s\n\nFlags:\n", name, args)
+		fs.PrintDefaults()
+	}
+	c := &connFlags{addr: "localhost:5555"}
+	if addr := os.Getenv("GTSDB_ADDR"); addr != "" {
+		c.addr = addr
+	}
+	fs.StringVar(&c.addr, "addr", c.addr, "address of the GTSDB server")
+	fs.DurationVar(&c.timeout, "timeout", 10*time.Second, "timeout of dialing and of each read and write")
+	fs.BoolVar(&c.tls, "tls", false, "connect over TLS")
+	fs.StringVar(&c.tlsCA, "tls-ca", "", "PEM file of the CA to verify the server with, instead of the system roots (implies -tls)")
+	fs.BoolVar(&c.tlsSkipVerify, "tls-skip-verify", false, "don't verify the server's certificate (implies -tls)")
+	fs.BoolVar(&c.verbose, "v", false, "log the driver's connection events to stderr")
+	return fs, c
+}
+
+// parseFlags parses args into fs, turning errors into usage errors
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		// The flag package already printed it
+		return &usageError{}
+	}
+	return nil
+}
+
+// dial connects to the server
+func (c *connFlags) dial(ctx context.Context, opts ...gtsdb.Option) (*gtsdb.TSDBClient, error) {
+	level := slog.LevelError
+	if c.verbose {
+		level = slog.LevelDebug
+	}
+	opts = append([]gtsdb.Option{
+		gtsdb.WithDialTimeout(c.timeout),
+		gtsdb.WithReadTimeout(c.timeout),
+		gtsdb.WithWriteTimeout(c.timeout),
+		gtsdb.WithLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))),
+	}, opts...)
+	if c.tls || c.tlsCA != "" || c.tlsSkipVerify {
+		cfg := &tls.Config{InsecureSkipVerify: c.tlsSkipVerify}
+		if c.tlsCA != "" {
+			pem, err := os.ReadFile(c.tlsCA)
+			if err != nil {
+				return nil, err
+			}
+			cfg.RootCAs = x509.NewCertPool()
+			if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates in %s", c.tlsCA)
+			}
+		}
+		opts = append(opts, gtsdb.WithTLS(cfg))
+	}
+	return gtsdb.NewTSDBClientContext(ctx, c.addr, opts...)
+}
+
+// parseTime parses a point in time given as now, Unix seconds, an RFC 3339 time, a
+// date, or a duration before now such as -1h
+func parseTime(s string, now time.Time) (time.Time, error) {
+	switch {
+	case s == "now":
+		return now, nil
+	case strings.HasPrefix(s, "-"):
+		if d, err := time.ParseDuration(s[1:]); err == nil {
+			return now.Add(-d), nil
+		}
+	}
+	if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(ts, 0), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: use now, Unix seconds, RFC 3339, a date or a duration such as -1h", s)
+}
+
+// parseRange parses the -start and -end flags
+func parseRange(start, end string) (time.Time, time.Time, error) {
+	now := time.Now()
+	from, err := parseTime(start, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, badUsage("-start: %v", err)
+	}
+	to, err := parseTime(end, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, badUsage("-end: %v", err)
+	}
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, badUsage("-end is before -start")
+	}
+	return from, to, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// pointWriter prints points in one of the output formats
+type pointWriter interface {
+	write(p gtsdb.DataPoint) error
+	// flush writes out what is buffered; tables are aligned per flush
+	flush() error
+	// close flushes and finishes the output, which Parquet needs
+	close() error
+}
+
+// outputFlag adds the -o flag choosing the output format, defaulting to def
+func outputFlag(fs *flag.FlagSet, def string) *string {
+	return fs.String("o", def, "output format: table, json (one object per line), csv or parquet")
+}
+
+// newPointWriter creates the writer of format; json, csv and parquet are the driver's
+// export formats
+func newPointWriter(format string, w io.Writer) (pointWriter, error) {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "KEY\tTIME\tVALUE")
+		return &tableWriter{w: tw}, nil
+	case "json":
+		format = string(gtsdb.ExportNDJSON)
+	case "csv", "parquet":
+	default:
+		return nil, badUsage("unknown output format %q", format)
+	}
+	e, err := gtsdb.NewExporter(w, gtsdb.ExportFormat(format))
+	if err != nil {
+		return nil, err
+	}
+	return exportWriter{e}, nil
+}
+
+type tableWriter struct {
+	w *tabwriter.Writer
+}
+
+func (t *tableWriter) write(p gtsdb.DataPoint) error {
+	_, err := fmt.Fprintf(t.w, "%s\t%s\t%s\n", p.Key, p.Timestamp.Format(time.RFC3339), formatValue(p.Value))
+	return err
+}
+
+func (t *tableWriter) flush() error { return t.w.Flush() }
+
+func (t *tableWriter) close() error { return t.w.Flush() }
+
+// exportWriter writes points with the driver's exporter
+type exportWriter struct {
+	e *gtsdb.Exporter
+}
+
+func (x exportWriter) write(p gtsdb.DataPoint) error { return x.e.Write(p) }
+
+func (x exportWriter) flush() error { return x.e.Flush() }
+
+func (x exportWriter) close() error { return x.e.Close() }
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// runRead prints the points of a key between -start and -end
+func runRead(ctx context.Context, args []string, stdout io.Writer) error {
+	fs, conn := newFlagSet("read", "key")
+	start := fs.String("start", "-1h", "start of the range: now, Unix seconds, RFC 3339, a date or a duration before now")
+	end := fs.String("end", "now", "end of the range, like -start")
+	downsample := fs.Int("downsample", 0, "combine the points of intervals of that many seconds (0 for raw points)")
+	aggregation := fs.String("agg", "", "how downsampled points are combined: avg, min, max, sum or count (the server's default when empty)")
+	output := outputFlag(fs, "table")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return badUsage("expected one key")
+	}
+	from, to, err := parseRange(*start, *end)
+	if err != nil {
+		return err
+	}
+	if *downsample < 0 {
+		return badUsage("-downsample must not be negative")
+	}
+	out, err := newPointWriter(*output, stdout)
+	if err != nil {
+		return err
+	}
+
+	client, err := conn.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	key := fs.Arg(0)
+	if *aggregation != "" {
+		points, err := client.ReadAggregatedContext(ctx, key, from.Unix(), to.Unix(), *downsample, gtsdb.Aggregation(*aggregation))
+		if err != nil {
+			return err
+		}
+		for _, p := range points {
+			if err := out.write(p); err != nil {
+				return err
+			}
+		}
+		return out.close()
+	}
+	if _, err := streamPoints(ctx, client, key, from.Unix(), to.Unix(), *downsample, out); err != nil {
+		return err
+	}
+	return out.close()
+}
+
+// runExport dumps the points of keys between -start and -end into a file for analysis
+// elsewhere, by default as CSV
+func runExport(ctx context.Context, args []string, stdout io.Writer) error {
+	fs, conn := newFlagSet("export", "[key...]")
+	prefix := fs.String("prefix", "", "export the keys starting with the prefix")
+	match := fs.String("match", "", "export the keys matching a shell pattern such as sensor-*")
+	start := fs.String("start", "0", "start of the range: now, Unix seconds, RFC 3339, a date or a duration before now")
+	end := fs.String("end", "now", "end of the range, like -start")
+	file := fs.String("out", "", "file to write to instead of stdout")
+	output := fs.String("o", "csv", "output format: csv, json (one object per line) or parquet")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	format := gtsdb.ExportFormat(*output)
+	switch *output {
+	case "json":
+		format = gtsdb.ExportNDJSON
+	case "csv", "parquet":
+	default:
+		return badUsage("unknown output format %q", *output)
+	}
+	selectors := 0
+	for _, set := range []bool{fs.NArg() > 0, *prefix != "", *match != ""} {
+		if set {
+			selectors++
+		}
+	}
+	if selectors != 1 {
+		return badUsage("give either keys, -prefix or -match")
+	}
+	from, to, err := parseRange(*start, *end)
+	if err != nil {
+		return err
+	}
+
+	client, err := conn.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	keys := fs.Args()
+	switch {
+	case *prefix != "":
+		keys, err = client.ListKeysContext(ctx, *prefix)
+	case *match != "":
+		keys, err = client.ListKeysMatchingContext(ctx, *match)
+	}
+	if err != nil {
+		return fmt.Errorf("list keys: %w", err)
+	}
+
+	w := stdout
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	out, err := gtsdb.NewExporter(w, format)
+	if err != nil {
+		return err
+	}
+	total := 0
+	for _, key := range keys {
+		n, err := client.ExportTo(ctx, out, key, from.Unix(), to.Unix())
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		total += n
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if *file != "" {
+		fmt.Fprintf(os.Stderr, "exported %d points of %d keys to %s\n", total, len(keys), *file)
+	}
+	return nil
+}
+
+// streamPoints writes the points of a range query to out as they arrive, returning how
+// many there were
+func streamPoints(ctx context.Context, client *gtsdb.TSDBClient, key string, start, end int64, downsample int, out pointWriter) (int, error) {
+	it, err := client.ReadStreamContext(ctx, key, start, end, downsample)
+	if err != nil {
+		return 0, err
+	}
+	defer it.Close()
+	n := 0
+	for it.Next() {
+		if err := out.write(it.Point()); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, it.Err()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// runRollup keeps rollups of the keys matching the patterns up to date, catching up
+// from where they stopped, until interrupted or once with -once
+func runRollup(ctx context.Context, args []string, stdout io.Writer) error {
+	fs, conn := newFlagSet("rollup", "pattern...")
+	spec := fs.String("rollups", "1m:avg,5m:avg,1h:avg,1h:min,1h:max", "comma-separated rollups, each an interval and an aggregation (avg, min, max, sum or count)")
+	delay := fs.Duration("delay", 30*time.Second, "time waited after an interval ends for late points")
+	lookback := fs.Duration("lookback", 24*time.Hour, "how far back to roll up keys never rolled up, and to catch up after downtime")
+	every := fs.Duration("every", 0, "how often to catch up (the shortest rollup interval when 0)")
+	once := fs.Bool("once", false, "catch up once and exit")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return badUsage("expected at least one key or pattern")
+	}
+	var rollups []gtsdb.Rollup
+	for _, s := range strings.Split(*spec, ",") {
+		r, err := gtsdb.ParseRollup(strings.TrimSpace(s))
+		if err != nil {
+			return badUsage("-rollups: %v", err)
+		}
+		rollups = append(rollups, r)
+		if *every == 0 || r.Interval < *every {
+			*every = r.Interval
+		}
+	}
+
+	client, err := conn.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	opts := []gtsdb.RollupOption{gtsdb.WithRollupDelay(*delay), gtsdb.WithRollupLookback(*lookback)}
+	var schedulers []*gtsdb.RollupScheduler
+	for _, pattern := range fs.Args() {
+		s, err := gtsdb.NewRollupScheduler(client, pattern, rollups, opts...)
+		if err != nil {
+			return badUsage("%v", err)
+		}
+		schedulers = append(schedulers, s)
+	}
+
+	ticker := time.NewTicker(*every)
+	defer ticker.Stop()
+	for {
+		written := 0
+		var errs []error
+		for _, s := range schedulers {
+			n, err := s.RunOnce(ctx)
+			written += n
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err := errors.Join(errs...)
+		fmt.Fprintf(stdout, "%s  %d rollup points written\n", time.Now().Format(time.RFC3339), written)
+		if *once {
+			return err
+		}
+		if err != nil {
+			// Keep going: the failed intervals are caught up on the next run
+			fmt.Fprintf(os.Stderr, "gtsdb-cli rollup: %v\n", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// runTail prints the updates pushed for keys as they arrive, until interrupted or -n
+// updates were printed
+func runTail(ctx context.Context, args []string, stdout io.Writer) error {
+	fs, conn := newFlagSet("tail", "key...")
+	count := fs.Int("n", 0, "exit after that many updates (0 to run until interrupted)")
+	output := outputFlag(fs, "table")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return badUsage("expected at least one key")
+	}
+	if *output == "parquet" {
+		return badUsage("parquet files are finished at the end, which tail never reaches; use export")
+	}
+	out, err := newPointWriter(*output, stdout)
+	if err != nil {
+		return err
+	}
+
+	// The driver re-issues the subscription when the connection drops, so tail
+	// outlives server restarts
+	client, err := conn.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	sub, err := client.SubscribeStreamContext(ctx, fs.Args()...)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+	// The header comes out before the first update
+	if err := out.flush(); err != nil {
+		return err
+	}
+
+	for n := 0; *count == 0 || n < *count; n++ {
+		select {
+		case p, ok := <-sub.Updates():
+			if !ok {
+				return errors.New("subscription closed")
+			}
+			if err := out.write(p); err != nil {
+				return err
+			}
+			if err := out.flush(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return nil
+}
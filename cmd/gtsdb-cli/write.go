@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// runWrite writes the points given as arguments or, without any, the lines read from
+// stdin, in the batches of -batch
+func runWrite(ctx context.Context, args []string, stdout io.Writer) error {
+	fs, conn := newFlagSet("write", "[key,timestamp,value | \"key value\"]...")
+	batchSize := fs.Int("batch", 500, "points written per batch")
+	sync := fs.Bool("sync", false, "wait for the server to acknowledge every batch")
+	quiet := fs.Bool("q", false, "don't report how many points were written")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *batchSize < 1 {
+		return badUsage("-batch must be at least 1")
+	}
+
+	var input io.Reader = os.Stdin
+	if fs.NArg() > 0 {
+		input = strings.NewReader(strings.Join(fs.Args(), "\n"))
+	}
+
+	client, err := conn.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	write := client.WriteBatchContext
+	if *sync {
+		write = client.WriteBatchSyncContext
+	}
+
+	written := 0
+	batch := make([]gtsdb.DataPoint, 0, *batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := write(ctx, batch); err != nil {
+			return fmt.Errorf("after %d points: %w", written, err)
+		}
+		written += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		point, err := parseLine(line, time.Now())
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+		if batch = append(batch, point); len(batch) >= *batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if !*quiet {
+		fmt.Fprintf(os.Stderr, "wrote %d points\n", written)
+	}
+	return nil
+}
+
+// parseLine parses a point as key,timestamp,value or "key value", which is timestamped
+// with now
+func parseLine(line string, now time.Time) (gtsdb.DataPoint, error) {
+	line = strings.TrimSpace(line)
+	var key, timestamp, value string
+	if parts := strings.Split(line, ","); len(parts) > 1 {
+		if len(parts) != 3 {
+			return gtsdb.DataPoint{}, fmt.Errorf("expected key,timestamp,value, got %d fields", len(parts))
+		}
+		key, timestamp, value = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), strings.TrimSpace(parts[2])
+	} else {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return gtsdb.DataPoint{}, fmt.Errorf("expected \"key value\" or key,timestamp,value")
+		}
+		key, value = fields[0], fields[1]
+	}
+
+	if key == "" || strings.ContainsAny(key, ", \t|") {
+		return gtsdb.DataPoint{}, fmt.Errorf("invalid key %q", key)
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return gtsdb.DataPoint{}, fmt.Errorf("invalid value %q", value)
+	}
+	point := gtsdb.DataPoint{Key: key, Timestamp: now, Value: v}
+	if timestamp != "" {
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || ts < 0 {
+			return gtsdb.DataPoint{}, fmt.Errorf("invalid timestamp %q", timestamp)
+		}
+		point.Timestamp = time.Unix(ts, 0)
+	}
+	return point, nil
+}
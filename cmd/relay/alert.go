@@ -0,0 +1,636 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+const (
+	// alertTick is how often pending alerts are checked for having held long enough
+	alertTick = time.Second
+	// notificationBuffer is the number of notifications waiting per notifier
+	notificationBuffer = 256
+	// notifyTimeout bounds a notification request, and notifyAttempts the requests
+	// made before giving a notification up
+	notifyTimeout  = 10 * time.Second
+	notifyAttempts = 3
+)
+
+// alertConfig configures the alerting rules evaluated against the updates of the keys
+// they watch, and the notifiers told when alerts fire and resolve
+type alertConfig struct {
+	// Refresh is how often the rules' patterns are matched against the upstream keys,
+	// so that new keys are watched
+	Refresh   time.Duration    `yaml:"refresh"`
+	Rules     []alertRule      `yaml:"rules"`
+	Notifiers []notifierConfig `yaml:"notifiers"`
+}
+
+// alertRule fires an alert for each key matching Keys whose updates meet Condition,
+// e.g. "value > 80 for 5m"
+type alertRule struct {
+	Name string `yaml:"name"`
+	// Keys is a key, or a shell pattern matching several
+	Keys      string `yaml:"keys"`
+	Condition string `yaml:"condition"`
+	// Dedup silences an alert firing again within that long of its last notification
+	Dedup time.Duration `yaml:"dedup"`
+	// Notify names the notifiers told about the rule's alerts, all when empty
+	Notify []string `yaml:"notify"`
+}
+
+// notifierConfig configures a notifier: a webhook receiving the notifications as JSON,
+// or a Slack incoming webhook
+type notifierConfig struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	URL  string `yaml:"url"`
+	// Headers are added to the requests of a webhook, e.g. to authenticate
+	Headers map[string]string `yaml:"headers"`
+}
+
+// notifierTypes creates the notifiers of each type a notifier config can name
+var notifierTypes = map[string]func(notifierConfig) (notifier, error){
+	"webhook": newWebhookNotifier,
+	"slack":   newSlackNotifier,
+}
+
+// notifier delivers alert notifications
+type notifier interface {
+	notify(ctx context.Context, n alertNotification) error
+}
+
+// alertNotification tells that an alert fired or resolved
+type alertNotification struct {
+	// Status is firing or resolved
+	Status    string    `json:"status"`
+	Rule      string    `json:"rule"`
+	Key       string    `json:"key"`
+	Condition string    `json:"condition"`
+	Value     float64   `json:"value"`
+	Since     time.Time `json:"since"`
+	Time      time.Time `json:"time"`
+}
+
+// alertCondition is a comparison of the values of a key with a threshold, which must
+// hold for a duration before an alert fires
+type alertCondition struct {
+	op        string
+	threshold float64
+	hold      time.Duration
+}
+
+// parseAlertCondition parses "value <op> <threshold> [for <duration>]", op being one
+// of >, >=, <, <=, == and !=
+func parseAlertCondition(s string) (alertCondition, error) {
+	fields := strings.Fields(s)
+	if (len(fields) != 3 && len(fields) != 5) || fields[0] != "value" {
+		return alertCondition{}, fmt.Errorf("condition %q: expected value <op> <threshold> [for <duration>]", s)
+	}
+	var c alertCondition
+	switch c.op = fields[1]; c.op {
+	case ">", ">=", "<", "<=", "==", "!=":
+	default:
+		return alertCondition{}, fmt.Errorf("condition %q: unknown comparison %q", s, c.op)
+	}
+	var err error
+	if c.threshold, err = strconv.ParseFloat(fields[2], 64); err != nil {
+		return alertCondition{}, fmt.Errorf("condition %q: invalid threshold %q", s, fields[2])
+	}
+	if len(fields) == 5 {
+		if fields[3] != "for" {
+			return alertCondition{}, fmt.Errorf("condition %q: expected for <duration>", s)
+		}
+		if c.hold, err = time.ParseDuration(fields[4]); err != nil || c.hold < 0 {
+			return alertCondition{}, fmt.Errorf("condition %q: invalid duration %q", s, fields[4])
+		}
+	}
+	return c, nil
+}
+
+// holds reports whether v meets the condition's comparison
+func (c alertCondition) holds(v float64) bool {
+	switch c.op {
+	case ">":
+		return v > c.threshold
+	case ">=":
+		return v >= c.threshold
+	case "<":
+		return v < c.threshold
+	case "<=":
+		return v <= c.threshold
+	case "==":
+		return v == c.threshold
+	}
+	return v != c.threshold
+}
+
+// compiledAlertRule is a rule ready to be evaluated
+type compiledAlertRule struct {
+	alertRule
+	condition alertCondition
+	// pattern is set when Keys is a shell pattern
+	pattern   bool
+	notifiers []*notifierQueue
+}
+
+// matches reports whether the rule watches key
+func (rule *compiledAlertRule) matches(key string) bool {
+	if !rule.pattern {
+		return key == rule.Keys
+	}
+	ok, _ := path.Match(rule.Keys, key)
+	return ok
+}
+
+// notifierQueue hands the notifications of a notifier to it in the background, so
+// that a slow notifier stalls neither the evaluation nor the other notifiers
+type notifierQueue struct {
+	name     string
+	notifier notifier
+	pending  chan alertNotification
+}
+
+// alertID identifies the alert of a rule for a key
+type alertID struct {
+	rule int
+	key  string
+}
+
+// alertState is an alert whose condition holds: pending until it held for the rule's
+// duration, then firing until an update breaks it
+type alertState struct {
+	rule  *compiledAlertRule
+	key   string
+	state string
+	// value is the last value of the key, and since when the condition has held
+	value float64
+	since time.Time
+	fired time.Time
+	// notified is set when the firing was notified rather than silenced, so that
+	// resolving is notified too
+	notified bool
+}
+
+// alertEngine evaluates the alerting rules against the updates of the keys they watch.
+// Durations are measured on the relay's clock from when updates arrive, so that a
+// sensor's wrong clock doesn't matter.
+type alertEngine struct {
+	rules   []*compiledAlertRule
+	queues  []*notifierQueue
+	refresh time.Duration
+	logger  *slog.Logger
+	metrics *relayMetrics
+	// listKeys lists the keys matching a pattern, and clientFor returns the upstream
+	// client to subscribe to a key with
+	listKeys  func(ctx context.Context, pattern string) ([]string, error)
+	clientFor func(key string) *gtsdb.TSDBClient
+
+	mu     sync.Mutex
+	alerts map[alertID]*alertState
+	// notified is when each alert was last notified as firing, for deduplication
+	notified map[alertID]time.Time
+	// subs are the subscriptions of the watched keys, only used by run
+	subs map[string]*gtsdb.Subscription
+}
+
+// compileAlertRules checks the alerting configuration and creates its rules and
+// notifiers
+func compileAlertRules(cfg alertConfig) ([]*compiledAlertRule, []*notifierQueue, error) {
+	if len(cfg.Rules) > 0 && cfg.Refresh <= 0 {
+		return nil, nil, fmt.Errorf("alerts: refresh must be positive")
+	}
+	queues := make([]*notifierQueue, 0, len(cfg.Notifiers))
+	byName := make(map[string]*notifierQueue, len(cfg.Notifiers))
+	for _, nc := range cfg.Notifiers {
+		if nc.Name == "" {
+			return nil, nil, fmt.Errorf("alerts: a notifier has no name")
+		}
+		if byName[nc.Name] != nil {
+			return nil, nil, fmt.Errorf("alerts: notifier %q defined twice", nc.Name)
+		}
+		create, ok := notifierTypes[nc.Type]
+		if !ok {
+			return nil, nil, fmt.Errorf("alerts: notifier %q: type must be webhook or slack, not %q", nc.Name, nc.Type)
+		}
+		n, err := create(nc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("alerts: notifier %q: %w", nc.Name, err)
+		}
+		q := &notifierQueue{name: nc.Name, notifier: n, pending: make(chan alertNotification, notificationBuffer)}
+		queues = append(queues, q)
+		byName[nc.Name] = q
+	}
+
+	rules := make([]*compiledAlertRule, 0, len(cfg.Rules))
+	names := make(map[string]bool, len(cfg.Rules))
+	for i, rule := range cfg.Rules {
+		if rule.Name == "" {
+			return nil, nil, fmt.Errorf("alerts: rule %d has no name", i+1)
+		}
+		if names[rule.Name] {
+			return nil, nil, fmt.Errorf("alerts: rule %q defined twice", rule.Name)
+		}
+		names[rule.Name] = true
+		c := &compiledAlertRule{alertRule: rule, pattern: strings.ContainsAny(rule.Keys, "*?[")}
+		if c.pattern {
+			if _, err := path.Match(rule.Keys, ""); err != nil {
+				return nil, nil, fmt.Errorf("alerts: rule %q: invalid pattern %q: %w", rule.Name, rule.Keys, err)
+			}
+		} else if err := checkKey(rule.Keys); err != nil {
+			return nil, nil, fmt.Errorf("alerts: rule %q: %w", rule.Name, err)
+		}
+		var err error
+		if c.condition, err = parseAlertCondition(rule.Condition); err != nil {
+			return nil, nil, fmt.Errorf("alerts: rule %q: %w", rule.Name, err)
+		}
+		if rule.Dedup < 0 {
+			return nil, nil, fmt.Errorf("alerts: rule %q: dedup must not be negative", rule.Name)
+		}
+		c.notifiers = queues
+		if len(rule.Notify) > 0 {
+			c.notifiers = nil
+			for _, name := range rule.Notify {
+				q, ok := byName[name]
+				if !ok {
+					return nil, nil, fmt.Errorf("alerts: rule %q: unknown notifier %q", rule.Name, name)
+				}
+				c.notifiers = append(c.notifiers, q)
+			}
+		}
+		rules = append(rules, c)
+	}
+	return rules, queues, nil
+}
+
+// newAlertEngine creates the engine of the configured rules, returning nil when there
+// are none
+func newAlertEngine(cfg alertConfig, logger *slog.Logger, metrics *relayMetrics,
+	listKeys func(ctx context.Context, pattern string) ([]string, error), clientFor func(key string) *gtsdb.TSDBClient) (*alertEngine, error) {
+	rules, queues, err := compileAlertRules(cfg)
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+	return &alertEngine{
+		rules:     rules,
+		queues:    queues,
+		refresh:   cfg.Refresh,
+		logger:    logger.With("component", "alerts"),
+		metrics:   metrics,
+		listKeys:  listKeys,
+		clientFor: clientFor,
+		alerts:    make(map[alertID]*alertState),
+		notified:  make(map[alertID]time.Time),
+		subs:      make(map[string]*gtsdb.Subscription),
+	}, nil
+}
+
+// run watches the keys of the rules, evaluating their updates, and sends the
+// notifications until stop is closed; ctx aborts the requests to the upstream and the
+// notifiers
+func (e *alertEngine) run(ctx context.Context, stop <-chan struct{}) {
+	var wg sync.WaitGroup
+	for _, q := range e.queues {
+		wg.Add(1)
+		go func(q *notifierQueue) {
+			defer wg.Done()
+			e.deliver(ctx, stop, q)
+		}(q)
+	}
+
+	refresh := time.NewTicker(e.refresh)
+	tick := time.NewTicker(alertTick)
+	defer func() {
+		refresh.Stop()
+		tick.Stop()
+		for key, sub := range e.subs {
+			sub.Close()
+			delete(e.subs, key)
+		}
+		wg.Wait()
+	}()
+	e.watch(ctx, &wg)
+	for {
+		select {
+		case <-refresh.C:
+			e.watch(ctx, &wg)
+		case now := <-tick.C:
+			e.promote(now)
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// watch subscribes to the keys of the rules not watched yet, evaluating their updates
+// in goroutines tracked by wg that end when the subscriptions are closed
+func (e *alertEngine) watch(ctx context.Context, wg *sync.WaitGroup) {
+	var keys []string
+	for _, rule := range e.rules {
+		if !rule.pattern {
+			keys = append(keys, rule.Keys)
+			continue
+		}
+		found, err := e.listKeys(ctx, rule.Keys)
+		if err != nil {
+			e.logger.Warn("listing the keys of an alerting rule failed", "rule", rule.Name, "keys", rule.Keys, "error", err)
+			continue
+		}
+		keys = append(keys, found...)
+	}
+	for _, key := range keys {
+		if e.subs[key] != nil {
+			continue
+		}
+		sub, err := e.clientFor(key).SubscribeStreamContext(ctx, key)
+		if err != nil {
+			e.logger.Warn("subscribing to an alerted key failed", "key", key, "error", err)
+			continue
+		}
+		e.subs[key] = sub
+		e.logger.Debug("watching key", "key", key)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for point := range sub.Updates() {
+				e.observe(point.Key, point.Value, time.Now())
+			}
+		}()
+	}
+}
+
+// observe evaluates the rules watching key against a value it was updated with at now
+func (e *alertEngine) observe(key string, value float64, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, rule := range e.rules {
+		if !rule.matches(key) {
+			continue
+		}
+		id := alertID{rule: i, key: key}
+		a := e.alerts[id]
+		if !rule.condition.holds(value) {
+			if a != nil {
+				e.resolve(id, a, value, now)
+			}
+			continue
+		}
+		if a == nil {
+			a = &alertState{rule: rule, key: key, state: "pending", since: now}
+			e.alerts[id] = a
+		}
+		a.value = value
+		if a.state == "pending" && now.Sub(a.since) >= rule.condition.hold {
+			e.fire(id, a, now)
+		}
+	}
+}
+
+// promote fires the pending alerts whose condition held long enough by now, even if
+// their key was not updated since
+func (e *alertEngine) promote(now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for id, a := range e.alerts {
+		if a.state == "pending" && now.Sub(a.since) >= a.rule.condition.hold {
+			e.fire(id, a, now)
+		}
+	}
+}
+
+// fire turns a pending alert into a firing one and notifies it, unless it was
+// notified within the rule's deduplication window. e.mu is held.
+func (e *alertEngine) fire(id alertID, a *alertState, now time.Time) {
+	a.state, a.fired = "firing", now
+	e.metrics.alertsFiring.WithLabelValues(a.rule.Name).Inc()
+	if last, ok := e.notified[id]; ok && now.Sub(last) < a.rule.Dedup {
+		e.metrics.alertsSilenced.WithLabelValues(a.rule.Name).Inc()
+		e.logger.Debug("alert firing again within its dedup window", "rule", a.rule.Name, "key", a.key, "value", a.value)
+		return
+	}
+	e.logger.Info("alert firing", "rule", a.rule.Name, "key", a.key, "value", a.value)
+	e.notified[id] = now
+	a.notified = true
+	e.notify(a, "firing", a.value, now)
+}
+
+// resolve forgets an alert whose condition was broken by value, notifying it resolved
+// if it was notified firing. e.mu is held.
+func (e *alertEngine) resolve(id alertID, a *alertState, value float64, now time.Time) {
+	delete(e.alerts, id)
+	if a.state != "firing" {
+		return
+	}
+	e.metrics.alertsFiring.WithLabelValues(a.rule.Name).Dec()
+	e.logger.Info("alert resolved", "rule", a.rule.Name, "key", a.key, "value", value)
+	if a.notified {
+		e.notify(a, "resolved", value, now)
+	}
+}
+
+// notify hands a notification of a to the notifiers of its rule, dropping it for those
+// whose queue is full. e.mu is held.
+func (e *alertEngine) notify(a *alertState, status string, value float64, now time.Time) {
+	n := alertNotification{Status: status, Rule: a.rule.Name, Key: a.key, Condition: a.rule.Condition, Value: value, Since: a.since, Time: now}
+	for _, q := range a.rule.notifiers {
+		select {
+		case q.pending <- n:
+		default:
+			e.metrics.alertNotifications.WithLabelValues(q.name, "dropped").Inc()
+			e.logger.Warn("notifier fell behind, dropping notification", "notifier", q.name, "rule", n.Rule, "key", n.Key)
+		}
+	}
+}
+
+// deliver sends the notifications queued for q until stop is closed, trying each a few
+// times before giving it up
+func (e *alertEngine) deliver(ctx context.Context, stop <-chan struct{}, q *notifierQueue) {
+	for {
+		var n alertNotification
+		select {
+		case n = <-q.pending:
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+		var err error
+		for attempt, backoff := 1, time.Second; ; attempt, backoff = attempt+1, backoff*2 {
+			reqCtx, cancel := context.WithTimeout(ctx, notifyTimeout)
+			err = q.notifier.notify(reqCtx, n)
+			cancel()
+			if err == nil || attempt == notifyAttempts {
+				break
+			}
+			select {
+			case <-time.After(backoff):
+				continue
+			case <-stop:
+			case <-ctx.Done():
+			}
+			break
+		}
+		if err != nil {
+			e.metrics.alertNotifications.WithLabelValues(q.name, "failed").Inc()
+			e.logger.Warn("notification failed", "notifier", q.name, "rule", n.Rule, "key", n.Key, "status", n.Status, "error", err)
+			continue
+		}
+		e.metrics.alertNotifications.WithLabelValues(q.name, "sent").Inc()
+	}
+}
+
+// alertStatus is an alert as listed by GET /alerts
+type alertStatus struct {
+	Rule      string     `json:"rule"`
+	Key       string     `json:"key"`
+	Condition string     `json:"condition"`
+	State     string     `json:"state"`
+	Value     float64    `json:"value"`
+	Since     time.Time  `json:"since"`
+	Fired     *time.Time `json:"fired,omitempty"`
+	// Silenced is set for a firing alert not notified because of deduplication
+	Silenced bool `json:"silenced,omitempty"`
+}
+
+// snapshot lists the pending and firing alerts by rule and key
+func (e *alertEngine) snapshot() []alertStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	list := make([]alertStatus, 0, len(e.alerts))
+	for _, a := range e.alerts {
+		s := alertStatus{Rule: a.rule.Name, Key: a.key, Condition: a.rule.Condition, State: a.state, Value: a.value, Since: a.since}
+		if a.state == "firing" {
+			fired := a.fired
+			s.Fired, s.Silenced = &fired, !a.notified
+		}
+		list = append(list, s)
+	}
+	slices.SortFunc(list, func(a, b alertStatus) int {
+		if c := strings.Compare(a.Rule, b.Rule); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+	return list
+}
+
+// handleAlerts lists the pending and firing alerts, only the firing ones with
+// state=firing, and only those on its keys to a tenant
+func (r *relay) handleAlerts(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("use GET"))
+		return
+	}
+	alerts := r.alerts.snapshot()
+	if state := req.URL.Query().Get("state"); state != "" {
+		if state != "pending" && state != "firing" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("state must be pending or firing, not %q", state))
+			return
+		}
+		alerts = slices.DeleteFunc(alerts, func(a alertStatus) bool { return a.State != state })
+	}
+	if t := tenantFrom(req.Context()); t != nil {
+		// Tenants only see the alerts on their own keys
+		alerts = slices.DeleteFunc(alerts, func(a alertStatus) bool { return !strings.HasPrefix(a.Key, t.prefix) })
+		for i := range alerts {
+			alerts[i].Key = t.strip(alerts[i].Key)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alerts)
+}
+
+// webhookNotifier POSTs notifications as JSON to a URL
+type webhookNotifier struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newWebhookNotifier(cfg notifierConfig) (notifier, error) {
+	if err := checkNotifierURL(cfg.URL); err != nil {
+		return nil, err
+	}
+	return &webhookNotifier{url: cfg.URL, headers: cfg.Headers, client: &http.Client{}}, nil
+}
+
+func (n *webhookNotifier) notify(ctx context.Context, a alertNotification) error {
+	return postJSON(ctx, n.client, n.url, n.headers, a)
+}
+
+// slackNotifier posts notifications as messages to a Slack incoming webhook
+type slackNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func newSlackNotifier(cfg notifierConfig) (notifier, error) {
+	if err := checkNotifierURL(cfg.URL); err != nil {
+		return nil, err
+	}
+	if len(cfg.Headers) > 0 {
+		return nil, fmt.Errorf("headers only apply to webhooks")
+	}
+	return &slackNotifier{url: cfg.URL, client: &http.Client{}}, nil
+}
+
+func (n *slackNotifier) notify(ctx context.Context, a alertNotification) error {
+	text := fmt.Sprintf(":rotating_light: *%s* firing for `%s`: %g meets `%s` since %s",
+		a.Rule, a.Key, a.Value, a.Condition, a.Since.UTC().Format(time.RFC3339))
+	if a.Status == "resolved" {
+		text = fmt.Sprintf(":white_check_mark: *%s* resolved for `%s`: %g, firing since %s",
+			a.Rule, a.Key, a.Value, a.Since.UTC().Format(time.RFC3339))
+	}
+	return postJSON(ctx, n.client, n.url, nil, map[string]string{"text": text})
+}
+
+func checkNotifierURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http or https URL, not %q", s)
+	}
+	return nil
+}
+
+// postJSON POSTs v as JSON to url, failing unless answered with a 2xx status
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("answered %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// authPrefix starts the line a connection authenticates with
+const authPrefix = "auth "
+
+// authenticator checks the tokens clients present against the configured ones, and
+// tells which tenant they belong to
+type authenticator struct {
+	tokens []authToken
+	// tenants are the configured tenants by name
+	tenants map[string]*tenant
+}
+
+// authToken is a valid token, and its tenant or nil for the relay's own tokens
+type authToken struct {
+	value  []byte
+	tenant *tenant
+}
+
+// newAuthenticator loads the tokens of the auth settings and tenants, or returns nil
+// when authentication is disabled. The token file holds one token per line; blank lines
+// and lines starting with # are skipped.
+func newAuthenticator(cfg config) (*authenticator, error) {
+	a := &authenticator{}
+	for _, token := range strings.Split(cfg.AuthTokens, ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			a.tokens = append(a.tokens, authToken{value: []byte(token)})
+		}
+	}
+	if cfg.AuthTokenFile != "" {
+		f, err := os.Open(cfg.AuthTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("auth token file: %w", err)
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if token := strings.TrimSpace(scanner.Text()); token != "" && !strings.HasPrefix(token, "#") {
+				a.tokens = append(a.tokens, authToken{value: []byte(token)})
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("auth token file: %w", err)
+		}
+		if len(a.tokens) == 0 {
+			return nil, fmt.Errorf("auth token file %s holds no tokens", cfg.AuthTokenFile)
+		}
+	}
+	tenants, err := newTenants(cfg.Tenants)
+	if err != nil {
+		return nil, err
+	}
+	a.tenants = make(map[string]*tenant, len(tenants))
+	for _, t := range tenants {
+		a.tenants[t.cfg.Name] = t
+		for _, token := range t.cfg.Tokens {
+			a.tokens = append(a.tokens, authToken{value: []byte(strings.TrimSpace(token)), tenant: t})
+		}
+	}
+	if len(a.tokens) == 0 {
+		return nil, nil
+	}
+	// A token shared by tenants, or by a tenant and the relay, wouldn't tell whose keys
+	// its clients may see
+	owners := make(map[string]*tenant, len(a.tokens))
+	for _, t := range a.tokens {
+		if owner, ok := owners[string(t.value)]; ok && (owner != nil || t.tenant != nil) {
+			if owner == nil {
+				owner, t.tenant = t.tenant, nil
+			}
+			other := "the relay"
+			if t.tenant != nil {
+				other = "tenant " + t.tenant.name()
+			}
+			return nil, fmt.Errorf("tenant %s shares a token with %s", owner.name(), other)
+		}
+		owners[string(t.value)] = t.tenant
+	}
+	return a, nil
+}
+
+// lookup reports whether token is one of the configured tokens, comparing it with
+// every one in constant time, and returns its tenant
+func (a *authenticator) lookup(token string) (*tenant, bool) {
+	var found *tenant
+	ok := 0
+	for _, t := range a.tokens {
+		match := subtle.ConstantTimeCompare(t.value, []byte(token))
+		if match == 1 {
+			found = t.tenant
+		}
+		ok |= match
+	}
+	return found, ok == 1
+}
+
+// valid reports whether token is one of the configured tokens, in constant time
+func (a *authenticator) valid(token string) bool {
+	_, ok := a.lookup(token)
+	return ok
+}
+
+// authLine reports whether line is an "auth <token>" line with a valid token, and
+// returns the token's tenant
+func (a *authenticator) authLine(line string) (*tenant, bool) {
+	token, ok := strings.CutPrefix(strings.TrimSpace(line), authPrefix)
+	if !ok {
+		return nil, false
+	}
+	return a.lookup(strings.TrimSpace(token))
+}
+
+// requireToken lets through the requests carrying a valid token, as a bearer token in
+// the Authorization header or, for browsers opening WebSockets, in the token parameter.
+// The requests of tenants carry the tenant in their context.
+func (r *relay) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auth := r.current().auth
+		if auth == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = req.URL.Query().Get("token")
+		}
+		t, ok := auth.lookup(strings.TrimSpace(token))
+		if !ok {
+			r.metrics.authFailures.WithLabelValues("http").Inc()
+			r.logger.Warn("rejected unauthenticated request", "remote", req.RemoteAddr, "path", req.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gtsdb-relay"`)
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid token"))
+			return
+		}
+		next.ServeHTTP(w, req.WithContext(withTenant(req.Context(), t)))
+	})
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix prefixes the environment variable of every flag, e.g. GTSDB_RELAY_LISTEN
+const envPrefix = "GTSDB_RELAY_"
+
+// config is the relay's configuration. Flags override environment variables, which
+// override the config file, which overrides the defaults.
+type config struct {
+	// Listen is the TCP address sensors connect to
+	Listen string `yaml:"listen"`
+	// UDPListen is the UDP address sensors send datagrams to; it is disabled when empty
+	UDPListen string `yaml:"udp_listen"`
+	// HTTPListen is the address of the HTTP API; it is disabled when empty
+	HTTPListen string `yaml:"http_listen"`
+	// GRPCListen is the address of the gRPC API; it is disabled when empty
+	GRPCListen string `yaml:"grpc_listen"`
+	// WSOrigins lists, comma-separated, the origins besides the relay's own allowed to
+	// open WebSockets; * allows any
+	WSOrigins string `yaml:"ws_origins"`
+	// RemoteWriteKey builds the keys of Prometheus remote write series from {__name__}
+	// and label names. When empty the metric name is followed by every label.
+	RemoteWriteKey string `yaml:"remote_write_key"`
+	// OTLPKey builds the keys of OTLP data points from {__name__}, data point and
+	// resource attributes. When empty the metric name is followed by the data point
+	// attributes.
+	OTLPKey string `yaml:"otlp_key"`
+	// AuthTokens lists, comma-separated, the tokens clients authenticate with, and
+	// AuthTokenFile names a file holding one per line. Clients needn't authenticate
+	// when neither is set.
+	AuthTokens    string `yaml:"auth_tokens"`
+	AuthTokenFile string `yaml:"auth_token_file"`
+	// TLSCert and TLSKey serve the TCP and HTTP listeners over TLS. With TLSClientCA
+	// clients must present a certificate it signed. The files are read again on SIGHUP.
+	TLSCert     string `yaml:"tls_cert"`
+	TLSKey      string `yaml:"tls_key"`
+	TLSClientCA string `yaml:"tls_client_ca"`
+	// AdminToken enables POST /admin/reload for the requests bearing it
+	AdminToken string `yaml:"admin_token"`
+	// MQTT subscribes the relay to a broker's topics
+	MQTT mqttConfig `yaml:"mqtt"`
+	// Upstream lists, comma-separated, the addresses of the GTSDB servers measurements
+	// are forwarded to: hosts and ports, or Unix sockets as unix:/path/to/socket
+	Upstream string `yaml:"upstream"`
+	// UpstreamProxy is the URL of a SOCKS5 proxy, socks5://[user:password@]host:port,
+	// the upstreams are connected to through
+	UpstreamProxy string `yaml:"upstream_proxy"`
+	// UpstreamMode is how measurements are spread over the upstreams: replicate writes
+	// them to all and queries the first, shard writes and queries each key on the one
+	// server owning it, and failover uses the first healthy server in the listed order
+	UpstreamMode string `yaml:"upstream_mode"`
+	// HealthInterval is how often the upstreams are checked in failover mode, and
+	// HealthFailures the number of failed checks in a row that takes one down
+	HealthInterval time.Duration `yaml:"health_interval"`
+	HealthFailures int           `yaml:"health_failures"`
+	// Failback switches back to a recovered upstream ranked higher than the active one
+	Failback bool `yaml:"failback"`
+	// ShardReplicas is the number of points each server has on the sharding hash ring
+	ShardReplicas int `yaml:"shard_replicas"`
+	// UpstreamBuffer is the number of batches buffered per upstream while it is slow
+	UpstreamBuffer int           `yaml:"upstream_buffer"`
+	DialTimeout    time.Duration `yaml:"dial_timeout"`
+	ReadTimeout    time.Duration `yaml:"read_timeout"`
+	WriteTimeout   time.Duration `yaml:"write_timeout"`
+	// ShutdownTimeout bounds how long shutting down waits for connections to finish and
+	// the backlog to be forwarded
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// SpoolDir keeps the measurements the upstream can't take until it recovers; spooling
+	// is disabled when empty
+	SpoolDir      string `yaml:"spool_dir"`
+	SpoolMaxBytes int64  `yaml:"spool_max_bytes"`
+	// SpoolDrop is what a full spool drops: its oldest measurements or the newest
+	SpoolDrop string `yaml:"spool_drop"`
+	// WriteAcks has upstreams acknowledge every point, and keeps batches buffered or
+	// spooled until they do: measurements are delivered at least once. Full buffers
+	// make senders wait rather than dropping their measurements.
+	WriteAcks bool `yaml:"write_acks"`
+	// Rewrite renames the keys of incoming measurements, and Transform then changes or
+	// drops them. They can only be set in the file.
+	Rewrite   []rewriteRule   `yaml:"rewrite"`
+	Transform []transformStep `yaml:"transform"`
+	// Validate rejects measurements breaking its rules before they are rewritten, and
+	// DeadLetter records the lines rejected by it or the parser
+	Validate   validationConfig `yaml:"validate"`
+	DeadLetter deadLetterConfig `yaml:"dead_letter"`
+	// Alerts evaluates alerting rules against the updates of the keys they watch. It
+	// can only be set in the file.
+	Alerts alertConfig `yaml:"alerts"`
+	// Tenants share the relay, each with its own tokens, keys, rate limit and quota. They
+	// can only be set in the file.
+	Tenants []tenantConfig `yaml:"tenants"`
+	// MaxConns bounds the TCP connections open at once, and ConnRate and IPRate the
+	// lines per second accepted from a connection and from a source address; zero
+	// means unlimited. MaxLineLength bounds the length of a line in bytes, and
+	// MaxBodySize the body of an HTTP write and a gRPC message.
+	MaxConns      int     `yaml:"max_connections"`
+	ConnRate      float64 `yaml:"conn_rate"`
+	IPRate        float64 `yaml:"ip_rate"`
+	MaxLineLength int     `yaml:"max_line_length"`
+	MaxBodySize   int     `yaml:"max_body_size"`
+	// BatchSize is the number of measurements written to the upstream at once
+	BatchSize int `yaml:"batch_size"`
+	// FlushInterval bounds how long a measurement waits for its batch to fill up
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	// Backlog is the number of measurements buffered while the upstream is slow
+	Backlog int `yaml:"backlog"`
+	// Format is the format of the lines sent by sensors: line, influx, graphite or json.
+	// A connection can pick another one by starting with a "FORMAT <name>" line.
+	Format string `yaml:"format"`
+	// KeyTemplate builds keys from InfluxDB measurements, fields and tags
+	KeyTemplate     string     `yaml:"key_template"`
+	InfluxPrecision string     `yaml:"influx_precision"`
+	LogLevel        slog.Level `yaml:"log_level"`
+}
+
+func defaultConfig() config {
+	return config{
+		Listen:          ":5554",
+		Upstream:        "localhost:5555",
+		DialTimeout:     10 * time.Second,
+		ReadTimeout:     30 * time.Second,
+		WriteTimeout:    10 * time.Second,
+		UpstreamMode:    "replicate",
+		ShardReplicas:   100,
+		HealthInterval:  5 * time.Second,
+		HealthFailures:  3,
+		Failback:        true,
+		UpstreamBuffer:  64,
+		ShutdownTimeout: 30 * time.Second,
+		SpoolMaxBytes:   1 << 30,
+		SpoolDrop:       "oldest",
+		MaxLineLength:   64 << 10,
+		MaxBodySize:     16 << 20,
+		BatchSize:       500,
+		FlushInterval:   100 * time.Millisecond,
+		Backlog:         4096,
+		Format:          "line",
+		KeyTemplate:     "{measurement}.{field}",
+		InfluxPrecision: "ns",
+		LogLevel:        slog.LevelInfo,
+		MQTT: mqttConfig{
+			ClientID: "gtsdb-relay",
+			QoS:      1,
+			Key:      "{topic}",
+		},
+		Alerts: alertConfig{Refresh: time.Minute},
+	}
+}
+
+// loadConfig builds the configuration from args, the environment and the config file
+// named by -config or GTSDB_RELAY_CONFIG
+func loadConfig(args []string, getenv func(string) string, output io.Writer) (config, error) {
+	// A first pass only finds the config file; the flags are parsed again on top of it
+	path := getenv(envName("config"))
+	probe := defaultConfig()
+	if err := newFlagSet(&probe, &path, output).Parse(args); err != nil {
+		return config{}, err
+	}
+
+	cfg := defaultConfig()
+	if path != "" {
+		if err := cfg.load(path); err != nil {
+			return config{}, err
+		}
+	}
+
+	fs := newFlagSet(&cfg, &path, io.Discard)
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envName(f.Name)
+		if f.Name == "config" || envErr != nil || getenv(name) == "" {
+			return
+		}
+		if err := f.Value.Set(getenv(name)); err != nil {
+			envErr = fmt.Errorf("invalid value %q for %s: %w", getenv(name), name, err)
+		}
+	})
+	if envErr != nil {
+		return config{}, envErr
+	}
+	if err := fs.Parse(args); err != nil {
+		return config{}, err
+	}
+	return cfg, cfg.validate()
+}
+
+func newFlagSet(cfg *config, path *string, output io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("relay", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(path, "config", *path, "YAML config file")
+	fs.StringVar(&cfg.Listen, "listen", cfg.Listen, "TCP address to accept sensor connections on")
+	fs.StringVar(&cfg.UDPListen, "udp-listen", cfg.UDPListen, "UDP address to accept sensor datagrams on (disabled when empty)")
+	fs.StringVar(&cfg.HTTPListen, "http-listen", cfg.HTTPListen, "address of the HTTP write and query API, e.g. :8086 (disabled when empty)")
+	fs.StringVar(&cfg.GRPCListen, "grpc-listen", cfg.GRPCListen, "address of the gRPC API, e.g. :5556 (disabled when empty)")
+	fs.StringVar(&cfg.WSOrigins, "ws-origins", cfg.WSOrigins, "comma-separated origins allowed to open WebSockets besides the relay's own, or *")
+	fs.StringVar(&cfg.RemoteWriteKey, "remote-write-key", cfg.RemoteWriteKey, "key built from Prometheus remote write series; {__name__} or a {label} name (name and all labels when empty)")
+	fs.StringVar(&cfg.OTLPKey, "otlp-key", cfg.OTLPKey, "key built from OTLP data points; {__name__} or an {attribute} name (name and data point attributes when empty)")
+	fs.StringVar(&cfg.MQTT.Broker, "mqtt-broker", cfg.MQTT.Broker, "URL of an MQTT broker to subscribe to, e.g. tcp://localhost:1883")
+	fs.StringVar(&cfg.MQTT.ClientID, "mqtt-client-id", cfg.MQTT.ClientID, "MQTT client identifier")
+	fs.StringVar(&cfg.MQTT.Username, "mqtt-username", cfg.MQTT.Username, "MQTT user name")
+	fs.StringVar(&cfg.MQTT.Password, "mqtt-password", cfg.MQTT.Password, "MQTT password")
+	fs.StringVar(&cfg.AuthTokens, "auth-tokens", cfg.AuthTokens, "comma-separated tokens clients must authenticate with")
+	fs.StringVar(&cfg.AuthTokenFile, "auth-token-file", cfg.AuthTokenFile, "file of tokens clients must authenticate with, one per line")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", cfg.TLSCert, "certificate file serving the TCP and HTTP listeners over TLS")
+	fs.StringVar(&cfg.TLSKey, "tls-key", cfg.TLSKey, "key file of the TLS certificate")
+	fs.StringVar(&cfg.TLSClientCA, "tls-client-ca", cfg.TLSClientCA, "CA file client certificates must be signed by; none are asked for when empty")
+	fs.StringVar(&cfg.AdminToken, "admin-token", cfg.AdminToken, "token enabling the admin endpoints of the HTTP API (disabled when empty)")
+	fs.StringVar(&cfg.MQTT.Topics, "mqtt-topics", cfg.MQTT.Topics, "comma-separated MQTT topic filters")
+	fs.IntVar(&cfg.MQTT.QoS, "mqtt-qos", cfg.MQTT.QoS, "MQTT subscription QoS: 0, 1 or 2")
+	fs.StringVar(&cfg.MQTT.Key, "mqtt-key", cfg.MQTT.Key, "key built from MQTT topics; {topic} or a level such as {2}")
+	fs.StringVar(&cfg.MQTT.ValueField, "mqtt-value-field", cfg.MQTT.ValueField, "dotted path of the value in JSON payloads (payload is the value when empty)")
+	fs.StringVar(&cfg.MQTT.TimeField, "mqtt-time-field", cfg.MQTT.TimeField, "dotted path of the Unix timestamp in JSON payloads")
+	fs.StringVar(&cfg.Upstream, "upstream", cfg.Upstream, "comma-separated addresses of the GTSDB servers to forward to, host:port or unix:/path/to/socket")
+	fs.StringVar(&cfg.UpstreamProxy, "upstream-proxy", cfg.UpstreamProxy, "URL of a SOCKS5 proxy to connect to the upstreams through, e.g. socks5://localhost:1080")
+	fs.StringVar(&cfg.UpstreamMode, "upstream-mode", cfg.UpstreamMode, "how measurements are spread over the upstreams: replicate, shard or failover")
+	fs.DurationVar(&cfg.HealthInterval, "health-interval", cfg.HealthInterval, "how often upstreams are health-checked in failover mode")
+	fs.IntVar(&cfg.HealthFailures, "health-failures", cfg.HealthFailures, "failed health checks in a row that take an upstream down")
+	fs.BoolVar(&cfg.Failback, "failback", cfg.Failback, "switch back to a recovered upstream ranked higher than the active one")
+	fs.IntVar(&cfg.ShardReplicas, "shard-replicas", cfg.ShardReplicas, "points per upstream on the sharding hash ring")
+	fs.IntVar(&cfg.UpstreamBuffer, "upstream-buffer", cfg.UpstreamBuffer, "batches buffered per upstream while it is slow")
+	fs.DurationVar(&cfg.DialTimeout, "dial-timeout", cfg.DialTimeout, "timeout for connecting to the upstream")
+	fs.DurationVar(&cfg.ReadTimeout, "read-timeout", cfg.ReadTimeout, "timeout for upstream responses")
+	fs.DurationVar(&cfg.WriteTimeout, "write-timeout", cfg.WriteTimeout, "timeout for upstream writes")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "time allowed on SIGINT or SIGTERM to forward the backlog before exiting")
+	fs.StringVar(&cfg.SpoolDir, "spool-dir", cfg.SpoolDir, "directory spooling measurements while the upstream is down (disabled when empty)")
+	fs.Int64Var(&cfg.SpoolMaxBytes, "spool-max-bytes", cfg.SpoolMaxBytes, "largest size of the spool on disk")
+	fs.StringVar(&cfg.SpoolDrop, "spool-drop", cfg.SpoolDrop, "what a full spool drops: oldest or newest")
+	fs.BoolVar(&cfg.WriteAcks, "write-acks", cfg.WriteAcks, "wait for upstreams to acknowledge every point, retrying batches until they do")
+	fs.StringVar(&cfg.Validate.Keys, "validate-keys", cfg.Validate.Keys, "regular expression the keys of incoming measurements must match")
+	fs.DurationVar(&cfg.Validate.MaxAge, "validate-max-age", cfg.Validate.MaxAge, "oldest timestamp accepted, relative to now (unchecked when 0)")
+	fs.DurationVar(&cfg.Validate.MaxFuture, "validate-max-future", cfg.Validate.MaxFuture, "furthest timestamp accepted ahead of now (unchecked when 0)")
+	fs.StringVar(&cfg.DeadLetter.File, "dead-letter-file", cfg.DeadLetter.File, "file recording rejected lines with the reason, as JSON one per line")
+	fs.StringVar(&cfg.DeadLetter.Key, "dead-letter-key", cfg.DeadLetter.Key, "upstream key recording rejected lines with the reason")
+	fs.IntVar(&cfg.MaxConns, "max-connections", cfg.MaxConns, "TCP connections open at once, 0 for unlimited")
+	fs.Float64Var(&cfg.ConnRate, "conn-rate", cfg.ConnRate, "lines per second accepted from a connection, 0 for unlimited")
+	fs.Float64Var(&cfg.IPRate, "ip-rate", cfg.IPRate, "lines per second accepted from a source address, 0 for unlimited")
+	fs.IntVar(&cfg.MaxLineLength, "max-line-length", cfg.MaxLineLength, "longest line accepted, in bytes")
+	fs.IntVar(&cfg.MaxBodySize, "max-body-size", cfg.MaxBodySize, "largest HTTP write body and gRPC message accepted, in bytes")
+	fs.IntVar(&cfg.BatchSize, "batch-size", cfg.BatchSize, "measurements written to the upstream at once")
+	fs.DurationVar(&cfg.FlushInterval, "flush-interval", cfg.FlushInterval, "longest a measurement waits for its batch to fill up")
+	fs.IntVar(&cfg.Backlog, "backlog", cfg.Backlog, "measurements buffered for the upstream before dropping")
+	fs.StringVar(&cfg.Format, "format", cfg.Format, "format of the received lines: line, influx, graphite or json")
+	fs.StringVar(&cfg.KeyTemplate, "key-template", cfg.KeyTemplate, "key built from influx points; {measurement}, {field} or a {tag} name")
+	fs.StringVar(&cfg.InfluxPrecision, "influx-precision", cfg.InfluxPrecision, "unit of influx timestamps: ns, us, ms or s")
+	fs.TextVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "minimum log level: debug, info, warn or error")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of relay:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nEvery flag can also be set with an environment variable, e.g. %s for -listen.\n", envName("listen"))
+	}
+	return fs
+}
+
+// envName returns the environment variable of a flag
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// load reads the YAML file at path over cfg, rejecting unknown settings
+func (cfg *config) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (cfg config) validate() error {
+	if _, _, err := net.SplitHostPort(cfg.Listen); err != nil {
+		return fmt.Errorf("invalid listen address %q: %w", cfg.Listen, err)
+	}
+	if _, _, err := net.SplitHostPort(cfg.UDPListen); cfg.UDPListen != "" && err != nil {
+		return fmt.Errorf("invalid UDP listen address %q: %w", cfg.UDPListen, err)
+	}
+	if _, _, err := net.SplitHostPort(cfg.HTTPListen); cfg.HTTPListen != "" && err != nil {
+		return fmt.Errorf("invalid HTTP listen address %q: %w", cfg.HTTPListen, err)
+	}
+	if _, _, err := net.SplitHostPort(cfg.GRPCListen); cfg.GRPCListen != "" && err != nil {
+		return fmt.Errorf("invalid gRPC listen address %q: %w", cfg.GRPCListen, err)
+	}
+	addrs := upstreamAddrs(cfg.Upstream)
+	if len(addrs) == 0 {
+		return fmt.Errorf("no upstream address")
+	}
+	for i, addr := range addrs {
+		if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+			if strings.TrimPrefix(path, "//") == "" {
+				return fmt.Errorf("invalid upstream address %q: no socket path", addr)
+			}
+			if cfg.UpstreamProxy != "" {
+				return fmt.Errorf("upstream %q: a Unix socket can't be reached through the upstream proxy", addr)
+			}
+		} else if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("invalid upstream address %q: %w", addr, err)
+		}
+		for _, other := range addrs[:i] {
+			if other == addr {
+				return fmt.Errorf("upstream %q listed twice", addr)
+			}
+		}
+	}
+	if cfg.UpstreamProxy != "" {
+		if _, err := newProxyDialer(cfg.UpstreamProxy); err != nil {
+			return fmt.Errorf("upstream proxy: %w", err)
+		}
+	}
+	switch cfg.UpstreamMode {
+	case "replicate", "shard", "failover":
+	default:
+		return fmt.Errorf("upstream mode must be replicate, shard or failover, not %q", cfg.UpstreamMode)
+	}
+	if cfg.HealthInterval <= 0 {
+		return fmt.Errorf("health interval must be positive")
+	}
+	if cfg.HealthFailures < 1 {
+		return fmt.Errorf("health failures must be at least 1")
+	}
+	if cfg.ShardReplicas < 1 {
+		return fmt.Errorf("shard replicas must be at least 1")
+	}
+	if cfg.UpstreamBuffer < 1 {
+		return fmt.Errorf("upstream buffer must be at least 1")
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return fmt.Errorf("tls: a certificate needs a key and a key a certificate")
+	}
+	if cfg.TLSClientCA != "" && cfg.TLSCert == "" {
+		return fmt.Errorf("tls: verifying client certificates needs a certificate and key")
+	}
+	if cfg.DialTimeout < 0 || cfg.ReadTimeout < 0 || cfg.WriteTimeout < 0 || cfg.ShutdownTimeout < 0 {
+		return fmt.Errorf("timeouts must not be negative")
+	}
+	if cfg.Backlog < 1 {
+		return fmt.Errorf("backlog must be at least 1")
+	}
+	if cfg.SpoolMaxBytes < 1 {
+		return fmt.Errorf("spool max bytes must be positive")
+	}
+	if cfg.SpoolDrop != "oldest" && cfg.SpoolDrop != "newest" {
+		return fmt.Errorf("spool drop must be oldest or newest, not %q", cfg.SpoolDrop)
+	}
+	if cfg.MaxConns < 0 || cfg.ConnRate < 0 || cfg.IPRate < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	if cfg.MaxLineLength < 1 {
+		return fmt.Errorf("max line length must be positive")
+	}
+	if cfg.MaxBodySize < cfg.MaxLineLength {
+		return fmt.Errorf("max body size must be at least the max line length")
+	}
+	if cfg.BatchSize < 1 {
+		return fmt.Errorf("batch size must be at least 1")
+	}
+	if cfg.FlushInterval <= 0 {
+		return fmt.Errorf("flush interval must be positive")
+	}
+	if cfg.MQTT.Broker != "" {
+		if strings.TrimSpace(strings.ReplaceAll(cfg.MQTT.Topics, ",", "")) == "" {
+			return fmt.Errorf("mqtt: no topics to subscribe to")
+		}
+		if cfg.MQTT.QoS < 0 || cfg.MQTT.QoS > 2 {
+			return fmt.Errorf("mqtt: invalid QoS %d", cfg.MQTT.QoS)
+		}
+		if _, err := newMQTTMapping(cfg.MQTT); err != nil {
+			return fmt.Errorf("mqtt: %w", err)
+		}
+	}
+	if _, err := newRewriter(cfg.Rewrite); err != nil {
+		return err
+	}
+	if _, err := newTransforms(cfg.Transform); err != nil {
+		return err
+	}
+	if _, err := newValidator(cfg.Validate); err != nil {
+		return err
+	}
+	if cfg.DeadLetter.Key != "" {
+		if err := checkKey(cfg.DeadLetter.Key); err != nil {
+			return fmt.Errorf("dead letter: %w", err)
+		}
+	}
+	if _, _, err := compileAlertRules(cfg.Alerts); err != nil {
+		return err
+	}
+	if _, err := newTenants(cfg.Tenants); err != nil {
+		return err
+	}
+	if _, err := parseKeyTemplate(cfg.RemoteWriteKey); err != nil {
+		return fmt.Errorf("remote write: %w", err)
+	}
+	if _, err := parseKeyTemplate(cfg.OTLPKey); err != nil {
+		return fmt.Errorf("otlp: %w", err)
+	}
+	_, err := newParser(cfg)
+	return err
+}
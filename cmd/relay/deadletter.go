@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+const (
+	// deadLetterBuffer is the number of rejected lines waiting for the sink
+	deadLetterBuffer = 1024
+	// maxDeadLetterLine bounds the length of the lines kept by a gtsdb key sink, which
+	// stores small raw values
+	maxDeadLetterLine = 512
+)
+
+// deadLetter is a rejected line and why it was rejected
+type deadLetter struct {
+	Time     time.Time `json:"time"`
+	Listener string    `json:"listener"`
+	Remote   string    `json:"remote,omitempty"`
+	Line     string    `json:"line"`
+	Reason   string    `json:"reason"`
+	Error    string    `json:"error"`
+}
+
+// deadLetterConfig records rejected lines in File, as JSON objects one per line, and/or
+// as raw values of Key on the upstream
+type deadLetterConfig struct {
+	File string `yaml:"file"`
+	Key  string `yaml:"key"`
+}
+
+// deadLetterSink records rejected lines in the background, so that a slow sink never
+// stalls the listeners; lines are dropped when it can't keep up
+type deadLetterSink struct {
+	file *os.File
+	key  string
+	// clientFor returns the upstream client writing key
+	clientFor func(key string) *gtsdb.TSDBClient
+	letters   chan deadLetter
+	done      chan struct{}
+}
+
+// newDeadLetterSink opens the configured sinks, returning nil when there are none.
+// Keyed letters are written with the client clientFor returns.
+func newDeadLetterSink(cfg deadLetterConfig, clientFor func(key string) *gtsdb.TSDBClient) (*deadLetterSink, error) {
+	if cfg.File == "" && cfg.Key == "" {
+		return nil, nil
+	}
+	s := &deadLetterSink{key: cfg.Key, clientFor: clientFor, letters: make(chan deadLetter, deadLetterBuffer), done: make(chan struct{})}
+	if cfg.File != "" {
+		f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("dead letter file: %w", err)
+		}
+		s.file = f
+	}
+	return s, nil
+}
+
+// offer hands a letter to the sink, reporting false when its buffer is full
+func (s *deadLetterSink) offer(letter deadLetter) bool {
+	select {
+	case s.letters <- letter:
+		return true
+	default:
+		return false
+	}
+}
+
+// run records letters until stop is closed, then records those still buffered; ctx
+// aborts writing to the upstream
+func (s *deadLetterSink) run(ctx context.Context, stop <-chan struct{}, errorf func(error)) {
+	defer close(s.done)
+	for {
+		select {
+		case letter := <-s.letters:
+			s.record(ctx, letter, errorf)
+		case <-stop:
+			for {
+				select {
+				case letter := <-s.letters:
+					s.record(ctx, letter, errorf)
+				default:
+					if s.file != nil {
+						if err := s.file.Close(); err != nil {
+							errorf(err)
+						}
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *deadLetterSink) record(ctx context.Context, letter deadLetter, errorf func(error)) {
+	if s.file != nil {
+		data, _ := json.Marshal(letter)
+		if _, err := s.file.Write(append(data, '\n')); err != nil {
+			errorf(fmt.Errorf("dead letter file: %w", err))
+		}
+	}
+	if s.key != "" {
+		line := letter.Line
+		if len(line) > maxDeadLetterLine {
+			line = line[:maxDeadLetterLine]
+		}
+		// Query escaping leaves none of the protocol's delimiters
+		encoded := url.Values{"reason": {letter.Reason}, "error": {letter.Error}, "line": {line}, "remote": {letter.Remote}}.Encode()
+		if err := s.clientFor(s.key).WriteRawContext(ctx, s.key, letter.Time.Unix(), encoded); err != nil {
+			errorf(fmt.Errorf("dead letter key: %w", err))
+		}
+	}
+}
+
+// reject counts a line rejected by err and records it in the dead-letter sink. err is
+// a rejection when validation failed, or the parse error otherwise.
+func (r *relay) reject(src source, line string, err error) {
+	reason := "parse"
+	var rej *rejection
+	if errors.As(err, &rej) {
+		reason = rej.reason
+	}
+	r.metrics.rejected.WithLabelValues(reason).Inc()
+	if r.deadLetters == nil {
+		return
+	}
+	letter := deadLetter{Time: time.Now(), Listener: src.listener, Remote: src.remote, Line: line, Reason: reason, Error: err.Error()}
+	if !r.deadLetters.offer(letter) {
+		r.metrics.deadLettersDropped.Inc()
+	}
+}
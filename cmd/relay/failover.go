@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// failover sends writes and queries to one upstream at a time, the primary unless
+// health checks found it down. Upstreams are ranked in the order they are listed.
+type failover struct {
+	upstreams []*upstream
+	interval  time.Duration
+	// threshold is the number of failed checks in a row that takes an upstream down
+	threshold int
+	// failback returns to a higher ranked upstream as soon as it is healthy again
+	failback bool
+	logger   *slog.Logger
+	metrics  *relayMetrics
+
+	active atomic.Int32
+	// failures counts the failed checks in a row per upstream; only check touches it
+	failures []int
+	// stopped is closed when a reload replaced the failover
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+func newFailover(cfg config, upstreams []*upstream, logger *slog.Logger, metrics *relayMetrics) *failover {
+	f := &failover{
+		upstreams: upstreams,
+		interval:  cfg.HealthInterval,
+		threshold: cfg.HealthFailures,
+		failback:  cfg.Failback,
+		logger:    logger,
+		metrics:   metrics,
+		failures:  make([]int, len(upstreams)),
+		stopped:   make(chan struct{}),
+	}
+	for i, u := range upstreams {
+		f.metrics.activeUpstream.WithLabelValues(u.addr).Set(boolGauge(i == 0))
+	}
+	return f
+}
+
+// current returns the upstream writes and queries go to
+func (f *failover) current() *upstream {
+	return f.upstreams[f.active.Load()]
+}
+
+// resume keeps the upstream prev had active, if it is still listed, so that a reload
+// doesn't switch back to an upstream prev found down
+func (f *failover) resume(prev *failover) {
+	active := prev.current().addr
+	for i, u := range f.upstreams {
+		if u.addr == active {
+			f.active.Store(int32(i))
+		}
+	}
+	for i, u := range f.upstreams {
+		f.metrics.activeUpstream.WithLabelValues(u.addr).Set(boolGauge(i == int(f.active.Load())))
+	}
+}
+
+// run checks the health of the upstreams every interval until stop is closed or the
+// failover is stopped
+func (f *failover) run(ctx context.Context, stop <-chan struct{}) {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.check(ctx)
+		case <-stop:
+			return
+		case <-f.stopped:
+			return
+		}
+	}
+}
+
+// stop ends the health checks once a reload replaced the failover
+func (f *failover) stop() {
+	f.closeOnce.Do(func() { close(f.stopped) })
+}
+
+// check pings every upstream and switches to another one when the active upstream is
+// down, or to a higher ranked one that recovered if failing back
+func (f *failover) check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, f.interval)
+	defer cancel()
+
+	errs := make([]error, len(f.upstreams))
+	var wg sync.WaitGroup
+	for i, u := range f.upstreams {
+		wg.Add(1)
+		go func(i int, u *upstream) {
+			defer wg.Done()
+			errs[i] = u.client.PingContext(ctx)
+		}(i, u)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		u := f.upstreams[i]
+		if err == nil {
+			if f.failures[i] >= f.threshold {
+				f.logger.Info("upstream recovered", "upstream", u.addr)
+			}
+			f.failures[i] = 0
+			continue
+		}
+		f.failures[i]++
+		if f.failures[i] == f.threshold {
+			f.logger.Warn("upstream down", "upstream", u.addr, "error", err)
+		}
+	}
+
+	active := int(f.active.Load())
+	healthy := func(i int) bool { return f.failures[i] < f.threshold }
+	next := active
+	for i := range f.upstreams {
+		if healthy(i) && (!healthy(active) || f.failback && i < active) {
+			next = i
+			break
+		}
+	}
+	if next == active {
+		return
+	}
+
+	from, to := f.upstreams[active], f.upstreams[next]
+	f.active.Store(int32(next))
+	f.logger.Warn("failing over", "from", from.addr, "to", to.addr)
+	f.metrics.failovers.WithLabelValues(from.addr, to.addr).Inc()
+	f.metrics.activeUpstream.WithLabelValues(from.addr).Set(0)
+	f.metrics.activeUpstream.WithLabelValues(to.addr).Set(1)
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// maxGrafanaBody is the largest request body accepted by the Grafana endpoints
+const maxGrafanaBody = 1 << 20
+
+// grafanaRange is the time range of a Grafana request
+type grafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// grafanaQuery is the body of POST /grafana/query
+type grafanaQuery struct {
+	Range         grafanaRange    `json:"range"`
+	IntervalMs    int64           `json:"intervalMs"`
+	MaxDataPoints int64           `json:"maxDataPoints"`
+	Targets       []grafanaTarget `json:"targets"`
+}
+
+// grafanaTarget is a series asked for by a panel: a key, or a shell pattern matching
+// several
+type grafanaTarget struct {
+	Target string `json:"target"`
+	RefID  string `json:"refId"`
+	// Type is timeserie or table
+	Type string `json:"type"`
+	// Data, or Payload in newer versions of the plugin, carries the target's options
+	Data    grafanaTargetOptions `json:"data"`
+	Payload grafanaTargetOptions `json:"payload"`
+}
+
+// grafanaTargetOptions are the options a target can set in its JSON payload
+type grafanaTargetOptions struct {
+	// Aggregation combines the points of each interval: avg, min, max, sum or count
+	Aggregation gtsdb.Aggregation `json:"aggregation"`
+}
+
+// UnmarshalJSON ignores payloads that aren't objects, which older plugins send as ""
+func (o *grafanaTargetOptions) UnmarshalJSON(data []byte) error {
+	if len(data) == 0 || data[0] != '{' {
+		return nil
+	}
+	type options grafanaTargetOptions
+	return json.Unmarshal(data, (*options)(o))
+}
+
+// grafanaSeries is a time series of a query response, with [value, ms] datapoints
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	RefID      string       `json:"refId,omitempty"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// grafanaTable is a table of a query response
+type grafanaTable struct {
+	Type    string          `json:"type"`
+	RefID   string          `json:"refId,omitempty"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]any         `json:"rows"`
+}
+
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// grafanaAnnotationQuery is the body of POST /grafana/annotations
+type grafanaAnnotationQuery struct {
+	Range      grafanaRange `json:"range"`
+	Annotation struct {
+		Name  string `json:"name"`
+		Query string `json:"query"`
+	} `json:"annotation"`
+}
+
+// handleGrafana serves the endpoints of Grafana's JSON datasource (SimpleJSON) under
+// /grafana/: the root answers the connection test, /search lists keys, /query reads
+// series downsampled to the panel's resolution, and /annotations turns the points of a
+// key into annotations
+func (r *relay) handleGrafana(w http.ResponseWriter, req *http.Request) {
+	path := strings.TrimPrefix(req.URL.Path, "/grafana")
+	if path == "/" || path == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("use POST"))
+		return
+	}
+	handle, ok := map[string]func(context.Context, *json.Decoder) (any, int, error){
+		"/search":      r.grafanaSearch,
+		"/query":       r.grafanaQuery,
+		"/annotations": r.grafanaAnnotations,
+	}[path]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown Grafana endpoint %s", req.URL.Path))
+		return
+	}
+	result, status, err := handle(req.Context(), json.NewDecoder(http.MaxBytesReader(w, req.Body, maxGrafanaBody)))
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// grafanaSearch lists the keys starting with the target, or matching it when it is a
+// shell pattern, for the metric picker and template variables
+func (r *relay) grafanaSearch(ctx context.Context, dec *json.Decoder) (any, int, error) {
+	var body struct {
+		Target string `json:"target"`
+	}
+	if err := dec.Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		return nil, http.StatusBadRequest, err
+	}
+	keys, err := r.listTenantKeys(ctx, body.Target)
+	if err != nil {
+		r.logger.Error("listing keys for Grafana failed", "target", body.Target, "error", err)
+		return nil, http.StatusBadGateway, err
+	}
+	return keys, 0, nil
+}
+
+// grafanaQuery reads the series of the targets over the range, downsampled so that no
+// more than maxDataPoints come back and intervals are no shorter than intervalMs
+func (r *relay) grafanaQuery(ctx context.Context, dec *json.Decoder) (any, int, error) {
+	var q grafanaQuery
+	if err := dec.Decode(&q); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	start, end := q.Range.From.Unix(), q.Range.To.Unix()
+	if q.Range.From.IsZero() || end < start {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid range")
+	}
+	downsample := grafanaInterval(end-start, q.IntervalMs, q.MaxDataPoints)
+	t := tenantFrom(ctx)
+
+	results := make([]any, 0, len(q.Targets))
+	for _, target := range q.Targets {
+		if target.Target == "" {
+			continue
+		}
+		options := target.Payload
+		if options.Aggregation == "" {
+			options = target.Data
+		}
+		switch options.Aggregation {
+		case gtsdb.AggregateDefault, gtsdb.AggregateAvg, gtsdb.AggregateMin, gtsdb.AggregateMax, gtsdb.AggregateSum, gtsdb.AggregateCount:
+		default:
+			return nil, http.StatusBadRequest, fmt.Errorf("%s: unknown aggregation %q", target.Target, options.Aggregation)
+		}
+		keys := []string{target.Target}
+		if strings.ContainsAny(target.Target, "*?[") {
+			var err error
+			if keys, err = r.listTenantKeys(ctx, target.Target); err != nil {
+				r.logger.Error("listing keys for Grafana failed", "target", target.Target, "error", err)
+				return nil, http.StatusBadGateway, err
+			}
+		}
+		table := grafanaTable{Type: "table", RefID: target.RefID, Rows: [][]any{},
+			Columns: []grafanaColumn{{"Time", "time"}, {"Key", "string"}, {"Value", "number"}}}
+		for _, key := range keys {
+			if err := checkKey(key); err != nil {
+				return nil, http.StatusBadRequest, err
+			}
+			points, err := r.clientFor(t.key(key)).ReadAggregatedContext(ctx, t.key(key), start, end, downsample, options.Aggregation)
+			switch {
+			case errors.Is(err, gtsdb.ErrNoData), errors.Is(err, gtsdb.ErrKeyNotFound):
+				// A panel shows an empty series rather than an error
+			case errors.Is(err, gtsdb.ErrInvalidTimeRange), errors.Is(err, gtsdb.ErrRangeTooLarge), errors.Is(err, gtsdb.ErrMalformedQuery):
+				return nil, http.StatusBadRequest, fmt.Errorf("%s: %w", key, err)
+			case err != nil:
+				r.logger.Error("query for Grafana failed", "key", key, "error", err)
+				return nil, http.StatusBadGateway, fmt.Errorf("%s: %w", key, err)
+			}
+			// JSON can't carry NaN or Inf, so they are left out as gaps
+			points = slices.DeleteFunc(points, func(p gtsdb.DataPoint) bool {
+				return math.IsNaN(p.Value) || math.IsInf(p.Value, 0)
+			})
+			if target.Type == "table" {
+				for _, p := range points {
+					table.Rows = append(table.Rows, []any{p.Timestamp.UnixMilli(), t.strip(p.Key), p.Value})
+				}
+				continue
+			}
+			series := grafanaSeries{Target: key, RefID: target.RefID, Datapoints: make([][2]float64, 0, len(points))}
+			for _, p := range points {
+				series.Datapoints = append(series.Datapoints, [2]float64{p.Value, float64(p.Timestamp.UnixMilli())})
+			}
+			results = append(results, series)
+		}
+		if target.Type == "table" {
+			results = append(results, table)
+		}
+	}
+	return results, 0, nil
+}
+
+// grafanaInterval returns the downsampling interval, in seconds, of a query spanning
+// span seconds: at least intervalMs, and long enough to return no more than
+// maxDataPoints. 0 reads raw points once intervals would be a second or less.
+func grafanaInterval(span, intervalMs, maxDataPoints int64) int {
+	interval := float64(intervalMs) / 1000
+	if maxDataPoints > 0 {
+		interval = max(interval, float64(span)/float64(maxDataPoints))
+	}
+	if interval <= 1 {
+		return 0
+	}
+	return int(math.Ceil(interval))
+}
+
+// grafanaAnnotations returns the points of the key named by the annotation's query as
+// annotations, each titled with the key and telling its value
+func (r *relay) grafanaAnnotations(ctx context.Context, dec *json.Decoder) (any, int, error) {
+	var q grafanaAnnotationQuery
+	if err := dec.Decode(&q); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	key := strings.TrimSpace(q.Annotation.Query)
+	if key == "" {
+		return []any{}, 0, nil
+	}
+	if err := checkKey(key); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	t := tenantFrom(ctx)
+	points, err := r.clientFor(t.key(key)).ReadPointsContext(ctx, t.key(key), q.Range.From.Unix(), q.Range.To.Unix(), 0)
+	switch {
+	case errors.Is(err, gtsdb.ErrNoData), errors.Is(err, gtsdb.ErrKeyNotFound):
+	case err != nil:
+		r.logger.Error("annotations for Grafana failed", "key", key, "error", err)
+		return nil, http.StatusBadGateway, err
+	}
+	type annotation struct {
+		Annotation any    `json:"annotation"`
+		Time       int64  `json:"time"`
+		Title      string `json:"title"`
+		Text       string `json:"text"`
+	}
+	result := make([]annotation, 0, len(points))
+	for _, p := range points {
+		result = append(result, annotation{
+			Annotation: q.Annotation,
+			Time:       p.Timestamp.UnixMilli(),
+			Title:      key,
+			Text:       fmt.Sprintf("%s = %g", key, p.Value),
+		})
+	}
+	return result, 0, nil
+}
+
+// listTenantKeys lists the keys of the tenant of ctx like listKeys, by the names the
+// tenant knows them by
+func (r *relay) listTenantKeys(ctx context.Context, target string) ([]string, error) {
+	t := tenantFrom(ctx)
+	keys, err := r.listKeys(ctx, t.key(target))
+	for i, key := range keys {
+		keys[i] = t.strip(key)
+	}
+	return keys, err
+}
+
+// listKeys lists the keys starting with target, or matching it when it is a shell
+// pattern, asking every shard when sharding
+func (r *relay) listKeys(ctx context.Context, target string) ([]string, error) {
+	set := r.upstreams.Load()
+	clients := []*gtsdb.TSDBClient{r.clientFor(target)}
+	if set.shards != nil {
+		clients = clients[:0]
+		for _, u := range set.list {
+			clients = append(clients, u.client)
+		}
+	}
+	keys := []string{}
+	for _, client := range clients {
+		var found []string
+		var err error
+		if strings.ContainsAny(target, "*?[") {
+			found, err = client.ListKeysMatchingContext(ctx, target)
+		} else {
+			found, err = client.ListKeysContext(ctx, target)
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, found...)
+	}
+	slices.Sort(keys)
+	return slices.Compact(keys), nil
+}
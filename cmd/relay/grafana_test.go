@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// readUpstream is a GTSDB server answering reads with the records of replies, by key,
+// and recording the commands it was sent
+type readUpstream struct {
+	ln      net.Listener
+	replies map[string]string
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func newReadUpstream(t *testing.T, replies map[string]string) *readUpstream {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &readUpstream{ln: ln, replies: replies}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return s
+}
+
+func (s *readUpstream) handle(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		s.mu.Lock()
+		s.commands = append(s.commands, scanner.Text())
+		s.mu.Unlock()
+		key, _, _ := strings.Cut(scanner.Text(), ",")
+		if reply, ok := s.replies[key]; ok {
+			fmt.Fprintln(conn, reply)
+		} else {
+			fmt.Fprintf(conn, "ERR %s no such key %s\n", gtsdb.CodeKeyNotFound, key)
+		}
+	}
+}
+
+// sent returns the commands the server was sent
+func (s *readUpstream) sent() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// newQueryRelay creates a relay reading from upstream, to test the handlers querying it
+func newQueryRelay(t *testing.T, upstream string) *relay {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.Upstream = upstream
+	dial := func(cfg config, addr string) (*gtsdb.TSDBClient, error) {
+		return gtsdb.NewTSDBClient(addr, gtsdb.WithPoolSize(0, 1), gtsdb.WithDialTimeout(time.Second))
+	}
+	r, err := newRelay(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), dial, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, u := range r.upstreams.Load().list {
+			u.client.Close()
+		}
+	})
+	return r
+}
+
+func TestGrafanaQuery(t *testing.T) {
+	server := newReadUpstream(t, map[string]string{
+		"temp": "temp,1700000000,21.5|temp,1700000060,22",
+		"hum":  "hum,1700000000,40|hum,1700000030,NaN|hum,1700000060,+Inf|hum,1700000090,41",
+	})
+	r := newQueryRelay(t, server.ln.Addr().String())
+	const rangeJSON = `"range":{"from":"2023-11-14T22:00:00Z","to":"2023-11-14T23:00:00Z"}`
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		// want is the JSON of the response when it succeeds
+		want string
+		// command is the read the upstream must have been sent
+		command string
+	}{
+		{"time series", `{` + rangeJSON + `,"targets":[{"target":"temp","refId":"A"}]}`, http.StatusOK,
+			`[{"target":"temp","refId":"A","datapoints":[[21.5,1700000000000],[22,1700000060000]]}]`,
+			"temp,1699999200,1700002800,0"},
+		{"table", `{` + rangeJSON + `,"targets":[{"target":"temp","refId":"B","type":"table"}]}`, http.StatusOK,
+			`[{"type":"table","refId":"B","columns":[{"text":"Time","type":"time"},{"text":"Key","type":"string"},{"text":"Value","type":"number"}],` +
+				`"rows":[[1700000000000,"temp",21.5],[1700000060000,"temp",22]]}]`, ""},
+		{"downsampled", `{` + rangeJSON + `,"maxDataPoints":60,"targets":[{"target":"temp","payload":{"aggregation":"max"}}]}`, http.StatusOK,
+			`[{"target":"temp","datapoints":[[21.5,1700000000000],[22,1700000060000]]}]`,
+			"temp,1699999200,1700002800,60,max"},
+		{"NaN and Inf left out", `{` + rangeJSON + `,"targets":[{"target":"hum","data":""}]}`, http.StatusOK,
+			`[{"target":"hum","datapoints":[[40,1700000000000],[41,1700000090000]]}]`, ""},
+		{"unknown key", `{` + rangeJSON + `,"targets":[{"target":"wind"}]}`, http.StatusOK,
+			`[{"target":"wind","datapoints":[]}]`, ""},
+		{"missing target", `{` + rangeJSON + `,"targets":[{"refId":"A"}]}`, http.StatusOK, `[]`, ""},
+
+		{"missing range", `{"targets":[{"target":"temp"}]}`, http.StatusBadRequest, "", ""},
+		{"inverted range", `{"range":{"from":"2023-11-14T23:00:00Z","to":"2023-11-14T22:00:00Z"},"targets":[{"target":"temp"}]}`,
+			http.StatusBadRequest, "", ""},
+		{"bad timestamp", `{"range":{"from":"yesterday","to":"2023-11-14T23:00:00Z"},"targets":[{"target":"temp"}]}`,
+			http.StatusBadRequest, "", ""},
+		{"range before the epoch", `{"range":{"from":"1969-12-31T00:00:00Z","to":"2023-11-14T23:00:00Z"},"targets":[{"target":"temp"}]}`,
+			http.StatusBadRequest, "", ""},
+		{"unknown aggregation", `{` + rangeJSON + `,"targets":[{"target":"temp","payload":{"aggregation":"median"}}]}`,
+			http.StatusBadRequest, "", ""},
+		{"key with a space", `{` + rangeJSON + `,"targets":[{"target":"room temp"}]}`, http.StatusBadRequest, "", ""},
+		{"key with the record delimiter", `{` + rangeJSON + `,"targets":[{"target":"temp|hum"}]}`, http.StatusBadRequest, "", ""},
+		{"malformed JSON", `{"range":`, http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.handleGrafana(rec, httptest.NewRequest(http.MethodPost, "/grafana/query", strings.NewReader(tt.body)))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var got, want any
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("%v: %s", err, rec.Body)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if gotJSON, _ := json.Marshal(got); string(gotJSON) != mustJSON(t, want) {
+				t.Errorf("got %s, want %s", gotJSON, tt.want)
+			}
+			if tt.command != "" {
+				if sent := server.sent(); len(sent) == 0 || sent[len(sent)-1] != tt.command {
+					t.Errorf("upstream was sent %q, want %q last", sent, tt.command)
+				}
+			}
+		})
+	}
+}
+
+// mustJSON marshals v, which was unmarshalled, back into canonical JSON
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestGrafanaAnnotations(t *testing.T) {
+	server := newReadUpstream(t, map[string]string{"deploys": "deploys,1700000000,3"})
+	r := newQueryRelay(t, server.ln.Addr().String())
+	const rangeJSON = `"range":{"from":"2023-11-14T22:00:00Z","to":"2023-11-14T23:00:00Z"}`
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   string
+	}{
+		{"points", `{` + rangeJSON + `,"annotation":{"name":"Deploys","query":" deploys "}}`, http.StatusOK,
+			`[{"annotation":{"name":"Deploys","query":" deploys "},"time":1700000000000,"title":"deploys","text":"deploys = 3"}]`},
+		{"missing query", `{` + rangeJSON + `,"annotation":{"name":"Deploys"}}`, http.StatusOK, `[]`},
+		{"bad timestamp", `{"range":{"from":1700000000,"to":"2023-11-14T23:00:00Z"},"annotation":{"query":"deploys"}}`,
+			http.StatusBadRequest, ""},
+		{"key with the record delimiter", `{` + rangeJSON + `,"annotation":{"query":"deploys|2"}}`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.handleGrafana(rec, httptest.NewRequest(http.MethodPost, "/grafana/annotations", strings.NewReader(tt.body)))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status == http.StatusOK && strings.TrimSpace(rec.Body.String()) != tt.want {
+				t.Errorf("got %s, want %s", rec.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestGrafanaInterval(t *testing.T) {
+	tests := []struct {
+		span, intervalMs, maxDataPoints int64
+		want                            int
+	}{
+		{3600, 0, 0, 0},
+		{3600, 1000, 0, 0},
+		{3600, 15000, 0, 15},
+		{3600, 1500, 0, 2},
+		{3600, 0, 60, 60},
+		{3600, 0, 7200, 0},
+		{3600, 120000, 60, 120},
+		{3601, 0, 60, 61},
+	}
+	for _, tt := range tests {
+		if got := grafanaInterval(tt.span, tt.intervalMs, tt.maxDataPoints); got != tt.want {
+			t.Errorf("grafanaInterval(%d, %d, %d) = %d, want %d", tt.span, tt.intervalMs, tt.maxDataPoints, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// parseGraphite parses a line of the Graphite plaintext protocol, "metric.path value
+// timestamp", storing the value under the metric path. A missing timestamp or -1, which
+// some senders use for "now", is replaced by now.
+func parseGraphite(line string, now time.Time) ([]gtsdb.DataPoint, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, errEmptyLine
+	}
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, fmt.Errorf("expected \"metric.path value timestamp\"")
+	}
+
+	if err := checkKey(fields[0]); err != nil {
+		return nil, err
+	}
+	value, err := parseValue(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	point := gtsdb.DataPoint{Key: fields[0], Timestamp: now, Value: value}
+	if len(fields) == 3 && fields[2] != "-1" {
+		// Some senders write the timestamp with a fractional part
+		ts, err := strconv.ParseFloat(fields[2], 64)
+		// Negated so that NaN fails too, like negative and out of range timestamps
+		if err != nil || !(ts >= 0 && ts < math.MaxInt64) {
+			return nil, fmt.Errorf("invalid timestamp %q", fields[2])
+		}
+		point.Timestamp = time.Unix(int64(ts), 0)
+	}
+	return []gtsdb.DataPoint{point}, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+func TestParseGraphite(t *testing.T) {
+	now := time.Unix(1700000100, 0)
+	at := time.Unix(1700000000, 0)
+	runParseTests(t, parseGraphite, now, []parseTest{
+		{"full line", "servers.web1.cpu 0.5 1700000000", []gtsdb.DataPoint{point("servers.web1.cpu", at, 0.5)}},
+		{"fractional timestamp", "servers.web1.cpu 0.5 1700000000.75", []gtsdb.DataPoint{point("servers.web1.cpu", at, 0.5)}},
+		{"without timestamp", "servers.web1.cpu 0.5", []gtsdb.DataPoint{point("servers.web1.cpu", now, 0.5)}},
+		{"timestamp -1", "servers.web1.cpu 0.5 -1", []gtsdb.DataPoint{point("servers.web1.cpu", now, 0.5)}},
+		{"missing value", "servers.web1.cpu", nil},
+		{"extra field", "servers.web1.cpu 0.5 1700000000 1", nil},
+		{"bad timestamp", "servers.web1.cpu 0.5 noon", nil},
+		{"negative timestamp", "servers.web1.cpu 0.5 -2", nil},
+		{"NaN timestamp", "servers.web1.cpu 0.5 NaN", nil},
+		{"overflowing timestamp", "servers.web1.cpu 0.5 1e30", nil},
+		{"bad value", "servers.web1.cpu high 1700000000", nil},
+		{"NaN", "servers.web1.cpu NaN 1700000000", nil},
+		{"Inf", "servers.web1.cpu +Inf 1700000000", nil},
+		{"key with a comma", "servers,web1 0.5 1700000000", nil},
+		{"key with the record delimiter", "servers|web1 0.5 1700000000", nil},
+	})
+
+	if _, err := parseGraphite("", now); !errors.Is(err, errEmptyLine) {
+		t.Errorf("blank line returned %v, want errEmptyLine", err)
+	}
+}
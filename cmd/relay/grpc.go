@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcSendBuffer is the number of updates buffered per Subscribe call before they are dropped
+const grpcSendBuffer = 256
+
+// grpcService implements the gtsdb.v1.GTSDB service of proto/gtsdb/v1/gtsdb.proto
+type grpcService interface {
+	writePoints(ctx context.Context, req *writePointsRequest) (*writePointsResponse, error)
+	queryRange(ctx context.Context, req *queryRangeRequest) (*queryRangeResponse, error)
+	subscribe(req *subscribeRequest, stream grpc.ServerStream) error
+}
+
+// grpcServiceDesc describes the service the way protoc-gen-go-grpc would
+var grpcServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gtsdb.v1.GTSDB",
+	HandlerType: (*grpcService)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "WritePoints", Handler: grpcUnaryHandler("WritePoints", grpcService.writePoints)},
+		{MethodName: "QueryRange", Handler: grpcUnaryHandler("QueryRange", grpcService.queryRange)},
+	},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Subscribe",
+		ServerStreams: true,
+		Handler: func(srv any, stream grpc.ServerStream) error {
+			req := new(subscribeRequest)
+			if err := stream.RecvMsg(req); err != nil {
+				return err
+			}
+			return srv.(grpcService).subscribe(req, stream)
+		},
+	}},
+	Metadata: "gtsdb/v1/gtsdb.proto",
+}
+
+// grpcUnaryHandler adapts the unary method of the service to grpc.MethodDesc
+func grpcUnaryHandler[Req any, PReq interface {
+	*Req
+	grpcMessage
+}, Resp grpcMessage](method string, call func(grpcService, context.Context, PReq) (Resp, error)) func(any, context.Context, func(any) error, grpc.UnaryServerInterceptor) (any, error) {
+	return func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		req := PReq(new(Req))
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(grpcService), ctx, req)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/gtsdb.v1.GTSDB/" + method}
+		return interceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+			return call(srv.(grpcService), ctx, req.(PReq))
+		})
+	}
+}
+
+// newGRPCServer creates the server of the gRPC API, secured like the other listeners
+func (r *relay) newGRPCServer() *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.ForceServerCodec(grpcCodec{}),
+		grpc.MaxRecvMsgSize(r.cfg.MaxBodySize),
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			ctx, err := r.authorizeGRPC(ctx, info.FullMethod)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			ctx, err := r.authorizeGRPC(stream.Context(), info.FullMethod)
+			if err != nil {
+				return err
+			}
+			return handler(srv, tenantStream{stream, ctx})
+		}),
+	}
+	if r.tls != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(r.tls.config("h2"))))
+	}
+	server := grpc.NewServer(opts...)
+	server.RegisterService(&grpcServiceDesc, &grpcServer{r: r})
+	return server
+}
+
+// authorizeGRPC checks the bearer token of the authorization metadata of a call when
+// authentication is enabled, returning the context of the call with its tenant
+func (r *relay) authorizeGRPC(ctx context.Context, method string) (context.Context, error) {
+	auth := r.current().auth
+	if auth == nil {
+		return ctx, nil
+	}
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			token, _ = strings.CutPrefix(values[0], "Bearer ")
+		}
+	}
+	t, ok := auth.lookup(strings.TrimSpace(token))
+	if !ok {
+		r.metrics.authFailures.WithLabelValues("grpc").Inc()
+		r.logger.Warn("rejected unauthenticated gRPC call", "remote", peerAddr(ctx), "method", method)
+		return nil, status.Error(codes.Unauthenticated, "missing or invalid token")
+	}
+	return withTenant(ctx, t), nil
+}
+
+// tenantStream is a server stream whose context carries the tenant of the call
+type tenantStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s tenantStream) Context() context.Context {
+	return s.ctx
+}
+
+// peerAddr returns the address of the client of a call
+func peerAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// grpcServer serves the gRPC API from the relay
+type grpcServer struct {
+	r *relay
+}
+
+// writePoints queues the points of the request for the upstream, or none of them if any
+// is invalid
+func (s *grpcServer) writePoints(ctx context.Context, req *writePointsRequest) (*writePointsResponse, error) {
+	r := s.r
+	now, addr := time.Now(), peerAddr(ctx)
+	src := newSource("grpc", addr)
+	src.tenant = tenantFrom(ctx)
+	r.metrics.linesReceived.WithLabelValues("grpc").Inc()
+
+	points := make([]gtsdb.DataPoint, 0, len(req.points))
+	for i, p := range req.points {
+		point := gtsdb.DataPoint{Key: p.key, Timestamp: now, Value: p.value}
+		if p.timestamp != 0 {
+			point.Timestamp = time.Unix(p.timestamp, 0)
+		}
+		err := checkKey(p.key)
+		switch {
+		case err != nil:
+		case math.IsNaN(p.value) || math.IsInf(p.value, 0):
+			err = fmt.Errorf("invalid value %g", p.value)
+		case p.timestamp < 0:
+			err = fmt.Errorf("invalid timestamp %d", p.timestamp)
+		}
+		if err != nil {
+			r.reject(src, formatPoint(point), err)
+		} else {
+			err = r.validate(src, formatPoint(point), []gtsdb.DataPoint{point}, now)
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "point %d: %v", i, err)
+		}
+		points = append(points, point)
+	}
+
+	if current := r.current(); !r.allowSource(current, addr, len(points)) {
+		return nil, status.Errorf(codes.ResourceExhausted, "rate limit of %g points per second exceeded", current.cfg.IPRate)
+	}
+	if _, err := r.admitTenant(src.tenant, len(points)); err != nil {
+		return nil, status.Errorf(codes.ResourceExhausted, "tenant %s: %v", src.tenant.name(), err)
+	}
+	// Wait for room in the backlog rather than dropping, like the HTTP API
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.WriteTimeout)
+	defer cancel()
+	queued, err := r.queue(ctx, src, points)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "backlog full, queued %d of %d points", queued, len(points))
+	}
+	return &writePointsResponse{queued: int64(queued)}, nil
+}
+
+// queryRange returns the points of a key between start and end, which defaults to now
+func (s *grpcServer) queryRange(ctx context.Context, req *queryRangeRequest) (*queryRangeResponse, error) {
+	if err := checkKey(req.key); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.downsample < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid downsample %d", req.downsample)
+	}
+	end := req.end
+	if end == 0 {
+		end = time.Now().Unix()
+	}
+
+	t := tenantFrom(ctx)
+	points, err := s.r.clientFor(t.key(req.key)).ReadPointsContext(ctx, t.key(req.key), req.start, end, int(req.downsample))
+	switch {
+	case errors.Is(err, gtsdb.ErrNoData):
+	case errors.Is(err, gtsdb.ErrKeyNotFound):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, gtsdb.ErrInvalidTimeRange), errors.Is(err, gtsdb.ErrRangeTooLarge), errors.Is(err, gtsdb.ErrMalformedQuery):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case err != nil:
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, status.FromContextError(ctxErr).Err()
+		}
+		s.r.logger.Error("query failed", "key", req.key, "error", err)
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	resp := &queryRangeResponse{points: make([]pointMessage, 0, len(points))}
+	for _, p := range points {
+		resp.points = append(resp.points, pointMessage{key: t.strip(p.Key), timestamp: p.Timestamp.Unix(), value: p.Value})
+	}
+	return resp, nil
+}
+
+// subscribe streams the updates of the keys of the request until the call is cancelled,
+// the relay shuts down or an upstream subscription is lost. A client too slow to keep
+// up loses updates rather than stalling the upstream subscription.
+func (s *grpcServer) subscribe(req *subscribeRequest, stream grpc.ServerStream) error {
+	r, ctx := s.r, stream.Context()
+	if len(req.keys) == 0 {
+		return status.Error(codes.InvalidArgument, "no keys to subscribe to")
+	}
+	// A single subscription per upstream, to the keys it serves
+	byClient := make(map[*gtsdb.TSDBClient][]string)
+	seen := make(map[string]bool, len(req.keys))
+	t := tenantFrom(ctx)
+	for _, key := range req.keys {
+		if err := checkKey(key); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		if key = t.key(key); !seen[key] {
+			seen[key] = true
+			client := r.clientFor(key)
+			byClient[client] = append(byClient[client], key)
+		}
+	}
+
+	log := r.logger.With("remote", peerAddr(ctx))
+	updates := make(chan gtsdb.DataPoint, grpcSendBuffer)
+	lost := make(chan struct{}, len(byClient))
+	var subs []*gtsdb.Subscription
+	defer func() {
+		for _, sub := range subs {
+			sub.Close()
+		}
+	}()
+	for client, keys := range byClient {
+		sub, err := client.SubscribeStreamContext(ctx, keys...)
+		if err != nil {
+			log.Warn("subscribe failed", "keys", keys, "error", err)
+			return status.Errorf(codes.Unavailable, "subscribe %s: %v", strings.Join(keys, ","), err)
+		}
+		subs = append(subs, sub)
+		go func() {
+			for p := range sub.Updates() {
+				select {
+				case updates <- p:
+				default:
+					log.Debug("gRPC client too slow, dropping update", "key", p.Key)
+				}
+			}
+			// Closed by us, or by the client when a reload removed its upstream
+			lost <- struct{}{}
+		}()
+	}
+
+	for {
+		select {
+		case p := <-updates:
+			if err := stream.SendMsg(&pointMessage{key: t.strip(p.Key), timestamp: p.Timestamp.Unix(), value: p.Value}); err != nil {
+				return err
+			}
+		case <-lost:
+			return status.Error(codes.Unavailable, "upstream subscription lost")
+		case <-r.closing:
+			return status.Error(codes.Unavailable, "relay shutting down")
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
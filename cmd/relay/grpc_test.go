@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// pointField encodes a Point as field 1 of a WritePointsRequest
+func pointField(fields ...[]byte) []byte {
+	return bytesField(1, join(fields...))
+}
+
+func TestGRPCWritePoints(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	tests := []struct {
+		name string
+		req  []byte
+		want []gtsdb.DataPoint // nil if the request is rejected
+	}{
+		{"full points", join(
+			pointField(bytesField(1, []byte("temp")), varintField(2, 1700000000), fixed64Field(3, math.Float64bits(21.5))),
+			pointField(bytesField(1, []byte("hum")), varintField(2, 1700000001), fixed64Field(3, math.Float64bits(40))),
+		), []gtsdb.DataPoint{point("temp", at, 21.5), point("hum", at.Add(time.Second), 40)}},
+		{"unknown fields", pointField(
+			bytesField(1, []byte("temp")), varintField(2, 1700000000), fixed64Field(3, math.Float64bits(21.5)), varintField(9, 1),
+		), []gtsdb.DataPoint{point("temp", at, 21.5)}},
+		{"missing value", pointField(bytesField(1, []byte("temp")), varintField(2, 1700000000)),
+			[]gtsdb.DataPoint{point("temp", at, 0)}},
+		{"missing key", pointField(varintField(2, 1700000000), fixed64Field(3, math.Float64bits(21.5))), nil},
+		{"negative timestamp", pointField(
+			bytesField(1, []byte("temp")), varintField(2, uint64(math.MaxUint64)), fixed64Field(3, math.Float64bits(21.5)),
+		), nil},
+		{"NaN", pointField(bytesField(1, []byte("temp")), varintField(2, 1700000000), fixed64Field(3, math.Float64bits(math.NaN()))), nil},
+		{"Inf", pointField(bytesField(1, []byte("temp")), varintField(2, 1700000000), fixed64Field(3, math.Float64bits(math.Inf(-1)))), nil},
+		{"key with a comma", pointField(bytesField(1, []byte("temp,2")), fixed64Field(3, math.Float64bits(21.5))), nil},
+		{"key with the record delimiter", pointField(bytesField(1, []byte("temp|2")), fixed64Field(3, math.Float64bits(21.5))), nil},
+		{"one bad point of two", join(
+			pointField(bytesField(1, []byte("temp")), varintField(2, 1700000000), fixed64Field(3, math.Float64bits(21.5))),
+			pointField(bytesField(1, []byte("hum")), varintField(2, 1700000001), fixed64Field(3, math.Float64bits(math.NaN()))),
+		), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newIdleRelay(t, defaultConfig())
+			req := new(writePointsRequest)
+			if err := req.unmarshal(tt.req); err != nil {
+				t.Fatal(err)
+			}
+			resp, err := (&grpcServer{r: r}).writePoints(context.Background(), req)
+			if tt.want == nil {
+				if status.Code(err) != codes.InvalidArgument {
+					t.Fatalf("got %v, want InvalidArgument", err)
+				}
+				if points := queued(r); len(points) != 0 {
+					t.Errorf("queued %+v from a rejected request", points)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.queued != int64(len(tt.want)) {
+				t.Errorf("queued %d points, want %d", resp.queued, len(tt.want))
+			}
+			checkPoints(t, queued(r), tt.want)
+		})
+	}
+}
+
+func TestGRPCWritePointsDefaultsTimestamp(t *testing.T) {
+	r := newIdleRelay(t, defaultConfig())
+	req := new(writePointsRequest)
+	if err := req.unmarshal(pointField(bytesField(1, []byte("temp")), fixed64Field(3, math.Float64bits(21.5)))); err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	if _, err := (&grpcServer{r: r}).writePoints(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	points := queued(r)
+	if len(points) != 1 || points[0].Key != "temp" || points[0].Value != 21.5 {
+		t.Fatalf("queued %+v", points)
+	}
+	if ts := points[0].Timestamp; ts.Before(before) || ts.After(time.Now()) {
+		t.Errorf("timestamp %v, want the time of the call", ts)
+	}
+}
+
+func TestGRPCMalformedMessage(t *testing.T) {
+	// A point whose length runs past the end of the message
+	truncated := protowire.AppendVarint(protowire.AppendTag(nil, 1, protowire.BytesType), 10)
+	if err := new(writePointsRequest).unmarshal(truncated); err == nil {
+		t.Error("decoded a truncated message")
+	}
+}
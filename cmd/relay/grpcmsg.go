@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The messages of proto/gtsdb/v1/gtsdb.proto, encoded by hand like the remote write and
+// OTLP requests, so the relay needs no generated code
+
+// grpcMessage is a message of the gRPC API
+type grpcMessage interface {
+	marshal() []byte
+	unmarshal(data []byte) error
+}
+
+// grpcCodec encodes the messages of the gRPC API in the protobuf wire format
+type grpcCodec struct{}
+
+func (grpcCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(grpcMessage)
+	if !ok {
+		return nil, fmt.Errorf("cannot marshal %T", v)
+	}
+	return m.marshal(), nil
+}
+
+func (grpcCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(grpcMessage)
+	if !ok {
+		return fmt.Errorf("cannot unmarshal into %T", v)
+	}
+	return m.unmarshal(data)
+}
+
+// Name is the content subtype clients send, application/grpc+proto or application/grpc
+func (grpcCodec) Name() string { return "proto" }
+
+// pointMessage is a Point: { string key = 1; int64 timestamp = 2; double value = 3; }
+type pointMessage struct {
+	key       string
+	timestamp int64
+	value     float64
+}
+
+func (m *pointMessage) marshal() []byte {
+	var b []byte
+	if m.key != "" {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, m.key)
+	}
+	if m.timestamp != 0 {
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(m.timestamp))
+	}
+	if m.value != 0 {
+		b = protowire.AppendTag(b, 3, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(m.value))
+	}
+	return b
+}
+
+func (m *pointMessage) unmarshal(data []byte) error {
+	return decodeMessage(data, func(num protowire.Number, typ protowire.Type, field []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			m.key = string(field)
+		case num == 2 && typ == protowire.VarintType:
+			v, _ := protowire.ConsumeVarint(field)
+			m.timestamp = int64(v)
+		case num == 3 && typ == protowire.Fixed64Type:
+			v, _ := protowire.ConsumeFixed64(field)
+			m.value = math.Float64frombits(v)
+		}
+		return nil
+	})
+}
+
+// appendPoints appends points as the repeated message field num
+func appendPoints(b []byte, num protowire.Number, points []pointMessage) []byte {
+	for i := range points {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendBytes(b, points[i].marshal())
+	}
+	return b
+}
+
+// decodePoints decodes the repeated message field num of data
+func decodePoints(data []byte, num protowire.Number) ([]pointMessage, error) {
+	var points []pointMessage
+	err := decodeMessage(data, func(n protowire.Number, typ protowire.Type, field []byte) error {
+		if n != num || typ != protowire.BytesType {
+			return nil
+		}
+		var p pointMessage
+		if err := p.unmarshal(field); err != nil {
+			return err
+		}
+		points = append(points, p)
+		return nil
+	})
+	return points, err
+}
+
+// writePointsRequest is a WritePointsRequest: { repeated Point points = 1; }
+type writePointsRequest struct {
+	points []pointMessage
+}
+
+func (m *writePointsRequest) marshal() []byte {
+	return appendPoints(nil, 1, m.points)
+}
+
+func (m *writePointsRequest) unmarshal(data []byte) (err error) {
+	m.points, err = decodePoints(data, 1)
+	return err
+}
+
+// writePointsResponse is a WritePointsResponse: { int64 queued = 1; }
+type writePointsResponse struct {
+	queued int64
+}
+
+func (m *writePointsResponse) marshal() []byte {
+	if m.queued == 0 {
+		return nil
+	}
+	b := protowire.AppendTag(nil, 1, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(m.queued))
+}
+
+func (m *writePointsResponse) unmarshal(data []byte) error {
+	return decodeMessage(data, func(num protowire.Number, typ protowire.Type, field []byte) error {
+		if num == 1 && typ == protowire.VarintType {
+			v, _ := protowire.ConsumeVarint(field)
+			m.queued = int64(v)
+		}
+		return nil
+	})
+}
+
+// queryRangeRequest is a QueryRangeRequest:
+//
+//	{ string key = 1; int64 start = 2; int64 end = 3; int32 downsample = 4; }
+type queryRangeRequest struct {
+	key        string
+	start, end int64
+	downsample int32
+}
+
+func (m *queryRangeRequest) marshal() []byte {
+	var b []byte
+	if m.key != "" {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, m.key)
+	}
+	for _, f := range []struct {
+		num   protowire.Number
+		value int64
+	}{{2, m.start}, {3, m.end}, {4, int64(m.downsample)}} {
+		if f.value != 0 {
+			b = protowire.AppendTag(b, f.num, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(f.value))
+		}
+	}
+	return b
+}
+
+func (m *queryRangeRequest) unmarshal(data []byte) error {
+	return decodeMessage(data, func(num protowire.Number, typ protowire.Type, field []byte) error {
+		if num == 1 && typ == protowire.BytesType {
+			m.key = string(field)
+			return nil
+		}
+		if typ != protowire.VarintType {
+			return nil
+		}
+		v, _ := protowire.ConsumeVarint(field)
+		switch num {
+		case 2:
+			m.start = int64(v)
+		case 3:
+			m.end = int64(v)
+		case 4:
+			m.downsample = int32(v)
+		}
+		return nil
+	})
+}
+
+// queryRangeResponse is a QueryRangeResponse: { repeated Point points = 1; }
+type queryRangeResponse struct {
+	points []pointMessage
+}
+
+func (m *queryRangeResponse) marshal() []byte {
+	return appendPoints(nil, 1, m.points)
+}
+
+func (m *queryRangeResponse) unmarshal(data []byte) (err error) {
+	m.points, err = decodePoints(data, 1)
+	return err
+}
+
+// subscribeRequest is a SubscribeRequest: { repeated string keys = 1; }
+type subscribeRequest struct {
+	keys []string
+}
+
+func (m *subscribeRequest) marshal() []byte {
+	var b []byte
+	for _, key := range m.keys {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, key)
+	}
+	return b
+}
+
+func (m *subscribeRequest) unmarshal(data []byte) error {
+	return decodeMessage(data, func(num protowire.Number, typ protowire.Type, field []byte) error {
+		if num == 1 && typ == protowire.BytesType {
+			m.keys = append(m.keys, string(field))
+		}
+		return nil
+	})
+}
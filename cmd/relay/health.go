@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// readyTimeout bounds how long a readiness check waits for the upstreams to answer
+const readyTimeout = 2 * time.Second
+
+// upstreamStatus is how an upstream fares in a readiness check
+type upstreamStatus struct {
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+	SpoolFull bool   `json:"spool_full,omitempty"`
+}
+
+// handleHealth answers GET /healthz as long as the process serves requests
+func (r *relay) handleHealth(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleReady answers GET /readyz with 200 when the relay can forward what it is sent:
+// the upstreams receiving writes answer a ping, the active one only in failover mode,
+// and no spool is full. Otherwise, and while shutting down, it answers 503.
+func (r *relay) handleReady(w http.ResponseWriter, req *http.Request) {
+	set := r.upstreams.Load()
+	targets := set.list
+	if set.failover != nil {
+		targets = []*upstream{set.failover.current()}
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), readyTimeout)
+	defer cancel()
+	statuses := make([]upstreamStatus, len(targets))
+	var wg sync.WaitGroup
+	for i, u := range targets {
+		wg.Add(1)
+		go func(i int, u *upstream) {
+			defer wg.Done()
+			err := u.client.PingContext(ctx)
+			statuses[i] = upstreamStatus{Reachable: err == nil, SpoolFull: u.spool != nil && u.spool.full()}
+			if err != nil {
+				statuses[i].Error = err.Error()
+			}
+		}(i, u)
+	}
+	wg.Wait()
+
+	ready := !r.isClosing()
+	upstreams := make(map[string]upstreamStatus, len(targets))
+	for i, status := range statuses {
+		upstreams[targets[i].addr] = status
+		ready = ready && status.Reachable && !status.SpoolFull
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(struct {
+		Ready     bool                      `json:"ready"`
+		Upstreams map[string]upstreamStatus `json:"upstreams"`
+	}{ready, upstreams})
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// queryPoint is a point returned by GET /query, in the shape of the JSON format
+type queryPoint struct {
+	Key   string  `json:"key"`
+	Ts    int64   `json:"ts"`
+	Value float64 `json:"value"`
+}
+
+// httpHandler serves POST /write, GET /query, the /subscribe WebSocket, /metrics, the
+// /healthz and /readyz probes, the Prometheus remote write and OTLP metrics receivers
+// and the Grafana JSON datasource under /grafana/, along with GET /alerts when alerting
+// rules are set and POST /admin/reload when an admin token is set. All but /metrics, the probes and the admin endpoint require a
+// token when authentication is enabled; the admin endpoint requires the admin token.
+func (r *relay) httpHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(r.metrics.registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", r.handleHealth)
+	mux.HandleFunc("/readyz", r.handleReady)
+	if r.cfg.AdminToken != "" {
+		mux.Handle("/admin/reload", r.requireAdminToken(http.HandlerFunc(r.handleReload)))
+	}
+	mux.Handle("/write", r.requireToken(http.HandlerFunc(r.handleWrite)))
+	mux.Handle("/query", r.requireToken(http.HandlerFunc(r.handleQuery)))
+	mux.Handle("/subscribe", r.requireToken(http.HandlerFunc(r.handleSubscribe)))
+	mux.Handle("/api/v1/write", r.requireToken(http.HandlerFunc(r.handleRemoteWrite)))
+	mux.Handle("/v1/metrics", r.requireToken(http.HandlerFunc(r.handleOTLPMetrics)))
+	mux.Handle("/grafana/", r.requireToken(http.HandlerFunc(r.handleGrafana)))
+	if r.alerts != nil {
+		mux.Handle("/alerts", r.requireToken(http.HandlerFunc(r.handleAlerts)))
+	}
+	return mux
+}
+
+// handleWrite queues the points of the request body for the upstream. The body is one
+// JSON value when sent as application/json, and lines in the format given by the format
+// parameter or the relay's format otherwise. Nothing is queued if any line is invalid.
+func (r *relay) handleWrite(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("use POST"))
+		return
+	}
+
+	parse := r.parse
+	if format := req.URL.Query().Get("format"); format != "" {
+		cfg := r.cfg
+		cfg.Format = format
+		var err error
+		if parse, err = newParser(cfg); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	now, src := time.Now(), requestSource(req)
+	body := http.MaxBytesReader(w, req.Body, int64(r.cfg.MaxBodySize))
+	var points []gtsdb.DataPoint
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType == "application/json" {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			r.writeBodyError(w, err)
+			return
+		}
+		r.metrics.linesReceived.WithLabelValues("http").Inc()
+		if points, err = parseJSON(string(data), now); err != nil && !errors.Is(err, errEmptyLine) {
+			r.reject(src, string(data), err)
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := r.validate(src, string(data), points, now); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	} else {
+		lines := newLineReader(body, r.cfg.MaxLineLength)
+		received := r.metrics.linesReceived.WithLabelValues("http")
+		for n := 1; ; n++ {
+			line, err := lines.next()
+			if errors.Is(err, errLineTooLong) {
+				r.lineTooLong("http")
+				writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("line %d longer than %d bytes", n, r.cfg.MaxLineLength))
+				return
+			}
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				r.writeBodyError(w, err)
+				return
+			}
+			parsed, err := parse(line, now)
+			if errors.Is(err, errEmptyLine) {
+				continue
+			}
+			received.Inc()
+			if err != nil {
+				r.reject(src, line, err)
+			} else {
+				err = r.validate(src, line, parsed, now)
+			}
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("line %d: %w", n, err))
+				return
+			}
+			points = append(points, parsed...)
+		}
+	}
+
+	if r.rateLimitRequest(w, req, len(points)) {
+		return
+	}
+	// Wait for room in the backlog rather than dropping, so HTTP clients feel the back pressure
+	ctx, cancel := context.WithTimeout(req.Context(), r.cfg.WriteTimeout)
+	defer cancel()
+	if queued, err := r.queue(ctx, src, points); err != nil {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("backlog full, queued %d of %d points", queued, len(points)))
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleQuery returns the points of key between start and end, in Unix seconds, as a
+// JSON array. end defaults to now and downsample to none.
+func (r *relay) handleQuery(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("use GET"))
+		return
+	}
+
+	params := req.URL.Query()
+	key := params.Get("key")
+	if err := checkKey(key); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	start, err := strconv.ParseInt(params.Get("start"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid start %q", params.Get("start")))
+		return
+	}
+	end := time.Now().Unix()
+	if s := params.Get("end"); s != "" {
+		if end, err = strconv.ParseInt(s, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid end %q", s))
+			return
+		}
+	}
+	downsample := 0
+	if s := params.Get("downsample"); s != "" {
+		if downsample, err = strconv.Atoi(s); err != nil || downsample < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid downsample %q", s))
+			return
+		}
+	}
+
+	t := tenantFrom(req.Context())
+	points, err := r.clientFor(t.key(key)).ReadPointsContext(req.Context(), t.key(key), start, end, downsample)
+	switch {
+	case errors.Is(err, gtsdb.ErrNoData):
+	case errors.Is(err, gtsdb.ErrKeyNotFound):
+		writeError(w, http.StatusNotFound, err)
+		return
+	case errors.Is(err, gtsdb.ErrInvalidTimeRange), errors.Is(err, gtsdb.ErrRangeTooLarge), errors.Is(err, gtsdb.ErrMalformedQuery):
+		writeError(w, http.StatusBadRequest, err)
+		return
+	case err != nil:
+		r.logger.Error("query failed", "key", key, "error", err)
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	result := make([]queryPoint, 0, len(points))
+	for _, p := range points {
+		result = append(result, queryPoint{Key: t.strip(p.Key), Ts: p.Timestamp.Unix(), Value: p.Value})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// requestSource describes the measurements of an HTTP request
+func requestSource(req *http.Request) source {
+	src := newSource("http", req.RemoteAddr)
+	src.tenant = tenantFrom(req.Context())
+	return src
+}
+
+// writeError replies with status and the error as a JSON object
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": strings.TrimSpace(err.Error())})
+}
+
+// writeBodyError answers a write whose body couldn't be read, with a 413 when it is
+// over the maximum body size
+func (r *relay) writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		r.metrics.limited.WithLabelValues("body_size").Inc()
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("body larger than %d bytes", tooLarge.Limit))
+		return
+	}
+	writeError(w, http.StatusBadRequest, err)
+}
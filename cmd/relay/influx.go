@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// influxPrecisions maps the precisions of InfluxDB line protocol timestamps to their unit
+var influxPrecisions = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+// newInfluxParser returns a parser of InfluxDB line protocol, as sent by Telegraf:
+// "measurement,tag=value field=value timestamp". Every numeric or boolean field becomes
+// a point of the key built by template, where {measurement} and {field} stand for their
+// names and any other placeholder for the value of that tag. String fields are skipped.
+func newInfluxParser(template, precision string) (parseFunc, error) {
+	t, err := parseKeyTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+	unit, ok := influxPrecisions[precision]
+	if !ok {
+		return nil, fmt.Errorf("unknown influx precision %q", precision)
+	}
+
+	return func(line string, now time.Time) ([]gtsdb.DataPoint, error) {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			return nil, errEmptyLine
+		}
+
+		sections := splitUnescaped(line, ' ')
+		if len(sections) < 2 || len(sections) > 3 {
+			return nil, fmt.Errorf("expected measurement, fields and optional timestamp")
+		}
+		timestamp := now
+		if len(sections) == 3 {
+			ts, err := strconv.ParseInt(sections[2], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timestamp %q", sections[2])
+			}
+			timestamp = time.Unix(0, ts*int64(unit))
+		}
+
+		series := splitUnescaped(sections[0], ',')
+		measurement := unescapeInflux(series[0])
+		if measurement == "" {
+			return nil, fmt.Errorf("missing measurement")
+		}
+		tags := make(map[string]string, len(series)-1)
+		for _, tag := range series[1:] {
+			name, value, ok := cutUnescaped(tag, '=')
+			if !ok || name == "" || value == "" {
+				return nil, fmt.Errorf("invalid tag %q", tag)
+			}
+			tags[unescapeInflux(name)] = unescapeInflux(value)
+		}
+
+		var points []gtsdb.DataPoint
+		for _, field := range splitUnescaped(sections[1], ',') {
+			name, raw, ok := cutUnescaped(field, '=')
+			if !ok || name == "" || raw == "" {
+				return nil, fmt.Errorf("invalid field %q", field)
+			}
+			value, numeric, err := parseInfluxValue(raw)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", name, err)
+			}
+			if !numeric {
+				continue
+			}
+			field := unescapeInflux(name)
+			key, err := t.expand(func(placeholder string) (string, bool) {
+				switch placeholder {
+				case "measurement":
+					return measurement, true
+				case "field":
+					return field, true
+				}
+				value, ok := tags[placeholder]
+				return value, ok
+			})
+			if err != nil {
+				return nil, err
+			}
+			points = append(points, gtsdb.DataPoint{Key: key, Timestamp: timestamp, Value: value})
+		}
+		if len(points) == 0 {
+			return nil, fmt.Errorf("no numeric fields")
+		}
+		return points, nil
+	}, nil
+}
+
+// parseInfluxValue parses a field value, reporting false for string fields
+func parseInfluxValue(raw string) (float64, bool, error) {
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true, nil
+	}
+	if raw[0] == '"' {
+		if len(raw) < 2 || raw[len(raw)-1] != '"' {
+			return 0, false, fmt.Errorf("unterminated string")
+		}
+		return 0, false, nil
+	}
+	switch digits := raw[:len(raw)-1]; raw[len(raw)-1] {
+	case 'i':
+		n, err := strconv.ParseInt(digits, 10, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid integer %q", raw)
+		}
+		return float64(n), true, nil
+	case 'u':
+		n, err := strconv.ParseUint(digits, 10, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid unsigned integer %q", raw)
+		}
+		return float64(n), true, nil
+	}
+	v, err := parseValue(raw)
+	return v, err == nil, err
+}
+
+// splitUnescaped splits s at every sep that is neither escaped with a backslash nor
+// inside a double-quoted string
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// cutUnescaped is like strings.Cut at the first unescaped sep
+func cutUnescaped(s string, sep byte) (string, string, bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+// unescapeInflux removes the backslashes escaping commas, spaces and equals signs
+func unescapeInflux(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(", =", s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
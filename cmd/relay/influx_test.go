@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+func TestInfluxParser(t *testing.T) {
+	parse, err := newInfluxParser("{host}.{measurement}.{field}", "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000100, 0)
+	at := time.Unix(1700000000, 0)
+	runParseTests(t, parse, now, []parseTest{
+		{"float field", "cpu,host=web1 usage=0.5 1700000000", []gtsdb.DataPoint{point("web1.cpu.usage", at, 0.5)}},
+		{"typed fields", `cpu,host=web1 a=3i,b=4u,c=t,d=F,label="idle" 1700000000`, []gtsdb.DataPoint{
+			point("web1.cpu.a", at, 3), point("web1.cpu.b", at, 4), point("web1.cpu.c", at, 1), point("web1.cpu.d", at, 0),
+		}},
+		{"without timestamp", "cpu,host=web1 usage=0.5", []gtsdb.DataPoint{point("web1.cpu.usage", now, 0.5)}},
+		{"escaped tag", `cpu,host=web\=1 usage=1 1700000000`, []gtsdb.DataPoint{point("web=1.cpu.usage", at, 1)}},
+		{"tag with a space", `cpu,host=web\ 1,zone=a usage=1 1700000000`, nil},
+		{"measurement with a comma", `cpu\,total,host=web1 usage=1 1700000000`, nil},
+		{"missing fields", "cpu,host=web1", nil},
+		{"missing tag", "cpu,zone=a usage=1 1700000000", nil},
+		{"empty field value", "cpu,host=web1 usage= 1700000000", nil},
+		{"only string fields", `cpu,host=web1 label="idle" 1700000000`, nil},
+		{"bad timestamp", "cpu,host=web1 usage=1 noon", nil},
+		{"bad integer", "cpu,host=web1 usage=1.5i 1700000000", nil},
+		{"NaN", "cpu,host=web1 usage=NaN 1700000000", nil},
+		{"Inf", "cpu,host=web1 usage=-Inf 1700000000", nil},
+		{"key with the record delimiter", "cpu,host=web|1 usage=1 1700000000", nil},
+	})
+
+	// Precision scales timestamps, and other templates place the parts elsewhere
+	parse, err = newInfluxParser("{measurement}_{field}", "ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runParseTests(t, parse, now, []parseTest{
+		{"milliseconds", "mem free=2 1700000000250", []gtsdb.DataPoint{point("mem_free", time.UnixMilli(1700000000250), 2)}},
+	})
+
+	for _, template := range []string{"{host", "{}.{field}"} {
+		if _, err := newInfluxParser(template, "s"); err == nil {
+			t.Errorf("template %q was accepted", template)
+		}
+	}
+	if _, err := newInfluxParser("{measurement}", "min"); err == nil {
+		t.Error("precision min was accepted")
+	}
+}
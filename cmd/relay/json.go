@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// jsonPoint is a point of the JSON format. Ts is in Unix seconds and optional.
+type jsonPoint struct {
+	Key   string   `json:"key"`
+	Ts    *int64   `json:"ts"`
+	Value *float64 `json:"value"`
+}
+
+// parseJSON parses a line holding a JSON point, {"key":"sensor1","ts":1700000000,"value":25.5},
+// or an array of them. Either the whole line is forwarded or none of it.
+func parseJSON(line string, now time.Time) ([]gtsdb.DataPoint, error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil, errEmptyLine
+	}
+
+	var decoded []jsonPoint
+	if line[0] == '[' {
+		if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+	} else {
+		var p jsonPoint
+		if err := json.Unmarshal([]byte(line), &p); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		decoded = []jsonPoint{p}
+	}
+
+	points := make([]gtsdb.DataPoint, 0, len(decoded))
+	for i, p := range decoded {
+		if err := checkKey(p.Key); err != nil {
+			return nil, fmt.Errorf("point %d: %w", i, err)
+		}
+		if p.Value == nil {
+			return nil, fmt.Errorf("point %d: missing value", i)
+		}
+		point := gtsdb.DataPoint{Key: p.Key, Timestamp: now, Value: *p.Value}
+		if p.Ts != nil {
+			if *p.Ts < 0 {
+				return nil, fmt.Errorf("point %d: invalid timestamp %d", i, *p.Ts)
+			}
+			point.Timestamp = time.Unix(*p.Ts, 0)
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+func TestParseJSON(t *testing.T) {
+	now := time.Unix(1700000100, 0)
+	at := time.Unix(1700000000, 0)
+	runParseTests(t, parseJSON, now, []parseTest{
+		{"point", `{"key":"sensor1","ts":1700000000,"value":25.5}`, []gtsdb.DataPoint{point("sensor1", at, 25.5)}},
+		{"without timestamp", `{"key":"sensor1","value":25.5}`, []gtsdb.DataPoint{point("sensor1", now, 25.5)}},
+		{"array", `[{"key":"a","ts":1700000000,"value":1},{"key":"b","value":0}]`, []gtsdb.DataPoint{
+			point("a", at, 1), point("b", now, 0),
+		}},
+		{"invalid JSON", `{"key":"sensor1",`, nil},
+		{"missing value", `{"key":"sensor1","ts":1700000000}`, nil},
+		{"missing key", `{"ts":1700000000,"value":1}`, nil},
+		{"string value", `{"key":"sensor1","value":"25.5"}`, nil},
+		{"negative timestamp", `{"key":"sensor1","ts":-1,"value":1}`, nil},
+		{"fractional timestamp", `{"key":"sensor1","ts":1700000000.5,"value":1}`, nil},
+		{"string timestamp", `{"key":"sensor1","ts":"noon","value":1}`, nil},
+		{"NaN", `{"key":"sensor1","value":NaN}`, nil},
+		{"Inf", `{"key":"sensor1","value":1e999}`, nil},
+		{"key with a comma", `{"key":"sensor,1","value":1}`, nil},
+		{"key with the record delimiter", `{"key":"sensor|1","value":1}`, nil},
+		// One bad point rejects the whole line
+		{"array with a bad point", `[{"key":"a","value":1},{"key":"b"}]`, nil},
+	})
+
+	if _, err := parseJSON(" ", now); !errors.Is(err, errEmptyLine) {
+		t.Errorf("blank line returned %v, want errEmptyLine", err)
+	}
+}
+
+func TestParseHandshake(t *testing.T) {
+	cfg := defaultConfig()
+	parse, ok, err := parseHandshake("FORMAT json", cfg)
+	if err != nil || !ok {
+		t.Fatalf("got %v, %v for a json handshake", ok, err)
+	}
+	points, err := parse(`{"key":"sensor1","ts":1700000000,"value":1}`, time.Now())
+	if err != nil || len(points) != 1 {
+		t.Fatalf("handshake parser returned %+v, %v", points, err)
+	}
+
+	if _, ok, err := parseHandshake("sensor1,1700000000,1", cfg); ok || err != nil {
+		t.Errorf("got %v, %v for a data line, want no handshake", ok, err)
+	}
+	if _, ok, err := parseHandshake("FORMAT xml", cfg); !ok || err == nil {
+		t.Errorf("got %v, %v for an unknown format, want an error", ok, err)
+	}
+}
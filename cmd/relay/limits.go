@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ipBucketIdle is how long the bucket of a source that stopped sending is kept
+const ipBucketIdle = time.Minute
+
+// tokenBucket lets through rate lines per second on average, in bursts of up to a
+// second's worth. A batch larger than a burst passes when the bucket is full and is
+// paid back before anything else passes.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, now time.Time) *tokenBucket {
+	burst := max(rate, 1)
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// allow takes n tokens, reporting false and taking none if there aren't enough
+func (b *tokenBucket) allow(n int, now time.Time) bool {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < min(float64(n), b.burst) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// ipLimiter rate limits the lines of each source address, whichever listener they
+// arrive on
+type ipLimiter struct {
+	rate float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+func newIPLimiter(rate float64) *ipLimiter {
+	return &ipLimiter{rate: rate, buckets: make(map[string]*tokenBucket), swept: time.Now()}
+}
+
+// allow takes n lines from the budget of the source at addr
+func (l *ipLimiter) allow(addr string, n int) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) > ipBucketIdle {
+		for source, b := range l.buckets {
+			if now.Sub(b.last) > ipBucketIdle {
+				delete(l.buckets, source)
+			}
+		}
+		l.swept = now
+	}
+	b, ok := l.buckets[host]
+	if !ok {
+		b = newTokenBucket(l.rate, now)
+		l.buckets[host] = b
+	}
+	return b.allow(n, now)
+}
+
+// allowSource reports whether the source at addr may send n more lines under the
+// limits of s, counting it as limited if not
+func (r *relay) allowSource(s *settings, addr string, n int) bool {
+	if s.ipLimiter == nil || s.ipLimiter.allow(addr, n) {
+		return true
+	}
+	r.metrics.limited.WithLabelValues("ip_rate").Inc()
+	return false
+}
+
+// rateLimitRequest answers 429 when the source of req, or its tenant, may not send n
+// more points, reporting whether it did
+func (r *relay) rateLimitRequest(w http.ResponseWriter, req *http.Request, n int) bool {
+	s := r.current()
+	if !r.allowSource(s, req.RemoteAddr, n) {
+		r.logger.Debug("rate limited request", "remote", req.RemoteAddr, "path", req.URL.Path, "points", n)
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusTooManyRequests, fmt.Errorf("rate limit of %g points per second exceeded", s.cfg.IPRate))
+		return true
+	}
+	t := tenantFrom(req.Context())
+	retry, err := r.admitTenant(t, n)
+	if err == nil {
+		return false
+	}
+	r.logger.Debug("tenant limited request", "tenant", t.name(), "path", req.URL.Path, "points", n, "error", err)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+	writeError(w, http.StatusTooManyRequests, fmt.Errorf("tenant %s: %w", t.name(), err))
+	return true
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// errLineTooLong is returned by lineReader for a line longer than its limit
+var errLineTooLong = errors.New("line too long")
+
+// lineReader reads lines of at most max bytes. Unlike bufio.Scanner, which stops for
+// good on a longer line, it skips that line, reports it with errLineTooLong and goes on
+// with the next one, so a sensor sending one bad line doesn't lose its connection.
+type lineReader struct {
+	r    *bufio.Reader
+	max  int
+	line []byte
+}
+
+func newLineReader(r io.Reader, max int) *lineReader {
+	return &lineReader{r: bufio.NewReaderSize(r, min(4096, max)), max: max}
+}
+
+// next returns the next line, without its \n or \r\n ending. A last line missing its
+// newline is returned at the end of the input, then io.EOF.
+func (l *lineReader) next() (string, error) {
+	l.line = l.line[:0]
+	tooLong := false
+	for {
+		// ReadSlice never buffers more than the reader's size, so a long line is
+		// dropped as it is read rather than held in memory
+		chunk, err := l.r.ReadSlice('\n')
+		if !tooLong {
+			l.line = append(l.line, chunk...)
+			if tooLong = len(trimLineEnd(l.line)) > l.max; tooLong {
+				l.line = l.line[:0]
+			}
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		switch {
+		case tooLong:
+			return "", errLineTooLong
+		case err == nil, errors.Is(err, io.EOF) && len(l.line) > 0:
+			return string(trimLineEnd(l.line)), nil
+		default:
+			return "", err
+		}
+	}
+}
+
+func trimLineEnd(line []byte) []byte {
+	return bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+}
+
+// lineTooLong counts a line of listener over the maximum line length
+func (r *relay) lineTooLong(listener string) {
+	r.metrics.limited.WithLabelValues("line_length").Inc()
+	r.metrics.oversizedLines.WithLabelValues(listener).Inc()
+}
@@ -0,0 +1,107 @@
+// a server listen to 5554 tcp and forward the measurements to gtsdb
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+func main() {
+	os.Exit(run())
+}
+
+// run runs the relay until it fails or is told to stop, returning the exit code
+func run() int {
+	cfg, err := loadConfig(os.Args[1:], os.Getenv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		return 2
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel}))
+
+	dial := func(cfg config, addr string) (*gtsdb.TSDBClient, error) {
+		opts := []gtsdb.Option{
+			gtsdb.WithDialTimeout(cfg.DialTimeout),
+			gtsdb.WithReadTimeout(cfg.ReadTimeout),
+			gtsdb.WithWriteTimeout(cfg.WriteTimeout),
+			gtsdb.WithLogger(logger.With("component", "gtsdb")),
+		}
+		if cfg.WriteAcks {
+			opts = append(opts, gtsdb.WithWriteAcks())
+		}
+		if cfg.UpstreamProxy != "" {
+			dial, err := newProxyDialer(cfg.UpstreamProxy)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, gtsdb.WithDialContext(dial))
+		}
+		if cfg.SpoolDir != "" || (len(upstreamAddrs(cfg.Upstream)) > 1 && cfg.UpstreamMode != "shard") {
+			// Spooled measurements wait for an upstream, and replicas or standbys cover
+			// for it, so the relay may start without it
+			opts = append(opts, gtsdb.WithPoolSize(0, 8))
+		}
+		return gtsdb.NewTSDBClient(addr, opts...)
+	}
+	reloadConfig := func() (config, error) {
+		return loadConfig(os.Args[1:], os.Getenv, os.Stderr)
+	}
+	r, err := newRelay(cfg, logger, dial, reloadConfig)
+	if err != nil {
+		logger.Error("starting relay failed", "error", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Forwarding outlives the signal, until the shutdown deadline
+	forwardCtx, abortForward := context.WithCancel(context.Background())
+	defer abortForward()
+	errc, err := r.start(forwardCtx)
+	if err != nil {
+		logger.Error("listen failed", "error", err)
+		return 1
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for range hup {
+			if _, err := r.reload(); err != nil {
+				logger.Error("reloading configuration failed, keeping the current one", "error", err)
+			}
+		}
+	}()
+
+	code := 0
+	select {
+	case <-ctx.Done():
+		logger.Info("shutting down", "timeout", cfg.ShutdownTimeout)
+	case err := <-errc:
+		logger.Error("relay failed", "error", err)
+		code = 1
+	}
+	// A second signal kills the relay right away
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	context.AfterFunc(shutdownCtx, abortForward)
+	if err := r.shutdown(shutdownCtx); err != nil {
+		logger.Error("shutdown incomplete", "error", err)
+		code = 1
+	}
+	logger.Info("relay stopped")
+	return code
+}
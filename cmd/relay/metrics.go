@@ -0,0 +1,164 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "gtsdb_relay"
+
+// relayMetrics are the relay's operational metrics, served on /metrics by the HTTP API
+type relayMetrics struct {
+	registry *prometheus.Registry
+
+	connsAccepted prometheus.Counter
+	connsActive   prometheus.Gauge
+	linesReceived *prometheus.CounterVec
+
+	udpDatagrams prometheus.Counter
+	udpInvalid   prometheus.Counter
+	udpDropped   prometheus.Counter
+
+	authFailures   *prometheus.CounterVec
+	limited        *prometheus.CounterVec
+	oversizedLines *prometheus.CounterVec
+
+	rejected           *prometheus.CounterVec
+	deadLettersDropped prometheus.Counter
+
+	failovers      *prometheus.CounterVec
+	activeUpstream *prometheus.GaugeVec
+
+	pointsForwarded *prometheus.CounterVec
+	upstreamErrors  *prometheus.CounterVec
+	forwardLatency  *prometheus.HistogramVec
+
+	tenantPoints    *prometheus.CounterVec
+	tenantLimited   *prometheus.CounterVec
+	tenantQuotaUsed *prometheus.GaugeVec
+
+	alertsFiring       *prometheus.GaugeVec
+	alertsSilenced     *prometheus.CounterVec
+	alertNotifications *prometheus.CounterVec
+}
+
+func newRelayMetrics() *relayMetrics {
+	m := &relayMetrics{
+		registry: prometheus.NewRegistry(),
+		connsAccepted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "connections_accepted_total",
+			Help:      "Sensor connections accepted on the TCP listener.",
+		}),
+		connsActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "connections_active",
+			Help:      "Sensor connections currently open on the TCP listener.",
+		}),
+		linesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "lines_received_total",
+			Help:      "Lines received from sensors, valid or not, by listener; an MQTT message, a JSON write request or a gRPC WritePoints call counts as one.",
+		}, []string{"listener"}),
+		udpDatagrams: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "udp_datagrams_total",
+			Help:      "Datagrams received on the UDP listener.",
+		}),
+		udpInvalid: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "udp_invalid_datagrams_total",
+			Help:      "Datagrams with at least one line that could not be parsed or broke the validation rules.",
+		}),
+		udpDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "udp_dropped_datagrams_total",
+			Help:      "Datagrams with at least one point dropped because the backlog was full or the sender exceeded its rate.",
+		}),
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "auth_failures_total",
+			Help:      "Connections, datagrams and requests rejected for lacking a valid token, by listener.",
+		}, []string{"listener"}),
+		limited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "limited_total",
+			Help:      "Connections, lines and requests rejected by the limits, by limit.",
+		}, []string{"limit"}),
+		oversizedLines: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "oversized_lines_total",
+			Help:      "Lines longer than the maximum line length, by listener. TCP connections skip them, UDP datagrams drop them and HTTP writes are rejected.",
+		}, []string{"listener"}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rejected_lines_total",
+			Help:      "Lines and data points rejected by the parser or the validation rules, by reason: parse, key, value or timestamp.",
+		}, []string{"reason"}),
+		deadLettersDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "dead_letters_dropped_total",
+			Help:      "Rejected lines not recorded because the dead-letter sink fell behind.",
+		}),
+		failovers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "upstream_failovers_total",
+			Help:      "Switches of the active upstream, by the upstream left and the one taken over by.",
+		}, []string{"from", "to"}),
+		activeUpstream: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "upstream_active",
+			Help:      "Whether an upstream receives the writes and queries in failover mode.",
+		}, []string{"upstream"}),
+		pointsForwarded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "points_forwarded_total",
+			Help:      "Points written to an upstream, replayed from its spool included, by upstream.",
+		}, []string{"upstream"}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "upstream_errors_total",
+			Help:      "Batch writes an upstream failed, replays of its spool included, by upstream.",
+		}, []string{"upstream"}),
+		forwardLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "forward_latency_seconds",
+			Help:      "Time from receiving the oldest point of a batch to an upstream taking the batch, by upstream. Spooled batches are not observed.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+		}, []string{"upstream"}),
+		tenantPoints: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tenant_points_total",
+			Help:      "Points accepted from each tenant.",
+		}, []string{"tenant"}),
+		tenantLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tenant_limited_total",
+			Help:      "Lines and requests of tenants rejected by their limits, by tenant and limit: rate or quota.",
+		}, []string{"tenant", "limit"}),
+		tenantQuotaUsed: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "tenant_quota_used_points",
+			Help:      "Points each tenant wrote in its current quota period.",
+		}, []string{"tenant"}),
+		alertsFiring: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "alerts_firing",
+			Help:      "Alerts currently firing, by rule.",
+		}, []string{"rule"}),
+		alertsSilenced: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "alerts_silenced_total",
+			Help:      "Alerts that fired again within the dedup window of their rule and were not notified, by rule.",
+		}, []string{"rule"}),
+		alertNotifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "alert_notifications_total",
+			Help:      "Alert notifications by notifier and result: sent, failed after retries, or dropped because the notifier fell behind.",
+		}, []string{"notifier", "result"}),
+	}
+	m.registry.MustRegister(m.connsAccepted, m.connsActive, m.linesReceived,
+		m.udpDatagrams, m.udpInvalid, m.udpDropped, m.authFailures, m.limited, m.oversizedLines, m.rejected, m.deadLettersDropped,
+		m.failovers, m.activeUpstream, m.pointsForwarded, m.upstreamErrors, m.forwardLatency,
+		m.tenantPoints, m.tenantLimited, m.tenantQuotaUsed, m.alertsFiring, m.alertsSilenced, m.alertNotifications)
+	return m
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttConfig configures the MQTT bridge, which is disabled without a broker
+type mqttConfig struct {
+	// Broker is the broker's URL, e.g. tcp://localhost:1883 or ssl://broker:8883
+	Broker   string `yaml:"broker"`
+	ClientID string `yaml:"client_id"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// Topics lists the comma-separated topic filters to subscribe to
+	Topics string `yaml:"topics"`
+	QoS    int    `yaml:"qos"`
+	// Key builds the key of a message from its topic, where {topic} is the whole topic
+	// with / replaced by . and {1}, {2}... are its levels
+	Key string `yaml:"key"`
+	// ValueField is the dotted path of the value in JSON payloads; when empty the
+	// payload is the value itself
+	ValueField string `yaml:"value_field"`
+	// TimeField is the dotted path of the Unix timestamp in JSON payloads; when empty,
+	// or missing from a payload, the time of arrival is used
+	TimeField string `yaml:"time_field"`
+}
+
+// mqttMapping turns MQTT messages into points
+type mqttMapping struct {
+	key        keyTemplate
+	valueField []string
+	timeField  []string
+}
+
+func newMQTTMapping(cfg mqttConfig) (*mqttMapping, error) {
+	key, err := parseKeyTemplate(cfg.Key)
+	if err != nil {
+		return nil, err
+	}
+	m := &mqttMapping{key: key}
+	if cfg.ValueField != "" {
+		m.valueField = strings.Split(cfg.ValueField, ".")
+	}
+	if cfg.TimeField != "" {
+		m.timeField = strings.Split(cfg.TimeField, ".")
+	}
+	return m, nil
+}
+
+// point extracts the point carried by a message
+func (m *mqttMapping) point(topic string, payload []byte, now time.Time) (gtsdb.DataPoint, error) {
+	levels := strings.Split(topic, "/")
+	key, err := m.key.expand(func(placeholder string) (string, bool) {
+		if placeholder == "topic" {
+			return strings.ReplaceAll(topic, "/", "."), true
+		}
+		n, err := strconv.Atoi(placeholder)
+		if err != nil || n < 1 || n > len(levels) {
+			return "", false
+		}
+		return levels[n-1], true
+	})
+	if err != nil {
+		return gtsdb.DataPoint{}, err
+	}
+	point := gtsdb.DataPoint{Key: key, Timestamp: now}
+
+	if m.valueField == nil {
+		point.Value, err = parseValue(strings.TrimSpace(string(payload)))
+		return point, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return gtsdb.DataPoint{}, fmt.Errorf("invalid JSON payload: %w", err)
+	}
+	value, ok := lookupJSON(doc, m.valueField)
+	if !ok {
+		return gtsdb.DataPoint{}, fmt.Errorf("payload has no %s", strings.Join(m.valueField, "."))
+	}
+	if point.Value, err = jsonNumber(value); err != nil {
+		return gtsdb.DataPoint{}, err
+	}
+	if m.timeField == nil {
+		return point, nil
+	}
+	if ts, ok := lookupJSON(doc, m.timeField); ok {
+		seconds, err := jsonNumber(ts)
+		if err != nil || seconds < 0 {
+			return gtsdb.DataPoint{}, fmt.Errorf("invalid timestamp %v", ts)
+		}
+		point.Timestamp = time.Unix(int64(seconds), 0)
+	}
+	return point, nil
+}
+
+// lookupJSON follows path through nested JSON objects
+func lookupJSON(doc any, path []string) (any, bool) {
+	for _, name := range path {
+		object, ok := doc.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if doc, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// jsonNumber reads a value from a JSON number, a numeric string or a boolean
+func jsonNumber(v any) (float64, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return parseValue(v.String())
+	case string:
+		return parseValue(strings.TrimSpace(v))
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("invalid value %v", v)
+	}
+}
+
+// startMQTT connects to the broker and forwards the messages of the configured topics.
+// The client keeps reconnecting, and resubscribing, whenever the broker is unreachable.
+func (r *relay) startMQTT() (mqtt.Client, error) {
+	cfg := r.cfg.MQTT
+	mapping, err := newMQTTMapping(cfg)
+	if err != nil {
+		return nil, err
+	}
+	log := r.logger.With("broker", cfg.Broker)
+	src := source{listener: "mqtt"}
+	if u, err := url.Parse(cfg.Broker); err == nil {
+		src.remote = u.Hostname()
+	}
+
+	filters := make(map[string]byte)
+	for _, topic := range strings.Split(cfg.Topics, ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			filters[topic] = byte(cfg.QoS)
+		}
+	}
+	received := r.metrics.linesReceived.WithLabelValues("mqtt")
+	handle := func(_ mqtt.Client, msg mqtt.Message) {
+		received.Inc()
+		now := time.Now()
+		point, err := mapping.point(msg.Topic(), msg.Payload(), now)
+		// The dead-letter sink records the topic along with the payload
+		line := msg.Topic() + " " + string(msg.Payload())
+		if err != nil {
+			r.reject(src, line, err)
+		} else {
+			err = r.validate(src, line, []gtsdb.DataPoint{point}, now)
+		}
+		if err != nil {
+			log.Debug("rejected MQTT message", "topic", msg.Topic(), "error", err)
+			return
+		}
+		r.enqueue(log, src, []gtsdb.DataPoint{point})
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetConnectTimeout(r.cfg.DialTimeout).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			log.Info("connected to MQTT broker")
+			token := c.SubscribeMultiple(filters, handle)
+			// Handlers must not block the client
+			go func() {
+				if token.WaitTimeout(r.cfg.WriteTimeout) && token.Error() != nil {
+					log.Error("MQTT subscribe failed", "error", token.Error())
+				}
+			}()
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Warn("MQTT connection lost", "error", err)
+		})
+
+	client := mqtt.NewClient(opts)
+	// With connect retry the token only completes once connected, so don't wait for it
+	client.Connect()
+	return client, nil
+}
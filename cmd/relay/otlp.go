@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// otlpNoRecordedValue is the data point flag marking a point without a value
+const otlpNoRecordedValue = 1
+
+// otlpPoint is a gauge or sum data point of an OTLP export, with the attributes of the
+// data point and of the resource that produced it
+type otlpPoint struct {
+	name     string
+	attrs    map[string]string
+	resource map[string]string
+	timeNano uint64
+	value    float64
+}
+
+// handleOTLPMetrics receives OTLP/HTTP metric exports, as protobuf or JSON, and queues
+// their gauge and sum data points. Histograms and summaries are skipped.
+func (r *relay) handleOTLPMetrics(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("use POST"))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxRemoteWriteBody))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	var exported []otlpPoint
+	switch mediaType {
+	case "application/x-protobuf":
+		exported, err = decodeOTLPMetrics(body)
+	case "application/json":
+		exported, err = decodeOTLPMetricsJSON(body)
+	default:
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type %q", mediaType))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	points := make([]gtsdb.DataPoint, 0, len(exported))
+	for _, p := range exported {
+		key, err := r.otlpKey(p)
+		if err != nil {
+			r.logger.Debug("rejected OTLP data point", "metric", p.name, "error", err)
+			continue
+		}
+		if math.IsNaN(p.value) || math.IsInf(p.value, 0) {
+			continue
+		}
+		timestamp := time.Unix(0, int64(p.timeNano))
+		if p.timeNano == 0 {
+			timestamp = time.Now()
+		}
+		points = append(points, gtsdb.DataPoint{Key: key, Timestamp: timestamp, Value: p.value})
+	}
+
+	src := requestSource(req)
+	points = r.keepValid(src, points, time.Now())
+	if r.rateLimitRequest(w, req, len(points)) {
+		return
+	}
+	// Exporters retry on 503, so wait for the backlog rather than dropping
+	ctx, cancel := context.WithTimeout(req.Context(), r.cfg.WriteTimeout)
+	defer cancel()
+	if queued, err := r.queue(ctx, src, points); err != nil {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("backlog full, queued %d of %d data points", queued, len(points)))
+		return
+	}
+	// An empty ExportMetricsServiceResponse reports full success
+	if mediaType == "application/json" {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, "{}")
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+}
+
+// otlpKey builds the key of a data point from the OTLP template, where {__name__} is the
+// metric name and other placeholders are data point or resource attributes, or from the
+// metric name followed by the data point attributes when there is none
+func (r *relay) otlpKey(p otlpPoint) (string, error) {
+	if r.otlpTemplate == nil {
+		return labelsKey(p.name, p.attrs)
+	}
+	return r.otlpTemplate.expand(func(name string) (string, bool) {
+		if name == "__name__" {
+			return p.name, true
+		}
+		value, ok := p.attrs[name]
+		if !ok {
+			value, ok = p.resource[name]
+		}
+		return keySanitizer.Replace(value), ok
+	})
+}
+
+// decodeOTLPMetrics decodes the gauge and sum data points of a protobuf
+// ExportMetricsServiceRequest:
+//
+//	message ExportMetricsServiceRequest { repeated ResourceMetrics resource_metrics = 1; }
+//	message ResourceMetrics { Resource resource = 1; repeated ScopeMetrics scope_metrics = 2; }
+//	message Resource { repeated KeyValue attributes = 1; }
+//	message ScopeMetrics { repeated Metric metrics = 2; }
+//	message Metric { string name = 1; Gauge gauge = 5; Sum sum = 7; }
+//	message Gauge { repeated NumberDataPoint data_points = 1; }
+//	message Sum { repeated NumberDataPoint data_points = 1; }
+//	message NumberDataPoint { repeated KeyValue attributes = 7; fixed64 time_unix_nano = 3;
+//	  double as_double = 4; sfixed64 as_int = 6; uint32 flags = 8; }
+func decodeOTLPMetrics(data []byte) ([]otlpPoint, error) {
+	var points []otlpPoint
+	err := decodeMessage(data, func(num protowire.Number, typ protowire.Type, field []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		resource := make(map[string]string)
+		first := len(points)
+		err := decodeMessage(field, func(num protowire.Number, typ protowire.Type, field []byte) error {
+			switch {
+			case num == 1 && typ == protowire.BytesType:
+				return decodeMessage(field, func(num protowire.Number, typ protowire.Type, field []byte) error {
+					if num == 1 && typ == protowire.BytesType {
+						return decodeKeyValue(field, resource)
+					}
+					return nil
+				})
+			case num == 2 && typ == protowire.BytesType:
+				return decodeMessage(field, func(num protowire.Number, typ protowire.Type, field []byte) error {
+					if num != 2 || typ != protowire.BytesType {
+						return nil
+					}
+					var err error
+					points, err = decodeOTLPMetric(field, points)
+					return err
+				})
+			}
+			return nil
+		})
+		// The resource may follow its metrics on the wire
+		for i := first; i < len(points); i++ {
+			points[i].resource = resource
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTLP request: %w", err)
+	}
+	return points, nil
+}
+
+// decodeOTLPMetric appends the data points of a gauge or sum Metric to points
+func decodeOTLPMetric(data []byte, points []otlpPoint) ([]otlpPoint, error) {
+	var name string
+	var dataPoints [][]byte
+	err := decodeMessage(data, func(num protowire.Number, typ protowire.Type, field []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			name = string(field)
+		case 5, 7:
+			return decodeMessage(field, func(num protowire.Number, typ protowire.Type, field []byte) error {
+				if num == 1 && typ == protowire.BytesType {
+					dataPoints = append(dataPoints, field)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return points, err
+	}
+
+	for _, data := range dataPoints {
+		p := otlpPoint{name: name, attrs: make(map[string]string)}
+		var flags uint64
+		err := decodeMessage(data, func(num protowire.Number, typ protowire.Type, field []byte) error {
+			switch {
+			case num == 7 && typ == protowire.BytesType:
+				return decodeKeyValue(field, p.attrs)
+			case num == 3 && typ == protowire.Fixed64Type:
+				p.timeNano, _ = protowire.ConsumeFixed64(field)
+			case num == 4 && typ == protowire.Fixed64Type:
+				v, _ := protowire.ConsumeFixed64(field)
+				p.value = math.Float64frombits(v)
+			case num == 6 && typ == protowire.Fixed64Type:
+				v, _ := protowire.ConsumeFixed64(field)
+				p.value = float64(int64(v))
+			case num == 8 && typ == protowire.VarintType:
+				flags, _ = protowire.ConsumeVarint(field)
+			}
+			return nil
+		})
+		if err != nil {
+			return points, err
+		}
+		if flags&otlpNoRecordedValue == 0 {
+			points = append(points, p)
+		}
+	}
+	return points, nil
+}
+
+// decodeKeyValue decodes a KeyValue into attrs, formatting its AnyValue as a string:
+//
+//	message KeyValue { string key = 1; AnyValue value = 2; }
+//	message AnyValue { oneof value { string string_value = 1; bool bool_value = 2;
+//	  int64 int_value = 3; double double_value = 4; ... } }
+func decodeKeyValue(data []byte, attrs map[string]string) error {
+	var key, value string
+	err := decodeMessage(data, func(num protowire.Number, typ protowire.Type, field []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			key = string(field)
+		case num == 2 && typ == protowire.BytesType:
+			return decodeMessage(field, func(num protowire.Number, typ protowire.Type, field []byte) error {
+				switch {
+				case num == 1 && typ == protowire.BytesType:
+					value = string(field)
+				case num == 2 && typ == protowire.VarintType:
+					v, _ := protowire.ConsumeVarint(field)
+					value = strconv.FormatBool(v != 0)
+				case num == 3 && typ == protowire.VarintType:
+					v, _ := protowire.ConsumeVarint(field)
+					value = strconv.FormatInt(int64(v), 10)
+				case num == 4 && typ == protowire.Fixed64Type:
+					v, _ := protowire.ConsumeFixed64(field)
+					value = strconv.FormatFloat(math.Float64frombits(v), 'g', -1, 64)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	attrs[key] = value
+	return err
+}
+
+// otlpInt is an int64 of the OTLP JSON encoding, which may be written as a string
+type otlpInt int64
+
+func (n *otlpInt) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	*n = otlpInt(v)
+	return err
+}
+
+type otlpJSONKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue *string  `json:"stringValue"`
+		BoolValue   *bool    `json:"boolValue"`
+		IntValue    *otlpInt `json:"intValue"`
+		DoubleValue *float64 `json:"doubleValue"`
+	} `json:"value"`
+}
+
+type otlpJSONDataPoint struct {
+	Attributes   []otlpJSONKeyValue `json:"attributes"`
+	TimeUnixNano otlpInt            `json:"timeUnixNano"`
+	AsDouble     *float64           `json:"asDouble"`
+	AsInt        *otlpInt           `json:"asInt"`
+	Flags        uint32             `json:"flags"`
+}
+
+type otlpJSONRequest struct {
+	ResourceMetrics []struct {
+		Resource struct {
+			Attributes []otlpJSONKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeMetrics []struct {
+			Metrics []struct {
+				Name  string `json:"name"`
+				Gauge *struct {
+					DataPoints []otlpJSONDataPoint `json:"dataPoints"`
+				} `json:"gauge"`
+				Sum *struct {
+					DataPoints []otlpJSONDataPoint `json:"dataPoints"`
+				} `json:"sum"`
+			} `json:"metrics"`
+		} `json:"scopeMetrics"`
+	} `json:"resourceMetrics"`
+}
+
+// decodeOTLPMetricsJSON decodes the gauge and sum data points of an
+// ExportMetricsServiceRequest in the OTLP JSON encoding
+func decodeOTLPMetricsJSON(data []byte) ([]otlpPoint, error) {
+	var req otlpJSONRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("invalid OTLP request: %w", err)
+	}
+
+	var points []otlpPoint
+	for _, rm := range req.ResourceMetrics {
+		resource := jsonAttributes(rm.Resource.Attributes)
+		for _, sm := range rm.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				var dataPoints []otlpJSONDataPoint
+				if m.Gauge != nil {
+					dataPoints = m.Gauge.DataPoints
+				} else if m.Sum != nil {
+					dataPoints = m.Sum.DataPoints
+				}
+				for _, dp := range dataPoints {
+					if dp.Flags&otlpNoRecordedValue != 0 {
+						continue
+					}
+					p := otlpPoint{name: m.Name, attrs: jsonAttributes(dp.Attributes), resource: resource, timeNano: uint64(dp.TimeUnixNano)}
+					switch {
+					case dp.AsDouble != nil:
+						p.value = *dp.AsDouble
+					case dp.AsInt != nil:
+						p.value = float64(*dp.AsInt)
+					}
+					points = append(points, p)
+				}
+			}
+		}
+	}
+	return points, nil
+}
+
+func jsonAttributes(kvs []otlpJSONKeyValue) map[string]string {
+	attrs := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		switch v := kv.Value; {
+		case v.StringValue != nil:
+			attrs[kv.Key] = *v.StringValue
+		case v.BoolValue != nil:
+			attrs[kv.Key] = strconv.FormatBool(*v.BoolValue)
+		case v.IntValue != nil:
+			attrs[kv.Key] = strconv.FormatInt(int64(*v.IntValue), 10)
+		case v.DoubleValue != nil:
+			attrs[kv.Key] = strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64)
+		default:
+			attrs[kv.Key] = ""
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Protobuf encoders of single fields, concatenated into messages with join
+func bytesField(num protowire.Number, b []byte) []byte {
+	return protowire.AppendBytes(protowire.AppendTag(nil, num, protowire.BytesType), b)
+}
+
+func fixed64Field(num protowire.Number, v uint64) []byte {
+	return protowire.AppendFixed64(protowire.AppendTag(nil, num, protowire.Fixed64Type), v)
+}
+
+func varintField(num protowire.Number, v uint64) []byte {
+	return protowire.AppendVarint(protowire.AppendTag(nil, num, protowire.VarintType), v)
+}
+
+func join(fields ...[]byte) []byte {
+	return bytes.Join(fields, nil)
+}
+
+// stringAttr encodes a KeyValue holding a string
+func stringAttr(key, value string) []byte {
+	return join(bytesField(1, []byte(key)), bytesField(2, bytesField(1, []byte(value))))
+}
+
+// otlpRequest is an export of a gauge, a sum, a point without a recorded value and a
+// histogram, from a resource whose attributes follow its metrics on the wire
+func otlpRequest() []byte {
+	gauge := join(
+		bytesField(1, []byte("cpu.usage")),
+		bytesField(5, bytesField(1, join(
+			bytesField(7, stringAttr("host", "web|1")),
+			fixed64Field(3, 1700000000123456789),
+			fixed64Field(4, math.Float64bits(0.25)),
+		))),
+	)
+	sum := join(
+		bytesField(1, []byte("requests")),
+		bytesField(7, join(
+			bytesField(1, join(
+				bytesField(7, stringAttr("host", "web2")),
+				fixed64Field(3, 1700000001000000000),
+				fixed64Field(6, uint64(42)),
+			)),
+			bytesField(1, join(
+				bytesField(7, stringAttr("host", "web3")),
+				fixed64Field(3, 1700000001000000000),
+				varintField(8, otlpNoRecordedValue),
+			)),
+		)),
+	)
+	histogram := join(bytesField(1, []byte("latency")), bytesField(9, bytesField(1, fixed64Field(3, 1))))
+	resourceMetrics := join(
+		bytesField(2, join(bytesField(2, gauge), bytesField(2, sum), bytesField(2, histogram))),
+		bytesField(1, bytesField(1, stringAttr("service.name", "api"))),
+	)
+	return bytesField(1, resourceMetrics)
+}
+
+// otlpJSON is otlpRequest in the OTLP JSON encoding
+const otlpJSON = `{"resourceMetrics":[{
+	"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"api"}}]},
+	"scopeMetrics":[{"metrics":[
+		{"name":"cpu.usage","gauge":{"dataPoints":[
+			{"attributes":[{"key":"host","value":{"stringValue":"web|1"}}],"timeUnixNano":"1700000000123456789","asDouble":0.25}]}},
+		{"name":"requests","sum":{"dataPoints":[
+			{"attributes":[{"key":"host","value":{"stringValue":"web2"}}],"timeUnixNano":"1700000001000000000","asInt":"42"},
+			{"attributes":[{"key":"host","value":{"stringValue":"web3"}}],"timeUnixNano":"1700000001000000000","flags":1}]}},
+		{"name":"latency","histogram":{"dataPoints":[{"timeUnixNano":"1"}]}}
+	]}]
+}]}`
+
+func TestOTLPMetrics(t *testing.T) {
+	bodies := []struct {
+		contentType string
+		body        []byte
+	}{
+		{"application/x-protobuf", otlpRequest()},
+		{"application/json", []byte(otlpJSON)},
+	}
+	tests := []struct {
+		name     string
+		template string
+		want     []gtsdb.DataPoint
+	}{
+		// Attribute values holding | are sanitized like the other characters keys can't hold
+		{"attributes key", "", []gtsdb.DataPoint{
+			point("cpu.usage.host=web_1", time.Unix(0, 1700000000123456789), 0.25),
+			point("requests.host=web2", time.Unix(1700000001, 0), 42),
+		}},
+		{"template", "{service.name}.{__name__}.{host}", []gtsdb.DataPoint{
+			point("api.cpu.usage.web_1", time.Unix(0, 1700000000123456789), 0.25),
+			point("api.requests.web2", time.Unix(1700000001, 0), 42),
+		}},
+	}
+	for _, b := range bodies {
+		for _, tt := range tests {
+			t.Run(b.contentType+" "+tt.name, func(t *testing.T) {
+				cfg := defaultConfig()
+				cfg.OTLPKey = tt.template
+				r := newIdleRelay(t, cfg)
+
+				req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(b.body))
+				req.Header.Set("Content-Type", b.contentType)
+				rec := httptest.NewRecorder()
+				r.handleOTLPMetrics(rec, req)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status %d: %s", rec.Code, rec.Body)
+				}
+				checkPoints(t, queued(r), tt.want)
+			})
+		}
+	}
+
+	r := newIdleRelay(t, defaultConfig())
+	for _, tt := range []struct {
+		name        string
+		contentType string
+		body        []byte
+		status      int
+	}{
+		{"truncated protobuf", "application/x-protobuf", otlpRequest()[:20], http.StatusBadRequest},
+		{"invalid JSON", "application/json", []byte(`{"resourceMetrics":[`), http.StatusBadRequest},
+		{"other content type", "text/plain", []byte("cpu 1"), http.StatusUnsupportedMediaType},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+		rec := httptest.NewRecorder()
+		r.handleOTLPMetrics(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.status)
+		}
+	}
+}
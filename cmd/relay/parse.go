@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// errEmptyLine is returned for lines carrying nothing to forward
+var errEmptyLine = errors.New("empty line")
+
+// parseFunc parses a line into the points it carries. Points without a timestamp of
+// their own are stamped with now.
+type parseFunc func(line string, now time.Time) ([]gtsdb.DataPoint, error)
+
+// newParser returns the parser of the format the relay is configured for
+func newParser(cfg config) (parseFunc, error) {
+	switch cfg.Format {
+	case "line":
+		return parseLine, nil
+	case "json":
+		return parseJSON, nil
+	case "graphite":
+		return parseGraphite, nil
+	case "influx":
+		return newInfluxParser(cfg.KeyTemplate, cfg.InfluxPrecision)
+	default:
+		return nil, fmt.Errorf("unknown format %q", cfg.Format)
+	}
+}
+
+// handshakePrefix starts the line a connection can open with to pick its own format
+const handshakePrefix = "FORMAT "
+
+// parseHandshake returns the parser chosen by a "FORMAT <name>" line, or false if line
+// isn't a handshake
+func parseHandshake(line string, cfg config) (parseFunc, bool, error) {
+	name, ok := strings.CutPrefix(strings.TrimSpace(line), handshakePrefix)
+	if !ok {
+		return nil, false, nil
+	}
+	cfg.Format = strings.TrimSpace(name)
+	parse, err := newParser(cfg)
+	return parse, true, err
+}
+
+// parseLine parses a "key,timestamp,value" or "key value" line. The timestamp is in
+// Unix seconds.
+func parseLine(line string, now time.Time) ([]gtsdb.DataPoint, error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil, errEmptyLine
+	}
+
+	var key, timestamp, value string
+	if parts := strings.Split(line, ","); len(parts) > 1 {
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("expected key,timestamp,value, got %d fields", len(parts))
+		}
+		key, timestamp, value = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), strings.TrimSpace(parts[2])
+	} else {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("expected \"key value\" or key,timestamp,value")
+		}
+		key, value = fields[0], fields[1]
+	}
+
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	v, err := parseValue(value)
+	if err != nil {
+		return nil, err
+	}
+	point := gtsdb.DataPoint{Key: key, Timestamp: now, Value: v}
+	if timestamp != "" {
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || ts < 0 {
+			return nil, fmt.Errorf("invalid timestamp %q", timestamp)
+		}
+		point.Timestamp = time.Unix(ts, 0)
+	}
+	return []gtsdb.DataPoint{point}, nil
+}
+
+// checkKey rejects keys the GTSDB protocol can't carry, including those holding the
+// | that separates the records of read replies
+func checkKey(key string) error {
+	if key == "" || strings.ContainsAny(key, ", \t\r\n|") {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	return nil
+}
+
+// parseValue parses a finite measurement value
+func parseValue(value string) (float64, error) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	return v, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// parseTest is a line and the points it parses to, or nil if it is rejected
+type parseTest struct {
+	name string
+	line string
+	want []gtsdb.DataPoint
+}
+
+// runParseTests checks that parse turns each line into the points expected of it
+func runParseTests(t *testing.T, parse parseFunc, now time.Time, tests []parseTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, err := parse(tt.line, now)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("parsed %q as %+v, want an error", tt.line, points)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkPoints(t, points, tt.want)
+		})
+	}
+}
+
+// checkPoints fails the test unless got holds exactly the points of want, in order
+func checkPoints(t *testing.T, got, want []gtsdb.DataPoint) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range got {
+		if got[i].Key != want[i].Key || !got[i].Timestamp.Equal(want[i].Timestamp) || got[i].Value != want[i].Value {
+			t.Errorf("point %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+// point builds the expected point of a test
+func point(key string, ts time.Time, value float64) gtsdb.DataPoint {
+	return gtsdb.DataPoint{Key: key, Timestamp: ts, Value: value}
+}
+
+func TestParseLine(t *testing.T) {
+	now := time.Unix(1700000100, 0)
+	at := time.Unix(1700000000, 0)
+	runParseTests(t, parseLine, now, []parseTest{
+		{"full record", "temp,1700000000,21.5", []gtsdb.DataPoint{point("temp", at, 21.5)}},
+		{"spaces around fields", " temp , 1700000000 , 21.5 ", []gtsdb.DataPoint{point("temp", at, 21.5)}},
+		{"key and value", "temp 21.5", []gtsdb.DataPoint{point("temp", now, 21.5)}},
+		{"missing value", "temp,1700000000", nil},
+		{"extra field", "temp,1700000000,21.5,1", nil},
+		{"key only", "temp", nil},
+		{"bad timestamp", "temp,yesterday,21.5", nil},
+		{"negative timestamp", "temp,-1,21.5", nil},
+		{"bad value", "temp,1700000000,warm", nil},
+		{"NaN", "temp,1700000000,NaN", nil},
+		{"Inf", "temp +Inf", nil},
+		{"empty key", ",1700000000,21.5", nil},
+		{"key with a space", "room temp,1700000000,21.5", nil},
+		{"key with the record delimiter", "temp|2,1700000000,21.5", nil},
+	})
+
+	if _, err := parseLine("  ", now); !errors.Is(err, errEmptyLine) {
+		t.Errorf("blank line returned %v, want errEmptyLine", err)
+	}
+}
+
+func TestCheckKey(t *testing.T) {
+	tests := []struct {
+		key string
+		ok  bool
+	}{
+		{"temp", true},
+		{"host-1.cpu_usage", true},
+		{"", false},
+		{"temp,1", false},
+		{"room temp", false},
+		{"temp\t1", false},
+		{"temp\r", false},
+		{"temp\n", false},
+		{"temp|1", false},
+	}
+	for _, tt := range tests {
+		if err := checkKey(tt.key); (err == nil) != tt.ok {
+			t.Errorf("checkKey(%q) = %v, want ok %v", tt.key, err, tt.ok)
+		}
+	}
+}
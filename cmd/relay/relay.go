@@ -0,0 +1,698 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"google.golang.org/grpc"
+)
+
+// receivedPoint is a measurement waiting in the backlog and when it was received
+type receivedPoint struct {
+	gtsdb.DataPoint
+	received time.Time
+}
+
+// relay accepts measurements from sensors and forwards them to the upstream GTSDB servers
+type relay struct {
+	// cfg is the configuration the relay started with; settings holds the parts a
+	// reload changed since
+	cfg      config
+	settings atomic.Pointer[settings]
+	logger   *slog.Logger
+	// upstreams are the servers measurements are forwarded to. Only the forwarder
+	// replaces them, as handed over on swap by a reload.
+	upstreams atomic.Pointer[upstreamSet]
+	swap      chan *upstreamSet
+	// retiring tracks the upstreams a reload removed that still write out their batches
+	retiring sync.WaitGroup
+	// dial creates the clients of upstreams, and loadConfig reads the configuration
+	// again for a reload
+	dial       dialFunc
+	loadConfig func() (config, error)
+	reloadMu   sync.Mutex
+	// ctx aborts writing to the upstreams once the relay started
+	ctx   context.Context
+	parse parseFunc
+	// tenantUsage tracks the rates and quotas of tenants
+	tenantUsage *tenantLedger
+	// tls secures the TCP and HTTP listeners, or is nil to serve them in plaintext
+	tls *tlsServer
+	// deadLetters records the rejected lines, or is nil when they are only counted
+	deadLetters *deadLetterSink
+	// alerts evaluates the alerting rules, or is nil when there are none
+	alerts  *alertEngine
+	metrics *relayMetrics
+	// remoteWriteTemplate builds the keys of remote write series, or is nil to use the
+	// metric name and all labels
+	remoteWriteTemplate keyTemplate
+	// otlpTemplate builds the keys of OTLP data points, or is nil to use the metric name
+	// and data point attributes
+	otlpTemplate keyTemplate
+	// backlog decouples the sensors from the upstream writer, so a slow upstream never
+	// stalls accepting or reading from sensors
+	backlog chan receivedPoint
+
+	listener   net.Listener
+	packetConn net.PacketConn
+	httpServer *http.Server
+	grpcServer *grpc.Server
+	mqttClient mqtt.Client
+
+	// serving tracks the goroutines reading from sensors outside the HTTP server
+	serving sync.WaitGroup
+	connMu  sync.Mutex
+	conns   map[net.Conn]struct{}
+	// closing is closed when the relay starts shutting down
+	closing chan struct{}
+	// stopped is closed once nothing queues measurements any more
+	stopped chan struct{}
+	// flushNow asks the forwarder to write its batch without waiting for the interval
+	flushNow chan struct{}
+	// drained is closed once the forwarder has written out the backlog
+	drained chan struct{}
+}
+
+// newRelay creates a relay forwarding to the upstreams of cfg, with clients created by
+// dial. loadConfig reads the configuration again when the relay is reloaded.
+func newRelay(cfg config, logger *slog.Logger, dial dialFunc, loadConfig func() (config, error)) (*relay, error) {
+	parse, err := newParser(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var remoteWriteTemplate keyTemplate
+	if cfg.RemoteWriteKey != "" {
+		if remoteWriteTemplate, err = parseKeyTemplate(cfg.RemoteWriteKey); err != nil {
+			return nil, err
+		}
+	}
+	var otlpTemplate keyTemplate
+	if cfg.OTLPKey != "" {
+		if otlpTemplate, err = parseKeyTemplate(cfg.OTLPKey); err != nil {
+			return nil, err
+		}
+	}
+	settings, err := newSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+	serverTLS, err := newTLSServer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r := &relay{
+		remoteWriteTemplate: remoteWriteTemplate,
+		otlpTemplate:        otlpTemplate,
+		cfg:                 cfg,
+		logger:              logger,
+		dial:                dial,
+		loadConfig:          loadConfig,
+		swap:                make(chan *upstreamSet),
+		parse:               parse,
+		tls:                 serverTLS,
+		tenantUsage:         newTenantLedger(),
+		metrics:             newRelayMetrics(),
+		backlog:             make(chan receivedPoint, cfg.Backlog),
+		conns:               make(map[net.Conn]struct{}),
+		closing:             make(chan struct{}),
+		stopped:             make(chan struct{}),
+		flushNow:            make(chan struct{}, 1),
+		drained:             make(chan struct{}),
+	}
+	r.settings.Store(settings)
+
+	var upstreams []*upstream
+	for _, addr := range upstreamAddrs(cfg.Upstream) {
+		client, err := dial(cfg, addr)
+		if err != nil {
+			for _, u := range upstreams {
+				u.client.Close()
+			}
+			return nil, fmt.Errorf("connect to upstream %s: %w", addr, err)
+		}
+		upstreams = append(upstreams, newUpstream(cfg, addr, client, logger, r.metrics))
+	}
+	r.upstreams.Store(r.newUpstreamSet(cfg, upstreams))
+	if r.deadLetters, err = newDeadLetterSink(cfg.DeadLetter, r.clientFor); err != nil {
+		return nil, err
+	}
+	if r.alerts, err = newAlertEngine(cfg.Alerts, logger, r.metrics, r.listKeys, r.clientFor); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// start opens the configured listeners and serves them, along with the forwarder.
+// Errors that stop a listener before shutdown are sent on the returned channel.
+func (r *relay) start(ctx context.Context) (<-chan error, error) {
+	errc := make(chan error, 4)
+	failed := func(what string, err error) {
+		select {
+		case <-r.closing:
+		default:
+			errc <- fmt.Errorf("%s: %w", what, err)
+		}
+	}
+
+	r.ctx = ctx
+	set := r.upstreams.Load()
+	var err error
+	if r.cfg.SpoolDir != "" {
+		for _, u := range set.list {
+			if err := u.openSpool(r.cfg); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if r.listener, err = net.Listen("tcp", r.cfg.Listen); err != nil {
+		return nil, err
+	}
+	if r.tls != nil {
+		r.listener = tls.NewListener(r.listener, r.tls.config())
+	}
+	r.logger.Info("relay started", "listen", r.listener.Addr().String(), "backend", r.cfg.Upstream)
+
+	if r.cfg.UDPListen != "" {
+		if r.packetConn, err = net.ListenPacket("udp", r.cfg.UDPListen); err != nil {
+			r.listener.Close()
+			return nil, err
+		}
+		r.logger.Info("UDP listener started", "listen", r.packetConn.LocalAddr().String())
+	}
+
+	var httpListener net.Listener
+	if r.cfg.HTTPListen != "" {
+		if httpListener, err = net.Listen("tcp", r.cfg.HTTPListen); err != nil {
+			r.listener.Close()
+			if r.packetConn != nil {
+				r.packetConn.Close()
+			}
+			return nil, err
+		}
+		if r.tls != nil {
+			// WebSockets need HTTP/1.1
+			httpListener = tls.NewListener(httpListener, r.tls.config("http/1.1"))
+		}
+		r.httpServer = &http.Server{
+			Handler:           r.httpHandler(),
+			ReadHeaderTimeout: 10 * time.Second,
+			// e.g. failed TLS handshakes
+			ErrorLog: slog.NewLogLogger(r.logger.Handler(), slog.LevelWarn),
+		}
+		r.logger.Info("HTTP API started", "listen", httpListener.Addr().String())
+	}
+
+	var grpcListener net.Listener
+	if r.cfg.GRPCListen != "" {
+		if grpcListener, err = net.Listen("tcp", r.cfg.GRPCListen); err != nil {
+			r.listener.Close()
+			if r.packetConn != nil {
+				r.packetConn.Close()
+			}
+			if httpListener != nil {
+				httpListener.Close()
+			}
+			return nil, err
+		}
+		r.grpcServer = r.newGRPCServer()
+		r.logger.Info("gRPC API started", "listen", grpcListener.Addr().String())
+	}
+
+	for _, u := range set.list {
+		go u.run(ctx)
+	}
+	go r.forward()
+	if r.deadLetters != nil {
+		go r.deadLetters.run(ctx, r.stopped, func(err error) {
+			r.logger.Warn("recording rejected line failed", "error", err)
+		})
+	}
+	if set.failover != nil {
+		go set.failover.run(ctx, r.closing)
+	}
+	if r.alerts != nil {
+		go r.alerts.run(ctx, r.closing)
+	}
+	go func() { failed("accept", r.serveTCP(r.listener)) }()
+	if r.packetConn != nil {
+		r.serving.Add(1)
+		go func() {
+			defer r.serving.Done()
+			failed("UDP listener", r.serveUDP(r.packetConn))
+		}()
+	}
+	if r.httpServer != nil {
+		go func() { failed("HTTP server", r.httpServer.Serve(httpListener)) }()
+	}
+	if r.grpcServer != nil {
+		go func() { failed("gRPC server", r.grpcServer.Serve(grpcListener)) }()
+	}
+	if r.cfg.MQTT.Broker != "" {
+		if r.mqttClient, err = r.startMQTT(); err != nil {
+			return nil, err
+		}
+	}
+	return errc, nil
+}
+
+// shutdown stops accepting measurements, lets the connections finish the lines already
+// received and writes the backlog out to the upstream, giving up when ctx is done
+func (r *relay) shutdown(ctx context.Context) error {
+	close(r.closing)
+
+	r.listener.Close()
+	if r.packetConn != nil {
+		r.packetConn.Close()
+	}
+	if r.mqttClient != nil {
+		r.mqttClient.Disconnect(250)
+	}
+	// Expiring the read deadline makes the line readers stop once their buffered lines are handled
+	r.connMu.Lock()
+	for conn := range r.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	r.connMu.Unlock()
+
+	var errs []error
+	if r.httpServer != nil {
+		if err := r.httpServer.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("HTTP server: %w", err))
+		}
+	}
+	if r.grpcServer != nil {
+		// Subscribe calls end on closing, so waiting is bounded by the writes in flight
+		stopped := make(chan struct{})
+		go func() {
+			r.grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			r.grpcServer.Stop()
+			errs = append(errs, fmt.Errorf("gRPC server: %w", ctx.Err()))
+		}
+	}
+	served := make(chan struct{})
+	go func() {
+		r.serving.Wait()
+		close(served)
+	}()
+	select {
+	case <-served:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("connections still open: %w", ctx.Err()))
+	}
+	close(r.stopped)
+
+	select {
+	case <-r.drained:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("%d measurements not forwarded: %w", len(r.backlog), ctx.Err()))
+	}
+	if r.deadLetters != nil {
+		select {
+		case <-r.deadLetters.done:
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("%d rejected lines not recorded: %w", len(r.deadLetters.letters), ctx.Err()))
+		}
+	}
+	for _, u := range r.upstreams.Load().list {
+		u.client.Close()
+	}
+	return errors.Join(errs...)
+}
+
+// dispatch hands b to every upstream, to the active one in failover mode, or splits it
+// between the shards owning its keys. With acknowledged writes it waits for room in a
+// full upstream buffer rather than dropping b, which holds back the backlog and in turn
+// the senders.
+func (r *relay) dispatch(b batch) {
+	offer := func(u *upstream, b batch) {
+		ok := u.offer(b)
+		if !ok && u.acks {
+			ok = u.put(r.ctx, b)
+		}
+		if !ok {
+			u.logger.Warn("upstream buffer full, dropping measurements", "points", len(b.points))
+		}
+	}
+	set := r.upstreams.Load()
+	switch {
+	case set.failover != nil:
+		offer(set.failover.current(), b)
+		return
+	case set.shards == nil:
+		for _, u := range set.list {
+			offer(u, b)
+		}
+		return
+	}
+
+	split := make([][]gtsdb.DataPoint, len(set.list))
+	for _, point := range b.points {
+		i := set.shards.pick(point.Key)
+		split[i] = append(split[i], point)
+	}
+	for i, points := range split {
+		if len(points) > 0 {
+			offer(set.list[i], batch{points: points, received: b.received})
+		}
+	}
+}
+
+// clientFor returns the client serving queries and subscriptions for key: the shard
+// owning it, the active upstream in failover mode, or the first when replicating
+func (r *relay) clientFor(key string) *gtsdb.TSDBClient {
+	set := r.upstreams.Load()
+	switch {
+	case set.shards != nil:
+		return set.list[set.shards.pick(key)].client
+	case set.failover != nil:
+		return set.failover.current().client
+	}
+	return set.list[0].client
+}
+
+// forward hands the queued measurements to the upstreams in batches, once a batch is
+// full or its first point waited for the flush interval. Once nothing queues any more
+// it hands over what is left in the backlog and returns when the upstreams wrote it out.
+func (r *relay) forward() {
+	defer close(r.drained)
+	b := batch{points: make([]gtsdb.DataPoint, 0, r.cfg.BatchSize)}
+	var due <-chan time.Time
+	flush := func() {
+		due = nil
+		if len(b.points) == 0 {
+			return
+		}
+		r.dispatch(b)
+		// The upstreams keep the points, so they can't be reused
+		b = batch{points: make([]gtsdb.DataPoint, 0, r.cfg.BatchSize)}
+	}
+	add := func(point receivedPoint) {
+		if len(b.points) == 0 || point.received.Before(b.received) {
+			b.received = point.received
+		}
+		b.points = append(b.points, point.DataPoint)
+		if len(b.points) >= r.cfg.BatchSize {
+			flush()
+		} else if len(b.points) == 1 {
+			due = time.After(r.cfg.FlushInterval)
+		}
+	}
+
+	for {
+		select {
+		case point := <-r.backlog:
+			add(point)
+		case <-due:
+			flush()
+		case <-r.flushNow:
+			flush()
+		case set := <-r.swap:
+			// What is batched goes to the upstreams it was received for
+			flush()
+			r.retire(r.upstreams.Swap(set), set)
+		case <-r.stopped:
+			for {
+				select {
+				case point := <-r.backlog:
+					add(point)
+				default:
+					flush()
+					set := r.upstreams.Load()
+					for _, u := range set.list {
+						close(u.batches)
+					}
+					for _, u := range set.list {
+						<-u.done
+					}
+					r.retiring.Wait()
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush asks the forwarder to write the points it holds right away
+func (r *relay) flush() {
+	select {
+	case r.flushNow <- struct{}{}:
+	default:
+	}
+}
+
+// prepare applies the rewrite rules and then the transforms to points received from
+// src, and namespaces the keys of tenants, dropping those whose key the rules make
+// invalid and those a transform drops
+func (r *relay) prepare(log *slog.Logger, src source, points []gtsdb.DataPoint) []gtsdb.DataPoint {
+	s := r.current()
+	if len(s.rewriter) == 0 && len(s.transforms) == 0 && src.tenant == nil {
+		return points
+	}
+	kept := points[:0]
+next:
+	for _, point := range points {
+		if len(s.rewriter) > 0 {
+			key, err := s.rewriter.rewrite(point.Key, src)
+			if err != nil {
+				log.Warn("rewritten key invalid, dropping measurement", "key", point.Key, "error", err)
+				continue
+			}
+			point.Key = key
+		}
+		for _, t := range s.transforms {
+			var ok bool
+			if point, ok = t(point); !ok {
+				continue next
+			}
+		}
+		// Last, so that no rule takes a key out of its tenant's namespace
+		point.Key = src.tenant.key(point.Key)
+		kept = append(kept, point)
+	}
+	return kept
+}
+
+// enqueue prepares points received from src and queues them for the upstream, dropping
+// those that don't fit in the backlog. With acknowledged writes it waits for room
+// instead, until the relay stops forwarding. It returns how many were dropped.
+func (r *relay) enqueue(log *slog.Logger, src source, points []gtsdb.DataPoint) int {
+	dropped, now := 0, time.Now()
+	for _, point := range r.prepare(log, src, points) {
+		select {
+		case r.backlog <- receivedPoint{point, now}:
+			continue
+		default:
+		}
+		if r.cfg.WriteAcks {
+			select {
+			case r.backlog <- receivedPoint{point, now}:
+				continue
+			case <-r.ctx.Done():
+			}
+		}
+		log.Warn("backend backlog full, dropping measurement", "key", point.Key)
+		dropped++
+	}
+	return dropped
+}
+
+// queue prepares points received from src and queues them for the upstream, waiting
+// for room in the backlog until ctx is done. It returns how many points were queued.
+func (r *relay) queue(ctx context.Context, src source, points []gtsdb.DataPoint) (int, error) {
+	points, now := r.prepare(r.logger, src, points), time.Now()
+	for i, point := range points {
+		select {
+		case r.backlog <- receivedPoint{point, now}:
+		case <-ctx.Done():
+			return i, ctx.Err()
+		}
+	}
+	return len(points), nil
+}
+
+// serveTCP accepts sensor connections on ln until it is closed
+func (r *relay) serveTCP(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+
+		r.connMu.Lock()
+		select {
+		case <-r.closing:
+			r.connMu.Unlock()
+			conn.Close()
+			continue
+		default:
+		}
+		if maxConns := r.current().cfg.MaxConns; maxConns > 0 && len(r.conns) >= maxConns {
+			r.connMu.Unlock()
+			r.metrics.limited.WithLabelValues("connections").Inc()
+			r.logger.Warn("too many connections, rejecting", "remote", conn.RemoteAddr().String(), "max", maxConns)
+			go rejectConn(conn, fmt.Sprintf("ERR 429 too many connections, limit is %d\n", maxConns))
+			continue
+		}
+		r.conns[conn] = struct{}{}
+		r.serving.Add(1)
+		r.connMu.Unlock()
+		r.metrics.connsAccepted.Inc()
+		r.metrics.connsActive.Inc()
+
+		go func() {
+			defer r.serving.Done()
+			r.handleConn(conn)
+			r.connMu.Lock()
+			delete(r.conns, conn)
+			r.connMu.Unlock()
+			r.metrics.connsActive.Dec()
+		}()
+	}
+}
+
+// rejectConn tells a connection why it is turned away and closes it, without waiting
+// long on a client that doesn't read
+func rejectConn(conn net.Conn, reply string) {
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	io.WriteString(conn, reply)
+}
+
+// handleConn reads the lines of a sensor connection, answering those it can't parse or
+// that exceed the rate or length limits
+func (r *relay) handleConn(c net.Conn) {
+	defer c.Close()
+	log := r.logger.With("remote", c.RemoteAddr().String())
+	log.Debug("connection accepted")
+	defer log.Debug("connection closed")
+
+	src := newSource("tcp", c.RemoteAddr().String())
+	received := r.metrics.linesReceived.WithLabelValues("tcp")
+	parse, first := r.parse, true
+	lines := newLineReader(c, r.cfg.MaxLineLength)
+	// connLimit follows the rate of the settings in effect
+	var connLimit *tokenBucket
+	var connRate float64
+	if auth := r.current().auth; auth != nil {
+		// The auth line isn't logged, it holds the token
+		line, err := lines.next()
+		if err != nil && !errors.Is(err, errLineTooLong) {
+			return
+		}
+		t, ok := auth.authLine(line)
+		if err != nil || !ok {
+			r.metrics.authFailures.WithLabelValues("tcp").Inc()
+			log.Warn("rejected unauthenticated connection")
+			fmt.Fprintf(c, "ERR authentication required: send \"auth <token>\" first\n")
+			return
+		}
+		if src.tenant = t; t != nil {
+			log = log.With("tenant", t.name())
+		}
+	}
+	for {
+		line, err := lines.next()
+		if errors.Is(err, errLineTooLong) {
+			r.lineTooLong("tcp")
+			log.Debug("skipped line too long", "max", r.cfg.MaxLineLength)
+			fmt.Fprintf(c, "ERR 413 line longer than %d bytes\n", r.cfg.MaxLineLength)
+			continue
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !r.isClosing() {
+				log.Warn("read failed", "error", err)
+			}
+			break
+		}
+		log.Debug("received line", "line", line)
+		if first {
+			first = false
+			chosen, ok, err := parseHandshake(line, r.cfg)
+			if err != nil {
+				log.Debug("rejected handshake", "line", line, "error", err)
+				fmt.Fprintf(c, "ERR %v\n", err)
+				return
+			}
+			if ok {
+				parse = chosen
+				continue
+			}
+		}
+		now, s := time.Now(), r.current()
+		points, err := parse(line, now)
+		if errors.Is(err, errEmptyLine) {
+			continue
+		}
+		received.Inc()
+		if s.cfg.ConnRate != connRate {
+			connRate, connLimit = s.cfg.ConnRate, nil
+			if connRate > 0 {
+				connLimit = newTokenBucket(connRate, now)
+			}
+		}
+		if connLimit != nil && !connLimit.allow(1, now) {
+			r.metrics.limited.WithLabelValues("conn_rate").Inc()
+			fmt.Fprintf(c, "ERR 429 rate limit of %g lines per second per connection exceeded\n", connRate)
+			continue
+		}
+		if !r.allowSource(s, c.RemoteAddr().String(), 1) {
+			fmt.Fprintf(c, "ERR 429 rate limit of %g lines per second per address exceeded\n", s.cfg.IPRate)
+			continue
+		}
+		if _, err := r.admitTenant(src.tenant, 1); err != nil {
+			fmt.Fprintf(c, "ERR 429 %v\n", err)
+			continue
+		}
+		if err != nil {
+			r.reject(src, line, err)
+		} else {
+			err = r.validate(src, line, points, now)
+		}
+		if err != nil {
+			log.Debug("rejected line", "line", line, "error", err)
+			fmt.Fprintf(c, "ERR %v\n", err)
+			continue
+		}
+		r.enqueue(log, src, points)
+	}
+	// Don't hold the last lines of a sensor that went away until the interval
+	r.flush()
+}
+
+// reloadTLS reads the certificate, key and client CA files again, for the connections
+// accepted from now on
+func (r *relay) reloadTLS() error {
+	if r.tls == nil {
+		return nil
+	}
+	if err := r.tls.reload(); err != nil {
+		return err
+	}
+	r.logger.Info("TLS certificates reloaded")
+	return nil
+}
+
+func (r *relay) isClosing() bool {
+	select {
+	case <-r.closing:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// newIdleRelay creates a relay that is never started, to test its handlers
+func newIdleRelay(t *testing.T, cfg config) *relay {
+	t.Helper()
+	dial := func(cfg config, addr string) (*gtsdb.TSDBClient, error) { return nil, nil }
+	r, err := newRelay(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), dial, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// lineCounter counts the log entries mentioning a sensor
+type lineCounter struct {
+	n atomic.Int64
+}
+
+func (c *lineCounter) Write(p []byte) (int, error) {
+	if strings.Contains(string(p), "sensor") {
+		c.n.Add(1)
+	}
+	return len(p), nil
+}
+
+func TestSlowBackendDoesNotStallSensors(t *testing.T) {
+	const sensors, lines = 10, 10
+	// The server takes two seconds for all the points
+	server := newFakeUpstream(t, func(s *fakeUpstream) { s.delay = 20 * time.Millisecond })
+	server.up.Store(true)
+	client, err := gtsdb.NewTSDBClient(server.ln.Addr().String(), gtsdb.WithDialTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	logged := &lineCounter{}
+	logger := slog.New(slog.NewTextHandler(logged, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	cfg := defaultConfig()
+	cfg.Listen = "127.0.0.1:0"
+	cfg.Upstream = server.ln.Addr().String()
+	dial := func(cfg config, addr string) (*gtsdb.TSDBClient, error) { return client, nil }
+	r, err := newRelay(cfg, logger, dial, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := r.start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, sensors)
+	for i := 0; i < sensors; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := net.DialTimeout("tcp", r.listener.Addr().String(), time.Second)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer conn.Close()
+			for j := 0; j < lines; j++ {
+				if _, err := fmt.Fprintf(conn, "sensor%d,%d,%d\n", i, 1700000000+j, j); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	// Every line is read long before the server can have stored them
+	deadline := time.Now().Add(time.Second)
+	for logged.n.Load() < sensors*lines {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d lines read while the server was slow", logged.n.Load(), sensors*lines)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := server.received(); n == sensors*lines {
+		t.Fatal("server stored every point already; it isn't slow enough to tell")
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelShutdown()
+	if err := r.shutdown(shutdownCtx); err != nil {
+		t.Fatal(err)
+	}
+	// Shutdown returns once the points are written; the server stores them in its time
+	deadline = time.Now().Add(10 * time.Second)
+	for server.received() < sensors*lines {
+		if time.Now().After(deadline) {
+			t.Fatalf("server received %d of %d points", server.received(), sensors*lines)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// dialFunc creates the client of the upstream at addr
+type dialFunc func(cfg config, addr string) (*gtsdb.TSDBClient, error)
+
+// settings are the parts of the configuration a reload changes while the relay runs.
+// They are replaced as a whole, so a line is handled under either the old or the new
+// settings, never a mix.
+type settings struct {
+	// cfg is the configuration the settings were built from. Outside the settings
+	// below, the relay keeps using the configuration it started with.
+	cfg config
+	// auth checks the tokens of clients, or is nil when they needn't authenticate
+	auth *authenticator
+	// ipLimiter rate limits the lines of each source, or is nil when they aren't limited
+	ipLimiter *ipLimiter
+	// rewriter renames the keys of incoming measurements, then transforms change or
+	// drop them
+	rewriter   rewriter
+	transforms []transform
+	// validator rejects measurements breaking the validation rules, or is nil when there
+	// are none
+	validator *validator
+}
+
+func newSettings(cfg config) (*settings, error) {
+	s := &settings{cfg: cfg}
+	var err error
+	if s.auth, err = newAuthenticator(cfg); err != nil {
+		return nil, err
+	}
+	if s.rewriter, err = newRewriter(cfg.Rewrite); err != nil {
+		return nil, err
+	}
+	if s.transforms, err = newTransforms(cfg.Transform); err != nil {
+		return nil, err
+	}
+	if s.validator, err = newValidator(cfg.Validate); err != nil {
+		return nil, err
+	}
+	if cfg.IPRate > 0 {
+		s.ipLimiter = newIPLimiter(cfg.IPRate)
+	}
+	return s, nil
+}
+
+// upstreamSet is the upstreams measurements are forwarded to and how they are spread
+// over them. A reload changing the upstream settings replaces it as a whole.
+type upstreamSet struct {
+	// list each receive every measurement, or the keys they own when sharding
+	list []*upstream
+	// shards assigns keys to upstreams when sharding
+	shards *hashRing
+	// failover picks the one upstream in use in failover mode
+	failover *failover
+}
+
+// newUpstreamSet spreads measurements over upstreams as cfg says
+func (r *relay) newUpstreamSet(cfg config, upstreams []*upstream) *upstreamSet {
+	set := &upstreamSet{list: upstreams}
+	addrs := make([]string, len(upstreams))
+	for i, u := range upstreams {
+		addrs[i] = u.addr
+	}
+	switch cfg.UpstreamMode {
+	case "shard":
+		set.shards = newHashRing(addrs, cfg.ShardReplicas)
+	case "failover":
+		set.failover = newFailover(cfg, upstreams, r.logger, r.metrics)
+	}
+	return set
+}
+
+// upstream returns the upstream of the set at addr, or nil
+func (set *upstreamSet) upstream(addr string) *upstream {
+	for _, u := range set.list {
+		if u.addr == addr {
+			return u
+		}
+	}
+	return nil
+}
+
+// upstreamSettings reports whether a and b spread measurements the same way over the
+// same upstreams
+func upstreamSettings(a, b config) bool {
+	return a.Upstream == b.Upstream && a.UpstreamMode == b.UpstreamMode && a.ShardReplicas == b.ShardReplicas &&
+		a.HealthInterval == b.HealthInterval && a.HealthFailures == b.HealthFailures && a.Failback == b.Failback
+}
+
+// needsRestart reports whether next changes settings of the configuration the relay
+// started with that a reload can't apply. TLS files are read again, but their paths
+// can't change.
+func needsRestart(started, next config) bool {
+	started.AuthTokens, started.AuthTokenFile = next.AuthTokens, next.AuthTokenFile
+	started.Upstream, started.UpstreamMode, started.ShardReplicas = next.Upstream, next.UpstreamMode, next.ShardReplicas
+	started.HealthInterval, started.HealthFailures, started.Failback = next.HealthInterval, next.HealthFailures, next.Failback
+	started.Rewrite, started.Transform, started.Validate = next.Rewrite, next.Transform, next.Validate
+	started.MaxConns, started.ConnRate, started.IPRate = next.MaxConns, next.ConnRate, next.IPRate
+	started.Tenants = next.Tenants
+	return !reflect.DeepEqual(started, next)
+}
+
+// reload reads the configuration again and applies the auth tokens, tenants, limits,
+// rewrite rules, transforms, validation rules and upstream settings it holds, along
+// with the TLS certificates, without dropping connections. Connections keep
+// authenticated, and the lines they send from now on are handled under the new
+// settings. On error the relay keeps running as it was. It reports whether settings
+// changed that only a restart applies.
+func (r *relay) reload() (restart bool, err error) {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	cfg, err := r.loadConfig()
+	if err != nil {
+		return false, err
+	}
+	next, err := newSettings(cfg)
+	if err != nil {
+		return false, err
+	}
+	if err := r.reloadTLS(); err != nil {
+		return false, err
+	}
+	current := r.current()
+	if !upstreamSettings(current.cfg, cfg) {
+		if err := r.reloadUpstreams(cfg); err != nil {
+			return false, err
+		}
+	}
+	if current.ipLimiter != nil && cfg.IPRate == current.cfg.IPRate {
+		// Don't forgive the sources what they sent
+		next.ipLimiter = current.ipLimiter
+	}
+	r.settings.Store(next)
+
+	restart = needsRestart(r.cfg, cfg)
+	if restart {
+		r.logger.Warn("configuration reloaded, but some changed settings only apply after a restart")
+	} else {
+		r.logger.Info("configuration reloaded")
+	}
+	return restart, nil
+}
+
+// reloadUpstreams hands the forwarder the upstreams of cfg. Upstreams listed before
+// are kept along with their buffers and spools; new ones are dialed, and those no
+// longer listed write out what they were handed before their clients are closed.
+func (r *relay) reloadUpstreams(cfg config) error {
+	old := r.upstreams.Load()
+	var upstreams, added []*upstream
+	discard := func() {
+		for _, u := range added {
+			u.client.Close()
+			if u.spool != nil {
+				u.spool.close()
+			}
+			u.unregister()
+		}
+	}
+	for _, addr := range upstreamAddrs(cfg.Upstream) {
+		if u := old.upstream(addr); u != nil {
+			upstreams = append(upstreams, u)
+			continue
+		}
+		client, err := r.dial(cfg, addr)
+		if err != nil {
+			discard()
+			return fmt.Errorf("upstream %s: %w", addr, err)
+		}
+		u := newUpstream(r.cfg, addr, client, r.logger, r.metrics)
+		added = append(added, u)
+		if r.cfg.SpoolDir != "" {
+			if err := u.openSpool(r.cfg); err != nil {
+				discard()
+				return err
+			}
+		}
+		upstreams = append(upstreams, u)
+	}
+
+	set := r.newUpstreamSet(cfg, upstreams)
+	for _, u := range added {
+		go u.run(r.ctx)
+	}
+	if set.failover != nil {
+		if old.failover != nil {
+			set.failover.resume(old.failover)
+		}
+		go set.failover.run(r.ctx, r.closing)
+	}
+	select {
+	case r.swap <- set:
+	case <-r.closing:
+		r.retire(set, old)
+		return errors.New("relay is shutting down")
+	}
+	r.logger.Info("upstreams changed", "upstream", cfg.Upstream, "mode", cfg.UpstreamMode)
+	return nil
+}
+
+// retire stops the upstreams of old that next doesn't list, once they wrote out what
+// they were handed, along with the health checks of old. Only the forwarder, or a
+// reload the forwarder never saw, may call it.
+func (r *relay) retire(old, next *upstreamSet) {
+	if old.failover != nil {
+		old.failover.stop()
+	}
+	for _, u := range old.list {
+		if next.upstream(u.addr) != nil {
+			continue
+		}
+		close(u.batches)
+		r.retiring.Add(1)
+		go func(u *upstream) {
+			defer r.retiring.Done()
+			<-u.done
+			u.client.Close()
+			u.unregister()
+			u.logger.Info("upstream removed")
+		}(u)
+	}
+}
+
+// handleReload reloads the configuration on POST /admin/reload, answering whether a
+// restart is needed to apply all of it
+func (r *relay) handleReload(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("use POST"))
+		return
+	}
+	restart, err := r.reload()
+	if err != nil {
+		r.logger.Error("reloading configuration failed, keeping the current one", "error", err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "{\"reloaded\":true,\"restart_needed\":%t}\n", restart)
+}
+
+// requireAdminToken lets through the requests carrying the admin token as a bearer token
+func (r *relay) requireAdminToken(next http.Handler) http.Handler {
+	admin := &authenticator{tokens: []authToken{{value: []byte(r.cfg.AdminToken)}}}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token, _ := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !admin.valid(strings.TrimSpace(token)) {
+			r.metrics.authFailures.WithLabelValues("admin").Inc()
+			r.logger.Warn("rejected unauthenticated admin request", "remote", req.RemoteAddr, "path", req.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gtsdb-relay admin"`)
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid admin token"))
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// current returns the settings in effect
+func (r *relay) current() *settings {
+	return r.settings.Load()
+}
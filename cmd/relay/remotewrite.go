@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// maxRemoteWriteBody bounds the compressed and the decompressed remote write requests
+const maxRemoteWriteBody = 32 << 20
+
+// promSeries is a time series of a remote write request
+type promSeries struct {
+	labels  map[string]string
+	samples []promSample
+}
+
+type promSample struct {
+	value     float64
+	timestamp int64 // milliseconds
+}
+
+// handleRemoteWrite receives Prometheus remote write requests, snappy-compressed
+// protobuf WriteRequests, and queues their samples under the keys built from their
+// metric names and labels
+func (r *relay) handleRemoteWrite(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("use POST"))
+		return
+	}
+
+	compressed, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxRemoteWriteBody))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	if n, err := snappy.DecodedLen(compressed); err != nil || n > maxRemoteWriteBody {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid snappy body"))
+		return
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid snappy body: %w", err))
+		return
+	}
+	series, err := decodeWriteRequest(data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var points []gtsdb.DataPoint
+	for _, s := range series {
+		key, err := r.remoteWriteKey(s.labels)
+		if err != nil {
+			r.logger.Debug("rejected remote write series", "labels", s.labels, "error", err)
+			continue
+		}
+		for _, sample := range s.samples {
+			// NaN also marks stale series, which has no equivalent in gtsdb
+			if math.IsNaN(sample.value) || math.IsInf(sample.value, 0) {
+				continue
+			}
+			points = append(points, gtsdb.DataPoint{Key: key, Timestamp: time.UnixMilli(sample.timestamp), Value: sample.value})
+		}
+	}
+
+	src := requestSource(req)
+	points = r.keepValid(src, points, time.Now())
+	if r.rateLimitRequest(w, req, len(points)) {
+		return
+	}
+	// A 5xx makes Prometheus retry the request, which is what a full backlog calls for
+	ctx, cancel := context.WithTimeout(req.Context(), r.cfg.WriteTimeout)
+	defer cancel()
+	if queued, err := r.queue(ctx, src, points); err != nil {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("backlog full, queued %d of %d samples", queued, len(points)))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// remoteWriteKey builds the key of a series from the remote write template, or from the
+// metric name followed by the sorted labels when there is none:
+// http_requests_total.code=200.job=api
+func (r *relay) remoteWriteKey(labels map[string]string) (string, error) {
+	if r.remoteWriteTemplate != nil {
+		return r.remoteWriteTemplate.expand(func(name string) (string, bool) {
+			value, ok := labels[name]
+			return keySanitizer.Replace(value), ok
+		})
+	}
+	return labelsKey(labels["__name__"], labels)
+}
+
+// decodeWriteRequest decodes a prometheus.WriteRequest, of which only the time series
+// are used:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func decodeWriteRequest(data []byte) ([]promSeries, error) {
+	var series []promSeries
+	err := decodeMessage(data, func(num protowire.Number, typ protowire.Type, field []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		s := promSeries{labels: make(map[string]string)}
+		err := decodeMessage(field, func(num protowire.Number, typ protowire.Type, field []byte) error {
+			if typ != protowire.BytesType {
+				return nil
+			}
+			switch num {
+			case 1:
+				var name, value string
+				err := decodeMessage(field, func(num protowire.Number, typ protowire.Type, field []byte) error {
+					switch {
+					case num == 1 && typ == protowire.BytesType:
+						name = string(field)
+					case num == 2 && typ == protowire.BytesType:
+						value = string(field)
+					}
+					return nil
+				})
+				s.labels[name] = value
+				return err
+			case 2:
+				var sample promSample
+				err := decodeMessage(field, func(num protowire.Number, typ protowire.Type, field []byte) error {
+					switch {
+					case num == 1 && typ == protowire.Fixed64Type:
+						v, _ := protowire.ConsumeFixed64(field)
+						sample.value = math.Float64frombits(v)
+					case num == 2 && typ == protowire.VarintType:
+						v, _ := protowire.ConsumeVarint(field)
+						sample.timestamp = int64(v)
+					}
+					return nil
+				})
+				s.samples = append(s.samples, sample)
+				return err
+			}
+			return nil
+		})
+		series = append(series, s)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid write request: %w", err)
+	}
+	return series, nil
+}
+
+// decodeMessage calls field for every field of a protobuf message. Length-delimited
+// fields are passed without their length, other fields with their raw encoding.
+func decodeMessage(data []byte, field func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var value []byte
+		if typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			value, data = v, data[n:]
+		} else {
+			n := protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			value, data = data[:n], data[n:]
+		}
+		if err := field(num, typ, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
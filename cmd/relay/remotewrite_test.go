@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// promSeriesFixture is a series to encode, its labels given as name, value pairs
+type promSeriesFixture struct {
+	labels  []string
+	samples []promSample
+}
+
+// encodeWriteRequest encodes series as a prometheus.WriteRequest
+func encodeWriteRequest(series ...promSeriesFixture) []byte {
+	var req []byte
+	for _, s := range series {
+		var ts []byte
+		for i := 0; i+1 < len(s.labels); i += 2 {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, s.labels[i])
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, s.labels[i+1])
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+		for _, sample := range s.samples {
+			var encoded []byte
+			encoded = protowire.AppendTag(encoded, 1, protowire.Fixed64Type)
+			encoded = protowire.AppendFixed64(encoded, math.Float64bits(sample.value))
+			encoded = protowire.AppendTag(encoded, 2, protowire.VarintType)
+			encoded = protowire.AppendVarint(encoded, uint64(sample.timestamp))
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, encoded)
+		}
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
+
+// queued drains the points queued in the backlog of r
+func queued(r *relay) []gtsdb.DataPoint {
+	var points []gtsdb.DataPoint
+	for {
+		select {
+		case point := <-r.backlog:
+			points = append(points, point.DataPoint)
+		default:
+			return points
+		}
+	}
+}
+
+func TestRemoteWrite(t *testing.T) {
+	payload := snappy.Encode(nil, encodeWriteRequest(
+		promSeriesFixture{
+			labels:  []string{"__name__", "http_requests_total", "job", "api", "code", "200"},
+			samples: []promSample{{value: 12, timestamp: 1700000000123}, {value: 13, timestamp: 1700000015123}},
+		},
+		promSeriesFixture{
+			labels: []string{"__name__", "up", "instance", "web|1:9100"},
+			// NaN marks a stale series and is skipped
+			samples: []promSample{{value: 1, timestamp: 1700000000000}, {value: math.NaN(), timestamp: 1700000015000}},
+		},
+	))
+
+	tests := []struct {
+		name     string
+		template string
+		want     []gtsdb.DataPoint
+	}{
+		{"labels key", "", []gtsdb.DataPoint{
+			point("http_requests_total.code=200.job=api", time.UnixMilli(1700000000123), 12),
+			point("http_requests_total.code=200.job=api", time.UnixMilli(1700000015123), 13),
+			point("up.instance=web_1:9100", time.UnixMilli(1700000000000), 1),
+		}},
+		// Label values holding | are sanitized like the other characters keys can't hold
+		{"template", "{__name__}.{instance}", []gtsdb.DataPoint{
+			point("up.web_1:9100", time.UnixMilli(1700000000000), 1),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.RemoteWriteKey = tt.template
+			r := newIdleRelay(t, cfg)
+
+			rec := httptest.NewRecorder()
+			r.handleRemoteWrite(rec, httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(payload)))
+			if rec.Code != http.StatusNoContent {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			checkPoints(t, queued(r), tt.want)
+		})
+	}
+
+	r := newIdleRelay(t, defaultConfig())
+	for name, body := range map[string][]byte{
+		"uncompressed":      encodeWriteRequest(promSeriesFixture{labels: []string{"__name__", "up"}}),
+		"truncated message": snappy.Encode(nil, encodeWriteRequest(promSeriesFixture{labels: []string{"__name__", "up"}})[:5]),
+	} {
+		rec := httptest.NewRecorder()
+		r.handleRemoteWrite(rec, httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s body: status %d, want %d", name, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+)
+
+// source tells where measurements came from, for the rules applied to them
+type source struct {
+	// listener is tcp, udp, http or mqtt
+	listener string
+	// remote is the host of the sender, or of the broker for MQTT
+	remote string
+	// tenant is the tenant that sent them, whose keys they are namespaced under
+	tenant *tenant
+}
+
+// newSource describes measurements received on listener from addr, a host with or
+// without port
+func newSource(listener, addr string) source {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return source{listener: listener, remote: addr}
+}
+
+// lookup resolves the placeholders of prefix templates
+func (s source) lookup(placeholder string) (string, bool) {
+	switch placeholder {
+	case "listener":
+		return s.listener, true
+	case "remote":
+		return keySanitizer.Replace(s.remote), true
+	}
+	return "", false
+}
+
+// rewriteRule rewrites the keys of incoming measurements before they are forwarded. A
+// rule either renames the keys matching Match by Replace, in which $1 refers to the
+// first group of Match, renames the keys listed in Map, or prepends Prefix to every
+// key matching Match, all keys when Match is empty. Prefix can hold the {listener} and
+// {remote} placeholders.
+type rewriteRule struct {
+	// Listener limits the rule to the measurements received on one listener
+	Listener string            `yaml:"listener"`
+	Match    string            `yaml:"match"`
+	Replace  string            `yaml:"replace"`
+	Map      map[string]string `yaml:"map"`
+	Prefix   string            `yaml:"prefix"`
+}
+
+// rewriter applies rewrite rules in order, each to the key the previous one produced
+type rewriter []compiledRule
+
+type compiledRule struct {
+	listener string
+	match    *regexp.Regexp
+	// rename is set for rules replacing what match matched by replace
+	rename  bool
+	replace string
+	mapping map[string]string
+	prefix  keyTemplate
+}
+
+// newRewriter compiles rules, returning nil when there are none
+func newRewriter(rules []rewriteRule) (rewriter, error) {
+	var rw rewriter
+	for i, rule := range rules {
+		c := compiledRule{listener: rule.Listener}
+		switch rule.Listener {
+		case "", "tcp", "udp", "http", "mqtt":
+		default:
+			return nil, fmt.Errorf("rewrite rule %d: unknown listener %q", i+1, rule.Listener)
+		}
+		if rule.Match != "" {
+			var err error
+			if c.match, err = regexp.Compile(rule.Match); err != nil {
+				return nil, fmt.Errorf("rewrite rule %d: %w", i+1, err)
+			}
+		}
+
+		kinds := 0
+		if rule.Replace != "" {
+			if c.match == nil {
+				return nil, fmt.Errorf("rewrite rule %d: replace needs match", i+1)
+			}
+			c.rename, c.replace = true, rule.Replace
+			kinds++
+		}
+		if len(rule.Map) > 0 {
+			if c.match != nil {
+				return nil, fmt.Errorf("rewrite rule %d: map doesn't take match", i+1)
+			}
+			c.mapping = rule.Map
+			kinds++
+		}
+		if rule.Prefix != "" {
+			var err error
+			if c.prefix, err = parseKeyTemplate(rule.Prefix); err != nil {
+				return nil, fmt.Errorf("rewrite rule %d: %w", i+1, err)
+			}
+			probe := source{listener: "tcp", remote: "127.0.0.1"}
+			if _, err := c.prefix.expand(probe.lookup); err != nil {
+				return nil, fmt.Errorf("rewrite rule %d: prefix %w", i+1, err)
+			}
+			kinds++
+		}
+		if kinds != 1 {
+			return nil, fmt.Errorf("rewrite rule %d: needs exactly one of replace, map or prefix", i+1)
+		}
+		rw = append(rw, c)
+	}
+	return rw, nil
+}
+
+// rewrite returns the key the rules make of key, for measurements from src
+func (rw rewriter) rewrite(key string, src source) (string, error) {
+	for _, rule := range rw {
+		if rule.listener != "" && rule.listener != src.listener {
+			continue
+		}
+		switch {
+		case rule.rename:
+			if rule.match.MatchString(key) {
+				key = rule.match.ReplaceAllString(key, rule.replace)
+			}
+		case rule.mapping != nil:
+			if renamed, ok := rule.mapping[key]; ok {
+				key = renamed
+			}
+		default:
+			if rule.match == nil || rule.match.MatchString(key) {
+				prefix, err := rule.prefix.expand(src.lookup)
+				if err != nil {
+					return "", err
+				}
+				key = prefix + key
+			}
+		}
+	}
+	return key, checkKey(key)
+}
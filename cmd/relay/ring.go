@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// hashRing assigns keys to servers by consistent hashing, so that adding or removing a
+// server only moves the keys of its neighbours on the ring
+type hashRing struct {
+	// hashes are the points of every server on the ring, sorted
+	hashes []uint32
+	owners map[uint32]int
+}
+
+// newHashRing places servers on the ring, each at replicas points to spread the keys
+// evenly. Servers are named by their address, so that the ring doesn't depend on the
+// order they are listed in.
+func newHashRing(addrs []string, replicas int) *hashRing {
+	ring := &hashRing{owners: make(map[uint32]int, len(addrs)*replicas)}
+	for i, addr := range addrs {
+		for n := 0; n < replicas; n++ {
+			hash := hashKey(addr + "#" + strconv.Itoa(n))
+			if _, taken := ring.owners[hash]; taken {
+				continue
+			}
+			ring.owners[hash] = i
+			ring.hashes = append(ring.hashes, hash)
+		}
+	}
+	sort.Slice(ring.hashes, func(i, j int) bool { return ring.hashes[i] < ring.hashes[j] })
+	return ring
+}
+
+// pick returns the index of the server owning key: the first one clockwise from its hash
+func (ring *hashRing) pick(key string) int {
+	hash := hashKey(key)
+	i := sort.Search(len(ring.hashes), func(i int) bool { return ring.hashes[i] >= hash })
+	if i == len(ring.hashes) {
+		i = 0
+	}
+	return ring.owners[ring.hashes[i]]
+}
+
+// hashKey hashes key onto the ring. Keys and server names differ in a few characters
+// only, which a cryptographic hash still spreads evenly.
+func hashKey(key string) uint32 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint32(sum[:4])
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// errSpoolFull is returned when the spool is full and keeps its oldest points
+var errSpoolFull = errors.New("spool is full")
+
+const (
+	// spoolExt is the extension of spool segment files
+	spoolExt = ".spool"
+	// spoolRetryInterval is how often replaying the spool is attempted
+	spoolRetryInterval = time.Second
+	// spoolReplayBatches bounds the batches replayed at once, so that new batches keep
+	// being served during a long replay
+	spoolReplayBatches = 16
+)
+
+// spool persists the measurements the upstream couldn't take in append-only segment
+// files, to be replayed in order once it recovers. A line holds a point as
+// "key,unixnano,value". Points are replayed at least once: a crash during a replay
+// sends the current segment again.
+type spool struct {
+	dir         string
+	maxBytes    int64
+	segmentSize int64
+	dropOldest  bool
+
+	mu sync.Mutex
+	// segments lists the ids of the segment files, oldest first; the last is appended to
+	segments []int64
+	size     int64
+	tail     *os.File
+	tailSize int64
+	// offset is where replay resumes in the oldest segment, and next where it resumes
+	// once the points last returned by peek are committed
+	offset, next int64
+}
+
+// openSpool opens the spool in dir, picking up the segments a previous run left behind.
+// The spool holds at most maxBytes. When full it drops its oldest segment to make room
+// if dropOldest is set, or rejects new points otherwise.
+func openSpool(dir string, maxBytes int64, dropOldest bool) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("spool: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("spool: %w", err)
+	}
+
+	s := &spool{dir: dir, maxBytes: maxBytes, dropOldest: dropOldest, segmentSize: max(maxBytes/8, 4<<10)}
+	for _, entry := range entries {
+		id, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), spoolExt), 10, 64)
+		if err != nil || !strings.HasSuffix(entry.Name(), spoolExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("spool: %w", err)
+		}
+		s.segments = append(s.segments, id)
+		s.size += info.Size()
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+	return s, nil
+}
+
+func (s *spool) path(id int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, spoolExt))
+}
+
+// bytes returns the size of the spool on disk
+func (s *spool) bytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// full reports whether the spool lacks room for another segment, and so drops or
+// rejects points soon
+func (s *spool) full() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size+s.segmentSize > s.maxBytes
+}
+
+// empty reports whether the spool has nothing left to replay
+func (s *spool) empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.segments) == 0
+}
+
+// append persists points after those already spooled
+func (s *spool) append(points []gtsdb.DataPoint) error {
+	var b strings.Builder
+	for _, p := range points {
+		fmt.Fprintf(&b, "%s,%d,%s\n", p.Key, p.Timestamp.UnixNano(), strconv.FormatFloat(p.Value, 'g', -1, 64))
+	}
+	data := b.String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.size+int64(len(data)) > s.maxBytes {
+		if !s.dropOldest || len(s.segments) < 2 {
+			return errSpoolFull
+		}
+		if err := s.dropHead(); err != nil {
+			return err
+		}
+	}
+
+	if s.tail == nil || s.tailSize >= s.segmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := io.WriteString(s.tail, data)
+	s.tailSize += int64(n)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("spool: %w", err)
+	}
+	if err := s.tail.Sync(); err != nil {
+		return fmt.Errorf("spool: %w", err)
+	}
+	return nil
+}
+
+// rotate starts a new tail segment. It must be called while holding mu.
+func (s *spool) rotate() error {
+	if s.tail != nil {
+		s.tail.Close()
+		s.tail = nil
+	}
+	id := time.Now().UnixNano()
+	if n := len(s.segments); n > 0 && id <= s.segments[n-1] {
+		id = s.segments[n-1] + 1
+	}
+	f, err := os.OpenFile(s.path(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("spool: %w", err)
+	}
+	s.tail, s.tailSize = f, 0
+	s.segments = append(s.segments, id)
+	return nil
+}
+
+// dropHead removes the oldest segment. It must be called while holding mu.
+func (s *spool) dropHead() error {
+	id := s.segments[0]
+	if len(s.segments) == 1 && s.tail != nil {
+		s.tail.Close()
+		s.tail = nil
+	}
+	info, err := os.Stat(s.path(id))
+	if err == nil {
+		s.size -= info.Size()
+	}
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("spool: %w", err)
+	}
+	s.segments = s.segments[1:]
+	s.offset, s.next = 0, 0
+	return nil
+}
+
+// peek returns up to limit of the oldest spooled points, which stay in the spool until
+// commit is called. Lines that can't be parsed are skipped.
+func (s *spool) peek(limit int) ([]gtsdb.DataPoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.segments) > 0 {
+		f, err := os.Open(s.path(s.segments[0]))
+		if err != nil {
+			return nil, fmt.Errorf("spool: %w", err)
+		}
+		points, next, err := readSpooled(f, s.offset, limit)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		s.next = next
+		if len(points) > 0 {
+			return points, nil
+		}
+		if len(s.segments) == 1 && s.tail != nil {
+			// The tail has been read out; appends may still continue it
+			return nil, nil
+		}
+		if err := s.dropHead(); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// commit removes the points returned by the last peek from the spool
+func (s *spool) commit() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset = s.next
+	if len(s.segments) == 1 && s.tail != nil && s.offset >= s.tailSize {
+		// Everything spooled was replayed; start afresh instead of growing the tail
+		return s.dropHead()
+	}
+	return nil
+}
+
+// readSpooled reads up to limit points from f starting at offset, returning where the
+// next read should start
+func readSpooled(f *os.File, offset int64, limit int) ([]gtsdb.DataPoint, int64, error) {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, offset, fmt.Errorf("spool: %w", err)
+	}
+	reader := bufio.NewReader(f)
+	var points []gtsdb.DataPoint
+	for len(points) < limit {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			// A partial line is the end of an interrupted append; it is read again later
+			break
+		}
+		if err != nil {
+			return nil, offset, fmt.Errorf("spool: %w", err)
+		}
+		offset += int64(len(line))
+		if point, ok := parseSpooled(strings.TrimSuffix(line, "\n")); ok {
+			points = append(points, point)
+		}
+	}
+	return points, offset, nil
+}
+
+func parseSpooled(line string) (gtsdb.DataPoint, bool) {
+	parts := strings.Split(line, ",")
+	if len(parts) != 3 {
+		return gtsdb.DataPoint{}, false
+	}
+	ts, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return gtsdb.DataPoint{}, false
+	}
+	value, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return gtsdb.DataPoint{}, false
+	}
+	return gtsdb.DataPoint{Key: parts[0], Timestamp: time.Unix(0, ts), Value: value}, true
+}
+
+// close closes the tail segment, keeping what is spooled for the next run
+func (s *spool) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tail == nil {
+		return nil
+	}
+	err := s.tail.Close()
+	s.tail = nil
+	return err
+}
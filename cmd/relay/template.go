@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// keySanitizer replaces the characters a key can't hold in label values
+var keySanitizer = strings.NewReplacer(",", "_", "|", "_", " ", "_", "\t", "_", "\r", "_", "\n", "_")
+
+// keyTemplate builds a GTSDB key by replacing the {name} placeholders of a template
+type keyTemplate []templatePart
+
+type templatePart struct {
+	literal     string
+	placeholder string
+}
+
+func parseKeyTemplate(s string) (keyTemplate, error) {
+	var t keyTemplate
+	for s != "" {
+		open := strings.IndexByte(s, '{')
+		if open < 0 {
+			t = append(t, templatePart{literal: s})
+			break
+		}
+		end := strings.IndexByte(s[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("key template: unclosed {")
+		}
+		name := s[open+1 : open+end]
+		if name == "" {
+			return nil, fmt.Errorf("key template: empty placeholder")
+		}
+		if open > 0 {
+			t = append(t, templatePart{literal: s[:open]})
+		}
+		t = append(t, templatePart{placeholder: name})
+		s = s[open+end+1:]
+	}
+	return t, nil
+}
+
+// expand builds the key, replacing each placeholder by what lookup returns for it
+func (t keyTemplate) expand(lookup func(placeholder string) (string, bool)) (string, error) {
+	var b strings.Builder
+	for _, part := range t {
+		if part.placeholder == "" {
+			b.WriteString(part.literal)
+			continue
+		}
+		value, ok := lookup(part.placeholder)
+		if !ok {
+			return "", fmt.Errorf("no value for {%s}", part.placeholder)
+		}
+		b.WriteString(value)
+	}
+	key := b.String()
+	return key, checkKey(key)
+}
+
+// labelsKey builds the key of a labelled metric from its name followed by its sorted
+// labels, other than __name__: http_requests_total.code=200.job=api
+func labelsKey(name string, labels map[string]string) (string, error) {
+	names := make([]string, 0, len(labels))
+	for label := range labels {
+		if label != "__name__" {
+			names = append(names, label)
+		}
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString(name)
+	for _, label := range names {
+		fmt.Fprintf(&b, ".%s=%s", label, labels[label])
+	}
+	key := keySanitizer.Replace(b.String())
+	return key, checkKey(key)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultQuotaPeriod is the period of the point quotas of tenants
+const defaultQuotaPeriod = 24 * time.Hour
+
+// tenantNamePattern is what tenant names, which prefix their keys, are made of
+var tenantNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// tenantConfig is a team sharing the relay. It authenticates with its own tokens, and
+// its keys are stored upstream under "<name>." where only it reads and writes them.
+type tenantConfig struct {
+	Name   string   `yaml:"name"`
+	Tokens []string `yaml:"tokens"`
+	// Rate bounds the points per second the tenant writes, over all its connections
+	// and listeners; zero means unlimited
+	Rate float64 `yaml:"rate"`
+	// Quota bounds the points the tenant writes per QuotaPeriod, a day by default,
+	// starting at midnight UTC; zero means unlimited
+	Quota       int64         `yaml:"quota"`
+	QuotaPeriod time.Duration `yaml:"quota_period"`
+}
+
+// tenant is the configuration in effect of a tenant. The nil tenant is that of the
+// clients authenticated with the relay's own tokens, or not at all, whose keys aren't
+// namespaced.
+type tenant struct {
+	cfg    tenantConfig
+	prefix string
+}
+
+// newTenants checks the tenants of cfg, returning them in order
+func newTenants(cfgs []tenantConfig) ([]*tenant, error) {
+	tenants := make([]*tenant, 0, len(cfgs))
+	names := make(map[string]bool, len(cfgs))
+	for _, cfg := range cfgs {
+		if !tenantNamePattern.MatchString(cfg.Name) {
+			return nil, fmt.Errorf("tenant %q: names are made of letters, digits, - and _", cfg.Name)
+		}
+		if names[cfg.Name] {
+			return nil, fmt.Errorf("tenant %s defined twice", cfg.Name)
+		}
+		names[cfg.Name] = true
+		if len(cfg.Tokens) == 0 {
+			return nil, fmt.Errorf("tenant %s: no tokens", cfg.Name)
+		}
+		for _, token := range cfg.Tokens {
+			if strings.TrimSpace(token) == "" {
+				return nil, fmt.Errorf("tenant %s: empty token", cfg.Name)
+			}
+		}
+		if cfg.Rate < 0 || cfg.Quota < 0 || cfg.QuotaPeriod < 0 {
+			return nil, fmt.Errorf("tenant %s: limits must not be negative", cfg.Name)
+		}
+		if cfg.QuotaPeriod == 0 {
+			cfg.QuotaPeriod = defaultQuotaPeriod
+		}
+		tenants = append(tenants, &tenant{cfg: cfg, prefix: cfg.Name + "."})
+	}
+	return tenants, nil
+}
+
+// name returns the name of t, empty for the nil tenant
+func (t *tenant) name() string {
+	if t == nil {
+		return ""
+	}
+	return t.cfg.Name
+}
+
+// key returns the upstream key of the key t knows as key
+func (t *tenant) key(key string) string {
+	if t == nil {
+		return key
+	}
+	return t.prefix + key
+}
+
+// strip returns the key t knows the upstream key as
+func (t *tenant) strip(key string) string {
+	if t == nil {
+		return key
+	}
+	return strings.TrimPrefix(key, t.prefix)
+}
+
+// tenantContextKey carries the tenant of a request in its context
+type tenantContextKey struct{}
+
+func withTenant(ctx context.Context, t *tenant) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, tenantContextKey{}, t)
+}
+
+// tenantFrom returns the tenant of the request of ctx, nil if not a tenant's
+func tenantFrom(ctx context.Context) *tenant {
+	t, _ := ctx.Value(tenantContextKey{}).(*tenant)
+	return t
+}
+
+// tenantUsage is what a tenant wrote recently, kept by name across reloads
+type tenantUsage struct {
+	mu     sync.Mutex
+	bucket *tokenBucket
+	// period is the start of the quota period, and used the points written since
+	period time.Time
+	used   int64
+}
+
+// tenantLedger keeps the usage of every tenant
+type tenantLedger struct {
+	mu    sync.Mutex
+	usage map[string]*tenantUsage
+}
+
+func newTenantLedger() *tenantLedger {
+	return &tenantLedger{usage: make(map[string]*tenantUsage)}
+}
+
+func (l *tenantLedger) of(name string) *tenantUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	u, ok := l.usage[name]
+	if !ok {
+		u = &tenantUsage{}
+		l.usage[name] = u
+	}
+	return u
+}
+
+// admitTenant takes n points from the rate limit and quota of t, as configured now. When
+// either is exhausted it takes none and returns an error naming it, and how long to wait
+// before retrying. The nil tenant is always admitted.
+func (r *relay) admitTenant(t *tenant, n int) (time.Duration, error) {
+	if t == nil {
+		return 0, nil
+	}
+	// A connection keeps the tenant it authenticated as, while reloads may change its
+	// limits. A removed tenant keeps those it had, like the connection its token.
+	if auth := r.current().auth; auth != nil && auth.tenants[t.cfg.Name] != nil {
+		t = auth.tenants[t.cfg.Name]
+	}
+	name, now := t.cfg.Name, time.Now()
+	u := r.tenantUsage.of(name)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if period := now.Truncate(t.cfg.QuotaPeriod); !period.Equal(u.period) {
+		u.period, u.used = period, 0
+	}
+	if t.cfg.Quota > 0 && u.used+int64(n) > t.cfg.Quota {
+		r.metrics.tenantLimited.WithLabelValues(name, "quota").Inc()
+		return u.period.Add(t.cfg.QuotaPeriod).Sub(now), fmt.Errorf("quota of %d points per %s exhausted", t.cfg.Quota, t.cfg.QuotaPeriod)
+	}
+	if t.cfg.Rate > 0 {
+		if u.bucket == nil || u.bucket.rate != t.cfg.Rate {
+			u.bucket = newTokenBucket(t.cfg.Rate, now)
+		}
+		if !u.bucket.allow(n, now) {
+			r.metrics.tenantLimited.WithLabelValues(name, "rate").Inc()
+			return time.Second, fmt.Errorf("rate limit of %g points per second exceeded", t.cfg.Rate)
+		}
+	}
+	u.used += int64(n)
+	r.metrics.tenantPoints.WithLabelValues(name).Add(float64(n))
+	r.metrics.tenantQuotaUsed.WithLabelValues(name).Set(float64(u.used))
+	return 0, nil
+}
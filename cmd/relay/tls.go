@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// tlsServer holds the TLS settings of the listeners, loaded from the configured files
+// and swapped as a whole when they are reloaded, so new connections pick up renewed
+// certificates while established ones keep theirs
+type tlsServer struct {
+	certFile, keyFile, clientCAFile string
+
+	current atomic.Pointer[tls.Config]
+}
+
+// newTLSServer loads the certificate, key and client CA of the TLS settings, or returns
+// nil when TLS is disabled
+func newTLSServer(cfg config) (*tlsServer, error) {
+	if cfg.TLSCert == "" {
+		return nil, nil
+	}
+	s := &tlsServer{certFile: cfg.TLSCert, keyFile: cfg.TLSKey, clientCAFile: cfg.TLSClientCA}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload reads the files again, keeping the settings in use if any of them is invalid
+func (s *tlsServer) reload() error {
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		// Already prefixed with tls:
+		return err
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if s.clientCAFile != "" {
+		pem, err := os.ReadFile(s.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tls: no certificates in client CA file %s", s.clientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	s.current.Store(cfg)
+	return nil
+}
+
+// config returns the configuration listeners are wrapped with, which hands each new
+// connection the settings last loaded
+func (s *tlsServer) config(nextProtos ...string) *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := s.current.Load().Clone()
+			cfg.NextProtos = nextProtos
+			return cfg, nil
+		},
+	}
+}
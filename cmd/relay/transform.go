@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// transform changes a point on its way to the upstream, or drops it by returning false.
+// Transforms run in order, each on the point the previous one returned, and may be
+// called concurrently.
+type transform func(gtsdb.DataPoint) (gtsdb.DataPoint, bool)
+
+// transformStep configures one of the built-in transforms, applied to the keys matching
+// Match, all keys when empty. A step either multiplies values by Scale and adds Offset,
+// e.g. scale 1.8 and offset 32 for Celsius to Fahrenheit, clamps them between Min and
+// Max, drops those repeating the key's last forwarded value until Dedupe passed, or
+// drops every point when Drop is set.
+type transformStep struct {
+	Match  string        `yaml:"match"`
+	Scale  *float64      `yaml:"scale"`
+	Offset *float64      `yaml:"offset"`
+	Min    *float64      `yaml:"min"`
+	Max    *float64      `yaml:"max"`
+	Dedupe time.Duration `yaml:"dedupe"`
+	Drop   bool          `yaml:"drop"`
+}
+
+// newTransforms builds the transforms of steps, in order
+func newTransforms(steps []transformStep) ([]transform, error) {
+	var transforms []transform
+	for i, step := range steps {
+		t, err := step.build()
+		if err != nil {
+			return nil, fmt.Errorf("transform %d: %w", i+1, err)
+		}
+		transforms = append(transforms, t)
+	}
+	return transforms, nil
+}
+
+func (step transformStep) build() (transform, error) {
+	var t transform
+	kinds := 0
+	if step.Scale != nil || step.Offset != nil {
+		scale, offset := 1.0, 0.0
+		if step.Scale != nil {
+			scale = *step.Scale
+		}
+		if step.Offset != nil {
+			offset = *step.Offset
+		}
+		t = linearTransform(scale, offset)
+		kinds++
+	}
+	if step.Min != nil || step.Max != nil {
+		if step.Min != nil && step.Max != nil && *step.Min > *step.Max {
+			return nil, fmt.Errorf("min %g above max %g", *step.Min, *step.Max)
+		}
+		t = clampTransform(step.Min, step.Max)
+		kinds++
+	}
+	if step.Dedupe != 0 {
+		if step.Dedupe < 0 {
+			return nil, fmt.Errorf("negative dedupe window")
+		}
+		t = dedupeTransform(step.Dedupe)
+		kinds++
+	}
+	if step.Drop {
+		t = func(p gtsdb.DataPoint) (gtsdb.DataPoint, bool) { return p, false }
+		kinds++
+	}
+	if kinds != 1 {
+		return nil, fmt.Errorf("needs exactly one of scale and offset, min and max, dedupe or drop")
+	}
+
+	if step.Match == "" {
+		return t, nil
+	}
+	match, err := regexp.Compile(step.Match)
+	if err != nil {
+		return nil, err
+	}
+	return func(p gtsdb.DataPoint) (gtsdb.DataPoint, bool) {
+		if !match.MatchString(p.Key) {
+			return p, true
+		}
+		return t(p)
+	}, nil
+}
+
+// linearTransform converts values by value*scale + offset
+func linearTransform(scale, offset float64) transform {
+	return func(p gtsdb.DataPoint) (gtsdb.DataPoint, bool) {
+		p.Value = p.Value*scale + offset
+		return p, true
+	}
+}
+
+// clampTransform brings values into [lo, hi]; a nil bound is open
+func clampTransform(lo, hi *float64) transform {
+	return func(p gtsdb.DataPoint) (gtsdb.DataPoint, bool) {
+		if lo != nil && p.Value < *lo {
+			p.Value = *lo
+		}
+		if hi != nil && p.Value > *hi {
+			p.Value = *hi
+		}
+		return p, true
+	}
+}
+
+// dedupeTransform drops the points repeating the value last forwarded for their key,
+// unless window passed since, so that unchanged keys still show up once per window
+func dedupeTransform(window time.Duration) transform {
+	type last struct {
+		value     float64
+		timestamp time.Time
+	}
+	var mu sync.Mutex
+	seen := make(map[string]last)
+	return func(p gtsdb.DataPoint) (gtsdb.DataPoint, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		prev, ok := seen[p.Key]
+		if ok && prev.value == p.Value && p.Timestamp.Sub(prev.timestamp) < window && !p.Timestamp.Before(prev.timestamp) {
+			return p, false
+		}
+		seen[p.Key] = last{value: p.Value, timestamp: p.Timestamp}
+		return p, true
+	}
+}
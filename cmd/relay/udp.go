@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// maxDatagram is the largest UDP payload
+const maxDatagram = 65535
+
+// serveUDP reads datagrams from conn until it fails. A datagram carries one or more lines
+// in the relay's format, after an "auth <token>" line when authentication is enabled; the
+// valid lines are forwarded even if others are not. UDP senders get no replies, so
+// rejections are only counted and logged.
+func (r *relay) serveUDP(conn net.PacketConn) error {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		r.handleDatagram(addr, string(buf[:n]))
+	}
+}
+
+func (r *relay) handleDatagram(addr net.Addr, datagram string) {
+	r.metrics.udpDatagrams.Inc()
+	log := r.logger.With("remote", addr.String())
+
+	src := newSource("udp", addr.String())
+	lines := strings.Split(datagram, "\n")
+	s := r.current()
+	if s.auth != nil {
+		t, ok := s.auth.authLine(lines[0])
+		if !ok {
+			r.metrics.authFailures.WithLabelValues("udp").Inc()
+			log.Warn("rejected unauthenticated datagram")
+			return
+		}
+		src.tenant, lines = t, lines[1:]
+	}
+
+	received := r.metrics.linesReceived.WithLabelValues("udp")
+	now, invalid, dropped := time.Now(), false, false
+	for _, line := range lines {
+		if len(line) > r.cfg.MaxLineLength {
+			r.lineTooLong("udp")
+			invalid = true
+			continue
+		}
+		points, err := r.parse(line, now)
+		if errors.Is(err, errEmptyLine) {
+			continue
+		}
+		received.Inc()
+		if !r.allowSource(s, addr.String(), 1) {
+			dropped = true
+			continue
+		}
+		if _, err := r.admitTenant(src.tenant, 1); err != nil {
+			dropped = true
+			continue
+		}
+		if err != nil {
+			r.reject(src, line, err)
+		} else {
+			err = r.validate(src, line, points, now)
+		}
+		if err != nil {
+			log.Debug("rejected line", "line", line, "error", err)
+			invalid = true
+			continue
+		}
+		if r.enqueue(log, src, points) > 0 {
+			dropped = true
+		}
+	}
+	if invalid {
+		r.metrics.udpInvalid.Inc()
+	}
+	if dropped {
+		r.metrics.udpDropped.Inc()
+	}
+}
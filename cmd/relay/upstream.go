@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/proxy"
+)
+
+// batch is a set of points handed to upstreams at once
+type batch struct {
+	points []gtsdb.DataPoint
+	// received is when the oldest of the points was received
+	received time.Time
+}
+
+// upstream writes batches to one GTSDB server from a buffer of its own, so that a slow
+// or unreachable server doesn't hold back the others
+type upstream struct {
+	addr      string
+	client    *gtsdb.TSDBClient
+	logger    *slog.Logger
+	batchSize int
+	// acks is set when the client waits for the server to acknowledge writes, so that
+	// a batch is only let go once the server has it
+	acks bool
+	// batches holds what is still to be written to this server; it is closed once the
+	// forwarder is done or a reload removed the server
+	batches chan batch
+	// spool keeps what the server can't take, when enabled
+	spool *spool
+
+	metrics   *relayMetrics
+	forwarded prometheus.Counter
+	errors    prometheus.Counter
+	latency   prometheus.Observer
+	// spoolBytes reports the size of the spool, when enabled
+	spoolBytes prometheus.Collector
+	// done is closed once the batches are written out or spooled
+	done chan struct{}
+}
+
+func newUpstream(cfg config, addr string, client *gtsdb.TSDBClient, logger *slog.Logger, metrics *relayMetrics) *upstream {
+	return &upstream{
+		addr:      addr,
+		client:    client,
+		logger:    logger.With("upstream", addr),
+		batchSize: cfg.BatchSize,
+		acks:      cfg.WriteAcks,
+		batches:   make(chan batch, cfg.UpstreamBuffer),
+		done:      make(chan struct{}),
+		metrics:   metrics,
+		forwarded: metrics.pointsForwarded.WithLabelValues(addr),
+		errors:    metrics.upstreamErrors.WithLabelValues(addr),
+		latency:   metrics.forwardLatency.WithLabelValues(addr),
+	}
+}
+
+// upstreamAddrs returns the addresses listed in the comma-separated upstream setting
+func upstreamAddrs(list string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(list, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// newProxyDialer returns the dial function connecting through the SOCKS5 proxy at
+// rawURL, socks5://[user:password@]host:port
+func newProxyDialer(rawURL string) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, fmt.Errorf("%q is not a socks5:// URL", rawURL)
+	}
+	d, err := proxy.FromURL(u, proxy.Direct)
+	if err != nil {
+		return nil, err
+	}
+	dialer, ok := d.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("proxy %s can't be dialed with a context", u.Redacted())
+	}
+	return dialer.DialContext, nil
+}
+
+// spoolDir returns the directory under dir spooling the measurements of the server at addr
+func spoolDir(dir, addr string) string {
+	return filepath.Join(dir, strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(addr))
+}
+
+// openSpool enables spooling the measurements the server can't take, picking up what a
+// previous run left behind
+func (u *upstream) openSpool(cfg config) error {
+	s, err := openSpool(spoolDir(cfg.SpoolDir, u.addr), cfg.SpoolMaxBytes, cfg.SpoolDrop == "oldest")
+	if err != nil {
+		return err
+	}
+	if !s.empty() {
+		u.logger.Info("replaying spool left by a previous run", "bytes", s.bytes())
+	}
+	u.spool = s
+	u.spoolBytes = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   metricsNamespace,
+		Name:        "spool_bytes",
+		Help:        "Size of an upstream's spool on disk, by upstream.",
+		ConstLabels: prometheus.Labels{"upstream": u.addr},
+	}, func() float64 { return float64(s.bytes()) })
+	u.metrics.registry.MustRegister(u.spoolBytes)
+	return nil
+}
+
+// unregister drops the metrics of an upstream a reload removed that are not counters
+func (u *upstream) unregister() {
+	if u.spoolBytes != nil {
+		u.metrics.registry.Unregister(u.spoolBytes)
+	}
+	u.metrics.activeUpstream.DeleteLabelValues(u.addr)
+}
+
+// offer buffers a batch for the server, reporting false when its buffer is full
+func (u *upstream) offer(b batch) bool {
+	select {
+	case u.batches <- b:
+		return true
+	default:
+		return false
+	}
+}
+
+// put buffers a batch for the server, waiting for room until ctx is done; it reports
+// false if it wasn't buffered
+func (u *upstream) put(ctx context.Context, b batch) bool {
+	select {
+	case u.batches <- b:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// run writes the buffered batches to the server and replays the spool while the server
+// takes writes, until the batches are closed and written out; ctx aborts the writes
+func (u *upstream) run(ctx context.Context) {
+	defer close(u.done)
+	var retry <-chan time.Time
+	if u.spool != nil {
+		ticker := time.NewTicker(spoolRetryInterval)
+		defer ticker.Stop()
+		retry = ticker.C
+		defer func() {
+			if err := u.spool.close(); err != nil {
+				u.logger.Error("closing spool failed", "error", err)
+			}
+		}()
+	}
+
+	for {
+		select {
+		case b, ok := <-u.batches:
+			if !ok {
+				return
+			}
+			u.deliver(ctx, b)
+		case <-retry:
+			u.replay(ctx)
+		}
+	}
+}
+
+// deliver writes a batch to the server. With a spool, points the server can't take are
+// spooled, and so are all points while earlier ones wait in the spool, so that they
+// reach the server in order. With acknowledgements and no spool, the batch is written
+// again until the server acknowledges it, holding back those buffered after it.
+func (u *upstream) deliver(ctx context.Context, b batch) {
+	points := b.points
+	if u.spool != nil && !u.spool.empty() {
+		u.spoolPoints(points)
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		err := u.client.WriteBatchContext(ctx, points)
+		if err == nil {
+			u.forwarded.Add(float64(len(points)))
+			u.latency.Observe(time.Since(b.received).Seconds())
+			return
+		}
+		u.errors.Inc()
+		switch {
+		case rejected(err):
+			// Writing them again would be rejected again
+			u.logger.Error("backend rejected measurements", "points", len(points), "error", err)
+			return
+		case u.spool != nil:
+			u.logger.Warn("backend write failed, spooling", "points", len(points), "error", err)
+			u.spoolPoints(points)
+			return
+		case !u.acks:
+			u.logger.Error("backend write failed", "points", len(points), "error", err)
+			return
+		}
+		log := u.logger.Debug
+		if attempt == 1 {
+			log = u.logger.Warn
+		}
+		log("backend write not acknowledged, retrying", "points", len(points), "attempt", attempt, "error", err)
+		select {
+		case <-time.After(spoolRetryInterval):
+		case <-ctx.Done():
+			u.logger.Error("giving up on unacknowledged measurements", "points", len(points), "error", ctx.Err())
+			return
+		}
+	}
+}
+
+// rejected reports whether err is the server refusing points of a write, which only
+// acknowledged writes tell, rather than the write failing
+func rejected(err error) bool {
+	var serverErr *gtsdb.ServerError
+	return errors.As(err, &serverErr)
+}
+
+func (u *upstream) spoolPoints(points []gtsdb.DataPoint) {
+	if err := u.spool.append(points); err != nil {
+		u.logger.Error("spooling failed, dropping measurements", "points", len(points), "error", err)
+	}
+}
+
+// replay writes spooled points back to the server, oldest first, until the spool is
+// empty, a write fails or spoolReplayBatches batches were written
+func (u *upstream) replay(ctx context.Context) {
+	for i := 0; i < spoolReplayBatches; i++ {
+		points, err := u.spool.peek(u.batchSize)
+		if err != nil {
+			u.logger.Error("reading spool failed", "error", err)
+			return
+		}
+		if len(points) == 0 {
+			return
+		}
+		err = u.client.WriteBatchContext(ctx, points)
+		switch {
+		case rejected(err):
+			// Committed anyway, or the spool would never get past them
+			u.errors.Inc()
+			u.logger.Error("backend rejected spooled measurements", "points", len(points), "error", err)
+		case err != nil:
+			u.errors.Inc()
+			u.logger.Debug("replaying spool failed", "error", err)
+			return
+		default:
+			u.forwarded.Add(float64(len(points)))
+		}
+		if err := u.spool.commit(); err != nil {
+			u.logger.Error("committing spool failed", "error", err)
+			return
+		}
+		if u.spool.empty() {
+			u.logger.Info("spool replayed")
+			return
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// fakeUpstream is a GTSDB server keeping the points written to it. Until it is up it
+// hangs up on every connection, the way a server that is restarting fails writes.
+type fakeUpstream struct {
+	ln net.Listener
+	up atomic.Bool
+	// acks has every write acknowledged, as for -write-acks
+	acks bool
+	// delay is how long each write takes
+	delay time.Duration
+
+	mu     sync.Mutex
+	points map[string]bool
+}
+
+func newFakeUpstream(t *testing.T, setup func(*fakeUpstream)) *fakeUpstream {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeUpstream{ln: ln, points: make(map[string]bool)}
+	setup(s)
+	t.Cleanup(func() { ln.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeUpstream) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		if !s.up.Load() {
+			conn.Close()
+			continue
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeUpstream) handle(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		// Writes are key,timestamp,value
+		if strings.Count(scanner.Text(), ",") != 2 {
+			fmt.Fprintln(conn, "ERR MALFORMED unexpected command")
+			continue
+		}
+		time.Sleep(s.delay)
+		s.mu.Lock()
+		s.points[scanner.Text()] = true
+		s.mu.Unlock()
+		if s.acks {
+			fmt.Fprintln(conn, "OK")
+		}
+	}
+}
+
+// received reports how many distinct points the server took
+func (s *fakeUpstream) received() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.points)
+}
+
+func TestWriteAcksDeliverEveryPoint(t *testing.T) {
+	const points = 50
+	server := newFakeUpstream(t, func(s *fakeUpstream) { s.acks = true })
+
+	cfg, err := loadConfig([]string{
+		"-listen", "127.0.0.1:0",
+		"-upstream", server.ln.Addr().String(),
+		"-write-acks",
+		"-backlog", "2",
+		"-upstream-buffer", "1",
+		"-batch-size", "1",
+		"-flush-interval", "1ms",
+	}, func(string) string { return "" }, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dial := func(cfg config, addr string) (*gtsdb.TSDBClient, error) {
+		// No connection is held while idle, so the relay starts with the server down
+		return gtsdb.NewTSDBClient(addr, gtsdb.WithWriteAcks(), gtsdb.WithPoolSize(0, 1),
+			gtsdb.WithDialTimeout(time.Second), gtsdb.WithWriteTimeout(time.Second))
+	}
+	r, err := newRelay(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), dial, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := r.start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := make(chan int, 1)
+	go func() {
+		dropped := 0
+		for i := 0; i < points; i++ {
+			point := gtsdb.DataPoint{Key: fmt.Sprintf("sensor%d", i), Timestamp: time.Unix(1700000000, 0), Value: float64(i)}
+			dropped += r.enqueue(r.logger, newSource("tcp", "127.0.0.1"), []gtsdb.DataPoint{point})
+		}
+		sent <- dropped
+	}()
+
+	// The backlog and buffer hold a few points; the sender must wait for the server
+	// rather than have the rest dropped
+	select {
+	case dropped := <-sent:
+		t.Fatalf("all points queued while the server was down, %d dropped", dropped)
+	case <-time.After(500 * time.Millisecond):
+	}
+	if n := server.received(); n != 0 {
+		t.Fatalf("server received %d points while down", n)
+	}
+
+	server.up.Store(true)
+	select {
+	case dropped := <-sent:
+		if dropped != 0 {
+			t.Fatalf("%d points dropped", dropped)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("points still not queued after the server came up")
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelShutdown()
+	if err := r.shutdown(shutdownCtx); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.received(); n != points {
+		t.Fatalf("server received %d of %d points", n, points)
+	}
+}
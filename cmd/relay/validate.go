@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// validationConfig rejects incoming measurements whose key doesn't match Keys, whose
+// value is outside Min and Max, or whose timestamp is older than MaxAge or further
+// ahead than MaxFuture. Unset rules accept everything.
+type validationConfig struct {
+	Keys      string        `yaml:"keys"`
+	Min       *float64      `yaml:"min"`
+	Max       *float64      `yaml:"max"`
+	MaxAge    time.Duration `yaml:"max_age"`
+	MaxFuture time.Duration `yaml:"max_future"`
+}
+
+// validator checks measurements against the validation rules
+type validator struct {
+	keys           *regexp.Regexp
+	min, max       *float64
+	maxAge, future time.Duration
+}
+
+// newValidator compiles the validation rules, returning nil when there are none
+func newValidator(cfg validationConfig) (*validator, error) {
+	if cfg.MaxAge < 0 || cfg.MaxFuture < 0 {
+		return nil, fmt.Errorf("validate: timestamp windows must not be negative")
+	}
+	if cfg.Min != nil && cfg.Max != nil && *cfg.Min > *cfg.Max {
+		return nil, fmt.Errorf("validate: min %g above max %g", *cfg.Min, *cfg.Max)
+	}
+	v := &validator{min: cfg.Min, max: cfg.Max, maxAge: cfg.MaxAge, future: cfg.MaxFuture}
+	if cfg.Keys != "" {
+		var err error
+		if v.keys, err = regexp.Compile(cfg.Keys); err != nil {
+			return nil, fmt.Errorf("validate: %w", err)
+		}
+	}
+	if v.keys == nil && v.min == nil && v.max == nil && v.maxAge == 0 && v.future == 0 {
+		return nil, nil
+	}
+	return v, nil
+}
+
+// rejection is why a line was turned away, as counted in the metrics and recorded in the
+// dead-letter sink
+type rejection struct {
+	reason string
+	err    error
+}
+
+func (rej *rejection) Error() string { return rej.err.Error() }
+func (rej *rejection) Unwrap() error { return rej.err }
+
+// check returns a rejection for the first point breaking a rule, received at now
+func (v *validator) check(points []gtsdb.DataPoint, now time.Time) *rejection {
+	for _, p := range points {
+		if v.keys != nil && !v.keys.MatchString(p.Key) {
+			return &rejection{"key", fmt.Errorf("key %q not allowed", p.Key)}
+		}
+		if v.min != nil && p.Value < *v.min || v.max != nil && p.Value > *v.max {
+			return &rejection{"value", fmt.Errorf("value %g of %s out of range", p.Value, p.Key)}
+		}
+		if v.maxAge > 0 && p.Timestamp.Before(now.Add(-v.maxAge)) {
+			return &rejection{"timestamp", fmt.Errorf("timestamp of %s older than %v", p.Key, v.maxAge)}
+		}
+		if v.future > 0 && p.Timestamp.After(now.Add(v.future)) {
+			return &rejection{"timestamp", fmt.Errorf("timestamp of %s more than %v ahead", p.Key, v.future)}
+		}
+	}
+	return nil
+}
+
+// validate checks the points parsed from line, rejecting the whole line if any breaks
+// the validation rules
+func (r *relay) validate(src source, line string, points []gtsdb.DataPoint, now time.Time) error {
+	v := r.current().validator
+	if v == nil {
+		return nil
+	}
+	if rej := v.check(points, now); rej != nil {
+		r.reject(src, line, rej)
+		return rej
+	}
+	return nil
+}
+
+// keepValid returns the points breaking no validation rule, rejecting the others one by
+// one, for receivers of structured data that have no lines to reject
+func (r *relay) keepValid(src source, points []gtsdb.DataPoint, now time.Time) []gtsdb.DataPoint {
+	v := r.current().validator
+	if v == nil {
+		return points
+	}
+	kept := points[:0]
+	for _, p := range points {
+		if rej := v.check([]gtsdb.DataPoint{p}, now); rej != nil {
+			r.reject(src, formatPoint(p), rej)
+			continue
+		}
+		kept = append(kept, p)
+	}
+	return kept
+}
+
+// formatPoint renders a point as a line in the line format
+func formatPoint(p gtsdb.DataPoint) string {
+	return p.Key + "," + strconv.FormatInt(p.Timestamp.Unix(), 10) + "," + strconv.FormatFloat(p.Value, 'g', -1, 64)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsPongWait is how long a WebSocket client may stay silent, pongs included
+	wsPongWait = 60 * time.Second
+	// wsPingPeriod is how often clients are pinged, leaving them time to answer
+	wsPingPeriod = wsPongWait * 9 / 10
+	wsWriteWait  = 10 * time.Second
+	// wsSendBuffer is the number of updates buffered per client before they are dropped
+	wsSendBuffer = 256
+	// wsMaxMessage bounds the requests clients send
+	wsMaxMessage = 64 << 10
+)
+
+// wsRequest asks to subscribe to or unsubscribe from keys
+type wsRequest struct {
+	Action string   `json:"action"`
+	Keys   []string `json:"keys"`
+}
+
+// wsSession is a WebSocket client and the keys it is subscribed to
+type wsSession struct {
+	r    *relay
+	conn *websocket.Conn
+	log  *slog.Logger
+	// tenant is the tenant whose keys the client reads
+	tenant *tenant
+	// send carries the messages to the client, written by writeLoop alone
+	send chan any
+	// subs is only used by the goroutine reading the client's requests
+	subs map[string]*gtsdb.Subscription
+	done chan struct{}
+}
+
+// handleSubscribe upgrades to a WebSocket pushing the updates of the keys in the keys
+// parameter, and of those the client later subscribes to with
+// {"action":"subscribe","keys":[...]}, as JSON points
+func (r *relay) handleSubscribe(w http.ResponseWriter, req *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: r.checkOrigin}
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		// The upgrader already replied
+		return
+	}
+
+	s := &wsSession{
+		r:      r,
+		conn:   conn,
+		log:    r.logger.With("remote", req.RemoteAddr),
+		tenant: tenantFrom(req.Context()),
+		send:   make(chan any, wsSendBuffer),
+		subs:   make(map[string]*gtsdb.Subscription),
+		done:   make(chan struct{}),
+	}
+	s.log.Debug("websocket opened")
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		s.writeLoop()
+	}()
+
+	go func() {
+		select {
+		case <-r.closing:
+			// The peer answers the close, which ends readLoop
+			s.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "relay shutting down"), time.Now().Add(wsWriteWait))
+			s.conn.NetConn().SetReadDeadline(time.Now().Add(wsWriteWait))
+		case <-s.done:
+		}
+	}()
+
+	if keys := req.URL.Query().Get("keys"); keys != "" {
+		s.subscribe(req.Context(), strings.Split(keys, ","))
+	}
+	s.readLoop()
+
+	for key, sub := range s.subs {
+		sub.Close()
+		delete(s.subs, key)
+	}
+	close(s.done)
+	<-written
+	s.log.Debug("websocket closed")
+}
+
+// checkOrigin allows same-origin requests and those from the configured origins
+func (r *relay) checkOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range strings.Split(r.cfg.WSOrigins, ",") {
+		if allowed = strings.TrimSpace(allowed); allowed == "*" || (allowed != "" && strings.EqualFold(allowed, origin)) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
+
+// readLoop handles the client's requests until it goes away
+func (s *wsSession) readLoop() {
+	s.conn.SetReadLimit(wsMaxMessage)
+	s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				s.log.Debug("websocket read failed", "error", err)
+			}
+			return
+		}
+		s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			s.reply(fmt.Errorf("invalid request: %w", err))
+			continue
+		}
+		switch req.Action {
+		case "subscribe":
+			s.subscribe(context.Background(), req.Keys)
+		case "unsubscribe":
+			s.unsubscribe(req.Keys)
+		default:
+			s.reply(fmt.Errorf("unknown action %q", req.Action))
+		}
+	}
+}
+
+// subscribe starts forwarding the updates of keys to the client
+func (s *wsSession) subscribe(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if _, ok := s.subs[key]; ok {
+			continue
+		}
+		if err := checkKey(key); err != nil {
+			s.reply(err)
+			continue
+		}
+		sub, err := s.r.clientFor(s.tenant.key(key)).SubscribeStreamContext(ctx, s.tenant.key(key))
+		if err != nil {
+			s.log.Warn("subscribe failed", "key", key, "error", err)
+			s.reply(fmt.Errorf("subscribe %s: %w", key, err))
+			continue
+		}
+		s.subs[key] = sub
+		go func() {
+			for p := range sub.Updates() {
+				select {
+				case s.send <- queryPoint{Key: s.tenant.strip(p.Key), Ts: p.Timestamp.Unix(), Value: p.Value}:
+				default:
+					s.log.Debug("websocket client too slow, dropping update", "key", p.Key)
+				}
+			}
+		}()
+	}
+}
+
+// unsubscribe stops forwarding the updates of keys
+func (s *wsSession) unsubscribe(keys []string) {
+	for _, key := range keys {
+		if sub, ok := s.subs[key]; ok {
+			sub.Close()
+			delete(s.subs, key)
+		}
+	}
+}
+
+// reply tells the client a request failed
+func (s *wsSession) reply(err error) {
+	select {
+	case s.send <- map[string]string{"error": err.Error()}:
+	default:
+	}
+}
+
+// writeLoop writes the queued messages and keeps the connection alive with pings, until
+// the session is done or a write fails
+func (s *wsSession) writeLoop() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+	defer s.conn.Close()
+
+	for {
+		select {
+		case msg := <-s.send:
+			s.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := s.conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			s.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := s.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-s.done:
+			s.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(wsWriteWait))
+			return
+		}
+	}
+}
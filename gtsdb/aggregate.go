@@ -0,0 +1,188 @@
+package gtsdb
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Aggregation selects how the server combines the points of a downsampling interval
+type Aggregation string
+
+const (
+	// AggregateDefault leaves the choice to the server
+	AggregateDefault Aggregation = ""
+	AggregateAvg     Aggregation = "avg"
+	AggregateMin     Aggregation = "min"
+	AggregateMax     Aggregation = "max"
+	AggregateSum     Aggregation = "sum"
+	AggregateCount   Aggregation = "count"
+)
+
+// validate rejects aggregations the protocol doesn't define
+func (a Aggregation) validate() error {
+	switch a {
+	case AggregateDefault, AggregateAvg, AggregateMin, AggregateMax, AggregateSum, AggregateCount:
+		return nil
+	}
+	return fmt.Errorf("unsupported aggregation %q", string(a))
+}
+
+// ReadAggregated is like ReadPoints but has the server combine the points of each
+// downsampling interval with the given aggregation
+func (c *TSDBClient) ReadAggregated(key string, startTime, endTime int64, downsampling int, aggregation Aggregation) ([]DataPoint, error) {
+	return c.ReadAggregatedContext(context.Background(), key, startTime, endTime, downsampling, aggregation)
+}
+
+// ReadAggregatedContext is like ReadAggregated but honours ctx for cancellation and deadlines
+func (c *TSDBClient) ReadAggregatedContext(ctx context.Context, key string, startTime, endTime int64, downsampling int, aggregation Aggregation) ([]DataPoint, error) {
+	data, err := c.readData(ctx, key, startTime, endTime, downsampling, aggregation)
+	if err != nil {
+		return nil, err
+	}
+	return c.parsePoints(data)
+}
+
+// GetMin returns the lowest measurement of a sensor over the last duration
+func (c *TSDBClient) GetMin(sensorID string, duration time.Duration) (float64, error) {
+	return c.GetMinContext(context.Background(), sensorID, duration)
+}
+
+// GetMinContext is like GetMin but honours ctx for cancellation and deadlines
+func (c *TSDBClient) GetMinContext(ctx context.Context, sensorID string, duration time.Duration) (float64, error) {
+	points, err := c.windowPoints(ctx, sensorID, duration)
+	if err != nil {
+		return 0, err
+	}
+
+	lowest := points[0].Value
+	for _, point := range points[1:] {
+		lowest = math.Min(lowest, point.Value)
+	}
+	return lowest, nil
+}
+
+// GetMax returns the highest measurement of a sensor over the last duration
+func (c *TSDBClient) GetMax(sensorID string, duration time.Duration) (float64, error) {
+	return c.GetMaxContext(context.Background(), sensorID, duration)
+}
+
+// GetMaxContext is like GetMax but honours ctx for cancellation and deadlines
+func (c *TSDBClient) GetMaxContext(ctx context.Context, sensorID string, duration time.Duration) (float64, error) {
+	points, err := c.windowPoints(ctx, sensorID, duration)
+	if err != nil {
+		return 0, err
+	}
+
+	highest := points[0].Value
+	for _, point := range points[1:] {
+		highest = math.Max(highest, point.Value)
+	}
+	return highest, nil
+}
+
+// GetSum returns the total of the measurements of a sensor over the last duration
+func (c *TSDBClient) GetSum(sensorID string, duration time.Duration) (float64, error) {
+	return c.GetSumContext(context.Background(), sensorID, duration)
+}
+
+// GetSumContext is like GetSum but honours ctx for cancellation and deadlines
+func (c *TSDBClient) GetSumContext(ctx context.Context, sensorID string, duration time.Duration) (float64, error) {
+	points, err := c.windowPoints(ctx, sensorID, duration)
+	if err != nil {
+		return 0, err
+	}
+
+	var sum float64
+	for _, point := range points {
+		sum += point.Value
+	}
+	return sum, nil
+}
+
+// GetCount returns the number of measurements of a sensor over the last duration.
+// Unlike the other aggregations it reports zero rather than ErrNoData for an empty window.
+func (c *TSDBClient) GetCount(sensorID string, duration time.Duration) (int, error) {
+	return c.GetCountContext(context.Background(), sensorID, duration)
+}
+
+// GetCountContext is like GetCount but honours ctx for cancellation and deadlines
+func (c *TSDBClient) GetCountContext(ctx context.Context, sensorID string, duration time.Duration) (int, error) {
+	startTime, endTime := lastWindow(duration)
+	points, err := c.readSorted(ctx, sensorID, startTime, endTime)
+	if err != nil {
+		return 0, err
+	}
+	return len(c.aggregatable(points)), nil
+}
+
+// GetStdDev returns the population standard deviation of the measurements of a
+// sensor over the last duration
+func (c *TSDBClient) GetStdDev(sensorID string, duration time.Duration) (float64, error) {
+	return c.GetStdDevContext(context.Background(), sensorID, duration)
+}
+
+// GetStdDevContext is like GetStdDev but honours ctx for cancellation and deadlines
+func (c *TSDBClient) GetStdDevContext(ctx context.Context, sensorID string, duration time.Duration) (float64, error) {
+	points, err := c.windowPoints(ctx, sensorID, duration)
+	if err != nil {
+		return 0, err
+	}
+
+	// Welford's method stays accurate for large values with a small spread
+	var mean, m2 float64
+	for i, point := range points {
+		delta := point.Value - mean
+		mean += delta / float64(i+1)
+		m2 += delta * (point.Value - mean)
+	}
+	return math.Sqrt(m2 / float64(len(points))), nil
+}
+
+// GetPercentile returns the p-th percentile, with p between 0 and 100, of the
+// measurements of a sensor over the last duration. It interpolates linearly between
+// the closest ranks, so the 50th percentile of an even count is the mean of the middle two.
+func (c *TSDBClient) GetPercentile(sensorID string, p float64, duration time.Duration) (float64, error) {
+	return c.GetPercentileContext(context.Background(), sensorID, p, duration)
+}
+
+// GetPercentileContext is like GetPercentile but honours ctx for cancellation and deadlines
+func (c *TSDBClient) GetPercentileContext(ctx context.Context, sensorID string, p float64, duration time.Duration) (float64, error) {
+	if p < 0 || p > 100 || math.IsNaN(p) {
+		return 0, fmt.Errorf("percentile %v is outside [0, 100]", p)
+	}
+
+	points, err := c.windowPoints(ctx, sensorID, duration)
+	if err != nil {
+		return 0, err
+	}
+
+	values := make([]float64, len(points))
+	for i, point := range points {
+		values[i] = point.Value
+	}
+	sort.Float64s(values)
+
+	rank := p / 100 * float64(len(values)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return values[lower] + (values[upper]-values[lower])*(rank-float64(lower)), nil
+}
+
+// windowPoints reads the aggregatable points of a sensor over the last duration,
+// failing with ErrNoData when there are none
+func (c *TSDBClient) windowPoints(ctx context.Context, sensorID string, duration time.Duration) ([]DataPoint, error) {
+	startTime, endTime := lastWindow(duration)
+	points, err := c.readSorted(ctx, sensorID, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	points = c.aggregatable(points)
+
+	if len(points) == 0 {
+		return nil, fmt.Errorf("%w for sensor %s in the specified time range", ErrNoData, sensorID)
+	}
+	return points, nil
+}
@@ -0,0 +1,54 @@
+package gtsdb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestZeroAsMissing(t *testing.T) {
+	tests := []struct {
+		name          string
+		zeroAsMissing bool
+		avg, min      float64
+	}{
+		{"zeros included", false, 7.5, 0},
+		{"zeros missing", true, 15, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			var opts []Option
+			if tt.zeroAsMissing {
+				opts = append(opts, WithZeroAsMissing())
+			}
+			client := server.client(t, opts...)
+			now := time.Now().Unix()
+			for i, value := range []float64{0, 10, 0, 20} {
+				server.write("flow", now-50+int64(i)*10, value)
+			}
+			server.write("idle", now-30, 0)
+
+			avg, err := client.GetAverageMeasurement("flow", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if avg != tt.avg {
+				t.Errorf("average %g, want %g", avg, tt.avg)
+			}
+			min, err := client.GetMin("flow", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if min != tt.min {
+				t.Errorf("min %g, want %g", min, tt.min)
+			}
+
+			// A series of only zeros has no data once they are missing
+			_, err = client.GetAverageMeasurement("idle", time.Minute)
+			if tt.zeroAsMissing != errors.Is(err, ErrNoData) {
+				t.Errorf("average of zeros returned %v", err)
+			}
+		})
+	}
+}
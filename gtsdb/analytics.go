@@ -0,0 +1,304 @@
+package gtsdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// maxBuckets bounds the number of buckets of a series computed client-side
+const maxBuckets = 1_000_000
+
+// GetDropRateSeries computes, per bucket, the fraction of expected points that are missing
+// for a sensor reporting every expectedInterval. The values range from 0 (no drops) to 1
+// (nothing received) and are stamped with the start of their bucket. Only the timestamps
+// of the records matter, so raw values count as received; records without a valid
+// timestamp are skipped rather than failing the series, so they count as dropped.
+// A range of more than a million buckets is rejected with ErrRangeTooLarge.
+func (c *TSDBClient) GetDropRateSeries(sensorID string, start, end time.Time, bucket time.Duration, expectedInterval time.Duration) ([]Measurement, error) {
+	return c.GetDropRateSeriesContext(context.Background(), sensorID, start, end, bucket, expectedInterval)
+}
+
+// GetDropRateSeriesContext is like GetDropRateSeries but honours ctx for cancellation and deadlines
+func (c *TSDBClient) GetDropRateSeriesContext(ctx context.Context, sensorID string, start, end time.Time, bucket time.Duration, expectedInterval time.Duration) ([]Measurement, error) {
+	if bucket <= 0 {
+		return nil, fmt.Errorf("%w: bucket must be positive", ErrInvalidInterval)
+	}
+	if expectedInterval <= 0 {
+		return nil, fmt.Errorf("%w: expected interval must be positive", ErrInvalidInterval)
+	}
+	if span := end.Sub(start); span/bucket >= maxBuckets {
+		return nil, fmt.Errorf("%w: %s in buckets of %s makes more than %d buckets", ErrRangeTooLarge, span, bucket, maxBuckets)
+	}
+
+	data, err := c.ReadDataContext(ctx, sensorID, c.timestamp(start), c.timestamp(end), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[int64]int)
+	for _, measurement := range data {
+		_, timestamp, _, err := parseRecord(measurement, c.cfg.TimestampResolution)
+		if err != nil {
+			continue
+		}
+
+		offset := timestamp.Sub(start)
+		if offset < 0 {
+			continue
+		}
+		counts[int64(offset/bucket)]++
+	}
+
+	var series []Measurement
+	for i := int64(0); start.Add(time.Duration(i) * bucket).Before(end); i++ {
+		bucketStart := start.Add(time.Duration(i) * bucket)
+		bucketEnd := bucketStart.Add(bucket)
+		if bucketEnd.After(end) {
+			bucketEnd = end // the last bucket may be partial
+		}
+
+		expected := float64(bucketEnd.Sub(bucketStart)) / float64(expectedInterval)
+		rate := 0.0
+		if expected > 0 {
+			rate = 1 - float64(counts[i])/expected
+		}
+		if rate < 0 {
+			rate = 0
+		}
+
+		series = append(series, Measurement{
+			Timestamp: bucketStart,
+			Value:     rate,
+		})
+	}
+
+	return series, nil
+}
+
+// GetDutyCycle computes the fraction of time a 0/1 style sensor was nonzero between start
+// and end. Each sample's state holds until the next one, and the last one holds until end.
+// The state before the first sample in the window is unknown, so that stretch isn't counted.
+func (c *TSDBClient) GetDutyCycle(sensorID string, start, end time.Time) (float64, error) {
+	return c.GetDutyCycleContext(context.Background(), sensorID, start, end)
+}
+
+// GetDutyCycleContext is like GetDutyCycle but honours ctx for cancellation and deadlines
+func (c *TSDBClient) GetDutyCycleContext(ctx context.Context, sensorID string, start, end time.Time) (float64, error) {
+	points, err := c.readSorted(ctx, sensorID, start, end)
+	if err != nil {
+		return 0, err
+	}
+	if len(points) == 0 {
+		return 0, fmt.Errorf("%w for sensor %s in the specified time range", ErrNoData, sensorID)
+	}
+
+	var on, total time.Duration
+	for i, point := range points {
+		next := end
+		if i+1 < len(points) {
+			next = points[i+1].Timestamp
+		}
+
+		span := next.Sub(point.Timestamp)
+		if span <= 0 {
+			continue
+		}
+		total += span
+		if point.Value != 0 {
+			on += span
+		}
+	}
+
+	if total == 0 {
+		// A single sample right at the end of the window; its state is all we know
+		if points[len(points)-1].Value != 0 {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	return float64(on) / float64(total), nil
+}
+
+// GetRate computes the rate of change per second of a counter-style sensor over the given
+// duration. A decreasing value is treated as a counter reset and the interval spanning the
+// reset is skipped. At least two points are needed, otherwise ErrNoData is returned.
+func (c *TSDBClient) GetRate(sensorID string, duration time.Duration) (float64, error) {
+	return c.GetRateContext(context.Background(), sensorID, duration)
+}
+
+// GetRateContext is like GetRate but honours ctx for cancellation and deadlines
+func (c *TSDBClient) GetRateContext(ctx context.Context, sensorID string, duration time.Duration) (float64, error) {
+	startTime, endTime := lastWindow(duration)
+	points, err := c.readSorted(ctx, sensorID, startTime, endTime)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(points) < 2 {
+		return 0, fmt.Errorf("%w: need at least two points for sensor %s", ErrNoData, sensorID)
+	}
+
+	var delta float64
+	var elapsed time.Duration
+	for i := 1; i < len(points); i++ {
+		if points[i].Value < points[i-1].Value {
+			continue // counter reset
+		}
+		delta += points[i].Value - points[i-1].Value
+		elapsed += points[i].Timestamp.Sub(points[i-1].Timestamp)
+	}
+
+	if elapsed <= 0 {
+		return 0, fmt.Errorf("%w: no usable interval for sensor %s", ErrNoData, sensorID)
+	}
+
+	return delta / elapsed.Seconds(), nil
+}
+
+// GetValueHeatmap counts, per time bucket, how many values fell into each value band,
+// backing a time/value density heatmap. Time buckets are keyed by their start as a
+// timestamp in the client's resolution. valueBuckets holds the ascending lower bounds of the bands; a value is counted
+// in the band with the largest bound not above it, and values below the first bound are ignored.
+func (c *TSDBClient) GetValueHeatmap(sensorID string, start, end time.Time, timeBucket time.Duration, valueBuckets []float64) (map[int64]map[float64]int, error) {
+	return c.GetValueHeatmapContext(context.Background(), sensorID, start, end, timeBucket, valueBuckets)
+}
+
+// GetValueHeatmapContext is like GetValueHeatmap but honours ctx for cancellation and deadlines
+func (c *TSDBClient) GetValueHeatmapContext(ctx context.Context, sensorID string, start, end time.Time, timeBucket time.Duration, valueBuckets []float64) (map[int64]map[float64]int, error) {
+	if timeBucket <= 0 {
+		return nil, fmt.Errorf("%w: time bucket must be positive", ErrInvalidInterval)
+	}
+	if len(valueBuckets) == 0 {
+		return nil, fmt.Errorf("no value buckets given")
+	}
+
+	bounds := append([]float64(nil), valueBuckets...)
+	sort.Float64s(bounds)
+
+	data, err := c.ReadDataContext(ctx, sensorID, c.timestamp(start), c.timestamp(end), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	heatmap := make(map[int64]map[float64]int)
+	for _, record := range data {
+		point, err := c.parseMeasurement(record)
+		if err != nil || c.missing(point) {
+			continue
+		}
+
+		band := sort.SearchFloat64s(bounds, point.Value)
+		if band == len(bounds) || bounds[band] != point.Value {
+			band-- // SearchFloat64s finds the first bound >= value
+		}
+		if band < 0 {
+			continue
+		}
+
+		offset := point.Timestamp.Sub(start)
+		if offset < 0 {
+			continue
+		}
+		bucket := c.timestamp(start.Add(offset / timeBucket * timeBucket))
+
+		if heatmap[bucket] == nil {
+			heatmap[bucket] = make(map[float64]int)
+		}
+		heatmap[bucket][bounds[band]]++
+	}
+
+	return heatmap, nil
+}
+
+// SeasonalPeriod selects how GetSeasonalProfile buckets values
+type SeasonalPeriod int
+
+const (
+	// HourOfDay buckets values by hour, 0 to 23
+	HourOfDay SeasonalPeriod = iota
+	// DayOfWeek buckets values by weekday, 0 (Sunday) to 6
+	DayOfWeek
+)
+
+// GetSeasonalProfile averages the values of a sensor between start and end per hour of
+// day or day of week, giving a seasonal baseline. Buckets are computed in the location
+// of start, so pass it in the local time zone the pattern follows.
+func (c *TSDBClient) GetSeasonalProfile(sensorID string, start, end time.Time, period SeasonalPeriod) (map[int]float64, error) {
+	return c.GetSeasonalProfileContext(context.Background(), sensorID, start, end, period)
+}
+
+// GetSeasonalProfileContext is like GetSeasonalProfile but honours ctx for cancellation and deadlines
+func (c *TSDBClient) GetSeasonalProfileContext(ctx context.Context, sensorID string, start, end time.Time, period SeasonalPeriod) (map[int]float64, error) {
+	if period != HourOfDay && period != DayOfWeek {
+		return nil, fmt.Errorf("unknown seasonal period %d", period)
+	}
+
+	points, err := c.readSorted(ctx, sensorID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	points = c.aggregatable(points)
+	if len(points) == 0 {
+		return nil, fmt.Errorf("%w for sensor %s in the specified time range", ErrNoData, sensorID)
+	}
+
+	sums := make(map[int]float64)
+	counts := make(map[int]int)
+	for _, point := range points {
+		local := point.Timestamp.In(start.Location())
+		bucket := local.Hour()
+		if period == DayOfWeek {
+			bucket = int(local.Weekday())
+		}
+		sums[bucket] += point.Value
+		counts[bucket]++
+	}
+
+	profile := make(map[int]float64, len(sums))
+	for bucket, sum := range sums {
+		profile[bucket] = sum / float64(counts[bucket])
+	}
+	return profile, nil
+}
+
+// lastWindow returns the time range covering the given duration up to now
+func lastWindow(duration time.Duration) (time.Time, time.Time) {
+	endTime := time.Now()
+	return endTime.Add(-duration), endTime
+}
+
+// missing reports whether an aggregation should treat the point as absent
+func (c *TSDBClient) missing(point DataPoint) bool {
+	return c.cfg.ZeroAsMissing && point.Value == 0
+}
+
+// aggregatable filters out the points aggregations should treat as absent
+func (c *TSDBClient) aggregatable(points []DataPoint) []DataPoint {
+	if !c.cfg.ZeroAsMissing {
+		return points
+	}
+
+	kept := make([]DataPoint, 0, len(points))
+	for _, point := range points {
+		if !c.missing(point) {
+			kept = append(kept, point)
+		}
+	}
+	return kept
+}
+
+// readSorted reads the raw points of a sensor between start and end ordered by timestamp
+func (c *TSDBClient) readSorted(ctx context.Context, sensorID string, start, end time.Time) ([]DataPoint, error) {
+	points, err := c.ReadPointsContext(ctx, sensorID, c.timestamp(start), c.timestamp(end), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Timestamp.Before(points[j].Timestamp)
+	})
+	return points, nil
+}
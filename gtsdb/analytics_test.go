@@ -0,0 +1,187 @@
+package gtsdb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGetDropRateSeries(t *testing.T) {
+	server := newFakeServer(t)
+	client := server.client(t)
+
+	// A point every 10s over three minutes, but for four missing in the second minute
+	start := time.Unix(1700000040, 0)
+	for ts := start.Unix(); ts < start.Unix()+180; ts += 10 {
+		if offset := ts - start.Unix(); offset >= 70 && offset < 110 {
+			continue
+		}
+		server.write("pump", ts, 1)
+	}
+
+	series, err := client.GetDropRateSeries("pump", start, start.Add(3*time.Minute), time.Minute, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{0, 4.0 / 6, 0}
+	if len(series) != len(want) {
+		t.Fatalf("got %d buckets, want %d", len(series), len(want))
+	}
+	for i, m := range series {
+		if bucket := start.Add(time.Duration(i) * time.Minute); !m.Timestamp.Equal(bucket) {
+			t.Errorf("bucket %d at %s, want %s", i, m.Timestamp, bucket)
+		}
+		if diff := m.Value - want[i]; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("bucket %d drop rate %g, want %g", i, m.Value, want[i])
+		}
+	}
+
+	// A year in buckets of a millisecond is refused before anything is read
+	reads := server.count("pump,")
+	if _, err := client.GetDropRateSeries("pump", start, start.Add(365*24*time.Hour), time.Millisecond, time.Second); !errors.Is(err, ErrRangeTooLarge) {
+		t.Fatalf("a range of too many buckets returned %v, want ErrRangeTooLarge", err)
+	}
+	if n := server.count("pump,"); n != reads {
+		t.Fatalf("server received %d reads for a rejected range", n-reads)
+	}
+}
+
+func TestGetDutyCycle(t *testing.T) {
+	server := newFakeServer(t)
+	client := server.client(t)
+
+	// On 10s-40s and 50s-80s, off until the end at 100s; the first 10s are unknown
+	start := time.Unix(1700000000, 0)
+	for _, p := range []struct {
+		offset int64
+		value  float64
+	}{{10, 1}, {40, 0}, {50, 1}, {80, 0}} {
+		server.write("valve", start.Unix()+p.offset, p.value)
+	}
+
+	duty, err := client.GetDutyCycle("valve", start, start.Add(100*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 60.0 / 90; duty < want-1e-9 || duty > want+1e-9 {
+		t.Fatalf("duty cycle %g, want %g", duty, want)
+	}
+
+	if _, err := client.GetDutyCycle("valve", start.Add(time.Hour), start.Add(2*time.Hour)); !errors.Is(err, ErrNoData) {
+		t.Fatalf("empty window returned %v, want ErrNoData", err)
+	}
+}
+
+func TestGetValueHeatmap(t *testing.T) {
+	server := newFakeServer(t)
+	client := server.client(t)
+
+	// Two minutes of values, in bands starting at 0, 10 and 20
+	start := time.Unix(1700000040, 0)
+	for i, value := range []float64{
+		-5, 1, 9.5, 10, 15, 25, // first minute; -5 is below every band
+		20, 20, 21, 30, 11, 0, // second minute
+	} {
+		server.write("temp", start.Unix()+int64(i)*10, value)
+	}
+
+	heatmap, err := client.GetValueHeatmap("temp", start, start.Add(2*time.Minute), time.Minute, []float64{20, 0, 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := start.Unix(), start.Add(time.Minute).Unix()
+	want := map[int64]map[float64]int{
+		first:  {0: 2, 10: 2, 20: 1},
+		second: {0: 1, 10: 1, 20: 4},
+	}
+	if len(heatmap) != len(want) {
+		t.Fatalf("got time buckets %v, want %v", heatmap, want)
+	}
+	for bucket, bands := range want {
+		if len(heatmap[bucket]) != len(bands) {
+			t.Errorf("bucket %d: got %v, want %v", bucket, heatmap[bucket], bands)
+			continue
+		}
+		for band, n := range bands {
+			if heatmap[bucket][band] != n {
+				t.Errorf("bucket %d band %g: got %d values, want %d", bucket, band, heatmap[bucket][band], n)
+			}
+		}
+	}
+
+	if _, err := client.GetValueHeatmap("temp", start, start.Add(time.Minute), 0, []float64{0}); !errors.Is(err, ErrInvalidInterval) {
+		t.Fatalf("zero time bucket returned %v, want ErrInvalidInterval", err)
+	}
+}
+
+func TestGetValueHeatmapMilliseconds(t *testing.T) {
+	server := newFakeServer(t)
+	client := server.client(t, WithTimestampResolution(Milliseconds))
+
+	// Buckets of half a second, keyed in milliseconds like the points
+	start := time.UnixMilli(1700000000000)
+	for i, value := range []float64{1, 2, 3} {
+		server.write("temp", start.Add(time.Duration(i)*300*time.Millisecond).UnixMilli(), value)
+	}
+
+	heatmap, err := client.GetValueHeatmap("temp", start, start.Add(time.Second), 500*time.Millisecond, []float64{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := start.UnixMilli(), start.Add(500*time.Millisecond).UnixMilli()
+	if len(heatmap) != 2 || heatmap[first][0] != 2 || heatmap[second][0] != 1 {
+		t.Fatalf("got %v, want 2 values at %d and 1 at %d", heatmap, first, second)
+	}
+}
+
+func TestGetSeasonalProfile(t *testing.T) {
+	server := newFakeServer(t)
+	client := server.client(t)
+
+	// Three days of hourly values 10 times the local hour, plus the day: 0, 1 or 2
+	local := time.FixedZone("UTC+2", 2*3600)
+	start := time.Date(2023, time.November, 14, 0, 0, 0, 0, local) // a Tuesday
+	end := start.Add(72*time.Hour - time.Second)
+	for day := 0; day < 3; day++ {
+		for hour := 0; hour < 24; hour++ {
+			ts := start.Add(time.Duration(day*24+hour) * time.Hour).Unix()
+			server.write("load", ts, float64(hour*10+day))
+		}
+	}
+
+	profile, err := client.GetSeasonalProfile("load", start, end, HourOfDay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profile) != 24 {
+		t.Fatalf("got %d hours, want 24", len(profile))
+	}
+	for hour := 0; hour < 24; hour++ {
+		if want := float64(hour*10 + 1); profile[hour] != want {
+			t.Errorf("hour %d averages %g, want %g", hour, profile[hour], want)
+		}
+	}
+
+	// Bucketed in UTC, the pattern moves two hours earlier
+	profile, err = client.GetSeasonalProfile("load", start.In(time.UTC), end, HourOfDay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 21.0; profile[0] != want {
+		t.Errorf("UTC hour 0 averages %g, want %g", profile[0], want)
+	}
+
+	profile, err = client.GetSeasonalProfile("load", start, end, DayOfWeek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]float64{int(time.Tuesday): 115, int(time.Wednesday): 116, int(time.Thursday): 117}
+	if len(profile) != len(want) {
+		t.Fatalf("got days %v, want %v", profile, want)
+	}
+	for day, avg := range want {
+		if profile[day] != avg {
+			t.Errorf("day %d averages %g, want %g", day, profile[day], avg)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package gtsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrBufferFull is returned by AsyncWriter.Write when the buffer limit is reached
+var ErrBufferFull = errors.New("write buffer is full")
+
+// AsyncOption configures an AsyncWriter
+type AsyncOption func(*AsyncWriter)
+
+// WithBatchSize sets how many buffered points trigger a flush. It defaults to 1000.
+func WithBatchSize(n int) AsyncOption {
+	return func(w *AsyncWriter) {
+		w.batchSize = n
+	}
+}
+
+// WithFlushInterval sets how often buffered points are flushed regardless of the
+// batch size. It defaults to one second; zero flushes on size only.
+func WithFlushInterval(interval time.Duration) AsyncOption {
+	return func(w *AsyncWriter) {
+		w.flushInterval = interval
+	}
+}
+
+// WithBufferLimit bounds how many points may wait in the buffer, for example while
+// the server is unreachable. It defaults to ten batches.
+func WithBufferLimit(n int) AsyncOption {
+	return func(w *AsyncWriter) {
+		w.bufferLimit = n
+	}
+}
+
+// WithErrorHandler sets a function called with the error and the points of every
+// background flush that fails. Those points are not retried.
+func WithErrorHandler(handler func(err error, points []DataPoint)) AsyncOption {
+	return func(w *AsyncWriter) {
+		w.onError = handler
+	}
+}
+
+// AsyncWriter buffers points in memory and writes them to the TSDB in batches from
+// the background, so that Write never waits on the network
+type AsyncWriter struct {
+	client        *TSDBClient
+	batchSize     int
+	flushInterval time.Duration
+	bufferLimit   int
+	onError       func(err error, points []DataPoint)
+
+	mu     sync.Mutex
+	buf    []DataPoint
+	closed bool
+
+	// flushMu keeps batches in order when a Flush races the background flush
+	flushMu sync.Mutex
+	// full wakes the background flush once a batch is ready
+	full chan struct{}
+	done chan struct{}
+	// stopped is closed when the background flush has returned
+	stopped   chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// AsyncWriter starts a writer that buffers points and flushes them in the background.
+// It must be closed to flush the last points; closing it leaves the client open.
+func (c *TSDBClient) AsyncWriter(opts ...AsyncOption) (*AsyncWriter, error) {
+	w := &AsyncWriter{
+		client:        c,
+		batchSize:     1000,
+		flushInterval: time.Second,
+		full:          make(chan struct{}, 1),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.batchSize < 1 {
+		return nil, fmt.Errorf("batch size must be at least 1")
+	}
+	if w.bufferLimit == 0 {
+		w.bufferLimit = 10 * w.batchSize
+	}
+	if w.bufferLimit < w.batchSize {
+		return nil, fmt.Errorf("buffer limit %d is smaller than the batch size %d", w.bufferLimit, w.batchSize)
+	}
+
+	go w.run()
+	return w, nil
+}
+
+// Write adds a point to the buffer without waiting for it to be sent
+func (w *AsyncWriter) Write(point DataPoint) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return fmt.Errorf("async write: %w", ErrClosed)
+	}
+	if len(w.buf) >= w.bufferLimit {
+		w.client.logger.Warn("async buffer full, dropping point", "key", point.Key, "buffered", len(w.buf))
+		return fmt.Errorf("async write: %w", ErrBufferFull)
+	}
+	w.buf = append(w.buf, point)
+	w.client.bufferedPoints.Add(1)
+
+	if len(w.buf) >= w.batchSize {
+		select {
+		case w.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Flush writes out every buffered point and waits until they are sent
+func (w *AsyncWriter) Flush() error {
+	return w.FlushContext(context.Background())
+}
+
+// FlushContext is like Flush but honours ctx for cancellation and deadlines
+func (w *AsyncWriter) FlushContext(ctx context.Context) error {
+	points, err := w.flush(ctx)
+	if err != nil {
+		return fmt.Errorf("flush %d points: %w", len(points), err)
+	}
+	return nil
+}
+
+// Close stops the background flush and flushes the remaining points. It is safe
+// to call more than once.
+func (w *AsyncWriter) Close() error {
+	w.closeOnce.Do(func() {
+		w.mu.Lock()
+		w.closed = true
+		w.mu.Unlock()
+
+		close(w.done)
+		<-w.stopped
+		w.closeErr = w.Flush()
+	})
+	return w.closeErr
+}
+
+// run flushes whenever a batch fills up or the flush interval passes
+func (w *AsyncWriter) run() {
+	defer close(w.stopped)
+
+	var tick <-chan time.Time
+	if w.flushInterval > 0 {
+		ticker := time.NewTicker(w.flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-w.full:
+		case <-tick:
+		}
+		points, err := w.flush(context.Background())
+		if err == nil {
+			continue
+		}
+		if w.onError != nil {
+			w.onError(err, points)
+		} else {
+			w.client.logger.Error("async flush failed", "points", len(points), "error", err)
+		}
+	}
+}
+
+// flush sends the buffered points in batches, returning the points of a failed batch
+// along with its error. Points buffered behind a failed batch stay for the next flush.
+func (w *AsyncWriter) flush(ctx context.Context) ([]DataPoint, error) {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	for {
+		w.mu.Lock()
+		n := min(len(w.buf), w.batchSize)
+		if n == 0 {
+			w.mu.Unlock()
+			return nil, nil
+		}
+		batch := make([]DataPoint, n)
+		copy(batch, w.buf)
+		w.buf = append(w.buf[:0], w.buf[n:]...)
+		w.mu.Unlock()
+		w.client.bufferedPoints.Add(int64(-n))
+
+		if err := w.client.WriteBatchContext(ctx, batch); err != nil {
+			return batch, err
+		}
+	}
+}
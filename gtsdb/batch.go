@@ -0,0 +1,106 @@
+package gtsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// WriteBatch writes many data points in a single network write, pipelining the
+// commands instead of paying a write per point like WriteData. With WithWriteAcks it
+// waits for the acknowledgements like WriteBatchSync.
+func (c *TSDBClient) WriteBatch(points []DataPoint) error {
+	return c.WriteBatchContext(context.Background(), points)
+}
+
+// WriteBatchContext is like WriteBatch but honours ctx for cancellation and deadlines
+func (c *TSDBClient) WriteBatchContext(ctx context.Context, points []DataPoint) (err error) {
+	if c.cfg.WriteAcks {
+		return c.WriteBatchSyncContext(ctx, points)
+	}
+	done := c.observe(ctx, Command{Op: "write batch", Points: len(points)})
+	defer func() { done(err) }()
+
+	if len(points) == 0 {
+		return nil
+	}
+
+	payload, err := c.formatBatch("write batch", points)
+	if err != nil {
+		return err
+	}
+	defer c.wroteBatch(points)
+	return c.exchangePayload(ctx, "write batch", nonIdempotent, 0, payload, nil)
+}
+
+// WriteBatchSync is like WriteBatch but then waits for the server to acknowledge
+// every point. The acknowledgements are read back in one pass after the write, so
+// the batch still costs a single round trip. Points the server rejects are reported
+// together, each wrapped with its index in the batch.
+func (c *TSDBClient) WriteBatchSync(points []DataPoint) error {
+	return c.WriteBatchSyncContext(context.Background(), points)
+}
+
+// WriteBatchSyncContext is like WriteBatchSync but honours ctx for cancellation and deadlines
+func (c *TSDBClient) WriteBatchSyncContext(ctx context.Context, points []DataPoint) (err error) {
+	done := c.observe(ctx, Command{Op: "write batch sync", Points: len(points)})
+	defer func() { done(err) }()
+
+	if len(points) == 0 {
+		return nil
+	}
+
+	payload, err := c.formatBatch("write batch sync", points)
+	if err != nil {
+		return err
+	}
+	defer c.wroteBatch(points)
+
+	var rejected []error
+	err = c.exchangePayload(ctx, "write batch sync", nonIdempotent, c.cfg.WriteTimeout, payload, func(pc *poolConn) error {
+		// Start over if an earlier attempt was retried
+		rejected = nil
+		// Read every acknowledgement, even after a rejection, so the stream stays in sync
+		for i := range points {
+			response, err := pc.readLine()
+			var serverErr *ServerError
+			switch {
+			case errors.As(err, &serverErr):
+				rejected = append(rejected, fmt.Errorf("point %d (%s): %w", i, points[i].Key, err))
+			case err != nil:
+				if isTimeout(err) {
+					// The acknowledgements still due can't be told apart from later replies
+					pc.broken = true
+				}
+				return err
+			case strings.TrimSpace(response) != ackReply:
+				rejected = append(rejected, fmt.Errorf("point %d (%s): unexpected acknowledgement %q", i, points[i].Key, strings.TrimSpace(response)))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if isTimeout(err) && ctx.Err() == nil {
+			return fmt.Errorf("write batch sync: no acknowledgement within %s: %w", effectiveTimeout(ctx, c.cfg.WriteTimeout), err)
+		}
+		return err
+	}
+	if len(rejected) > 0 {
+		return fmt.Errorf("write batch sync: %d of %d points rejected: %w", len(rejected), len(points), errors.Join(rejected...))
+	}
+	return nil
+}
+
+// formatBatch builds the write commands of a batch into a single payload
+func (c *TSDBClient) formatBatch(op string, points []DataPoint) (string, error) {
+	var b strings.Builder
+	for i, point := range points {
+		size := b.Len()
+		b.WriteString(c.formatWrite(point.Key, c.timestamp(point.Timestamp), point.Value))
+		if n := b.Len() - size; n > MaxMessageBytes {
+			return "", fmt.Errorf("%s: point %d: command of %d bytes exceeds MaxMessageBytes", op, i, n)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,36 @@
+package gtsdb
+
+import (
+	"testing"
+	"time"
+)
+
+// BenchmarkWriteData and BenchmarkWriteBatch write b.N points, one network write per
+// point and one per batchSize points respectively
+func BenchmarkWriteData(b *testing.B) {
+	client := newFakeServer(b).client(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := client.WriteData("temp", int64(i), float64(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteBatch(b *testing.B) {
+	const batchSize = 256
+	client := newFakeServer(b).client(b)
+
+	batch := make([]DataPoint, 0, batchSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		batch = append(batch, DataPoint{Key: "temp", Timestamp: time.Unix(int64(i), 0), Value: float64(i)})
+		if len(batch) == batchSize || i == b.N-1 {
+			if err := client.WriteBatch(batch); err != nil {
+				b.Fatal(err)
+			}
+			batch = batch[:0]
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package gtsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the server while the circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerState is the state of the client's circuit breaker
+type BreakerState int
+
+const (
+	// BreakerClosed lets every command through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails every command with ErrCircuitOpen until the cool-down has passed
+	BreakerOpen
+	// BreakerHalfOpen lets a trial command through to probe whether the server is back
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerPolicy configures the circuit breaker enabled with WithCircuitBreaker
+type BreakerPolicy struct {
+	// FailureThreshold is the number of consecutive connection failures that open the
+	// breaker; zero disables it
+	FailureThreshold int
+	// CoolDown is how long the breaker stays open before a trial command is let through
+	CoolDown time.Duration
+	// SuccessThreshold is the number of successful trials that close the breaker again.
+	// It defaults to one.
+	SuccessThreshold int
+	// OnStateChange, when set, is called after every transition
+	OnStateChange func(from, to BreakerState)
+}
+
+// circuitBreaker fast-fails commands once the server stopped answering, instead of
+// letting every caller wait for its own dial or read to time out
+type circuitBreaker struct {
+	policy BreakerPolicy
+	// notify reports transitions, outside of mu so that it may inspect the breaker
+	notify func(from, to BreakerState)
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	successes int
+	openedAt  time.Time
+	// trial is set while the single command allowed in the half-open state is in flight
+	trial bool
+}
+
+func newCircuitBreaker(policy BreakerPolicy, notify func(from, to BreakerState)) *circuitBreaker {
+	if policy.SuccessThreshold < 1 {
+		policy.SuccessThreshold = 1
+	}
+	return &circuitBreaker{policy: policy, notify: notify}
+}
+
+// allow reports whether a command may be sent, moving an open breaker whose cool-down
+// has passed to half-open
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	from := b.state
+	allowed := true
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.policy.CoolDown {
+			allowed = false
+			break
+		}
+		b.state, b.successes, b.trial = BreakerHalfOpen, 0, true
+	case BreakerHalfOpen:
+		if b.trial {
+			allowed = false
+			break
+		}
+		b.trial = true
+	}
+	to := b.state
+	b.mu.Unlock()
+
+	if from != to {
+		b.notify(from, to)
+	}
+	return allowed
+}
+
+// record accounts for the outcome of an allowed command
+func (b *circuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	from := b.state
+	switch b.state {
+	case BreakerClosed:
+		if !failed {
+			b.failures = 0
+			break
+		}
+		if b.failures++; b.failures >= b.policy.FailureThreshold {
+			b.state, b.openedAt = BreakerOpen, time.Now()
+		}
+	case BreakerHalfOpen:
+		b.trial = false
+		if failed {
+			b.state, b.openedAt = BreakerOpen, time.Now()
+			break
+		}
+		if b.successes++; b.successes >= b.policy.SuccessThreshold {
+			b.state, b.failures = BreakerClosed, 0
+		}
+	}
+	to := b.state
+	b.mu.Unlock()
+
+	if from != to {
+		b.notify(from, to)
+	}
+}
+
+// abandon gives up on an allowed command whose outcome says nothing about the server
+func (b *circuitBreaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.trial = false
+	}
+}
+
+// current returns the state of the breaker
+func (b *circuitBreaker) current() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// BreakerState returns the state of the circuit breaker, which is always BreakerClosed
+// unless one was enabled with WithCircuitBreaker
+func (c *TSDBClient) BreakerState() BreakerState {
+	if c.breaker == nil {
+		return BreakerClosed
+	}
+	return c.breaker.current()
+}
+
+// guard runs attempt unless the circuit breaker is open, and records whether the
+// server could be reached. Errors the server replied with count as successes.
+func (c *TSDBClient) guard(ctx context.Context, op string, attempt func() error) error {
+	if c.breaker == nil {
+		return attempt()
+	}
+	if !c.breaker.allow() {
+		return fmt.Errorf("%s: %w", op, ErrCircuitOpen)
+	}
+
+	err := attempt()
+	if err != nil && (c.closed.Load() || ctx.Err() != nil) {
+		// Nothing learned about the server
+		c.breaker.abandon()
+		return err
+	}
+	c.breaker.record(err != nil && retryable(err))
+	return err
+}
+
+// breakerChanged logs transitions of the circuit breaker and forwards them to the policy's callback
+func (c *TSDBClient) breakerChanged(from, to BreakerState) {
+	c.logger.Warn("circuit breaker state changed", "address", c.cfg.Address, "from", from.String(), "to", to.String())
+	if c.cfg.Breaker.OnStateChange != nil {
+		c.cfg.Breaker.OnStateChange(from, to)
+	}
+}
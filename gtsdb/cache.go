@@ -0,0 +1,263 @@
+package gtsdb
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultQueryCacheSize is the number of results the query cache keeps by default
+const defaultQueryCacheSize = 1024
+
+// queryKey identifies a ReadData query
+type queryKey struct {
+	key          string
+	startTime    int64
+	endTime      int64
+	downsampling int
+	aggregation  Aggregation
+}
+
+// cacheEntry is a query result, or the latest point of a key, kept until it expires
+type cacheEntry struct {
+	// query identifies a query result; latest is set instead for a latest point
+	query   queryKey
+	latest  bool
+	records []string
+	point   DataPoint
+	expires time.Time
+}
+
+// queryCache keeps ReadData results and the latest points of keys for a time to live,
+// evicting the least recently used entries beyond its size
+type queryCache struct {
+	ttl  time.Duration
+	size int
+
+	mu sync.Mutex
+	// lru holds the entries, the most recently used first
+	lru     *list.List
+	queries map[queryKey]*list.Element
+	latest  map[string]*list.Element
+}
+
+func newQueryCache(ttl time.Duration, size int) *queryCache {
+	return &queryCache{
+		ttl:     ttl,
+		size:    size,
+		lru:     list.New(),
+		queries: make(map[queryKey]*list.Element),
+		latest:  make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached records of a query that hasn't expired yet
+func (qc *queryCache) get(k queryKey) ([]string, bool) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+
+	entry, ok := qc.lookup(qc.queries[k])
+	if !ok {
+		return nil, false
+	}
+	// Hand out a copy so callers can't modify the cached result
+	return append([]string(nil), entry.records...), true
+}
+
+// put stores the records of a query
+func (qc *queryCache) put(k queryKey, records []string) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+
+	if e := qc.queries[k]; e != nil {
+		qc.remove(e)
+	}
+	// Keep a copy so callers can't modify the cached result through the records they got
+	qc.queries[k] = qc.add(&cacheEntry{query: k, records: append([]string(nil), records...)})
+}
+
+// getLatest returns the cached latest point of key, if it hasn't expired yet
+func (qc *queryCache) getLatest(key string) (DataPoint, bool) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+
+	entry, ok := qc.lookup(qc.latest[key])
+	return entry.point, ok
+}
+
+// putLatest stores the latest point of a key, unless a more recent one is cached
+func (qc *queryCache) putLatest(point DataPoint) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+
+	if e := qc.latest[point.Key]; e != nil {
+		if e.Value.(*cacheEntry).point.Timestamp.After(point.Timestamp) {
+			return
+		}
+		qc.remove(e)
+	}
+	qc.latest[point.Key] = qc.add(&cacheEntry{latest: true, point: point})
+}
+
+// update takes in a point pushed for a subscribed key, at timestamp in the client's
+// resolution: it becomes the key's latest point, and the cached queries whose range
+// holds it are dropped
+func (qc *queryCache) update(point DataPoint, timestamp int64) {
+	qc.putLatest(point)
+	qc.drop(point.Key, timestamp, timestamp)
+}
+
+// drop removes the cached queries of key whose range overlaps startTime to endTime
+func (qc *queryCache) drop(key string, startTime, endTime int64) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	for k, e := range qc.queries {
+		if k.key == key && k.startTime <= endTime && startTime <= k.endTime {
+			qc.remove(e)
+		}
+	}
+}
+
+// dropLatest removes the cached latest point of key unless it is more recent than t
+func (qc *queryCache) dropLatest(key string, t time.Time) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	if e := qc.latest[key]; e != nil && !e.Value.(*cacheEntry).point.Timestamp.After(t) {
+		qc.remove(e)
+	}
+}
+
+// invalidate drops every cached query and the latest point of key
+func (qc *queryCache) invalidate(key string) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+
+	for k, e := range qc.queries {
+		if k.key == key {
+			qc.remove(e)
+		}
+	}
+	if e := qc.latest[key]; e != nil {
+		qc.remove(e)
+	}
+}
+
+// lookup returns the entry of e unless it is nil or expired, marking it recently used.
+// qc.mu is held.
+func (qc *queryCache) lookup(e *list.Element) (*cacheEntry, bool) {
+	if e == nil {
+		return &cacheEntry{}, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		qc.remove(e)
+		return &cacheEntry{}, false
+	}
+	qc.lru.MoveToFront(e)
+	return entry, true
+}
+
+// add inserts entry as the most recently used, evicting the least recently used
+// entries beyond the size. qc.mu is held.
+func (qc *queryCache) add(entry *cacheEntry) *list.Element {
+	entry.expires = time.Now().Add(qc.ttl)
+	e := qc.lru.PushFront(entry)
+	for qc.lru.Len() > qc.size {
+		qc.remove(qc.lru.Back())
+	}
+	return e
+}
+
+// remove drops the entry of e. qc.mu is held.
+func (qc *queryCache) remove(e *list.Element) {
+	entry := qc.lru.Remove(e).(*cacheEntry)
+	if entry.latest {
+		delete(qc.latest, entry.point.Key)
+	} else {
+		delete(qc.queries, entry.query)
+	}
+}
+
+// wrote drops the cached queries a write of points to key between startTime and endTime
+// may have changed, and its latest point unless it is more recent. It is called whether
+// or not the write succeeded, since the server may have taken the points before the
+// write failed.
+func (c *TSDBClient) wrote(key string, startTime, endTime int64) {
+	if c.cache != nil {
+		c.cache.drop(key, startTime, endTime)
+		c.cache.dropLatest(key, c.cfg.TimestampResolution.ToTime(endTime))
+	}
+}
+
+// wroteBatch is like wrote for the points of a batch
+func (c *TSDBClient) wroteBatch(points []DataPoint) {
+	if c.cache == nil {
+		return
+	}
+	type span struct{ start, end int64 }
+	spans := make(map[string]span)
+	for _, point := range points {
+		ts := c.timestamp(point.Timestamp)
+		s, ok := spans[point.Key]
+		if !ok {
+			s = span{ts, ts}
+		}
+		spans[point.Key] = span{min(s.start, ts), max(s.end, ts)}
+	}
+	for key, s := range spans {
+		c.wrote(key, s.start, s.end)
+	}
+}
+
+// WarmCache pre-populates the query cache with the history of each key over the time
+// range at the given interval, so that the matching GetMeasurementHistory calls are served
+// from memory. Queries run with at most WithMaxConcurrency in flight and no new ones are
+// started once ctx is done. It requires the cache to be enabled with WithQueryCache.
+func (c *TSDBClient) WarmCache(ctx context.Context, keys []string, start, end time.Time, interval time.Duration) error {
+	if c.cache == nil {
+		return fmt.Errorf("query cache is not enabled")
+	}
+
+	downsampling, err := c.downsampling(interval)
+	if err != nil {
+		return err
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	slots := make(chan struct{}, c.cfg.MaxConcurrency)
+
+schedule:
+	for _, key := range keys {
+		select {
+		case <-ctx.Done():
+			break schedule
+		case slots <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			if _, err := c.ReadDataContext(ctx, key, c.timestamp(start), c.timestamp(end), downsampling); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(key)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return firstErr
+}
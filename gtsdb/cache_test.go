@@ -0,0 +1,174 @@
+package gtsdb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWarmCache(t *testing.T) {
+	server := newFakeServer(t)
+	client := server.client(t, WithQueryCache(time.Minute))
+
+	start := time.Unix(1700000000, 0)
+	end := start.Add(time.Hour)
+	for i := 0; i < 6; i++ {
+		server.write("temp", start.Add(time.Duration(i)*10*time.Minute).Unix(), float64(i))
+		server.write("humidity", start.Add(time.Duration(i)*10*time.Minute).Unix(), float64(50+i))
+	}
+
+	if err := client.WarmCache(context.Background(), []string{"temp", "humidity"}, start, end, 30*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	reads := server.count("temp,")
+	if reads != 1 {
+		t.Fatalf("warming read temp %d times, want 1", reads)
+	}
+
+	history, err := client.GetMeasurementHistory("temp", start, end, 30*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.count("temp,") != reads {
+		t.Fatal("history of a warmed key read from the server")
+	}
+	if len(history) != 2 || history[0].Value != 1 || history[1].Value != 4 {
+		t.Fatalf("got %+v, want the averages 1 and 4", history)
+	}
+
+	// Changing the history doesn't change the cached one
+	history[0].Value = 100
+	records, err := client.ReadData("temp", start.Unix(), end.Unix(), 1800)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records[0] = "temp,0,100"
+	history, err = client.GetMeasurementHistory("temp", start, end, 30*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Value != 1 {
+		t.Fatalf("got %+v after changing a result, want the cached averages", history)
+	}
+}
+
+func TestWritesInvalidateCache(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	end := start.Add(time.Hour)
+	// The history reads, as opposed to the writes, of a key
+	historyReads := func(server *fakeServer, key string) int {
+		return server.count(fmt.Sprintf("%s,%d,%d,", key, start.Unix(), end.Unix()))
+	}
+
+	tests := []struct {
+		name  string
+		acks  bool
+		write func(c *TSDBClient) error
+	}{
+		{"WriteData", false, func(c *TSDBClient) error { return c.WriteData("temp", start.Unix()+1, 1) }},
+		{"WriteDataSync", true, func(c *TSDBClient) error { return c.WriteDataSync("temp", start.Unix()+2, 1) }},
+		{"WriteBatch", false, func(c *TSDBClient) error {
+			return c.WriteBatch([]DataPoint{{Key: "temp", Timestamp: start.Add(3 * time.Second), Value: 1}})
+		}},
+		{"WriteBatchSync", true, func(c *TSDBClient) error {
+			return c.WriteBatchSync([]DataPoint{{Key: "temp", Timestamp: start.Add(4 * time.Second), Value: 1}})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, func(s *fakeServer) { s.acks = tt.acks })
+			client := server.client(t, WithQueryCache(time.Minute))
+			server.write("temp", start.Unix(), 0)
+			server.write("humidity", start.Unix(), 50)
+
+			for _, key := range []string{"temp", "humidity"} {
+				if _, err := client.GetMeasurementHistory(key, start, end, 30*time.Minute); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := tt.write(client); err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range []string{"temp", "humidity"} {
+				if _, err := client.GetMeasurementHistory(key, start, end, 30*time.Minute); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if n := historyReads(server, "temp"); n != 2 {
+				t.Fatal("the write left the cached history in place")
+			}
+			if n := historyReads(server, "humidity"); n != 1 {
+				t.Fatal("a write to temp dropped the cached history of humidity")
+			}
+
+			// A write outside the range keeps it too
+			if err := client.WriteData("temp", end.Unix()+60, 1); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := client.GetMeasurementHistory("temp", start, end, 30*time.Minute); err != nil {
+				t.Fatal(err)
+			}
+			if n := historyReads(server, "temp"); n != 2 {
+				t.Fatal("a write outside the range dropped the cached history")
+			}
+		})
+	}
+}
+
+func TestWriteRawInvalidatesCache(t *testing.T) {
+	server := newFakeServer(t)
+	client := server.client(t, WithQueryCache(time.Minute))
+	server.write("state", 1700000000, 1)
+
+	if _, err := client.ReadRaw("state", 1700000000, 1700000100); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WriteRaw("state", 1700000050, "on"); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := client.ReadRaw("state", 1700000000, 1700000100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 2 || raw[1].Value != "on" {
+		t.Fatalf("got %+v, want the raw value written", raw)
+	}
+}
+
+func TestWritesDropCachedLatest(t *testing.T) {
+	server := newFakeServer(t, func(s *fakeServer) { s.acks = true })
+	client := server.client(t, WithQueryCache(time.Minute))
+	now := time.Now().Unix()
+	server.write("temp", now-60, 20)
+
+	latest := func() float64 {
+		t.Helper()
+		value, _, err := client.GetLatestMeasurement("temp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	if got := latest(); got != 20 {
+		t.Fatalf("latest is %g, want 20", got)
+	}
+
+	if err := client.WriteDataSync("temp", now, 21); err != nil {
+		t.Fatal(err)
+	}
+	if got := latest(); got != 21 {
+		t.Fatalf("latest is %g after writing a newer point, want 21", got)
+	}
+
+	lookups := server.count("last,")
+	if err := client.WriteBatchSync([]DataPoint{{Key: "temp", Timestamp: time.Unix(now-120, 0), Value: 19}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := latest(); got != 21 {
+		t.Fatalf("latest is %g after writing an older point, want 21", got)
+	}
+	if server.count("last,") != lookups {
+		t.Fatal("writing an older point dropped the cached latest point")
+	}
+}
@@ -0,0 +1,92 @@
+package gtsdb
+
+import (
+	"context"
+	"time"
+)
+
+// ReadWriter is the subset of TSDB operations that composite clients such as
+// TieredClient build on and expose themselves
+type ReadWriter interface {
+	WriteData(key string, timestamp int64, value float64) error
+	WriteDataContext(ctx context.Context, key string, timestamp int64, value float64) error
+	ReadData(key string, startTime, endTime int64, downsampling int) ([]string, error)
+	ReadDataContext(ctx context.Context, key string, startTime, endTime int64, downsampling int) ([]string, error)
+	Close() error
+}
+
+// Client covers the reads, writes, queries and key management of the driver, so that
+// code depending on them can be tested against an in-memory implementation such as
+// the one in package fakegtsdb. Subscriptions are covered by SubscribeChannels only.
+// The rest is only offered by *TSDBClient: Subscribe, Unsubscribe and SubscribeStream,
+// which are bound to its subscription connection, ReadStream, Export and AsyncWriter,
+// Config, Stats, BreakerState, AddObserver and WarmCache, and the client-side
+// analytics such as GetRate and GetDutyCycle.
+type Client interface {
+	ReadWriter
+
+	WriteDataSync(key string, timestamp int64, value float64) error
+	WriteDataSyncContext(ctx context.Context, key string, timestamp int64, value float64) error
+	WriteBatch(points []DataPoint) error
+	WriteBatchContext(ctx context.Context, points []DataPoint) error
+	WriteBatchSync(points []DataPoint) error
+	WriteBatchSyncContext(ctx context.Context, points []DataPoint) error
+	WriteRaw(key string, timestamp int64, encoded string) error
+	WriteRawContext(ctx context.Context, key string, timestamp int64, encoded string) error
+
+	ReadPoints(key string, startTime, endTime int64, downsampling int) ([]DataPoint, error)
+	ReadPointsContext(ctx context.Context, key string, startTime, endTime int64, downsampling int) ([]DataPoint, error)
+	ReadAggregated(key string, startTime, endTime int64, downsampling int, aggregation Aggregation) ([]DataPoint, error)
+	ReadAggregatedContext(ctx context.Context, key string, startTime, endTime int64, downsampling int, aggregation Aggregation) ([]DataPoint, error)
+	ReadRaw(key string, startTime, endTime int64) ([]RawMeasurement, error)
+	ReadRawContext(ctx context.Context, key string, startTime, endTime int64) ([]RawMeasurement, error)
+	ReadMultiple(keys []string, startTime, endTime int64, downsampling int) (map[string][]string, error)
+	ReadMultipleContext(ctx context.Context, keys []string, startTime, endTime int64, downsampling int) (map[string][]string, error)
+	ReadMulti(keys []string, startTime, endTime int64, downsampling int) (map[string][]DataPoint, error)
+	ReadMultiContext(ctx context.Context, keys []string, startTime, endTime int64, downsampling int) (map[string][]DataPoint, error)
+
+	RecordMeasurement(sensorID string, value float64) error
+	RecordMeasurementContext(ctx context.Context, sensorID string, value float64) error
+	GetLatestMeasurement(sensorID string) (float64, time.Time, error)
+	GetLatestMeasurementContext(ctx context.Context, sensorID string) (float64, time.Time, error)
+	GetLatestMeasurementWithin(sensorID string, window time.Duration) (float64, time.Time, error)
+	GetLatestMeasurementWithinContext(ctx context.Context, sensorID string, window time.Duration) (float64, time.Time, error)
+	GetAverageMeasurement(sensorID string, duration time.Duration) (float64, error)
+	GetAverageMeasurementContext(ctx context.Context, sensorID string, duration time.Duration) (float64, error)
+	GetMeasurementHistory(sensorID string, startTime, endTime time.Time, interval time.Duration, opts ...HistoryOption) ([]Measurement, error)
+	GetMeasurementHistoryContext(ctx context.Context, sensorID string, startTime, endTime time.Time, interval time.Duration, opts ...HistoryOption) ([]Measurement, error)
+	GetAggregatedHistory(sensorID string, startTime, endTime time.Time, interval time.Duration, aggregation Aggregation, opts ...HistoryOption) ([]Measurement, error)
+	GetAggregatedHistoryContext(ctx context.Context, sensorID string, startTime, endTime time.Time, interval time.Duration, aggregation Aggregation, opts ...HistoryOption) ([]Measurement, error)
+
+	GetMin(sensorID string, duration time.Duration) (float64, error)
+	GetMinContext(ctx context.Context, sensorID string, duration time.Duration) (float64, error)
+	GetMax(sensorID string, duration time.Duration) (float64, error)
+	GetMaxContext(ctx context.Context, sensorID string, duration time.Duration) (float64, error)
+	GetSum(sensorID string, duration time.Duration) (float64, error)
+	GetSumContext(ctx context.Context, sensorID string, duration time.Duration) (float64, error)
+	GetCount(sensorID string, duration time.Duration) (int, error)
+	GetCountContext(ctx context.Context, sensorID string, duration time.Duration) (int, error)
+	GetStdDev(sensorID string, duration time.Duration) (float64, error)
+	GetStdDevContext(ctx context.Context, sensorID string, duration time.Duration) (float64, error)
+	GetPercentile(sensorID string, p float64, duration time.Duration) (float64, error)
+	GetPercentileContext(ctx context.Context, sensorID string, p float64, duration time.Duration) (float64, error)
+
+	ListKeys(prefix string) ([]string, error)
+	ListKeysContext(ctx context.Context, prefix string) ([]string, error)
+	ListKeysMatching(pattern string) ([]string, error)
+	ListKeysMatchingContext(ctx context.Context, pattern string) ([]string, error)
+	KeyInfo(key string) (KeyInfo, error)
+	KeyInfoContext(ctx context.Context, key string) (KeyInfo, error)
+	DeleteKey(key string) error
+	DeleteKeyContext(ctx context.Context, key string) error
+	DeleteRange(key string, startTime, endTime int64) error
+	DeleteRangeContext(ctx context.Context, key string, startTime, endTime int64) error
+
+	SubscribeChannels(keys []string) (map[string]<-chan Measurement, func(), error)
+	SubscribeChannelsContext(ctx context.Context, keys []string) (map[string]<-chan Measurement, func(), error)
+
+	Ping() error
+	PingContext(ctx context.Context) error
+}
+
+var _ Client = (*TSDBClient)(nil)
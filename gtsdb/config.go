@@ -0,0 +1,46 @@
+package gtsdb
+
+import "time"
+
+// ClientConfig is a snapshot of a client's effective settings, meant to be
+// dumped into logs or diagnostics endpoints. It must not carry secrets in
+// plain form so that it is always safe to log.
+type ClientConfig struct {
+	Address           string
+	ResponseDelimiter string
+	MaxConcurrency    int
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	ProtocolVersion   ProtocolVersion
+	MaxTimeRange      time.Duration
+	ZeroAsMissing     bool
+	QueryCacheTTL     time.Duration
+	QueryCacheSize    int
+	Reconnect         ReconnectPolicy
+	Retry             RetryPolicy
+	Breaker           BreakerPolicy
+	MinConns          int
+	MaxConns          int
+	IdleTimeout       time.Duration
+	HealthCheckAfter  time.Duration
+	DialTimeout       time.Duration
+	KeepAlive         time.Duration
+	ReadBufferSize    int
+	WriteBufferSize   int
+	// ValuePrecision is the number of decimals written, or FullPrecision
+	ValuePrecision      int
+	TimestampResolution TimestampResolution
+	LatestLookback      time.Duration
+	// TLS reports whether connections use TLS; the tls.Config itself is not exposed
+	TLS bool
+	// CustomDialer reports whether connections are opened by the function set with
+	// WithDialContext
+	CustomDialer bool
+	// WriteAcks reports whether WriteData and WriteBatch wait for acknowledgements
+	WriteAcks bool
+}
+
+// Config returns a copy of the effective configuration of the client
+func (c *TSDBClient) Config() ClientConfig {
+	return c.cfg
+}
@@ -0,0 +1,80 @@
+package gtsdb
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfig(t *testing.T) {
+	tlsConfig := &tls.Config{
+		ServerName:   "tsdb.internal",
+		Certificates: []tls.Certificate{{Certificate: [][]byte{[]byte("client certificate")}, PrivateKey: "client private key"}},
+	}
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, address)
+	}
+	// No connection is opened up front, so the TLS settings needn't work
+	client, err := NewTSDBClient("127.0.0.1:5555",
+		WithPoolSize(0, 3),
+		WithReadTimeout(7*time.Second),
+		WithWriteTimeout(3*time.Second),
+		WithMaxConcurrency(5),
+		WithResponseDelimiter(";"),
+		WithProtocolVersion(ProtocolV1),
+		WithMaxTimeRange(24*time.Hour),
+		WithValuePrecision(4),
+		WithTimestampResolution(Milliseconds),
+		WithZeroAsMissing(),
+		WithTLS(tlsConfig),
+		WithDialContext(dial),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	cfg := client.Config()
+	checks := []struct {
+		name      string
+		got, want any
+	}{
+		{"Address", cfg.Address, "127.0.0.1:5555"},
+		{"MinConns", cfg.MinConns, 0},
+		{"MaxConns", cfg.MaxConns, 3},
+		{"ReadTimeout", cfg.ReadTimeout, 7 * time.Second},
+		{"WriteTimeout", cfg.WriteTimeout, 3 * time.Second},
+		{"MaxConcurrency", cfg.MaxConcurrency, 5},
+		{"ResponseDelimiter", cfg.ResponseDelimiter, ";"},
+		{"ProtocolVersion", cfg.ProtocolVersion, ProtocolV1},
+		{"MaxTimeRange", cfg.MaxTimeRange, 24 * time.Hour},
+		{"ValuePrecision", cfg.ValuePrecision, 4},
+		{"TimestampResolution", cfg.TimestampResolution, Milliseconds},
+		{"ZeroAsMissing", cfg.ZeroAsMissing, true},
+		{"TLS", cfg.TLS, true},
+		{"CustomDialer", cfg.CustomDialer, true},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+
+	// The TLS credentials are reported as in use, never included
+	dump := fmt.Sprintf("%+v %#v", cfg, cfg)
+	for _, secret := range []string{"client certificate", "client private key", "tsdb.internal"} {
+		if strings.Contains(dump, secret) {
+			t.Errorf("config dump contains %q: %s", secret, dump)
+		}
+	}
+
+	// Config is a copy
+	cfg.MaxConns = 100
+	if got := client.Config().MaxConns; got != 3 {
+		t.Fatalf("changing the copy changed MaxConns to %d", got)
+	}
+}
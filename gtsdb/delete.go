@@ -0,0 +1,56 @@
+package gtsdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// DeleteKey deletes a key and all of its data, waiting for the server to confirm.
+// Cached queries of the key are dropped.
+func (c *TSDBClient) DeleteKey(key string) error {
+	return c.DeleteKeyContext(context.Background(), key)
+}
+
+// DeleteKeyContext is like DeleteKey but honours ctx for cancellation and deadlines
+func (c *TSDBClient) DeleteKeyContext(ctx context.Context, key string) error {
+	return c.delete(ctx, "delete key", key, "delete,%s\n", key)
+}
+
+// DeleteRange deletes the points of a key between startTime and endTime inclusive,
+// waiting for the server to confirm. Cached queries of the key are dropped.
+func (c *TSDBClient) DeleteRange(key string, startTime, endTime int64) error {
+	return c.DeleteRangeContext(context.Background(), key, startTime, endTime)
+}
+
+// DeleteRangeContext is like DeleteRange but honours ctx for cancellation and deadlines
+func (c *TSDBClient) DeleteRangeContext(ctx context.Context, key string, startTime, endTime int64) error {
+	if err := validateRange(startTime, endTime); err != nil {
+		return err
+	}
+	return c.delete(ctx, "delete range", key, "delete,%s,%d,%d\n", key, startTime, endTime)
+}
+
+// delete sends a delete command and checks its acknowledgement
+func (c *TSDBClient) delete(ctx context.Context, op, key string, format string, args ...any) (err error) {
+	done := c.observe(ctx, Command{Op: op, Key: key})
+	defer func() { done(err) }()
+
+	if key == "" {
+		return fmt.Errorf("%s: empty key", op)
+	}
+
+	// Drop cached results even if the reply is lost, the data may be gone already
+	if c.cache != nil {
+		defer c.cache.invalidate(key)
+	}
+
+	response, err := c.roundTrip(ctx, op, c.cfg.WriteTimeout, format, args...)
+	if err != nil {
+		return err
+	}
+	if ack := strings.TrimSpace(response); ack != ackReply {
+		return fmt.Errorf("%s: unexpected acknowledgement %q", op, ack)
+	}
+	return nil
+}
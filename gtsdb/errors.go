@@ -0,0 +1,153 @@
+package gtsdb
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNoData is returned when a query finds no usable measurements
+	ErrNoData = errors.New("no data found")
+	// ErrInvalidTimeRange is returned for negative timestamps or a start after the end
+	ErrInvalidTimeRange = errors.New("invalid time range")
+	// ErrInvalidInterval is returned for non-positive intervals and bucket sizes
+	ErrInvalidInterval = errors.New("invalid interval")
+	// ErrRangeTooLarge is returned for queries spanning more than the configured maximum time range,
+	// or more buckets than the client computes
+	ErrRangeTooLarge = errors.New("time range too large")
+	// ErrTruncatedResponse is returned when the connection closes in the middle of a response
+	ErrTruncatedResponse = errors.New("truncated response")
+	// ErrMalformedRecord is returned when a record in a response cannot be parsed
+	ErrMalformedRecord = errors.New("malformed record")
+	// ErrKeyNotFound is matched by server errors reporting an unknown key
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrMalformedQuery is matched by server errors rejecting a command as invalid
+	ErrMalformedQuery = errors.New("malformed query")
+	// ErrServerUnavailable is matched by server errors reporting an overloaded or
+	// unavailable server, and wraps failures to connect. Unlike the other errors it
+	// is transient and the operation may be retried.
+	ErrServerUnavailable = errors.New("server unavailable")
+)
+
+// serverErrorPrefix starts every error reply sent by the server
+const serverErrorPrefix = "ERR "
+
+// Error codes the server may put in front of the message of an error reply
+const (
+	CodeKeyNotFound    = "NOT_FOUND"
+	CodeMalformedQuery = "MALFORMED"
+	CodeUnavailable    = "UNAVAILABLE"
+)
+
+// serverErrorCodes maps the error codes of error replies to the errors they match
+var serverErrorCodes = map[string]error{
+	CodeKeyNotFound:    ErrKeyNotFound,
+	CodeMalformedQuery: ErrMalformedQuery,
+	CodeUnavailable:    ErrServerUnavailable,
+}
+
+// ServerError is an error reply sent by the TSDB server. Replies of the form
+// "ERR <CODE> <message>" with a known code match ErrKeyNotFound, ErrMalformedQuery
+// or ErrServerUnavailable with errors.Is.
+type ServerError struct {
+	// Code is the error code of the reply, empty for replies without a known code
+	Code    string
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	if e.Code == "" {
+		return "server error: " + e.Message
+	}
+	return "server error: " + e.Code + " " + e.Message
+}
+
+func (e *ServerError) Is(target error) bool {
+	return e.Code != "" && serverErrorCodes[e.Code] == target
+}
+
+// IsTransient reports whether err is a failure that may go away when the operation
+// is retried, such as an unavailable server or a timeout, as opposed to one that
+// will recur, such as a malformed query
+func IsTransient(err error) bool {
+	return errors.Is(err, ErrServerUnavailable) || isTimeout(err)
+}
+
+// TruncatedResponseError carries the part of a response received before the
+// connection closed. It matches ErrTruncatedResponse with errors.Is.
+type TruncatedResponseError struct {
+	Partial string
+}
+
+func (e *TruncatedResponseError) Error() string {
+	return fmt.Sprintf("%s after %d bytes", ErrTruncatedResponse, len(e.Partial))
+}
+
+func (e *TruncatedResponseError) Unwrap() error {
+	return ErrTruncatedResponse
+}
+
+// RecordError reports a record of a response that could not be parsed, along
+// with its position. It matches ErrMalformedRecord with errors.Is.
+type RecordError struct {
+	Index  int
+	Record string
+	Err    error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("%s %d: %v", ErrMalformedRecord, e.Index, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RecordError) Is(target error) bool {
+	return target == ErrMalformedRecord
+}
+
+// parseServerError returns a *ServerError if the response line is an error reply
+func parseServerError(response string) error {
+	response = strings.TrimSpace(response)
+	if !strings.HasPrefix(response, serverErrorPrefix) {
+		return nil
+	}
+	message := strings.TrimPrefix(response, serverErrorPrefix)
+	if code, rest, _ := strings.Cut(message, " "); serverErrorCodes[code] != nil {
+		return &ServerError{Code: code, Message: rest}
+	}
+	return &ServerError{Message: message}
+}
+
+// isTimeout reports whether err is a network timeout
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// checkRange validates a time range and enforces the client's maximum time range
+func (c *TSDBClient) checkRange(startTime, endTime int64) error {
+	if err := validateRange(startTime, endTime); err != nil {
+		return err
+	}
+	unit := c.cfg.TimestampResolution.Unit()
+	if limit := c.cfg.MaxTimeRange; limit > 0 && endTime-startTime > int64(limit/unit) {
+		return fmt.Errorf("%w: %s exceeds the limit of %s", ErrRangeTooLarge, time.Duration(endTime-startTime)*unit, limit)
+	}
+	return nil
+}
+
+// validateRange checks a time range before it is sent to the server
+func validateRange(startTime, endTime int64) error {
+	if startTime < 0 || endTime < 0 {
+		return fmt.Errorf("%w: negative timestamp", ErrInvalidTimeRange)
+	}
+	if startTime > endTime {
+		return fmt.Errorf("%w: start %d is after end %d", ErrInvalidTimeRange, startTime, endTime)
+	}
+	return nil
+}
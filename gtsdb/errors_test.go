@@ -0,0 +1,128 @@
+package gtsdb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidateRange(t *testing.T) {
+	tests := []struct {
+		name       string
+		start, end int64
+		want       error
+	}{
+		{"ordered", 1700000000, 1700000010, nil},
+		{"zero range", 1700000000, 1700000000, nil},
+		{"from the epoch", 0, 1700000000, nil},
+		{"inverted", 1700000010, 1700000000, ErrInvalidTimeRange},
+		{"negative start", -1, 1700000000, ErrInvalidTimeRange},
+		{"negative end", 0, -1, ErrInvalidTimeRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateRange(tt.start, tt.end); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadsRejectBadArguments(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name string
+		read func(c *TSDBClient) error
+		want error
+	}{
+		{"inverted ReadData", func(c *TSDBClient) error {
+			_, err := c.ReadData("temp", 1700000010, 1700000000, 0)
+			return err
+		}, ErrInvalidTimeRange},
+		{"negative ReadData", func(c *TSDBClient) error {
+			_, err := c.ReadData("temp", -10, 1700000000, 0)
+			return err
+		}, ErrInvalidTimeRange},
+		{"inverted history", func(c *TSDBClient) error {
+			_, err := c.GetMeasurementHistory("temp", start, start.Add(-time.Minute), time.Second)
+			return err
+		}, ErrInvalidTimeRange},
+		{"zero interval", func(c *TSDBClient) error {
+			_, err := c.GetMeasurementHistory("temp", start, start.Add(time.Minute), 0)
+			return err
+		}, ErrInvalidInterval},
+		{"negative interval", func(c *TSDBClient) error {
+			_, err := c.GetMeasurementHistory("temp", start, start.Add(time.Minute), -time.Second)
+			return err
+		}, ErrInvalidInterval},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			client := server.client(t)
+			if err := tt.read(client); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			// Bad arguments are caught before anything is sent
+			if commands := server.received(); len(commands) != 0 {
+				t.Fatalf("server received %q", commands)
+			}
+		})
+	}
+}
+
+func TestMaxTimeRange(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name       string
+		resolution TimestampResolution
+		read       func(c *TSDBClient) error
+		want       error
+	}{
+		{"ReadData over the limit", Seconds, func(c *TSDBClient) error {
+			_, err := c.ReadData("temp", 1700000000, 1700000000+3601, 0)
+			return err
+		}, ErrRangeTooLarge},
+		{"ReadData at the limit", Seconds, func(c *TSDBClient) error {
+			_, err := c.ReadData("temp", 1700000000, 1700000000+3600, 0)
+			return err
+		}, nil},
+		{"milliseconds over the limit", Milliseconds, func(c *TSDBClient) error {
+			_, err := c.ReadData("temp", 1700000000000, 1700000000000+3600001, 0)
+			return err
+		}, ErrRangeTooLarge},
+		{"milliseconds within the limit", Milliseconds, func(c *TSDBClient) error {
+			_, err := c.ReadData("temp", 1700000000000, 1700000000000+60000, 0)
+			return err
+		}, nil},
+		{"history over the limit", Seconds, func(c *TSDBClient) error {
+			_, err := c.GetMeasurementHistory("temp", start, start.Add(24*time.Hour), time.Minute)
+			return err
+		}, ErrRangeTooLarge},
+		{"history within the limit", Seconds, func(c *TSDBClient) error {
+			_, err := c.GetMeasurementHistory("temp", start, start.Add(10*time.Minute), time.Minute)
+			return err
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			client := server.client(t, WithMaxTimeRange(time.Hour), WithTimestampResolution(tt.resolution))
+			server.write("temp", tt.resolution.FromTime(start.Add(time.Second)), 1.5)
+
+			err := tt.read(client)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			sent := len(server.received())
+			if tt.want != nil && sent != 0 {
+				t.Fatalf("server received %q for a rejected read", server.received())
+			}
+			if tt.want == nil && sent != 1 {
+				t.Fatalf("server received %d commands, want the read", sent)
+			}
+		})
+	}
+}
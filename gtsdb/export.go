@@ -0,0 +1,140 @@
+package gtsdb
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// ExportFormat selects the file format Export writes
+type ExportFormat string
+
+const (
+	// ExportCSV writes a key,timestamp,value header then a row per point, with Unix
+	// second timestamps
+	ExportCSV ExportFormat = "csv"
+	// ExportNDJSON writes a {"key","ts","value"} object per line, with Unix second
+	// timestamps
+	ExportNDJSON ExportFormat = "ndjson"
+	// ExportParquet writes a Parquet file with a key string column, a timestamp column
+	// in milliseconds and a double value column, uncompressed
+	ExportParquet ExportFormat = "parquet"
+)
+
+// Export writes the points of key between startTime and endTime to w in format,
+// returning how many there were. The points are streamed as they arrive rather than
+// read into memory first.
+func (c *TSDBClient) Export(key string, startTime, endTime int64, w io.Writer, format ExportFormat) (int, error) {
+	return c.ExportContext(context.Background(), key, startTime, endTime, w, format)
+}
+
+// ExportContext is like Export but honours ctx for cancellation and deadlines
+func (c *TSDBClient) ExportContext(ctx context.Context, key string, startTime, endTime int64, w io.Writer, format ExportFormat) (int, error) {
+	e, err := NewExporter(w, format)
+	if err != nil {
+		return 0, err
+	}
+	n, err := c.ExportTo(ctx, e, key, startTime, endTime)
+	if err != nil {
+		return n, err
+	}
+	return n, e.Close()
+}
+
+// ExportTo writes the points of key between startTime and endTime to e, returning how
+// many there were. It lets the points of several keys go to one file.
+func (c *TSDBClient) ExportTo(ctx context.Context, e *Exporter, key string, startTime, endTime int64) (int, error) {
+	it, err := c.ReadStreamContext(ctx, key, startTime, endTime, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer it.Close()
+	n := 0
+	for it.Next() {
+		if err := e.Write(it.Point()); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, it.Err()
+}
+
+// Exporter encodes points into one of the export formats
+type Exporter struct {
+	enc pointEncoder
+}
+
+// pointEncoder is the encoder of an export format
+type pointEncoder interface {
+	write(p DataPoint) error
+	flush() error
+	close() error
+}
+
+// NewExporter returns an exporter writing points to w in format. Close must be called
+// once done, to write out what is buffered and the Parquet footer; it doesn't close w.
+func NewExporter(w io.Writer, format ExportFormat) (*Exporter, error) {
+	switch format {
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"key", "timestamp", "value"}); err != nil {
+			return nil, err
+		}
+		return &Exporter{enc: &csvEncoder{w: cw}}, nil
+	case ExportNDJSON:
+		bw := bufio.NewWriter(w)
+		return &Exporter{enc: &ndjsonEncoder{w: bw, enc: json.NewEncoder(bw)}}, nil
+	case ExportParquet:
+		return &Exporter{enc: newParquetEncoder(w)}, nil
+	}
+	return nil, fmt.Errorf("unsupported export format %q", string(format))
+}
+
+// Write encodes a point
+func (e *Exporter) Write(p DataPoint) error { return e.enc.write(p) }
+
+// Flush writes out the points buffered so far. Each flush of a Parquet export ends a row
+// group, so flushing after every few points makes for a large file.
+func (e *Exporter) Flush() error { return e.enc.flush() }
+
+// Close writes out what is buffered and finishes the file
+func (e *Exporter) Close() error { return e.enc.close() }
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (c *csvEncoder) write(p DataPoint) error {
+	return c.w.Write([]string{p.Key, strconv.FormatInt(p.Timestamp.Unix(), 10), strconv.FormatFloat(p.Value, 'g', -1, 64)})
+}
+
+func (c *csvEncoder) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvEncoder) close() error { return c.flush() }
+
+// ndjsonPoint is a point as the relay's json format takes it
+type ndjsonPoint struct {
+	Key   string  `json:"key"`
+	Ts    int64   `json:"ts"`
+	Value float64 `json:"value"`
+}
+
+type ndjsonEncoder struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (j *ndjsonEncoder) write(p DataPoint) error {
+	return j.enc.Encode(ndjsonPoint{Key: p.Key, Ts: p.Timestamp.Unix(), Value: p.Value})
+}
+
+func (j *ndjsonEncoder) flush() error { return j.w.Flush() }
+
+func (j *ndjsonEncoder) close() error { return j.flush() }
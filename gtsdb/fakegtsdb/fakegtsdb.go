@@ -0,0 +1,801 @@
+// Package fakegtsdb provides an in-memory gtsdb.Client for testing code that depends
+// on the driver without a running TSDB.
+package fakegtsdb
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+// subscriptionBuffer is the number of updates buffered per channel before the oldest is dropped
+const subscriptionBuffer = 64
+
+// Client is an in-memory gtsdb.Client keeping the points of each key in a map. Like the
+// default driver configuration it counts timestamps in seconds. Downsampled reads bucket
+// points into intervals counted from the start of the range and average them unless
+// another aggregation is requested. The zero value is not usable; create one with New.
+type Client struct {
+	mu sync.Mutex
+	// series maps each key to its encoded values by timestamp
+	series map[string]map[int64]string
+	// listeners holds the channels of the subscriptions to each key
+	listeners map[string]map[chan gtsdb.Measurement]struct{}
+	closed    bool
+}
+
+var _ gtsdb.Client = (*Client)(nil)
+
+// New creates an empty Client
+func New() *Client {
+	return &Client{
+		series:    make(map[string]map[int64]string),
+		listeners: make(map[string]map[chan gtsdb.Measurement]struct{}),
+	}
+}
+
+// check fails operations on a closed client or with a done context
+func (c *Client) check(ctx context.Context, op string) error {
+	if c.closed {
+		return fmt.Errorf("%s: %w", op, gtsdb.ErrClosed)
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// store saves a value and delivers it to the subscribers of its key. It must be
+// called while holding mu.
+func (c *Client) store(key string, timestamp int64, encoded string) {
+	values, ok := c.series[key]
+	if !ok {
+		values = make(map[int64]string)
+		c.series[key] = values
+	}
+	values[timestamp] = encoded
+
+	value, err := strconv.ParseFloat(encoded, 64)
+	if err != nil {
+		return
+	}
+	m := gtsdb.Measurement{Timestamp: time.Unix(timestamp, 0), Value: value}
+	for ch := range c.listeners[key] {
+		deliverDropOldest(ch, m)
+	}
+}
+
+// timestamps returns the sorted timestamps of key between startTime and endTime.
+// It must be called while holding mu.
+func (c *Client) timestamps(key string, startTime, endTime int64) []int64 {
+	var timestamps []int64
+	for ts := range c.series[key] {
+		if ts >= startTime && ts <= endTime {
+			timestamps = append(timestamps, ts)
+		}
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps
+}
+
+// WriteData writes a single data point
+func (c *Client) WriteData(key string, timestamp int64, value float64) error {
+	return c.WriteDataContext(context.Background(), key, timestamp, value)
+}
+
+// WriteDataContext is like WriteData but honours ctx for cancellation
+func (c *Client) WriteDataContext(ctx context.Context, key string, timestamp int64, value float64) error {
+	return c.WriteRawContext(ctx, key, timestamp, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+// WriteDataSync is like WriteData; writes to the fake are always acknowledged
+func (c *Client) WriteDataSync(key string, timestamp int64, value float64) error {
+	return c.WriteDataContext(context.Background(), key, timestamp, value)
+}
+
+// WriteDataSyncContext is like WriteDataSync but honours ctx for cancellation
+func (c *Client) WriteDataSyncContext(ctx context.Context, key string, timestamp int64, value float64) error {
+	return c.WriteDataContext(ctx, key, timestamp, value)
+}
+
+// WriteBatch writes several data points at once
+func (c *Client) WriteBatch(points []gtsdb.DataPoint) error {
+	return c.WriteBatchContext(context.Background(), points)
+}
+
+// WriteBatchContext is like WriteBatch but honours ctx for cancellation
+func (c *Client) WriteBatchContext(ctx context.Context, points []gtsdb.DataPoint) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.check(ctx, "write batch"); err != nil {
+		return err
+	}
+	for _, point := range points {
+		if err := checkKey(point.Key); err != nil {
+			return err
+		}
+	}
+	for _, point := range points {
+		c.store(point.Key, point.Timestamp.Unix(), strconv.FormatFloat(point.Value, 'f', -1, 64))
+	}
+	return nil
+}
+
+// WriteBatchSync is like WriteBatch; writes to the fake are always acknowledged
+func (c *Client) WriteBatchSync(points []gtsdb.DataPoint) error {
+	return c.WriteBatchContext(context.Background(), points)
+}
+
+// WriteBatchSyncContext is like WriteBatchSync but honours ctx for cancellation
+func (c *Client) WriteBatchSyncContext(ctx context.Context, points []gtsdb.DataPoint) error {
+	return c.WriteBatchContext(ctx, points)
+}
+
+// WriteRaw stores a string-encoded value as is
+func (c *Client) WriteRaw(key string, timestamp int64, encoded string) error {
+	return c.WriteRawContext(context.Background(), key, timestamp, encoded)
+}
+
+// WriteRawContext is like WriteRaw but honours ctx for cancellation
+func (c *Client) WriteRawContext(ctx context.Context, key string, timestamp int64, encoded string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.check(ctx, "write data"); err != nil {
+		return err
+	}
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	// The same checks as the real client, whose records these values end up in
+	if encoded == "" {
+		return fmt.Errorf("raw value must not be empty")
+	}
+	if strings.ContainsAny(encoded, ",|\r\n") {
+		return fmt.Errorf("raw value %q contains a protocol delimiter", encoded)
+	}
+	c.store(key, timestamp, encoded)
+	return nil
+}
+
+// checkKey rejects keys that would not survive the records ReadData returns
+func checkKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("key must not be empty")
+	}
+	if strings.ContainsAny(key, ",|\r\n") {
+		return fmt.Errorf("key %q contains a protocol delimiter", key)
+	}
+	return nil
+}
+
+// ReadData returns the "key,timestamp,value" records of key in the time range
+func (c *Client) ReadData(key string, startTime, endTime int64, downsampling int) ([]string, error) {
+	return c.ReadDataContext(context.Background(), key, startTime, endTime, downsampling)
+}
+
+// ReadDataContext is like ReadData but honours ctx for cancellation
+func (c *Client) ReadDataContext(ctx context.Context, key string, startTime, endTime int64, downsampling int) ([]string, error) {
+	return c.read(ctx, key, startTime, endTime, downsampling, gtsdb.AggregateDefault)
+}
+
+// read returns the records of key in the time range, bucketed and aggregated when
+// downsampling spans more than one second
+func (c *Client) read(ctx context.Context, key string, startTime, endTime int64, downsampling int, aggregation gtsdb.Aggregation) ([]string, error) {
+	if startTime < 0 || endTime < 0 || startTime > endTime {
+		return nil, fmt.Errorf("%w: %d to %d", gtsdb.ErrInvalidTimeRange, startTime, endTime)
+	}
+	if _, err := aggregate([]float64{0}, aggregation); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.check(ctx, "read data"); err != nil {
+		return nil, err
+	}
+	values := c.series[key]
+	timestamps := c.timestamps(key, startTime, endTime)
+
+	records := []string{}
+	if downsampling <= 1 {
+		for _, ts := range timestamps {
+			records = append(records, fmt.Sprintf("%s,%d,%s", key, ts, values[ts]))
+		}
+		return records, nil
+	}
+
+	var (
+		bucket  int64
+		inGroup []float64
+	)
+	flush := func() error {
+		if len(inGroup) == 0 {
+			return nil
+		}
+		value, err := aggregate(inGroup, aggregation)
+		if err != nil {
+			return err
+		}
+		records = append(records, fmt.Sprintf("%s,%d,%s", key, bucket, strconv.FormatFloat(value, 'f', -1, 64)))
+		inGroup = inGroup[:0]
+		return nil
+	}
+	for _, ts := range timestamps {
+		value, err := strconv.ParseFloat(values[ts], 64)
+		if err != nil {
+			return nil, fmt.Errorf("read data: %w: %s,%d,%s", gtsdb.ErrMalformedRecord, key, ts, values[ts])
+		}
+		if b := startTime + (ts-startTime)/int64(downsampling)*int64(downsampling); b != bucket {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			bucket = b
+		}
+		inGroup = append(inGroup, value)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// aggregate combines the values of a bucket
+func aggregate(values []float64, aggregation gtsdb.Aggregation) (float64, error) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+
+	switch aggregation {
+	case gtsdb.AggregateDefault, gtsdb.AggregateAvg:
+		return sum / float64(len(values)), nil
+	case gtsdb.AggregateSum:
+		return sum, nil
+	case gtsdb.AggregateCount:
+		return float64(len(values)), nil
+	case gtsdb.AggregateMin:
+		lowest := values[0]
+		for _, v := range values[1:] {
+			lowest = math.Min(lowest, v)
+		}
+		return lowest, nil
+	case gtsdb.AggregateMax:
+		highest := values[0]
+		for _, v := range values[1:] {
+			highest = math.Max(highest, v)
+		}
+		return highest, nil
+	}
+	return 0, fmt.Errorf("unsupported aggregation %q", string(aggregation))
+}
+
+// parsePoints parses the records of a read, failing with a *gtsdb.RecordError
+func parsePoints(records []string) ([]gtsdb.DataPoint, error) {
+	points := make([]gtsdb.DataPoint, 0, len(records))
+	for i, record := range records {
+		point, err := gtsdb.ParseMeasurement(record)
+		if err != nil {
+			return nil, &gtsdb.RecordError{Index: i, Record: record, Err: err}
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
+
+// ReadPoints is like ReadData but parses the records into data points
+func (c *Client) ReadPoints(key string, startTime, endTime int64, downsampling int) ([]gtsdb.DataPoint, error) {
+	return c.ReadPointsContext(context.Background(), key, startTime, endTime, downsampling)
+}
+
+// ReadPointsContext is like ReadPoints but honours ctx for cancellation
+func (c *Client) ReadPointsContext(ctx context.Context, key string, startTime, endTime int64, downsampling int) ([]gtsdb.DataPoint, error) {
+	return c.ReadAggregatedContext(ctx, key, startTime, endTime, downsampling, gtsdb.AggregateDefault)
+}
+
+// ReadAggregated is like ReadPoints but combines the points of each downsampling
+// interval with the given aggregation
+func (c *Client) ReadAggregated(key string, startTime, endTime int64, downsampling int, aggregation gtsdb.Aggregation) ([]gtsdb.DataPoint, error) {
+	return c.ReadAggregatedContext(context.Background(), key, startTime, endTime, downsampling, aggregation)
+}
+
+// ReadAggregatedContext is like ReadAggregated but honours ctx for cancellation
+func (c *Client) ReadAggregatedContext(ctx context.Context, key string, startTime, endTime int64, downsampling int, aggregation gtsdb.Aggregation) ([]gtsdb.DataPoint, error) {
+	records, err := c.read(ctx, key, startTime, endTime, downsampling, aggregation)
+	if err != nil {
+		return nil, err
+	}
+	return parsePoints(records)
+}
+
+// ReadRaw returns the stored values of key in the time range without parsing them
+func (c *Client) ReadRaw(key string, startTime, endTime int64) ([]gtsdb.RawMeasurement, error) {
+	return c.ReadRawContext(context.Background(), key, startTime, endTime)
+}
+
+// ReadRawContext is like ReadRaw but honours ctx for cancellation
+func (c *Client) ReadRawContext(ctx context.Context, key string, startTime, endTime int64) ([]gtsdb.RawMeasurement, error) {
+	if startTime < 0 || endTime < 0 || startTime > endTime {
+		return nil, fmt.Errorf("%w: %d to %d", gtsdb.ErrInvalidTimeRange, startTime, endTime)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.check(ctx, "read data"); err != nil {
+		return nil, err
+	}
+	var measurements []gtsdb.RawMeasurement
+	for _, ts := range c.timestamps(key, startTime, endTime) {
+		measurements = append(measurements, gtsdb.RawMeasurement{Timestamp: time.Unix(ts, 0), Value: c.series[key][ts]})
+	}
+	return measurements, nil
+}
+
+// ReadMultiple reads several keys over the same time range
+func (c *Client) ReadMultiple(keys []string, startTime, endTime int64, downsampling int) (map[string][]string, error) {
+	return c.ReadMultipleContext(context.Background(), keys, startTime, endTime, downsampling)
+}
+
+// ReadMultipleContext is like ReadMultiple but honours ctx for cancellation
+func (c *Client) ReadMultipleContext(ctx context.Context, keys []string, startTime, endTime int64, downsampling int) (map[string][]string, error) {
+	results := make(map[string][]string, len(keys))
+	for _, key := range keys {
+		records, err := c.ReadDataContext(ctx, key, startTime, endTime, downsampling)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", key, err)
+		}
+		results[key] = records
+	}
+	return results, nil
+}
+
+// ReadMulti is like ReadMultiple but parses the records into data points
+func (c *Client) ReadMulti(keys []string, startTime, endTime int64, downsampling int) (map[string][]gtsdb.DataPoint, error) {
+	return c.ReadMultiContext(context.Background(), keys, startTime, endTime, downsampling)
+}
+
+// ReadMultiContext is like ReadMulti but honours ctx for cancellation
+func (c *Client) ReadMultiContext(ctx context.Context, keys []string, startTime, endTime int64, downsampling int) (map[string][]gtsdb.DataPoint, error) {
+	results := make(map[string][]gtsdb.DataPoint, len(keys))
+	for _, key := range keys {
+		points, err := c.ReadPointsContext(ctx, key, startTime, endTime, downsampling)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", key, err)
+		}
+		results[key] = points
+	}
+	return results, nil
+}
+
+// RecordMeasurement records a measurement at the current time
+func (c *Client) RecordMeasurement(sensorID string, value float64) error {
+	return c.RecordMeasurementContext(context.Background(), sensorID, value)
+}
+
+// RecordMeasurementContext is like RecordMeasurement but honours ctx for cancellation
+func (c *Client) RecordMeasurementContext(ctx context.Context, sensorID string, value float64) error {
+	return c.WriteDataContext(ctx, sensorID, time.Now().Unix(), value)
+}
+
+// GetLatestMeasurement returns the most recent measurement of a sensor
+func (c *Client) GetLatestMeasurement(sensorID string) (float64, time.Time, error) {
+	return c.GetLatestMeasurementContext(context.Background(), sensorID)
+}
+
+// GetLatestMeasurementContext is like GetLatestMeasurement but honours ctx for cancellation
+func (c *Client) GetLatestMeasurementContext(ctx context.Context, sensorID string) (float64, time.Time, error) {
+	return c.latest(ctx, sensorID, 0, math.MaxInt64)
+}
+
+// GetLatestMeasurementWithin is like GetLatestMeasurement but only looks back window
+func (c *Client) GetLatestMeasurementWithin(sensorID string, window time.Duration) (float64, time.Time, error) {
+	return c.GetLatestMeasurementWithinContext(context.Background(), sensorID, window)
+}
+
+// GetLatestMeasurementWithinContext is like GetLatestMeasurementWithin but honours ctx for cancellation
+func (c *Client) GetLatestMeasurementWithinContext(ctx context.Context, sensorID string, window time.Duration) (float64, time.Time, error) {
+	startTime, endTime := lastWindow(window)
+	return c.latest(ctx, sensorID, startTime, endTime)
+}
+
+func (c *Client) latest(ctx context.Context, sensorID string, startTime, endTime int64) (float64, time.Time, error) {
+	points, err := c.ReadPointsContext(ctx, sensorID, startTime, endTime, 0)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if len(points) == 0 {
+		return 0, time.Time{}, fmt.Errorf("%w for sensor %s", gtsdb.ErrNoData, sensorID)
+	}
+	point := points[len(points)-1]
+	return point.Value, point.Timestamp, nil
+}
+
+// GetAverageMeasurement returns the mean measurement of a sensor over the last duration
+func (c *Client) GetAverageMeasurement(sensorID string, duration time.Duration) (float64, error) {
+	return c.GetAverageMeasurementContext(context.Background(), sensorID, duration)
+}
+
+// GetAverageMeasurementContext is like GetAverageMeasurement but honours ctx for cancellation
+func (c *Client) GetAverageMeasurementContext(ctx context.Context, sensorID string, duration time.Duration) (float64, error) {
+	values, err := c.windowValues(ctx, sensorID, duration)
+	if err != nil {
+		return 0, err
+	}
+	return aggregate(values, gtsdb.AggregateAvg)
+}
+
+// GetMeasurementHistory returns the measurements of a sensor averaged per interval
+func (c *Client) GetMeasurementHistory(sensorID string, startTime, endTime time.Time, interval time.Duration, opts ...gtsdb.HistoryOption) ([]gtsdb.Measurement, error) {
+	return c.GetMeasurementHistoryContext(context.Background(), sensorID, startTime, endTime, interval, opts...)
+}
+
+// GetMeasurementHistoryContext is like GetMeasurementHistory but honours ctx for cancellation
+func (c *Client) GetMeasurementHistoryContext(ctx context.Context, sensorID string, startTime, endTime time.Time, interval time.Duration, opts ...gtsdb.HistoryOption) ([]gtsdb.Measurement, error) {
+	return c.GetAggregatedHistoryContext(ctx, sensorID, startTime, endTime, interval, gtsdb.AggregateDefault, opts...)
+}
+
+// GetAggregatedHistory is like GetMeasurementHistory but combines the points of each
+// interval with the given aggregation
+func (c *Client) GetAggregatedHistory(sensorID string, startTime, endTime time.Time, interval time.Duration, aggregation gtsdb.Aggregation, opts ...gtsdb.HistoryOption) ([]gtsdb.Measurement, error) {
+	return c.GetAggregatedHistoryContext(context.Background(), sensorID, startTime, endTime, interval, aggregation, opts...)
+}
+
+// GetAggregatedHistoryContext is like GetAggregatedHistory but honours ctx for cancellation
+func (c *Client) GetAggregatedHistoryContext(ctx context.Context, sensorID string, startTime, endTime time.Time, interval time.Duration, aggregation gtsdb.Aggregation, opts ...gtsdb.HistoryOption) ([]gtsdb.Measurement, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("%w: %s", gtsdb.ErrInvalidInterval, interval)
+	}
+	// Whole seconds and at least one, like the driver sends
+	interval = max(interval.Truncate(time.Second), time.Second)
+
+	points, err := c.ReadAggregatedContext(ctx, sensorID, startTime.Unix(), endTime.Unix(), int(interval/time.Second), aggregation)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]gtsdb.Measurement, 0, len(points))
+	for _, point := range points {
+		history = append(history, gtsdb.Measurement{Timestamp: point.Timestamp, Value: point.Value})
+	}
+	return gtsdb.ArrangeHistory(history, startTime, endTime, interval, opts...)
+}
+
+// GetMin returns the lowest measurement of a sensor over the last duration
+func (c *Client) GetMin(sensorID string, duration time.Duration) (float64, error) {
+	return c.GetMinContext(context.Background(), sensorID, duration)
+}
+
+// GetMinContext is like GetMin but honours ctx for cancellation
+func (c *Client) GetMinContext(ctx context.Context, sensorID string, duration time.Duration) (float64, error) {
+	values, err := c.windowValues(ctx, sensorID, duration)
+	if err != nil {
+		return 0, err
+	}
+	return aggregate(values, gtsdb.AggregateMin)
+}
+
+// GetMax returns the highest measurement of a sensor over the last duration
+func (c *Client) GetMax(sensorID string, duration time.Duration) (float64, error) {
+	return c.GetMaxContext(context.Background(), sensorID, duration)
+}
+
+// GetMaxContext is like GetMax but honours ctx for cancellation
+func (c *Client) GetMaxContext(ctx context.Context, sensorID string, duration time.Duration) (float64, error) {
+	values, err := c.windowValues(ctx, sensorID, duration)
+	if err != nil {
+		return 0, err
+	}
+	return aggregate(values, gtsdb.AggregateMax)
+}
+
+// GetSum returns the total of the measurements of a sensor over the last duration
+func (c *Client) GetSum(sensorID string, duration time.Duration) (float64, error) {
+	return c.GetSumContext(context.Background(), sensorID, duration)
+}
+
+// GetSumContext is like GetSum but honours ctx for cancellation
+func (c *Client) GetSumContext(ctx context.Context, sensorID string, duration time.Duration) (float64, error) {
+	values, err := c.windowValues(ctx, sensorID, duration)
+	if err != nil {
+		return 0, err
+	}
+	return aggregate(values, gtsdb.AggregateSum)
+}
+
+// GetCount returns the number of measurements of a sensor over the last duration,
+// zero for an empty window
+func (c *Client) GetCount(sensorID string, duration time.Duration) (int, error) {
+	return c.GetCountContext(context.Background(), sensorID, duration)
+}
+
+// GetCountContext is like GetCount but honours ctx for cancellation
+func (c *Client) GetCountContext(ctx context.Context, sensorID string, duration time.Duration) (int, error) {
+	startTime, endTime := lastWindow(duration)
+	points, err := c.ReadPointsContext(ctx, sensorID, startTime, endTime, 0)
+	if err != nil {
+		return 0, err
+	}
+	return len(points), nil
+}
+
+// GetStdDev returns the population standard deviation of the measurements of a
+// sensor over the last duration
+func (c *Client) GetStdDev(sensorID string, duration time.Duration) (float64, error) {
+	return c.GetStdDevContext(context.Background(), sensorID, duration)
+}
+
+// GetStdDevContext is like GetStdDev but honours ctx for cancellation
+func (c *Client) GetStdDevContext(ctx context.Context, sensorID string, duration time.Duration) (float64, error) {
+	values, err := c.windowValues(ctx, sensorID, duration)
+	if err != nil {
+		return 0, err
+	}
+	mean, _ := aggregate(values, gtsdb.AggregateAvg)
+	var squares float64
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(squares / float64(len(values))), nil
+}
+
+// GetPercentile returns the p-th percentile, with p between 0 and 100, of the
+// measurements of a sensor over the last duration, interpolating between ranks
+func (c *Client) GetPercentile(sensorID string, p float64, duration time.Duration) (float64, error) {
+	return c.GetPercentileContext(context.Background(), sensorID, p, duration)
+}
+
+// GetPercentileContext is like GetPercentile but honours ctx for cancellation
+func (c *Client) GetPercentileContext(ctx context.Context, sensorID string, p float64, duration time.Duration) (float64, error) {
+	if p < 0 || p > 100 || math.IsNaN(p) {
+		return 0, fmt.Errorf("percentile %v is outside [0, 100]", p)
+	}
+	values, err := c.windowValues(ctx, sensorID, duration)
+	if err != nil {
+		return 0, err
+	}
+	sort.Float64s(values)
+
+	rank := p / 100 * float64(len(values)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return values[lower] + (values[upper]-values[lower])*(rank-float64(lower)), nil
+}
+
+// windowValues returns the values of a sensor over the last duration, failing with
+// gtsdb.ErrNoData when there are none
+func (c *Client) windowValues(ctx context.Context, sensorID string, duration time.Duration) ([]float64, error) {
+	startTime, endTime := lastWindow(duration)
+	points, err := c.ReadPointsContext(ctx, sensorID, startTime, endTime, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(points) == 0 {
+		return nil, fmt.Errorf("%w for sensor %s in the specified time range", gtsdb.ErrNoData, sensorID)
+	}
+
+	values := make([]float64, len(points))
+	for i, point := range points {
+		values[i] = point.Value
+	}
+	return values, nil
+}
+
+// lastWindow returns the time range covering the last duration
+func lastWindow(duration time.Duration) (int64, int64) {
+	now := time.Now()
+	return now.Add(-duration).Unix(), now.Unix()
+}
+
+// ListKeys lists the keys holding data, optionally restricted to those starting with prefix
+func (c *Client) ListKeys(prefix string) ([]string, error) {
+	return c.ListKeysContext(context.Background(), prefix)
+}
+
+// ListKeysContext is like ListKeys but honours ctx for cancellation
+func (c *Client) ListKeysContext(ctx context.Context, prefix string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.check(ctx, "list keys"); err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for key, values := range c.series {
+		if len(values) > 0 && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// ListKeysMatching lists the keys matching a shell pattern as understood by path.Match
+func (c *Client) ListKeysMatching(pattern string) ([]string, error) {
+	return c.ListKeysMatchingContext(context.Background(), pattern)
+}
+
+// ListKeysMatchingContext is like ListKeysMatching but honours ctx for cancellation
+func (c *Client) ListKeysMatchingContext(ctx context.Context, pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("list keys: invalid pattern %q: %w", pattern, err)
+	}
+	candidates, err := c.ListKeysContext(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for _, key := range candidates {
+		if ok, _ := path.Match(pattern, key); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// KeyInfo returns the first and last timestamps and the point count of a key.
+// A key without data yields gtsdb.ErrKeyNotFound.
+func (c *Client) KeyInfo(key string) (gtsdb.KeyInfo, error) {
+	return c.KeyInfoContext(context.Background(), key)
+}
+
+// KeyInfoContext is like KeyInfo but honours ctx for cancellation
+func (c *Client) KeyInfoContext(ctx context.Context, key string) (gtsdb.KeyInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.check(ctx, "key info"); err != nil {
+		return gtsdb.KeyInfo{}, err
+	}
+	timestamps := c.timestamps(key, math.MinInt64, math.MaxInt64)
+	if len(timestamps) == 0 {
+		return gtsdb.KeyInfo{}, fmt.Errorf("key info: %w: %s", gtsdb.ErrKeyNotFound, key)
+	}
+	return gtsdb.KeyInfo{
+		Key:   key,
+		First: time.Unix(timestamps[0], 0),
+		Last:  time.Unix(timestamps[len(timestamps)-1], 0),
+		Count: int64(len(timestamps)),
+	}, nil
+}
+
+// DeleteKey removes every point of a key
+func (c *Client) DeleteKey(key string) error {
+	return c.DeleteKeyContext(context.Background(), key)
+}
+
+// DeleteKeyContext is like DeleteKey but honours ctx for cancellation
+func (c *Client) DeleteKeyContext(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.check(ctx, "delete key"); err != nil {
+		return err
+	}
+	delete(c.series, key)
+	return nil
+}
+
+// DeleteRange removes the points of a key between startTime and endTime inclusive
+func (c *Client) DeleteRange(key string, startTime, endTime int64) error {
+	return c.DeleteRangeContext(context.Background(), key, startTime, endTime)
+}
+
+// DeleteRangeContext is like DeleteRange but honours ctx for cancellation
+func (c *Client) DeleteRangeContext(ctx context.Context, key string, startTime, endTime int64) error {
+	if startTime < 0 || endTime < 0 || startTime > endTime {
+		return fmt.Errorf("%w: %d to %d", gtsdb.ErrInvalidTimeRange, startTime, endTime)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.check(ctx, "delete range"); err != nil {
+		return err
+	}
+	for _, ts := range c.timestamps(key, startTime, endTime) {
+		delete(c.series[key], ts)
+	}
+	return nil
+}
+
+// SubscribeChannels delivers the measurements written to each key on a channel of its
+// own. A slow consumer loses the oldest buffered updates. The returned cancel function
+// closes every channel.
+func (c *Client) SubscribeChannels(keys []string) (map[string]<-chan gtsdb.Measurement, func(), error) {
+	return c.SubscribeChannelsContext(context.Background(), keys)
+}
+
+// SubscribeChannelsContext is like SubscribeChannels but honours ctx for cancellation
+func (c *Client) SubscribeChannelsContext(ctx context.Context, keys []string) (map[string]<-chan gtsdb.Measurement, func(), error) {
+	if len(keys) == 0 {
+		return nil, nil, fmt.Errorf("no keys to subscribe to")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.check(ctx, "subscribe channels"); err != nil {
+		return nil, nil, err
+	}
+	channels := make(map[string]chan gtsdb.Measurement, len(keys))
+	result := make(map[string]<-chan gtsdb.Measurement, len(keys))
+	for _, key := range keys {
+		ch := make(chan gtsdb.Measurement, subscriptionBuffer)
+		if c.listeners[key] == nil {
+			c.listeners[key] = make(map[chan gtsdb.Measurement]struct{})
+		}
+		c.listeners[key][ch] = struct{}{}
+		channels[key] = ch
+		result[key] = ch
+	}
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			for key, ch := range channels {
+				// Close may already have closed the channel
+				if _, ok := c.listeners[key][ch]; ok {
+					delete(c.listeners[key], ch)
+					close(ch)
+				}
+			}
+		})
+	}
+	return result, cancel, nil
+}
+
+// deliverDropOldest sends m on ch, discarding the oldest buffered value when ch is full
+func deliverDropOldest(ch chan gtsdb.Measurement, m gtsdb.Measurement) {
+	for {
+		select {
+		case ch <- m:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// Ping succeeds until the client is closed
+func (c *Client) Ping() error {
+	return c.PingContext(context.Background())
+}
+
+// PingContext is like Ping but honours ctx for cancellation
+func (c *Client) PingContext(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.check(ctx, "ping")
+}
+
+// Close closes the subscription channels; later operations fail with gtsdb.ErrClosed
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for key, registered := range c.listeners {
+		for ch := range registered {
+			close(ch)
+		}
+		delete(c.listeners, key)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package fakegtsdb
+
+import "testing"
+
+func TestWriteRawRejectsDelimiters(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		encoded string
+	}{
+		{"empty key", "", "on"},
+		{"comma in key", "a,b", "on"},
+		{"pipe in key", "a|b", "on"},
+		{"newline in key", "a\nb", "on"},
+		{"empty value", "state", ""},
+		{"comma in value", "state", "on,off"},
+		{"pipe in value", "state", "on|off"},
+		{"newline in value", "state", "on\noff"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New()
+			if err := c.WriteRaw(tt.key, 1000, tt.encoded); err == nil {
+				t.Fatalf("WriteRaw(%q, %q) succeeded", tt.key, tt.encoded)
+			}
+			if len(c.series) != 0 {
+				t.Fatalf("WriteRaw(%q, %q) stored a value", tt.key, tt.encoded)
+			}
+		})
+	}
+
+	c := New()
+	if err := c.WriteRaw("state", 1000, "on"); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := c.ReadRaw("state", 0, 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 1 || raw[0].Value != "on" {
+		t.Fatalf("got %+v, want the value on", raw)
+	}
+}
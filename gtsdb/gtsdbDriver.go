@@ -0,0 +1,842 @@
+package gtsdb
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MaxMessageBytes is the largest command line the client sends in a single request
+const MaxMessageBytes = 64 * 1024
+
+// ackReply is the server's acknowledgement of an accepted write
+const ackReply = "OK"
+
+// ErrClosed is returned by operations on a client that has been closed
+var ErrClosed = errors.New("client is closed")
+
+// TSDBClient is a client for a GTSDB server
+type TSDBClient struct {
+	cfg ClientConfig
+	// tlsConfig is kept out of cfg since it may hold private keys
+	tlsConfig *tls.Config
+	// dialContext opens connections when set with WithDialContext
+	dialContext func(ctx context.Context, network, address string) (net.Conn, error)
+	logger      *slog.Logger
+	// pool lends a connection to each request/response exchange
+	pool *connPool
+
+	// subConn is pinned to subscriptions, so pushed updates never arrive on a
+	// connection that is waiting for a response
+	subConn *poolConn
+	// sem serializes commands on subConn; unlike a mutex, waiting for it can be
+	// abandoned when the context is done
+	sem chan struct{}
+	// connMu guards swapping subConn on reconnect against a concurrent Close
+	connMu sync.Mutex
+
+	// subscriptions are the keys subscribed with Subscribe and listeners the
+	// receivers of pushed updates per key; both are re-issued after a reconnect
+	subMu         sync.Mutex
+	subscriptions map[string]bool
+	listeners     map[string]map[*listener]struct{}
+
+	// cache holds recent ReadData results when enabled with WithQueryCache
+	cache *queryCache
+	// breaker fast-fails commands while the server is unreachable, when enabled
+	// with WithCircuitBreaker
+	breaker *circuitBreaker
+
+	// observers are notified of operations; observerMu serializes adding them
+	observers  atomic.Pointer[[]Observer]
+	observerMu sync.Mutex
+	// bytesSent, bytesReceived and bufferedPoints back Stats
+	bytesSent      atomic.Int64
+	bytesReceived  atomic.Int64
+	bufferedPoints atomic.Int64
+
+	// noLastCommand is set once the server rejected the last command, so that
+	// GetLatestMeasurement goes straight to searching
+	noLastCommand atomic.Bool
+	// hasLastCommand is set once the server answered the last command, after which
+	// it gets the full read timeout
+	hasLastCommand atomic.Bool
+
+	closeOnce sync.Once
+	closeErr  error
+	closed    atomic.Bool
+}
+
+// NewTSDBClient creates a new TSDB client. The address is a host and port, or the path
+// of a Unix domain socket given as unix:/path/to/socket.
+func NewTSDBClient(address string, opts ...Option) (*TSDBClient, error) {
+	return NewTSDBClientContext(context.Background(), address, opts...)
+}
+
+// NewTSDBClientContext is like NewTSDBClient but gives up dialing when ctx is done
+func NewTSDBClientContext(ctx context.Context, address string, opts ...Option) (*TSDBClient, error) {
+	c := &TSDBClient{cfg: ClientConfig{
+		Address:             address,
+		ResponseDelimiter:   "|",
+		MaxConcurrency:      8,
+		ReadTimeout:         30 * time.Second,
+		WriteTimeout:        10 * time.Second,
+		ProtocolVersion:     ProtocolV1,
+		Reconnect:           DefaultReconnectPolicy,
+		Retry:               DefaultRetryPolicy,
+		MinConns:            1,
+		MaxConns:            8,
+		IdleTimeout:         5 * time.Minute,
+		HealthCheckAfter:    30 * time.Second,
+		DialTimeout:         10 * time.Second,
+		ValuePrecision:      2,
+		QueryCacheSize:      defaultQueryCacheSize,
+		LatestLookback:      30 * 24 * time.Hour,
+		TimestampResolution: Seconds,
+	}, logger: slog.New(discardHandler{}), sem: make(chan struct{}, 1), subscriptions: make(map[string]bool), listeners: make(map[string]map[*listener]struct{})}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.cfg.ResponseDelimiter == "" || strings.ContainsAny(c.cfg.ResponseDelimiter, "\r\n") {
+		return nil, fmt.Errorf("invalid response delimiter %q", c.cfg.ResponseDelimiter)
+	}
+	if c.cfg.ProtocolVersion != ProtocolV1 && c.cfg.ProtocolVersion != ProtocolV2 {
+		return nil, fmt.Errorf("unsupported protocol version %d", c.cfg.ProtocolVersion)
+	}
+	if c.cfg.MaxConcurrency < 1 {
+		return nil, fmt.Errorf("max concurrency must be at least 1")
+	}
+	if c.cfg.MaxConns < 1 || c.cfg.MinConns < 0 || c.cfg.MinConns > c.cfg.MaxConns {
+		return nil, fmt.Errorf("invalid pool size %d..%d", c.cfg.MinConns, c.cfg.MaxConns)
+	}
+	if c.cfg.TimestampResolution < Seconds || c.cfg.TimestampResolution > Nanoseconds {
+		return nil, fmt.Errorf("unsupported timestamp resolution %d", c.cfg.TimestampResolution)
+	}
+	if c.cfg.ValuePrecision < FullPrecision {
+		return nil, fmt.Errorf("invalid value precision %d", c.cfg.ValuePrecision)
+	}
+	if c.cfg.ReadBufferSize < 0 || c.cfg.WriteBufferSize < 0 {
+		return nil, fmt.Errorf("invalid buffer sizes %d and %d", c.cfg.ReadBufferSize, c.cfg.WriteBufferSize)
+	}
+	if c.cfg.QueryCacheSize < 1 {
+		return nil, fmt.Errorf("invalid query cache size %d", c.cfg.QueryCacheSize)
+	}
+	if c.cfg.QueryCacheTTL > 0 {
+		c.cache = newQueryCache(c.cfg.QueryCacheTTL, c.cfg.QueryCacheSize)
+	}
+	if c.cfg.Breaker.FailureThreshold < 0 || c.cfg.Breaker.CoolDown < 0 {
+		return nil, fmt.Errorf("invalid circuit breaker policy")
+	}
+	if c.cfg.Breaker.FailureThreshold > 0 {
+		c.breaker = newCircuitBreaker(c.cfg.Breaker, c.breakerChanged)
+	}
+	if network, _ := splitAddress(address); network == "tcp" && c.tlsConfig != nil && c.tlsConfig.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", address, err)
+		}
+		c.tlsConfig.ServerName = host
+	}
+
+	// Fill the pool without retrying, so a wrong address fails fast
+	c.pool = newConnPool(c.redial, c.cfg, c.logger)
+	if err := c.pool.fill(ctx, c.dial); err != nil {
+		c.pool.close()
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("connect: %w: %w", ErrServerUnavailable, err)
+	}
+	return c, nil
+}
+
+// NewTSDBClientTLS creates a new TSDB client connecting over TLS. It is a shorthand
+// for NewTSDBClient with WithTLS.
+func NewTSDBClientTLS(address string, cfg *tls.Config, opts ...Option) (*TSDBClient, error) {
+	return NewTSDBClient(address, append([]Option{WithTLS(cfg)}, opts...)...)
+}
+
+// dial opens a new connection to the TSDB, completing the TLS handshake if enabled
+// within the same dial timeout
+func (c *TSDBClient) dial(ctx context.Context) (net.Conn, error) {
+	conn, err := c.dialConn(ctx)
+	c.dialed(err)
+	if err == nil {
+		c.logger.Debug("connected", "address", c.cfg.Address)
+	}
+	return conn, err
+}
+
+// splitAddress returns the network and address to dial: unix for the addresses of the
+// form unix:/path/to/socket or unix:///path/to/socket, tcp otherwise
+func splitAddress(address string) (network, addr string) {
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		return "unix", strings.TrimPrefix(path, "//")
+	}
+	return "tcp", address
+}
+
+// dialConn opens a connection with the dial function or over TCP or a Unix socket,
+// counting its traffic, and runs the TLS handshake when configured
+func (c *TSDBClient) dialConn(ctx context.Context) (net.Conn, error) {
+	if c.cfg.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.DialTimeout)
+		defer cancel()
+	}
+
+	network, addr := splitAddress(c.cfg.Address)
+	dial := c.dialContext
+	if dial == nil {
+		dialer := &net.Dialer{KeepAlive: c.cfg.KeepAlive}
+		dial = dialer.DialContext
+	}
+	conn, err := dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if c.cfg.ReadBufferSize > 0 {
+			tcpConn.SetReadBuffer(c.cfg.ReadBufferSize)
+		}
+		if c.cfg.WriteBufferSize > 0 {
+			tcpConn.SetWriteBuffer(c.cfg.WriteBufferSize)
+		}
+	}
+	conn = &countingConn{Conn: conn, c: c}
+	if c.tlsConfig == nil {
+		return conn, nil
+	}
+
+	tlsConn := tls.Client(conn, c.tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// Close closes the connections to the TSDB. It is safe to call more than once,
+// and any goroutine blocked reading a response returns ErrClosed.
+func (c *TSDBClient) Close() error {
+	c.closeOnce.Do(func() {
+		c.closed.Store(true)
+		c.closeErr = c.pool.close()
+
+		c.closeListeners()
+
+		c.connMu.Lock()
+		defer c.connMu.Unlock()
+		if c.subConn != nil {
+			c.subConn.conn.SetDeadline(time.Now())
+			if err := c.subConn.conn.Close(); c.closeErr == nil {
+				c.closeErr = err
+			}
+		}
+	})
+	return c.closeErr
+}
+
+// lock acquires exclusive use of the subscription connection, giving up when ctx is done
+func (c *TSDBClient) lock(ctx context.Context) error {
+	select {
+	case c.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// unlock releases the subscription connection acquired with lock
+func (c *TSDBClient) unlock() {
+	<-c.sem
+}
+
+// connErr reports err as ErrClosed when it was caused by closing the client,
+// or as the context's error when it was caused by ctx being done
+func (c *TSDBClient) connErr(ctx context.Context, op string, err error) error {
+	if c.closed.Load() {
+		return fmt.Errorf("%s: %w", op, ErrClosed)
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%s: %w", op, ctxErr)
+	}
+	return err
+}
+
+// send writes a single command line to the TSDB
+func (c *TSDBClient) send(ctx context.Context, op string, format string, args ...any) error {
+	return c.exchange(ctx, op, nonIdempotent, 0, nil, format, args...)
+}
+
+// callTimeoutKey is the context key of a per-call timeout set with WithCallTimeout
+type callTimeoutKey struct{}
+
+// WithCallTimeout returns a context overriding the client's read and write timeouts
+// for the calls made with it, where zero waits indefinitely. Unlike a context deadline
+// it may also raise the timeouts, e.g. for a single large query, and it applies to
+// each read and write of the call rather than to the call as a whole.
+func WithCallTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, callTimeoutKey{}, timeout)
+}
+
+// effectiveTimeout returns the per-call timeout set on ctx, or timeout if there is none
+func effectiveTimeout(ctx context.Context, timeout time.Duration) time.Duration {
+	if override, ok := ctx.Value(callTimeoutKey{}).(time.Duration); ok {
+		return override
+	}
+	return timeout
+}
+
+// deadline converts a timeout into an absolute deadline, where zero means none,
+// moved earlier if ctx has a sooner deadline. A per-call timeout on ctx replaces
+// the given one.
+func deadline(ctx context.Context, timeout time.Duration) time.Time {
+	timeout = effectiveTimeout(ctx, timeout)
+	var d time.Time
+	if timeout > 0 {
+		d = time.Now().Add(timeout)
+	}
+	if ctxDeadline, ok := ctx.Deadline(); ok && (d.IsZero() || ctxDeadline.Before(d)) {
+		d = ctxDeadline
+	}
+	return d
+}
+
+// formatCommand builds a command line, rejecting ones larger than MaxMessageBytes
+func formatCommand(op string, format string, args ...any) (string, error) {
+	line := fmt.Sprintf(format, args...)
+	if len(line) > MaxMessageBytes {
+		return "", fmt.Errorf("%s: command of %d bytes exceeds MaxMessageBytes", op, len(line))
+	}
+	return line, nil
+}
+
+// WriteData writes a single data point to the TSDB. It returns once the point is
+// sent, or once the server acknowledged it with WithWriteAcks.
+func (c *TSDBClient) WriteData(key string, timestamp int64, value float64) error {
+	return c.WriteDataContext(context.Background(), key, timestamp, value)
+}
+
+// WriteDataContext is like WriteData but honours ctx for cancellation and deadlines
+func (c *TSDBClient) WriteDataContext(ctx context.Context, key string, timestamp int64, value float64) (err error) {
+	if c.cfg.WriteAcks {
+		return c.WriteDataSyncContext(ctx, key, timestamp, value)
+	}
+	done := c.observe(ctx, Command{Op: "write data", Key: key, Points: 1})
+	defer func() { done(err) }()
+	defer c.wrote(key, timestamp, timestamp)
+
+	return c.send(ctx, "write data", "%s", c.formatWrite(key, timestamp, value))
+}
+
+// WriteDataSync writes a single data point and waits for the server to acknowledge it.
+// Unlike WriteData it costs a full round trip per point, but an error is returned if the
+// server rejects the point or no acknowledgement arrives within the write timeout.
+func (c *TSDBClient) WriteDataSync(key string, timestamp int64, value float64) error {
+	return c.WriteDataSyncContext(context.Background(), key, timestamp, value)
+}
+
+// WriteDataSyncContext is like WriteDataSync but honours ctx for cancellation and deadlines
+func (c *TSDBClient) WriteDataSyncContext(ctx context.Context, key string, timestamp int64, value float64) (err error) {
+	done := c.observe(ctx, Command{Op: "write data sync", Key: key, Points: 1})
+	defer func() { done(err) }()
+	defer c.wrote(key, timestamp, timestamp)
+
+	var response string
+	err = c.exchange(ctx, "write data sync", nonIdempotent, c.cfg.WriteTimeout, func(pc *poolConn) error {
+		var err error
+		response, err = pc.readLine()
+		return err
+	}, "%s", c.formatWrite(key, timestamp, value))
+	if err != nil {
+		if isTimeout(err) && ctx.Err() == nil {
+			return fmt.Errorf("write data sync: no acknowledgement within %s: %w", effectiveTimeout(ctx, c.cfg.WriteTimeout), err)
+		}
+		return err
+	}
+
+	if ack := strings.TrimSpace(response); ack != ackReply {
+		return fmt.Errorf("write data sync: unexpected acknowledgement %q", ack)
+	}
+	return nil
+}
+
+// ReadData reads data from the TSDB for a given key, time range, and downsampling
+func (c *TSDBClient) ReadData(key string, startTime, endTime int64, downsampling int) ([]string, error) {
+	return c.ReadDataContext(context.Background(), key, startTime, endTime, downsampling)
+}
+
+// ReadDataContext is like ReadData but honours ctx for cancellation and deadlines
+func (c *TSDBClient) ReadDataContext(ctx context.Context, key string, startTime, endTime int64, downsampling int) ([]string, error) {
+	return c.readData(ctx, key, startTime, endTime, downsampling, AggregateDefault)
+}
+
+// readData reads the records of a query, through the query cache when it is enabled
+func (c *TSDBClient) readData(ctx context.Context, key string, startTime, endTime int64, downsampling int, aggregation Aggregation) (records []string, err error) {
+	done := c.observe(ctx, Command{Op: "read data", Key: key})
+	defer func() { done(err) }()
+
+	if err := c.checkRange(startTime, endTime); err != nil {
+		return nil, err
+	}
+	if err := aggregation.validate(); err != nil {
+		return nil, err
+	}
+
+	if c.cache == nil {
+		return c.queryRange(ctx, key, startTime, endTime, downsampling, aggregation)
+	}
+
+	k := queryKey{key: key, startTime: startTime, endTime: endTime, downsampling: downsampling, aggregation: aggregation}
+	if records, ok := c.cache.get(k); ok {
+		return records, nil
+	}
+
+	records, err = c.queryRange(ctx, key, startTime, endTime, downsampling, aggregation)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.put(k, records)
+	return records, nil
+}
+
+// queryRange sends a read command, naming the aggregation only when one is chosen so
+// that servers without aggregation support keep working for default reads
+func (c *TSDBClient) queryRange(ctx context.Context, key string, startTime, endTime int64, downsampling int, aggregation Aggregation) ([]string, error) {
+	if aggregation == AggregateDefault {
+		return c.query(ctx, "read data", c.cfg.ReadTimeout, "%s,%d,%d,%d\n", key, startTime, endTime, downsampling)
+	}
+	return c.query(ctx, "read data", c.cfg.ReadTimeout, "%s,%d,%d,%d,%s\n", key, startTime, endTime, downsampling, aggregation)
+}
+
+// ReadPoints is like ReadData but parses the records into data points. An unparsable
+// record fails the whole read with a *RecordError.
+func (c *TSDBClient) ReadPoints(key string, startTime, endTime int64, downsampling int) ([]DataPoint, error) {
+	return c.ReadPointsContext(context.Background(), key, startTime, endTime, downsampling)
+}
+
+// ReadPointsContext is like ReadPoints but honours ctx for cancellation and deadlines
+func (c *TSDBClient) ReadPointsContext(ctx context.Context, key string, startTime, endTime int64, downsampling int) ([]DataPoint, error) {
+	data, err := c.ReadDataContext(ctx, key, startTime, endTime, downsampling)
+	if err != nil {
+		return nil, err
+	}
+	return c.parsePoints(data)
+}
+
+// parsePoints parses the records of a read response
+func (c *TSDBClient) parsePoints(records []string) ([]DataPoint, error) {
+	points := make([]DataPoint, 0, len(records))
+	for i, record := range records {
+		point, err := c.parseMeasurement(record)
+		if err != nil {
+			return nil, &RecordError{Index: i, Record: record, Err: err}
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
+
+// roundTrip sends a command line and reads the single response line it produces,
+// waiting at most readTimeout for it. An error reply from the server is returned
+// as a *ServerError.
+func (c *TSDBClient) roundTrip(ctx context.Context, op string, readTimeout time.Duration, format string, args ...any) (string, error) {
+	var response string
+	err := c.exchange(ctx, op, idempotent, readTimeout, func(pc *poolConn) error {
+		var err error
+		response, err = pc.readLine()
+		return err
+	}, format, args...)
+	return response, err
+}
+
+// query sends a command line and reads the records of its response, framed
+// according to the client's protocol version
+func (c *TSDBClient) query(ctx context.Context, op string, readTimeout time.Duration, format string, args ...any) ([]string, error) {
+	var records []string
+	err := c.exchange(ctx, op, idempotent, readTimeout, func(pc *poolConn) error {
+		var err error
+		records, err = pc.readRecords(&c.cfg)
+		return err
+	}, format, args...)
+	return records, err
+}
+
+// exchange sends a command line on a connection borrowed from the pool and lets read,
+// if given, consume the reply before the connection is returned. Failures are retried
+// as the retry policy and mode allow.
+func (c *TSDBClient) exchange(ctx context.Context, op string, mode retryMode, readTimeout time.Duration, read func(pc *poolConn) error, format string, args ...any) error {
+	line, err := formatCommand(op, format, args...)
+	if err != nil {
+		return err
+	}
+	return c.exchangePayload(ctx, op, mode, readTimeout, line, read)
+}
+
+// exchangePayload is like exchange for a payload of already formatted command lines
+func (c *TSDBClient) exchangePayload(ctx context.Context, op string, mode retryMode, readTimeout time.Duration, payload string, read func(pc *poolConn) error) error {
+	return c.retry(ctx, op, mode, func() error {
+		if c.closed.Load() {
+			return fmt.Errorf("%s: %w", op, ErrClosed)
+		}
+
+		return c.guard(ctx, op, func() error {
+			pc, err := c.pool.get(ctx)
+			if err != nil {
+				return c.connErr(ctx, op, &unsentError{fmt.Errorf("%s: connect: %w: %w", op, ErrServerUnavailable, err)})
+			}
+			defer c.pool.put(pc, true)
+			return c.converse(ctx, op, pc, readTimeout, payload, read)
+		})
+	})
+}
+
+// converse sends a command line on pc and lets read, if given, consume the reply.
+// When ctx is done the pending I/O is interrupted through its deadline.
+func (c *TSDBClient) converse(ctx context.Context, op string, pc *poolConn, readTimeout time.Duration, line string, read func(pc *poolConn) error) error {
+	conn := pc.conn
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+		close(interrupted)
+	})
+	defer func() {
+		if !stop() {
+			// Don't let the interruption leak into the next exchange
+			<-interrupted
+		}
+	}()
+
+	if pc.stale != nil {
+		// Don't let a late reply be taken for the answer to this command
+		discardTimeout := readTimeout
+		if discardTimeout <= 0 {
+			discardTimeout = c.cfg.WriteTimeout
+		}
+		conn.SetReadDeadline(deadline(ctx, discardTimeout))
+		c.logger.Debug("discarding stale response", "op", op)
+		if err := pc.discardStale(); err != nil {
+			return c.connErr(ctx, op, &unsentError{fmt.Errorf("%s: discard stale response: %w", op, err)})
+		}
+	}
+
+	conn.SetWriteDeadline(deadline(ctx, c.cfg.WriteTimeout))
+	if n, err := io.WriteString(conn, line); err != nil {
+		// Even a timed out write may have left half a command on the stream
+		pc.broken = true
+		if n == 0 {
+			err = &unsentError{err}
+		}
+		return c.connErr(ctx, op, err)
+	}
+	if read == nil {
+		return nil
+	}
+
+	conn.SetReadDeadline(deadline(ctx, readTimeout))
+	if err := read(pc); err != nil {
+		var serverErr *ServerError
+		if !errors.As(err, &serverErr) && !isTimeout(err) {
+			pc.broken = true
+			if ctx.Err() == nil && !c.closed.Load() {
+				c.logger.Warn("reading response failed, dropping connection", "op", op, "error", err)
+			}
+		}
+		return c.connErr(ctx, op, err)
+	}
+	return nil
+}
+
+// splitRecords splits a response line into records, dropping the empty
+// segments produced by leading, trailing or doubled delimiters
+func splitRecords(response, delimiter string) []string {
+	var records []string
+	for _, record := range strings.Split(response, delimiter) {
+		if record = strings.TrimSpace(record); record == "" {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// DataPoint is a single "key,timestamp,value" record as stored in the TSDB
+type DataPoint struct {
+	Key       string
+	Timestamp time.Time
+	Value     float64
+}
+
+// parseRecord splits a "key,timestamp,value" record into its fields
+func parseRecord(line string, resolution TimestampResolution) (string, time.Time, string, error) {
+	parts := strings.Split(strings.TrimSpace(line), ",")
+	if len(parts) != 3 {
+		return "", time.Time{}, "", fmt.Errorf("invalid data format %q: expected 3 fields, got %d", line, len(parts))
+	}
+
+	timestamp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, "", fmt.Errorf("invalid data format %q: bad timestamp: %w", line, err)
+	}
+
+	return parts[0], resolution.ToTime(timestamp), parts[2], nil
+}
+
+// ParseMeasurement parses a single record as returned by ReadData, with its
+// timestamp in whole seconds
+func ParseMeasurement(line string) (DataPoint, error) {
+	return parseMeasurement(line, Seconds)
+}
+
+// parseMeasurement parses a single record in the client's timestamp resolution
+func (c *TSDBClient) parseMeasurement(line string) (DataPoint, error) {
+	return parseMeasurement(line, c.cfg.TimestampResolution)
+}
+
+func parseMeasurement(line string, resolution TimestampResolution) (DataPoint, error) {
+	key, timestamp, raw, err := parseRecord(line, resolution)
+	if err != nil {
+		return DataPoint{}, err
+	}
+
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return DataPoint{}, fmt.Errorf("invalid data format %q: bad value: %w", line, err)
+	}
+
+	return DataPoint{Key: key, Timestamp: timestamp, Value: value}, nil
+}
+
+// Measurement is a single timestamped value of a sensor
+type Measurement struct {
+	Timestamp time.Time
+	Value     float64
+}
+
+// RawMeasurement is a string-encoded value read back by ReadRaw
+type RawMeasurement struct {
+	Timestamp time.Time
+	Value     string
+}
+
+// WriteRaw writes a small string-encoded value (e.g. "ON"/"OFF") to the TSDB.
+// Raw values share the timeseries with numeric ones, but aggregations such as
+// GetAverageMeasurement or downsampling don't apply to them. Reads parsing values,
+// such as ReadPoints and GetMeasurementHistory, fail with a *RecordError on a key
+// holding raw values, so keep those under keys of their own and read them with ReadRaw.
+func (c *TSDBClient) WriteRaw(key string, timestamp int64, encoded string) error {
+	return c.WriteRawContext(context.Background(), key, timestamp, encoded)
+}
+
+// WriteRawContext is like WriteRaw but honours ctx for cancellation and deadlines
+func (c *TSDBClient) WriteRawContext(ctx context.Context, key string, timestamp int64, encoded string) (err error) {
+	done := c.observe(ctx, Command{Op: "write raw", Key: key, Points: 1})
+	defer func() { done(err) }()
+
+	if err := checkRaw(key, encoded, c.cfg.ResponseDelimiter); err != nil {
+		return err
+	}
+	defer c.wrote(key, timestamp, timestamp)
+
+	return c.send(ctx, "write raw", "%s,%d,%s\n", key, timestamp, encoded)
+}
+
+// checkRaw rejects raw writes that wouldn't read back: an empty key or value, or one
+// holding a protocol delimiter
+func checkRaw(key, encoded, delimiter string) error {
+	if key == "" {
+		return fmt.Errorf("key must not be empty")
+	}
+	if strings.ContainsAny(key, ",\r\n") || strings.Contains(key, delimiter) {
+		return fmt.Errorf("key %q contains a protocol delimiter", key)
+	}
+	if encoded == "" {
+		return fmt.Errorf("raw value must not be empty")
+	}
+	if strings.ContainsAny(encoded, ",\r\n") || strings.Contains(encoded, delimiter) {
+		return fmt.Errorf("raw value %q contains a protocol delimiter", encoded)
+	}
+	return nil
+}
+
+// ReadRaw reads string-encoded values written with WriteRaw for a given key and time range.
+// No downsampling is requested since the values can't be aggregated.
+func (c *TSDBClient) ReadRaw(key string, startTime, endTime int64) ([]RawMeasurement, error) {
+	return c.ReadRawContext(context.Background(), key, startTime, endTime)
+}
+
+// ReadRawContext is like ReadRaw but honours ctx for cancellation and deadlines
+func (c *TSDBClient) ReadRawContext(ctx context.Context, key string, startTime, endTime int64) ([]RawMeasurement, error) {
+	data, err := c.ReadDataContext(ctx, key, startTime, endTime, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var measurements []RawMeasurement
+	for _, record := range data {
+		_, timestamp, value, err := parseRecord(record, c.cfg.TimestampResolution)
+		if err != nil {
+			return nil, err
+		}
+
+		measurements = append(measurements, RawMeasurement{
+			Timestamp: timestamp,
+			Value:     value,
+		})
+	}
+
+	return measurements, nil
+}
+
+// RecordMeasurement records a single measurement for a given sensor
+func (c *TSDBClient) RecordMeasurement(sensorID string, value float64) error {
+	return c.RecordMeasurementContext(context.Background(), sensorID, value)
+}
+
+// RecordMeasurementContext is like RecordMeasurement but honours ctx for cancellation and deadlines
+func (c *TSDBClient) RecordMeasurementContext(ctx context.Context, sensorID string, value float64) error {
+	return c.WriteDataContext(ctx, sensorID, c.timestamp(time.Now()), value)
+}
+
+// GetLatestMeasurement retrieves the most recent measurement for a given sensor, however
+// long ago it was reported, up to the maximum lookback set with WithLatestLookback.
+// It asks the server for the last value directly and falls back to searching
+// backwards in growing windows on servers without a last command. A server that leaves
+// the first last command unanswered for two seconds is taken to lack it.
+func (c *TSDBClient) GetLatestMeasurement(sensorID string) (float64, time.Time, error) {
+	return c.GetLatestMeasurementContext(context.Background(), sensorID)
+}
+
+// GetLatestMeasurementContext is like GetLatestMeasurement but honours ctx for cancellation and deadlines
+func (c *TSDBClient) GetLatestMeasurementContext(ctx context.Context, sensorID string) (float64, time.Time, error) {
+	point, err := c.latestPoint(ctx, sensorID)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return point.Value, point.Timestamp, nil
+}
+
+// GetLatestMeasurementWithin retrieves the most recent measurement for a given sensor
+// reported within the given lookback window
+func (c *TSDBClient) GetLatestMeasurementWithin(sensorID string, window time.Duration) (float64, time.Time, error) {
+	return c.GetLatestMeasurementWithinContext(context.Background(), sensorID, window)
+}
+
+// GetLatestMeasurementWithinContext is like GetLatestMeasurementWithin but honours ctx for cancellation and deadlines
+func (c *TSDBClient) GetLatestMeasurementWithinContext(ctx context.Context, sensorID string, window time.Duration) (float64, time.Time, error) {
+	if window <= 0 {
+		return 0, time.Time{}, fmt.Errorf("%w: lookback window must be positive", ErrInvalidInterval)
+	}
+
+	now := time.Now()
+	points, err := c.ReadPointsContext(ctx, sensorID, c.timestamp(now.Add(-window)), c.timestamp(now), 0)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	if len(points) == 0 {
+		return 0, time.Time{}, fmt.Errorf("%w for sensor %s", ErrNoData, sensorID)
+	}
+
+	point := latest(points)
+	return point.Value, point.Timestamp, nil
+}
+
+// GetAverageMeasurement calculates the average measurement over a specified time period
+func (c *TSDBClient) GetAverageMeasurement(sensorID string, duration time.Duration) (float64, error) {
+	return c.GetAverageMeasurementContext(context.Background(), sensorID, duration)
+}
+
+// GetAverageMeasurementContext is like GetAverageMeasurement but honours ctx for cancellation and deadlines
+func (c *TSDBClient) GetAverageMeasurementContext(ctx context.Context, sensorID string, duration time.Duration) (float64, error) {
+	points, err := c.windowPoints(ctx, sensorID, duration)
+	if err != nil {
+		return 0, err
+	}
+
+	var sum float64
+	var count int
+
+	for _, point := range points {
+		sum += point.Value
+		count++
+	}
+
+	return sum / float64(count), nil
+}
+
+// GetMeasurementHistory retrieves the measurement history for a given sensor and time range.
+// The interval must be positive; intervals under a second are rounded up to one second.
+// By default only the intervals holding measurements are returned; WithAlignment and
+// WithFill turn the history into a regular grid suited to charting.
+func (c *TSDBClient) GetMeasurementHistory(sensorID string, startTime, endTime time.Time, interval time.Duration, opts ...HistoryOption) ([]Measurement, error) {
+	return c.GetMeasurementHistoryContext(context.Background(), sensorID, startTime, endTime, interval, opts...)
+}
+
+// GetMeasurementHistoryContext is like GetMeasurementHistory but honours ctx for cancellation and deadlines
+func (c *TSDBClient) GetMeasurementHistoryContext(ctx context.Context, sensorID string, startTime, endTime time.Time, interval time.Duration, opts ...HistoryOption) ([]Measurement, error) {
+	return c.GetAggregatedHistoryContext(ctx, sensorID, startTime, endTime, interval, AggregateDefault, opts...)
+}
+
+// GetAggregatedHistory is like GetMeasurementHistory but has the server combine the
+// points of each interval with the given aggregation
+func (c *TSDBClient) GetAggregatedHistory(sensorID string, startTime, endTime time.Time, interval time.Duration, aggregation Aggregation, opts ...HistoryOption) ([]Measurement, error) {
+	return c.GetAggregatedHistoryContext(context.Background(), sensorID, startTime, endTime, interval, aggregation, opts...)
+}
+
+// GetAggregatedHistoryContext is like GetAggregatedHistory but honours ctx for cancellation and deadlines
+func (c *TSDBClient) GetAggregatedHistoryContext(ctx context.Context, sensorID string, startTime, endTime time.Time, interval time.Duration, aggregation Aggregation, opts ...HistoryOption) ([]Measurement, error) {
+	options, err := newHistoryOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	downsampling, err := c.downsampling(interval)
+	if err != nil {
+		return nil, err
+	}
+	// The interval the server actually buckets by, after rounding to the resolution
+	interval = time.Duration(downsampling) * c.cfg.TimestampResolution.Unit()
+
+	if options.align {
+		startTime = alignTime(startTime, interval)
+	}
+
+	points, err := c.ReadAggregatedContext(ctx, sensorID, c.timestamp(startTime), c.timestamp(endTime), downsampling, aggregation)
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]Measurement, 0, len(points))
+	for _, point := range points {
+		history = append(history, Measurement{
+			Timestamp: point.Timestamp,
+			Value:     point.Value,
+		})
+	}
+
+	return options.arrange(history, startTime, endTime, interval)
+}
+
+// downsampling converts a history interval into the downsampling sent to the server,
+// counted in the client's timestamp resolution
+func (c *TSDBClient) downsampling(interval time.Duration) (int, error) {
+	if interval <= 0 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidInterval, interval)
+	}
+
+	ticks := int(interval / c.cfg.TimestampResolution.Unit())
+	if ticks < 1 {
+		ticks = 1
+	}
+	return ticks, nil
+}
@@ -0,0 +1,643 @@
+package gtsdb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer is a TSDB server on a loopback listener, keeping the points written to it
+// in memory and answering the commands of the driver the way the real server does.
+type fakeServer struct {
+	ln net.Listener
+
+	// acks has writes answered with OK, as the server does for WriteDataSync
+	acks bool
+	// framed answers reads with ProtocolV2 blocks
+	framed bool
+	// delimiter separates the records of ProtocolV1 replies, | unless set
+	delimiter string
+	// noInfo answers info commands with an error, like servers predating them
+	noInfo bool
+	// handle, when set, sees every command first; it returns true if it answered it
+	handle func(conn net.Conn, line string) bool
+
+	mu       sync.Mutex
+	series   map[string]map[int64]string
+	commands []string
+	// accepted counts the connections accepted so far
+	accepted int
+	// subscribers are the connections subscribed to each key
+	subscribers map[string]map[net.Conn]bool
+	conns       map[net.Conn]bool
+}
+
+// newFakeServer starts a fakeServer, configured by setup before it accepts connections
+func newFakeServer(t testing.TB, setup ...func(*fakeServer)) *fakeServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{
+		ln:          ln,
+		delimiter:   "|",
+		series:      make(map[string]map[int64]string),
+		subscribers: make(map[string]map[net.Conn]bool),
+		conns:       make(map[net.Conn]bool),
+	}
+	for _, f := range setup {
+		f(s)
+	}
+	t.Cleanup(s.close)
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) addr() string {
+	return s.ln.Addr().String()
+}
+
+// client connects a client to the server, failing the test if it can't. Reads time
+// out after a few seconds unless opts say otherwise, so a broken test fails rather
+// than hangs.
+func (s *fakeServer) client(t testing.TB, opts ...Option) *TSDBClient {
+	t.Helper()
+	c, err := NewTSDBClient(s.addr(), append([]Option{WithDialTimeout(time.Second), WithReadTimeout(5 * time.Second)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// close stops accepting connections and hangs up on the open ones
+func (s *fakeServer) close() {
+	s.ln.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.accepted++
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+func (s *fakeServer) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		for _, subscribed := range s.subscribers {
+			delete(subscribed, conn)
+		}
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		s.mu.Unlock()
+		if s.handle != nil && s.handle(conn, line) {
+			continue
+		}
+		if reply, ok := s.answer(conn, line); ok {
+			if _, err := conn.Write([]byte(reply)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// answer returns the reply to a command, or false for commands answered by none
+func (s *fakeServer) answer(conn net.Conn, line string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fields := strings.Split(line, ",")
+	switch {
+	case line == "ping":
+		return "PONG\n", true
+	case fields[0] == "subscribe" && len(fields) == 2:
+		if s.subscribers[fields[1]] == nil {
+			s.subscribers[fields[1]] = make(map[net.Conn]bool)
+		}
+		s.subscribers[fields[1]][conn] = true
+		return "", false
+	case fields[0] == "unsubscribe" && len(fields) == 2:
+		delete(s.subscribers[fields[1]], conn)
+		return "", false
+	case fields[0] == "keys":
+		var keys []string
+		for key := range s.series {
+			if len(fields) == 1 || strings.HasPrefix(key, fields[1]) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		return s.records([]string{strings.Join(keys, ",")}), true
+	case fields[0] == "info" && len(fields) == 2:
+		if s.noInfo {
+			return "ERR unknown command info\n", true
+		}
+		timestamps := s.timestamps(fields[1], math.MinInt64, math.MaxInt64)
+		if len(timestamps) == 0 {
+			return "ERR NOT_FOUND " + fields[1] + "\n", true
+		}
+		return fmt.Sprintf("%s,%d,%d,%d\n", fields[1], timestamps[0], timestamps[len(timestamps)-1], len(timestamps)), true
+	case fields[0] == "last" && len(fields) == 2:
+		timestamps := s.timestamps(fields[1], math.MinInt64, math.MaxInt64)
+		if len(timestamps) == 0 {
+			return "ERR NOT_FOUND " + fields[1] + "\n", true
+		}
+		ts := timestamps[len(timestamps)-1]
+		return fmt.Sprintf("%s,%d,%s\n", fields[1], ts, s.series[fields[1]][ts]), true
+	case len(fields) == 3:
+		ts, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return "ERR MALFORMED bad timestamp\n", s.acks
+		}
+		s.store(fields[0], ts, fields[2])
+		return "OK\n", s.acks
+	case len(fields) == 4 || len(fields) == 5:
+		start, err1 := strconv.ParseInt(fields[1], 10, 64)
+		end, err2 := strconv.ParseInt(fields[2], 10, 64)
+		downsampling, err3 := strconv.Atoi(fields[3])
+		if err1 != nil || err2 != nil || err3 != nil {
+			return "\n", true
+		}
+		aggregation := AggregateAvg
+		if len(fields) == 5 {
+			aggregation = Aggregation(fields[4])
+		}
+		return s.records(s.read(fields[0], start, end, downsampling, aggregation)), true
+	}
+	return "", false
+}
+
+// records frames the records of a reply
+func (s *fakeServer) records(records []string) string {
+	if s.framed {
+		block := strings.Join(records, "\n")
+		return strconv.Itoa(len(block)) + "\n" + block
+	}
+	return strings.Join(records, s.delimiter) + "\n"
+}
+
+// store saves a value and pushes it to the subscribers of its key. It must be called
+// while holding mu.
+func (s *fakeServer) store(key string, ts int64, value string) {
+	if s.series[key] == nil {
+		s.series[key] = make(map[int64]string)
+	}
+	s.series[key][ts] = value
+	for conn := range s.subscribers[key] {
+		fmt.Fprintf(conn, "%s,%d,%s\n", key, ts, value)
+	}
+}
+
+// write stores a point as if a client wrote it
+func (s *fakeServer) write(key string, ts int64, value float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store(key, ts, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+// timestamps returns the sorted timestamps of key in the range. It must be called
+// while holding mu.
+func (s *fakeServer) timestamps(key string, start, end int64) []int64 {
+	var timestamps []int64
+	for ts := range s.series[key] {
+		if ts >= start && ts <= end {
+			timestamps = append(timestamps, ts)
+		}
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps
+}
+
+// read returns the records of key in the range, combining those of each downsampling
+// interval. It must be called while holding mu.
+func (s *fakeServer) read(key string, start, end int64, downsampling int, aggregation Aggregation) []string {
+	var records []string
+	if downsampling <= 1 {
+		for _, ts := range s.timestamps(key, start, end) {
+			records = append(records, fmt.Sprintf("%s,%d,%s", key, ts, s.series[key][ts]))
+		}
+		return records
+	}
+
+	buckets := make(map[int64][]float64)
+	var order []int64
+	for _, ts := range s.timestamps(key, start, end) {
+		value, _ := strconv.ParseFloat(s.series[key][ts], 64)
+		bucket := start + (ts-start)/int64(downsampling)*int64(downsampling)
+		if buckets[bucket] == nil {
+			order = append(order, bucket)
+		}
+		buckets[bucket] = append(buckets[bucket], value)
+	}
+	for _, bucket := range order {
+		values := buckets[bucket]
+		var result float64
+		switch aggregation {
+		case AggregateMin:
+			result = values[0]
+			for _, v := range values {
+				result = min(result, v)
+			}
+		case AggregateMax:
+			result = values[0]
+			for _, v := range values {
+				result = max(result, v)
+			}
+		case AggregateCount:
+			result = float64(len(values))
+		default:
+			for _, v := range values {
+				result += v
+			}
+			if aggregation != AggregateSum {
+				result /= float64(len(values))
+			}
+		}
+		records = append(records, fmt.Sprintf("%s,%d,%s", key, bucket, strconv.FormatFloat(result, 'f', -1, 64)))
+	}
+	return records
+}
+
+// received returns the commands received so far
+func (s *fakeServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// count returns how many of the commands received so far start with prefix
+func (s *fakeServer) count(prefix string) int {
+	n := 0
+	for _, command := range s.received() {
+		if strings.HasPrefix(command, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// eventually waits up to a few seconds for cond to hold
+func eventually(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWriteAndReadData(t *testing.T) {
+	server := newFakeServer(t)
+	client := server.client(t)
+
+	if err := client.WriteData("temp", 1700000000, 21.5); err != nil {
+		t.Fatal(err)
+	}
+	// Writes aren't acknowledged, so the write may still be on its way
+	eventually(t, "the write arrived", func() bool { return server.count("temp,1700000000,") == 1 })
+
+	records, err := client.ReadData("temp", 1700000000, 1700000010, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"temp,1700000000,21.50"}; !equalStrings(records, want) {
+		t.Fatalf("got %q, want %q", records, want)
+	}
+}
+
+// replying sets up a fakeServer to answer the reads of key with response
+func replying(key, response string) func(*fakeServer) {
+	return func(s *fakeServer) {
+		s.handle = func(conn net.Conn, line string) bool {
+			if strings.HasPrefix(line, key+",") && strings.Count(line, ",") >= 3 {
+				io.WriteString(conn, response)
+				return true
+			}
+			return false
+		}
+	}
+}
+
+func TestReadDataSkipsEmptyRecords(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     []string
+	}{
+		{"plain", "a,1,2.0|b,2,3.0", []string{"a,1,2.0", "b,2,3.0"}},
+		{"leading delimiter", "|a,1,2.0|b,2,3.0", []string{"a,1,2.0", "b,2,3.0"}},
+		{"trailing delimiter", "a,1,2.0|b,2,3.0|", []string{"a,1,2.0", "b,2,3.0"}},
+		{"doubled delimiter", "a,1,2.0||b,2,3.0", []string{"a,1,2.0", "b,2,3.0"}},
+		{"all of them", "||a,1,2.0|||b,2,3.0||", []string{"a,1,2.0", "b,2,3.0"}},
+		{"only delimiters", "|||", nil},
+		{"empty", "", nil},
+		{"padded records", " a,1,2.0 | b,2,3.0 ", []string{"a,1,2.0", "b,2,3.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitRecords(tt.response, "|"); !equalStrings(got, tt.want) {
+				t.Errorf("splitRecords(%q) = %q, want %q", tt.response, got, tt.want)
+			}
+
+			server := newFakeServer(t, replying("temp", tt.response+"\n"))
+			client := server.client(t)
+			records, err := client.ReadData("temp", 0, 10, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalStrings(records, tt.want) {
+				t.Errorf("ReadData got %q, want %q", records, tt.want)
+			}
+			points, err := client.ReadPoints("temp", 0, 10, 0)
+			if err != nil {
+				t.Fatalf("ReadPoints: %v", err)
+			}
+			if len(points) != len(tt.want) {
+				t.Errorf("ReadPoints got %d points, want %d", len(points), len(tt.want))
+			}
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestWriteRawRoundTrip(t *testing.T) {
+	server := newFakeServer(t)
+	client := server.client(t)
+
+	states := []string{"ON", "OFF", "STANDBY", "ON"}
+	for i, state := range states {
+		if err := client.WriteRaw("switch", 1700000000+int64(i), state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	eventually(t, "the writes arrived", func() bool { return server.count("switch,") == len(states) })
+
+	got, err := client.ReadRaw("switch", 1700000000, 1700000010)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(states) {
+		t.Fatalf("got %d values, want %d", len(got), len(states))
+	}
+	for i, m := range got {
+		if want := time.Unix(1700000000+int64(i), 0); m.Value != states[i] || !m.Timestamp.Equal(want) {
+			t.Errorf("value %d = %s at %s, want %s at %s", i, m.Value, m.Timestamp, states[i], want)
+		}
+	}
+
+	for _, bad := range []struct{ key, value string }{
+		{"switch", ""},
+		{"switch", "ON,OFF"},
+		{"switch", "ON|OFF"},
+		{"switch", "ON\n"},
+		{"", "ON"},
+		{"switch,1", "ON"},
+		{"switch|1", "ON"},
+		{"switch\n", "ON"},
+	} {
+		if err := client.WriteRaw(bad.key, 1700000010, bad.value); err == nil {
+			t.Errorf("WriteRaw accepted %q = %q", bad.key, bad.value)
+		}
+	}
+	if commands := server.received(); len(commands) != len(states)+1 {
+		t.Fatalf("server received %q after the rejected writes", commands[len(states)+1:])
+	}
+}
+
+func TestCloseUnblocksRead(t *testing.T) {
+	// The server takes the read and never answers it
+	server := newFakeServer(t, func(s *fakeServer) {
+		s.handle = func(conn net.Conn, line string) bool { return strings.HasPrefix(line, "temp,") }
+	})
+	client := server.client(t, WithReadTimeout(time.Minute))
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := client.ReadData("temp", 0, 10, 0)
+		errc <- err
+	}()
+	eventually(t, "the read was sent", func() bool { return server.count("temp,") == 1 })
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("blocked read returned %v, want ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked read still running after Close")
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+	if _, err := client.ReadData("temp", 0, 10, 0); !errors.Is(err, ErrClosed) {
+		t.Fatalf("read after Close returned %v, want ErrClosed", err)
+	}
+}
+
+func TestResponseDelimiter(t *testing.T) {
+	for _, delimiter := range []string{";", "\t", "||"} {
+		t.Run(strconv.Quote(delimiter), func(t *testing.T) {
+			server := newFakeServer(t, func(s *fakeServer) { s.delimiter = delimiter })
+			client := server.client(t, WithResponseDelimiter(delimiter))
+			server.write("temp", 1700000000, 1.5)
+			server.write("temp", 1700000001, 2.5)
+			server.write("temp", 1700000002, 3.5)
+			want := []string{"temp,1700000000,1.5", "temp,1700000001,2.5", "temp,1700000002,3.5"}
+
+			records, err := client.ReadData("temp", 1700000000, 1700000010, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalStrings(records, want) {
+				t.Fatalf("got %q, want %q", records, want)
+			}
+
+			// Streamed reads split records the same way
+			it, err := client.ReadStream("temp", 1700000000, 1700000010, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer it.Close()
+			var streamed []string
+			for it.Next() {
+				p := it.Point()
+				streamed = append(streamed, p.Key+","+strconv.FormatInt(p.Timestamp.Unix(), 10)+","+strconv.FormatFloat(p.Value, 'f', -1, 64))
+			}
+			if err := it.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if !equalStrings(streamed, want) {
+				t.Fatalf("streamed %q, want %q", streamed, want)
+			}
+
+			// Raw values and keys mustn't hold the delimiter in use, but may hold |
+			if err := client.WriteRaw("switch", 1700000000, "ON"+delimiter+"OFF"); err == nil {
+				t.Errorf("WriteRaw accepted a value holding %q", delimiter)
+			}
+			if err := client.WriteRaw("switch"+delimiter+"2", 1700000000, "ON"); err == nil {
+				t.Errorf("WriteRaw accepted a key holding %q", delimiter)
+			}
+			if delimiter != "||" {
+				if err := client.WriteRaw("switch", 1700000000, "ON|OFF"); err != nil {
+					t.Errorf("WriteRaw: %v", err)
+				}
+			}
+		})
+	}
+
+	// The default delimiter doesn't split another dialect's records
+	server := newFakeServer(t, func(s *fakeServer) { s.delimiter = ";" })
+	client := server.client(t)
+	server.write("temp", 1700000000, 1.5)
+	server.write("temp", 1700000001, 2.5)
+	records, err := client.ReadData("temp", 1700000000, 1700000010, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"temp,1700000000,1.5;temp,1700000001,2.5"}; !equalStrings(records, want) {
+		t.Fatalf("got %q, want %q", records, want)
+	}
+
+	if _, err := NewTSDBClient(server.addr(), WithResponseDelimiter("")); err == nil {
+		t.Error("an empty delimiter was accepted")
+	}
+	if _, err := NewTSDBClient(server.addr(), WithResponseDelimiter("\n")); err == nil {
+		t.Error("a newline delimiter was accepted")
+	}
+}
+
+func TestParseMeasurement(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    DataPoint
+		wantErr string
+	}{
+		{"valid", "temp,1700000000,21.5", DataPoint{Key: "temp", Timestamp: time.Unix(1700000000, 0), Value: 21.5}, ""},
+		{"surrounding space", " temp,1700000000,-3\n", DataPoint{Key: "temp", Timestamp: time.Unix(1700000000, 0), Value: -3}, ""},
+		{"exponent", "temp,0,1e3", DataPoint{Key: "temp", Timestamp: time.Unix(0, 0), Value: 1000}, ""},
+		{"empty", "", DataPoint{}, "expected 3 fields, got 1"},
+		{"too few fields", "temp,1700000000", DataPoint{}, "expected 3 fields, got 2"},
+		{"too many fields", "temp,1700000000,21.5,1", DataPoint{}, "expected 3 fields, got 4"},
+		{"non-numeric timestamp", "temp,yesterday,21.5", DataPoint{}, "bad timestamp"},
+		{"fractional timestamp", "temp,1700000000.5,21.5", DataPoint{}, "bad timestamp"},
+		{"non-numeric value", "temp,1700000000,ON", DataPoint{}, "bad value"},
+		{"empty value", "temp,1700000000,", DataPoint{}, "bad value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMeasurement(tt.line)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one mentioning %q", err, tt.wantErr)
+				}
+				if !strings.Contains(err.Error(), fmt.Sprintf("%q", tt.line)) {
+					t.Fatalf("error %v doesn't quote the line", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Key != tt.want.Key || !got.Timestamp.Equal(tt.want.Timestamp) || got.Value != tt.want.Value {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRecordResolution(t *testing.T) {
+	// parseRecord leaves the value alone, so raw values parse too
+	key, timestamp, value, err := parseRecord("switch,1700000000123,ON", Milliseconds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.UnixMilli(1700000000123); key != "switch" || !timestamp.Equal(want) || value != "ON" {
+		t.Fatalf("got %s %s %s, want switch %s ON", key, timestamp, value, want)
+	}
+
+	client := newFakeServer(t).client(t, WithTimestampResolution(Milliseconds))
+	point, err := client.parseMeasurement("temp,1700000000123,1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.UnixMilli(1700000000123); !point.Timestamp.Equal(want) {
+		t.Fatalf("parsed %s, want %s", point.Timestamp, want)
+	}
+}
+
+func TestNumericReadsOfRawValues(t *testing.T) {
+	server := newFakeServer(t)
+	client := server.client(t)
+	start := time.Unix(1700000000, 0)
+	for i, state := range []string{"ON", "OFF", "ON"} {
+		if err := client.WriteRaw("switch", start.Unix()+int64(i)*20, state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var recordErr *RecordError
+	if _, err := client.ReadPoints("switch", start.Unix(), start.Unix()+60, 0); !errors.As(err, &recordErr) || recordErr.Index != 0 {
+		t.Fatalf("ReadPoints returned %v, want a *RecordError for the first record", err)
+	}
+	if _, err := client.GetMeasurementHistory("switch", start, start.Add(time.Minute), time.Second); !errors.Is(err, ErrMalformedRecord) {
+		t.Fatalf("GetMeasurementHistory returned %v, want ErrMalformedRecord", err)
+	}
+
+	// Raw values count as received in the drop rate
+	series, err := client.GetDropRateSeries("switch", start, start.Add(time.Minute), time.Minute, 20*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 1 || series[0].Value != 0 {
+		t.Fatalf("got drop rates %+v, want none dropped", series)
+	}
+}
@@ -0,0 +1,81 @@
+// Package gtsdbotel traces the operations of a gtsdb client with OpenTelemetry. It lives
+// in its own package so that the driver itself stays free of dependencies.
+package gtsdbotel
+
+import (
+	"context"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the spans of this package
+const instrumentationName = "github.com/abbychau/gtsdb-drivers/gtsdb/gtsdbotel"
+
+// Option configures a Tracer
+type Option func(*Tracer)
+
+// WithTracerProvider sets the provider the spans are created with. It defaults to the
+// global provider.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(t *Tracer) {
+		t.provider = provider
+	}
+}
+
+// WithAttributes adds attributes to every span, such as the server address
+func WithAttributes(attrs ...attribute.KeyValue) Option {
+	return func(t *Tracer) {
+		t.attrs = append(t.attrs, attrs...)
+	}
+}
+
+// Tracer is a gtsdb.Observer recording a client span per operation, as a child of the
+// span in the operation's context. Register it with gtsdb.WithObserver.
+type Tracer struct {
+	provider trace.TracerProvider
+	attrs    []attribute.KeyValue
+	tracer   trace.Tracer
+}
+
+var _ gtsdb.Observer = (*Tracer)(nil)
+
+// New creates a Tracer
+func New(opts ...Option) *Tracer {
+	t := &Tracer{attrs: []attribute.KeyValue{attribute.String("db.system", "gtsdb")}}
+	for _, opt := range opts {
+		opt(t)
+	}
+	if t.provider == nil {
+		t.provider = otel.GetTracerProvider()
+	}
+	t.tracer = t.provider.Tracer(instrumentationName)
+	return t
+}
+
+// CommandStart implements gtsdb.Observer by starting a span named after the operation
+func (t *Tracer) CommandStart(ctx context.Context, cmd gtsdb.Command) func(err error) {
+	attrs := append([]attribute.KeyValue{attribute.String("db.operation", cmd.Op)}, t.attrs...)
+	if cmd.Key != "" {
+		attrs = append(attrs, attribute.String("gtsdb.key", cmd.Key))
+	}
+	if cmd.Points > 0 {
+		attrs = append(attrs, attribute.Int("gtsdb.points", cmd.Points))
+	}
+
+	_, span := t.tracer.Start(ctx, "gtsdb "+cmd.Op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	return func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// Dialed implements gtsdb.Observer. Dials are not tied to an operation's context, so
+// they are not traced.
+func (t *Tracer) Dialed(error) {}
@@ -0,0 +1,140 @@
+// Package gtsdbprom exports the metrics of a gtsdb client to Prometheus. It lives in
+// its own package so that the driver itself stays free of dependencies.
+package gtsdbprom
+
+import (
+	"context"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "gtsdb_client"
+
+// Collector is a prometheus.Collector reporting the operations, errors, latency, dials,
+// traffic, pool usage and async write buffer of a client. To tell several clients apart,
+// register each collector with prometheus.WrapRegistererWith and a distinguishing label.
+type Collector struct {
+	client *gtsdb.TSDBClient
+
+	commands      *prometheus.CounterVec
+	errors        *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	pointsWritten prometheus.Counter
+	dials         *prometheus.CounterVec
+
+	bytesSent      *prometheus.Desc
+	bytesReceived  *prometheus.Desc
+	openConns      *prometheus.Desc
+	inUseConns     *prometheus.Desc
+	bufferedPoints *prometheus.Desc
+	breakerState   *prometheus.Desc
+}
+
+var _ prometheus.Collector = (*Collector)(nil)
+var _ gtsdb.Observer = (*Collector)(nil)
+
+// NewCollector creates a collector for client and registers it as an observer of the
+// client's operations. The returned collector still has to be registered with Prometheus.
+func NewCollector(client *gtsdb.TSDBClient) *Collector {
+	c := &Collector{
+		client: client,
+		commands: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "operations_total",
+			Help:      "Operations run by the client, by operation.",
+		}, []string{"op"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "operation_errors_total",
+			Help:      "Operations that failed, by operation.",
+		}, []string{"op"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of the client's operations, by operation.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 4, 10),
+		}, []string{"op"}),
+		pointsWritten: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "points_written_total",
+			Help:      "Points handed to the server by write operations.",
+		}),
+		dials: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "dials_total",
+			Help:      "Connection attempts, reconnects included, by result.",
+		}, []string{"result"}),
+
+		bytesSent: prometheus.NewDesc(namespace+"_sent_bytes_total",
+			"Bytes written to the server.", nil, nil),
+		bytesReceived: prometheus.NewDesc(namespace+"_received_bytes_total",
+			"Bytes read from the server.", nil, nil),
+		openConns: prometheus.NewDesc(namespace+"_open_connections",
+			"Pooled connections currently open.", nil, nil),
+		inUseConns: prometheus.NewDesc(namespace+"_in_use_connections",
+			"Pooled connections currently lent to an operation.", nil, nil),
+		bufferedPoints: prometheus.NewDesc(namespace+"_buffered_points",
+			"Points waiting in the client's async writers.", nil, nil),
+		breakerState: prometheus.NewDesc(namespace+"_circuit_breaker_state",
+			"State of the circuit breaker: 0 closed, 1 open, 2 half-open.", nil, nil),
+	}
+	client.AddObserver(c)
+	return c
+}
+
+// CommandStart implements gtsdb.Observer
+func (c *Collector) CommandStart(_ context.Context, cmd gtsdb.Command) func(err error) {
+	start := time.Now()
+	return func(err error) {
+		c.duration.WithLabelValues(cmd.Op).Observe(time.Since(start).Seconds())
+		c.commands.WithLabelValues(cmd.Op).Inc()
+		if err != nil {
+			c.errors.WithLabelValues(cmd.Op).Inc()
+			return
+		}
+		c.pointsWritten.Add(float64(cmd.Points))
+	}
+}
+
+// Dialed implements gtsdb.Observer
+func (c *Collector) Dialed(err error) {
+	if err != nil {
+		c.dials.WithLabelValues("failure").Inc()
+		return
+	}
+	c.dials.WithLabelValues("success").Inc()
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.commands.Describe(ch)
+	c.errors.Describe(ch)
+	c.duration.Describe(ch)
+	c.pointsWritten.Describe(ch)
+	c.dials.Describe(ch)
+	ch <- c.bytesSent
+	ch <- c.bytesReceived
+	ch <- c.openConns
+	ch <- c.inUseConns
+	ch <- c.bufferedPoints
+	ch <- c.breakerState
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.commands.Collect(ch)
+	c.errors.Collect(ch)
+	c.duration.Collect(ch)
+	c.pointsWritten.Collect(ch)
+	c.dials.Collect(ch)
+
+	stats := c.client.Stats()
+	ch <- prometheus.MustNewConstMetric(c.bytesSent, prometheus.CounterValue, float64(stats.BytesSent))
+	ch <- prometheus.MustNewConstMetric(c.bytesReceived, prometheus.CounterValue, float64(stats.BytesReceived))
+	ch <- prometheus.MustNewConstMetric(c.openConns, prometheus.GaugeValue, float64(stats.OpenConns))
+	ch <- prometheus.MustNewConstMetric(c.inUseConns, prometheus.GaugeValue, float64(stats.InUseConns))
+	ch <- prometheus.MustNewConstMetric(c.bufferedPoints, prometheus.GaugeValue, float64(stats.BufferedPoints))
+	ch <- prometheus.MustNewConstMetric(c.breakerState, prometheus.GaugeValue, float64(c.client.BreakerState()))
+}
@@ -0,0 +1,582 @@
+// Package gtsdbsql is a database/sql driver for GTSDB, for reusing SQL tooling in
+// read-mostly settings. Importing it registers the driver as "gtsdb":
+//
+//	db, err := sql.Open("gtsdb", "localhost:5555?timeout=5s")
+//	rows, err := db.Query("SELECT ts, value FROM series WHERE key = ? AND ts BETWEEN ? AND ?", "sensor1", start, end)
+//
+// The series live in a table named series with the columns key, ts and value. Two
+// statements are understood:
+//
+//	SELECT columns FROM series WHERE key = x [AND ts BETWEEN a AND b | AND ts < b ...] [ORDER BY ts [ASC|DESC]] [LIMIT n]
+//	INSERT INTO series [(key, ts, value)] VALUES (x, y, z)[, ...]
+//
+// A select reads one series: columns is * or a list of key, ts and value, and ts can be
+// compared with =, <, <=, > and >=. An insert without ts writes the current time. Values
+// are ? placeholders, numbers or 'quoted' strings. ts is an integer in the client's
+// timestamp resolution, Unix seconds by default; times, and strings holding RFC 3339
+// times, are accepted in its place. An insert is rejected as a whole if a row has a key
+// holding a comma, whitespace or |, a value that isn't finite, or a ts before the epoch.
+// Transactions are not supported.
+//
+// The DSN is the address of the server, optionally followed by parameters:
+//
+//	timeout=5s      dial, read and write timeout
+//	pool=8          most connections the client opens
+//	tls=true        connect over TLS, verifying the server with the system roots
+//	resolution=ms   unit of ts: s, ms, us or ns
+//	parse_time=true return ts as a time.Time rather than an integer
+//	sync=true       have the server acknowledge inserts before Exec returns
+//
+// It is a package of its own so that only programs importing it register the driver.
+package gtsdbsql
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+func init() {
+	sql.Register("gtsdb", &Driver{})
+}
+
+// Driver is the database/sql driver registered as "gtsdb"
+type Driver struct{}
+
+// Open connects to the server of dsn with a client of the connection's own; sql.DB
+// calls OpenConnector instead, so that its connections share one client
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	c, err := d.OpenConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	cn, err := c.Connect(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	cn.(*conn).owned = true
+	return cn, nil
+}
+
+// OpenConnector parses dsn into a connector whose connections share one client
+func (d *Driver) OpenConnector(dsn string) (driver.Connector, error) {
+	cfg, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &connector{driver: d, cfg: cfg}, nil
+}
+
+// config is a parsed DSN
+type config struct {
+	addr       string
+	opts       []gtsdb.Option
+	resolution gtsdb.TimestampResolution
+	parseTime  bool
+	sync       bool
+}
+
+var resolutions = map[string]gtsdb.TimestampResolution{
+	"s":  gtsdb.Seconds,
+	"ms": gtsdb.Milliseconds,
+	"us": gtsdb.Microseconds,
+	"ns": gtsdb.Nanoseconds,
+}
+
+func parseDSN(dsn string) (config, error) {
+	addr, query, _ := strings.Cut(strings.TrimPrefix(dsn, "gtsdb://"), "?")
+	cfg := config{addr: addr, resolution: gtsdb.Seconds}
+	if addr == "" {
+		return cfg, errors.New("gtsdbsql: the DSN lacks the server address")
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return cfg, fmt.Errorf("gtsdbsql: DSN parameters: %w", err)
+	}
+	for name, values := range params {
+		value := values[len(values)-1]
+		switch name {
+		case "timeout":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return cfg, fmt.Errorf("gtsdbsql: timeout: %w", err)
+			}
+			cfg.opts = append(cfg.opts, gtsdb.WithDialTimeout(d), gtsdb.WithReadTimeout(d), gtsdb.WithWriteTimeout(d))
+		case "pool":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return cfg, fmt.Errorf("gtsdbsql: pool must be a positive number, not %q", value)
+			}
+			cfg.opts = append(cfg.opts, gtsdb.WithPoolSize(0, n))
+		case "resolution":
+			var ok bool
+			if cfg.resolution, ok = resolutions[value]; !ok {
+				return cfg, fmt.Errorf("gtsdbsql: unknown resolution %q", value)
+			}
+		case "tls", "parse_time", "sync":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return cfg, fmt.Errorf("gtsdbsql: %s: %w", name, err)
+			}
+			switch name {
+			case "tls":
+				if b {
+					cfg.opts = append(cfg.opts, gtsdb.WithTLS(&tls.Config{}))
+				}
+			case "parse_time":
+				cfg.parseTime = b
+			case "sync":
+				cfg.sync = b
+			}
+		default:
+			return cfg, fmt.Errorf("gtsdbsql: unknown DSN parameter %q", name)
+		}
+	}
+	cfg.opts = append(cfg.opts, gtsdb.WithTimestampResolution(cfg.resolution))
+	return cfg, nil
+}
+
+// NewConnector returns a connector for sql.OpenDB that runs statements with client.
+// dsnParams holds DSN parameters such as "parse_time=true"; of those configuring the
+// client, its own settings stand. Closing the database leaves client open.
+func NewConnector(client *gtsdb.TSDBClient, dsnParams string) (driver.Connector, error) {
+	cfg, err := parseDSN(client.Config().Address + "?" + dsnParams)
+	if err != nil {
+		return nil, err
+	}
+	cfg.resolution = client.Config().TimestampResolution
+	return &connector{driver: &Driver{}, cfg: cfg, client: client, shared: true}, nil
+}
+
+// connector hands out connections sharing one client, which pools the connections to
+// the server itself, dialled on the first Connect
+type connector struct {
+	driver *Driver
+	cfg    config
+
+	mu     sync.Mutex
+	client *gtsdb.TSDBClient
+	// shared is set when the client was handed to NewConnector
+	shared bool
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == nil {
+		client, err := gtsdb.NewTSDBClientContext(ctx, c.cfg.addr, c.cfg.opts...)
+		if err != nil {
+			return nil, err
+		}
+		c.client = client
+	}
+	return &conn{client: c.client, cfg: &c.cfg}, nil
+}
+
+func (c *connector) Driver() driver.Driver { return c.driver }
+
+// Close closes the client when sql.DB is closed
+func (c *connector) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == nil || c.shared {
+		return nil
+	}
+	err := c.client.Close()
+	c.client = nil
+	return err
+}
+
+// conn is a connection of sql.DB, a handle on the shared client
+type conn struct {
+	client *gtsdb.TSDBClient
+	cfg    *config
+	// owned is set when the client is the connection's own, opened by Driver.Open
+	owned bool
+}
+
+var (
+	_ driver.ConnPrepareContext = (*conn)(nil)
+	_ driver.QueryerContext     = (*conn)(nil)
+	_ driver.ExecerContext      = (*conn)(nil)
+	_ driver.Pinger             = (*conn)(nil)
+	_ driver.NamedValueChecker  = (*conn)(nil)
+)
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(_ context.Context, query string) (driver.Stmt, error) {
+	parsed, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{conn: c, parsed: parsed}, nil
+}
+
+// Close leaves the shared client open
+func (c *conn) Close() error {
+	if c.owned {
+		return c.client.Close()
+	}
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return nil, errors.New("gtsdbsql: transactions are not supported")
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	parsed, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return c.query(ctx, parsed, args)
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	parsed, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return c.exec(ctx, parsed, args)
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	return c.client.PingContext(ctx)
+}
+
+// CheckNamedValue takes the default conversions, which cover every type an operand
+// can be, but refuses named arguments
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nv.Name != "" {
+		return errors.New("gtsdbsql: named arguments are not supported, use ?")
+	}
+	var err error
+	nv.Value, err = driver.DefaultParameterConverter.ConvertValue(nv.Value)
+	return err
+}
+
+// query runs a select
+func (c *conn) query(ctx context.Context, s *statement, args []driver.NamedValue) (driver.Rows, error) {
+	if s.insert {
+		return nil, errors.New("gtsdbsql: an INSERT returns no rows, use Exec")
+	}
+	if len(args) != s.numInput {
+		return nil, fmt.Errorf("gtsdbsql: expected %d arguments, got %d", s.numInput, len(args))
+	}
+	key, err := stringOf("key", s.key.value(args))
+	if err != nil {
+		return nil, err
+	}
+	res := c.cfg.resolution
+	start, end := int64(0), res.FromTime(time.Now())
+	if s.start != nil {
+		if start, err = c.timestamp(s.start.value(args)); err != nil {
+			return nil, err
+		}
+		if s.start.exclusive {
+			start++
+		}
+	}
+	if s.end != nil {
+		if end, err = c.timestamp(s.end.value(args)); err != nil {
+			return nil, err
+		}
+		if s.end.exclusive {
+			end--
+		}
+	}
+	r := &rows{columns: s.columns, cfg: c.cfg, limit: -1}
+	if s.limit != nil {
+		limit, ok := s.limit.value(args).(int64)
+		if !ok || limit < 0 {
+			return nil, fmt.Errorf("gtsdbsql: LIMIT must be a non-negative integer, not %v", s.limit.value(args))
+		}
+		r.limit = limit
+	}
+	if end < start || r.limit == 0 {
+		return r, nil
+	}
+
+	it, err := c.client.ReadStreamContext(ctx, key, start, end, 0)
+	if noRows(err) {
+		return r, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if !s.desc {
+		r.it = it
+		return r, nil
+	}
+	// The server returns points oldest first; the newest come first only once all
+	// are read
+	defer it.Close()
+	var points []gtsdb.DataPoint
+	for it.Next() {
+		points = append(points, it.Point())
+	}
+	if err := it.Err(); err != nil && !noRows(err) {
+		return nil, err
+	}
+	slices.Reverse(points)
+	r.buffered = points
+	return r, nil
+}
+
+// exec runs an insert
+func (c *conn) exec(ctx context.Context, s *statement, args []driver.NamedValue) (driver.Result, error) {
+	if !s.insert {
+		return nil, errors.New("gtsdbsql: a SELECT returns rows, use Query")
+	}
+	if len(args) != s.numInput {
+		return nil, fmt.Errorf("gtsdbsql: expected %d arguments, got %d", s.numInput, len(args))
+	}
+	points, err := c.points(s, args, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	write := c.client.WriteBatchContext
+	if c.cfg.sync {
+		write = c.client.WriteBatchSyncContext
+	}
+	if err := write(ctx, points); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(points)), nil
+}
+
+// points converts the rows of an insert into the points it writes, at now unless they
+// give ts
+func (c *conn) points(s *statement, args []driver.NamedValue, now time.Time) ([]gtsdb.DataPoint, error) {
+	points := make([]gtsdb.DataPoint, len(s.values))
+	for i, row := range s.values {
+		points[i].Timestamp = now
+		for j, column := range s.columns {
+			v := row[j].value(args)
+			var err error
+			switch column {
+			case columnKey:
+				if points[i].Key, err = stringOf("key", v); err == nil {
+					err = checkKey(points[i].Key)
+				}
+			case columnTs:
+				var ts int64
+				if ts, err = c.timestamp(v); err == nil {
+					points[i].Timestamp = c.cfg.resolution.ToTime(ts)
+				}
+			case columnValue:
+				points[i].Value, err = floatOf(v)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %w", i+1, err)
+			}
+		}
+	}
+	return points, nil
+}
+
+// checkKey rejects keys the GTSDB protocol can't carry, including those holding the |
+// that separates the records of read replies
+func checkKey(key string) error {
+	if key == "" || strings.ContainsAny(key, ", \t\r\n|") {
+		return fmt.Errorf("gtsdbsql: invalid key %q", key)
+	}
+	return nil
+}
+
+// noRows reports whether err means the series has no points in the range, which is an
+// empty result rather than an error
+func noRows(err error) bool {
+	return errors.Is(err, gtsdb.ErrNoData) || errors.Is(err, gtsdb.ErrKeyNotFound)
+}
+
+// timestamp converts an operand of ts into a timestamp of the client's resolution,
+// rejecting those before the epoch
+func (c *conn) timestamp(v driver.Value) (int64, error) {
+	switch v := v.(type) {
+	case int64:
+		if v >= 0 {
+			return v, nil
+		}
+	case float64:
+		// NaN fails both comparisons
+		if v >= 0 && v < math.MaxInt64 {
+			return int64(v), nil
+		}
+	case time.Time:
+		if ts := c.cfg.resolution.FromTime(v); ts >= 0 {
+			return ts, nil
+		}
+	case string:
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return c.timestamp(n)
+		}
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return c.timestamp(t)
+		}
+	case []byte:
+		return c.timestamp(string(v))
+	}
+	return 0, fmt.Errorf("gtsdbsql: invalid ts %v (%T)", v, v)
+}
+
+func stringOf(column string, v driver.Value) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	}
+	return "", fmt.Errorf("gtsdbsql: %s must be a string, not %T", column, v)
+}
+
+// floatOf converts an operand of value into a finite number
+func floatOf(v driver.Value) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			return v, nil
+		}
+	case int64:
+		return float64(v), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return floatOf(f)
+		}
+	case []byte:
+		return floatOf(string(v))
+	}
+	return 0, fmt.Errorf("gtsdbsql: invalid value %v (%T)", v, v)
+}
+
+// stmt is a prepared statement, parsed once
+type stmt struct {
+	conn   *conn
+	parsed *statement
+}
+
+var (
+	_ driver.StmtQueryContext = (*stmt)(nil)
+	_ driver.StmtExecContext  = (*stmt)(nil)
+)
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return s.parsed.numInput }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), named(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), named(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.exec(ctx, s.parsed, args)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.query(ctx, s.parsed, args)
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	nv := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		nv[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return nv
+}
+
+// rows yields the points of a select, streamed from it, or from buffered when they
+// had to be read first
+type rows struct {
+	columns  []string
+	cfg      *config
+	it       *gtsdb.PointIterator
+	buffered []gtsdb.DataPoint
+	// limit is the number of rows left to return, or -1 for all
+	limit int64
+}
+
+var _ driver.RowsColumnTypeDatabaseTypeName = (*rows)(nil)
+
+func (r *rows) Columns() []string { return r.columns }
+
+func (r *rows) Close() error {
+	if r.it != nil {
+		return r.it.Close()
+	}
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.limit == 0 {
+		return io.EOF
+	}
+	var p gtsdb.DataPoint
+	switch {
+	case r.it != nil:
+		if !r.it.Next() {
+			if err := r.it.Err(); err != nil && !noRows(err) {
+				return err
+			}
+			return io.EOF
+		}
+		p = r.it.Point()
+	case len(r.buffered) > 0:
+		p, r.buffered = r.buffered[0], r.buffered[1:]
+	default:
+		return io.EOF
+	}
+	if r.limit > 0 {
+		r.limit--
+	}
+	for i, column := range r.columns {
+		switch column {
+		case columnKey:
+			dest[i] = p.Key
+		case columnTs:
+			if r.cfg.parseTime {
+				dest[i] = p.Timestamp
+			} else {
+				dest[i] = r.cfg.resolution.FromTime(p.Timestamp)
+			}
+		case columnValue:
+			dest[i] = p.Value
+		}
+	}
+	return nil
+}
+
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
+	switch r.columns[index] {
+	case columnKey:
+		return "TEXT"
+	case columnTs:
+		if r.cfg.parseTime {
+			return "TIMESTAMP"
+		}
+		return "BIGINT"
+	}
+	return "DOUBLE"
+}
@@ -0,0 +1,453 @@
+package gtsdbsql
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// statement is a parsed query
+type statement struct {
+	insert bool
+	// numInput is the number of ? placeholders
+	numInput int
+
+	// columns are those selected, or those given values by an insert
+	columns []string
+
+	// key and the bounds of ts filter a select; start and end are nil when unbounded
+	key        *operand
+	start, end *bound
+	desc       bool
+	limit      *operand
+
+	// values are the rows of an insert, in the order of columns
+	values [][]operand
+}
+
+// operand is a literal or a placeholder in a statement
+type operand struct {
+	// arg is the index of the placeholder's argument, or -1 for a literal
+	arg     int
+	literal driver.Value
+}
+
+// value returns the literal, or the argument standing for the placeholder
+func (o operand) value(args []driver.NamedValue) driver.Value {
+	if o.arg < 0 {
+		return o.literal
+	}
+	return args[o.arg].Value
+}
+
+// bound is a bound of the ts range a select reads
+type bound struct {
+	operand
+	// exclusive is set for > and <, which leave the bound itself out
+	exclusive bool
+}
+
+// Columns of the series table
+const (
+	columnKey   = "key"
+	columnTs    = "ts"
+	columnValue = "value"
+)
+
+// columnName returns the column an identifier names, which ts can also be called
+// timestamp by
+func columnName(ident string) (string, bool) {
+	switch strings.ToLower(ident) {
+	case "key":
+		return columnKey, true
+	case "ts", "timestamp":
+		return columnTs, true
+	case "value":
+		return columnValue, true
+	}
+	return "", false
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenPlaceholder
+	tokenSymbol
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// parser reads a statement token by token
+type parser struct {
+	tokens []token
+	next   int
+	args   int
+}
+
+// parse parses query, which is a SELECT of the series table or an INSERT into it
+func parse(query string) (*statement, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	var stmt *statement
+	switch {
+	case p.keyword("SELECT"):
+		stmt, err = p.parseSelect()
+	case p.keyword("INSERT"):
+		stmt, err = p.parseInsert()
+	default:
+		return nil, p.errorf("expected SELECT or INSERT")
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.symbol(";")
+	if p.peek().kind != tokenEOF {
+		return nil, p.errorf("unexpected %q", p.peek().text)
+	}
+	stmt.numInput = p.args
+	return stmt, nil
+}
+
+// parseSelect parses what follows SELECT:
+//
+//	SELECT columns FROM series WHERE key = x [AND ts ...] [ORDER BY ts [ASC|DESC]] [LIMIT n]
+func (p *parser) parseSelect() (*statement, error) {
+	stmt := &statement{}
+	if p.symbol("*") {
+		stmt.columns = []string{columnKey, columnTs, columnValue}
+	} else {
+		for {
+			column, err := p.column()
+			if err != nil {
+				return nil, err
+			}
+			stmt.columns = append(stmt.columns, column)
+			if !p.symbol(",") {
+				break
+			}
+		}
+	}
+	if err := p.table("FROM"); err != nil {
+		return nil, err
+	}
+	if !p.keyword("WHERE") {
+		return nil, p.errorf("expected WHERE key = ...; a select reads one series")
+	}
+	for {
+		if err := p.condition(stmt); err != nil {
+			return nil, err
+		}
+		if !p.keyword("AND") {
+			break
+		}
+	}
+	if stmt.key == nil {
+		return nil, p.errorf("expected key = ... in the WHERE clause")
+	}
+	if p.keyword("ORDER") {
+		if !p.keyword("BY") {
+			return nil, p.errorf("expected BY")
+		}
+		if column, err := p.column(); err != nil {
+			return nil, err
+		} else if column != columnTs {
+			return nil, p.errorf("only ORDER BY ts is supported")
+		}
+		if p.keyword("DESC") {
+			stmt.desc = true
+		} else {
+			p.keyword("ASC")
+		}
+	}
+	if p.keyword("LIMIT") {
+		limit, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		stmt.limit = &limit
+	}
+	return stmt, nil
+}
+
+// condition parses a condition of the WHERE clause: key = x, ts BETWEEN a AND b, or
+// a comparison of ts
+func (p *parser) condition(stmt *statement) error {
+	column, err := p.column()
+	if err != nil {
+		return err
+	}
+	if column == columnKey {
+		if !p.symbol("=") {
+			return p.errorf("expected key = ...")
+		}
+		key, err := p.operand()
+		if err != nil {
+			return err
+		}
+		stmt.key = &key
+		return nil
+	}
+	if column != columnTs {
+		return p.errorf("only key and ts can be filtered on")
+	}
+	if p.keyword("BETWEEN") {
+		start, err := p.operand()
+		if err != nil {
+			return err
+		}
+		if !p.keyword("AND") {
+			return p.errorf("expected AND")
+		}
+		end, err := p.operand()
+		if err != nil {
+			return err
+		}
+		stmt.start, stmt.end = &bound{operand: start}, &bound{operand: end}
+		return nil
+	}
+	op := p.peek()
+	if op.kind != tokenSymbol {
+		return p.errorf("expected BETWEEN or a comparison after ts")
+	}
+	p.next++
+	value, err := p.operand()
+	if err != nil {
+		return err
+	}
+	switch op.text {
+	case "=":
+		stmt.start, stmt.end = &bound{operand: value}, &bound{operand: value}
+	case ">", ">=":
+		stmt.start = &bound{operand: value, exclusive: op.text == ">"}
+	case "<", "<=":
+		stmt.end = &bound{operand: value, exclusive: op.text == "<"}
+	default:
+		return fmt.Errorf("gtsdbsql: unsupported comparison %q at offset %d", op.text, op.pos)
+	}
+	return nil
+}
+
+// parseInsert parses what follows INSERT:
+//
+//	INSERT INTO series [(key, ts, value)] VALUES (x, y, z)[, ...]
+func (p *parser) parseInsert() (*statement, error) {
+	stmt := &statement{insert: true}
+	if err := p.table("INTO"); err != nil {
+		return nil, err
+	}
+	if p.symbol("(") {
+		seen := make(map[string]bool)
+		for {
+			column, err := p.column()
+			if err != nil {
+				return nil, err
+			}
+			if seen[column] {
+				return nil, p.errorf("column %s given twice", column)
+			}
+			seen[column] = true
+			stmt.columns = append(stmt.columns, column)
+			if !p.symbol(",") {
+				break
+			}
+		}
+		if !p.symbol(")") {
+			return nil, p.errorf("expected )")
+		}
+		if !seen[columnKey] || !seen[columnValue] {
+			return nil, p.errorf("an insert needs the key and value columns")
+		}
+	} else {
+		stmt.columns = []string{columnKey, columnTs, columnValue}
+	}
+	if !p.keyword("VALUES") {
+		return nil, p.errorf("expected VALUES")
+	}
+	for {
+		if !p.symbol("(") {
+			return nil, p.errorf("expected (")
+		}
+		var row []operand
+		for {
+			value, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			row = append(row, value)
+			if !p.symbol(",") {
+				break
+			}
+		}
+		if !p.symbol(")") {
+			return nil, p.errorf("expected )")
+		}
+		if len(row) != len(stmt.columns) {
+			return nil, p.errorf("%d values for %d columns", len(row), len(stmt.columns))
+		}
+		stmt.values = append(stmt.values, row)
+		if !p.symbol(",") {
+			break
+		}
+	}
+	return stmt, nil
+}
+
+// table parses the keyword introducing the table, then its name, which is series
+func (p *parser) table(keyword string) error {
+	if !p.keyword(keyword) {
+		return p.errorf("expected %s", keyword)
+	}
+	t := p.peek()
+	if t.kind != tokenIdent || !strings.EqualFold(t.text, "series") {
+		return p.errorf("the only table is series")
+	}
+	p.next++
+	return nil
+}
+
+func (p *parser) column() (string, error) {
+	t := p.peek()
+	if t.kind == tokenIdent {
+		if column, ok := columnName(t.text); ok {
+			p.next++
+			return column, nil
+		}
+	}
+	return "", p.errorf("expected a column: key, ts or value")
+}
+
+// operand parses a placeholder, a number or a string
+func (p *parser) operand() (operand, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenPlaceholder:
+		p.next++
+		p.args++
+		return operand{arg: p.args - 1}, nil
+	case tokenString:
+		p.next++
+		return operand{arg: -1, literal: t.text}, nil
+	case tokenNumber:
+		p.next++
+		if n, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return operand{arg: -1, literal: n}, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return operand{}, fmt.Errorf("gtsdbsql: invalid number %q at offset %d", t.text, t.pos)
+		}
+		return operand{arg: -1, literal: f}, nil
+	}
+	return operand{}, p.errorf("expected ?, a number or a string")
+}
+
+// keyword consumes the next token if it is the keyword kw
+func (p *parser) keyword(kw string) bool {
+	if t := p.peek(); t.kind == tokenIdent && strings.EqualFold(t.text, kw) {
+		p.next++
+		return true
+	}
+	return false
+}
+
+// symbol consumes the next token if it is s
+func (p *parser) symbol(s string) bool {
+	if t := p.peek(); t.kind == tokenSymbol && t.text == s {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	t := p.peek()
+	where := fmt.Sprintf("at %q", t.text)
+	if t.kind == tokenEOF {
+		where = "at the end"
+	}
+	return fmt.Errorf("gtsdbsql: %s %s", fmt.Sprintf(format, args...), where)
+}
+
+// tokenize splits query into tokens, ending with a tokenEOF
+func tokenize(query string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(query); {
+		c := rune(query[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '?':
+			tokens = append(tokens, token{kind: tokenPlaceholder, text: "?", pos: i})
+			i++
+		case c == '\'':
+			// A quote is doubled within a string
+			var b strings.Builder
+			j := i + 1
+			for {
+				if j >= len(query) {
+					return nil, fmt.Errorf("gtsdbsql: unterminated string at offset %d", i)
+				}
+				if query[j] == '\'' {
+					if j+1 < len(query) && query[j+1] == '\'' {
+						b.WriteByte('\'')
+						j += 2
+						continue
+					}
+					break
+				}
+				b.WriteByte(query[j])
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenString, text: b.String(), pos: i})
+			i = j + 1
+		case c == '"' || c == '`':
+			end := strings.IndexByte(query[i+1:], query[i])
+			if end < 0 {
+				return nil, fmt.Errorf("gtsdbsql: unterminated identifier at offset %d", i)
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: query[i+1 : i+1+end], pos: i})
+			i += end + 2
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(query) && (query[j] == '_' || unicode.IsLetter(rune(query[j])) || unicode.IsDigit(rune(query[j]))) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: query[i:j], pos: i})
+			i = j
+		case unicode.IsDigit(c) || (c == '-' || c == '.') && i+1 < len(query) && (unicode.IsDigit(rune(query[i+1])) || query[i+1] == '.'):
+			j := i + 1
+			for j < len(query) && (unicode.IsDigit(rune(query[j])) || strings.IndexByte(".eE", query[j]) >= 0 ||
+				(query[j] == '-' || query[j] == '+') && (query[j-1] == 'e' || query[j-1] == 'E')) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: query[i:j], pos: i})
+			i = j
+		case strings.HasPrefix(query[i:], "<=") || strings.HasPrefix(query[i:], ">="):
+			tokens = append(tokens, token{kind: tokenSymbol, text: query[i : i+2], pos: i})
+			i += 2
+		case strings.ContainsRune("*,()=<>;", c):
+			tokens = append(tokens, token{kind: tokenSymbol, text: string(c), pos: i})
+			i++
+		default:
+			return nil, fmt.Errorf("gtsdbsql: unexpected %q at offset %d", c, i)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(query)}), nil
+}
@@ -0,0 +1,176 @@
+package gtsdbsql
+
+import (
+	"database/sql/driver"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/abbychau/gtsdb-drivers/gtsdb"
+)
+
+func TestParse(t *testing.T) {
+	literal := func(v driver.Value) *operand { return &operand{arg: -1, literal: v} }
+	tests := []struct {
+		name  string
+		query string
+		want  *statement // nil if the query is rejected
+	}{
+		{"select all", "SELECT * FROM series WHERE key = 'temp'", &statement{
+			columns: []string{columnKey, columnTs, columnValue},
+			key:     literal("temp"),
+		}},
+		{"select range", "select timestamp, value from SERIES where key = ? and ts between ? and ?;", &statement{
+			numInput: 3,
+			columns:  []string{columnTs, columnValue},
+			key:      &operand{arg: 0},
+			start:    &bound{operand: operand{arg: 1}},
+			end:      &bound{operand: operand{arg: 2}},
+		}},
+		{"select comparisons", "SELECT value FROM series WHERE ts > 10 AND key = 'it''s' AND ts <= 20.5 ORDER BY ts DESC LIMIT 5", &statement{
+			columns: []string{columnValue},
+			key:     literal("it's"),
+			start:   &bound{operand: *literal(int64(10)), exclusive: true},
+			end:     &bound{operand: *literal(20.5)},
+			desc:    true,
+			limit:   literal(int64(5)),
+		}},
+		{"select one timestamp", `SELECT "value" FROM series WHERE key = 'temp' AND ts = -1`, &statement{
+			columns: []string{columnValue},
+			key:     literal("temp"),
+			start:   &bound{operand: *literal(int64(-1))},
+			end:     &bound{operand: *literal(int64(-1))},
+		}},
+		{"insert", "INSERT INTO series VALUES ('temp', 1700000000, 21.5), (?, ?, ?)", &statement{
+			insert:   true,
+			numInput: 3,
+			columns:  []string{columnKey, columnTs, columnValue},
+			values: [][]operand{
+				{*literal("temp"), *literal(int64(1700000000)), *literal(21.5)},
+				{{arg: 0}, {arg: 1}, {arg: 2}},
+			},
+		}},
+		{"insert without ts", "INSERT INTO series (value, key) VALUES (1e3, 'temp')", &statement{
+			insert:  true,
+			columns: []string{columnValue, columnKey},
+			values:  [][]operand{{*literal(1e3), *literal("temp")}},
+		}},
+
+		{"empty", "", nil},
+		{"update", "UPDATE series SET value = 1", nil},
+		{"select without WHERE", "SELECT * FROM series", nil},
+		{"select without key", "SELECT * FROM series WHERE ts > 5", nil},
+		{"select of another table", "SELECT * FROM points WHERE key = 'temp'", nil},
+		{"select of an unknown column", "SELECT host FROM series WHERE key = 'temp'", nil},
+		{"filter on value", "SELECT * FROM series WHERE key = 'temp' AND value > 1", nil},
+		{"missing end of range", "SELECT * FROM series WHERE key = 'temp' AND ts BETWEEN 1", nil},
+		{"missing operand", "SELECT * FROM series WHERE key =", nil},
+		{"unsupported comparison", "SELECT * FROM series WHERE key = 'temp' AND ts ( 5", nil},
+		{"order by value", "SELECT * FROM series WHERE key = 'temp' ORDER BY value", nil},
+		{"unterminated string", "SELECT * FROM series WHERE key = 'temp", nil},
+		{"unterminated identifier", `SELECT "value FROM series WHERE key = 'temp'`, nil},
+		{"unexpected character", "SELECT * FROM series WHERE key = 'temp' AND ts >= @start", nil},
+		{"trailing tokens", "SELECT * FROM series WHERE key = 'temp' LIMIT 1 2", nil},
+		{"invalid number", "INSERT INTO series VALUES ('temp', 1.2.3, 1)", nil},
+		{"insert without VALUES", "INSERT INTO series ('temp', 1, 2)", nil},
+		{"insert without value column", "INSERT INTO series (key, ts) VALUES ('temp', 1)", nil},
+		{"insert with a column twice", "INSERT INTO series (key, value, key) VALUES ('temp', 1, 'hum')", nil},
+		{"insert missing a field", "INSERT INTO series VALUES ('temp', 1700000000)", nil},
+		{"insert with an extra field", "INSERT INTO series (key, value) VALUES ('temp', 1, 2)", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, err := parse(tt.query)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("parsed %q as %+v, want an error", tt.query, stmt)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(stmt, tt.want) {
+				t.Errorf("parsed %q as %+v, want %+v", tt.query, stmt, tt.want)
+			}
+		})
+	}
+}
+
+func TestInsertPoints(t *testing.T) {
+	now := time.Unix(1700000100, 0)
+	at := time.Unix(1700000000, 0)
+	tests := []struct {
+		name  string
+		query string
+		args  []driver.Value
+		want  []gtsdb.DataPoint // nil if the insert is rejected
+	}{
+		{"literals", "INSERT INTO series VALUES ('temp', 1700000000, 21.5), ('hum', 1700000001, 40)",
+			nil, []gtsdb.DataPoint{{Key: "temp", Timestamp: at, Value: 21.5}, {Key: "hum", Timestamp: at.Add(time.Second), Value: 40}}},
+		{"arguments", "INSERT INTO series VALUES (?, ?, ?)",
+			[]driver.Value{[]byte("temp"), at, true}, []gtsdb.DataPoint{{Key: "temp", Timestamp: at, Value: 1}}},
+		{"strings", "INSERT INTO series VALUES ('temp', '2023-11-14T22:13:20Z', '21.5'), ('hum', '1700000001', '-4e1')",
+			nil, []gtsdb.DataPoint{{Key: "temp", Timestamp: at, Value: 21.5}, {Key: "hum", Timestamp: at.Add(time.Second), Value: -40}}},
+		{"missing ts", "INSERT INTO series (key, value) VALUES ('temp', 21.5)",
+			nil, []gtsdb.DataPoint{{Key: "temp", Timestamp: now, Value: 21.5}}},
+
+		{"bad ts", "INSERT INTO series VALUES ('temp', 'yesterday', 21.5)", nil, nil},
+		{"negative ts", "INSERT INTO series VALUES ('temp', -1, 21.5)", nil, nil},
+		{"time before the epoch", "INSERT INTO series VALUES ('temp', '1969-12-31T23:59:59Z', 21.5)", nil, nil},
+		{"NaN ts", "INSERT INTO series VALUES ('temp', ?, 21.5)", []driver.Value{math.NaN()}, nil},
+		{"ts of the wrong type", "INSERT INTO series VALUES ('temp', ?, 21.5)", []driver.Value{true}, nil},
+		{"bad value", "INSERT INTO series VALUES ('temp', 1700000000, 'warm')", nil, nil},
+		{"NaN", "INSERT INTO series VALUES ('temp', 1700000000, 'NaN')", nil, nil},
+		{"Inf", "INSERT INTO series VALUES ('temp', 1700000000, ?)", []driver.Value{math.Inf(1)}, nil},
+		{"empty key", "INSERT INTO series VALUES ('', 1700000000, 21.5)", nil, nil},
+		{"key with a space", "INSERT INTO series VALUES ('room temp', 1700000000, 21.5)", nil, nil},
+		{"key with a comma", "INSERT INTO series VALUES ('temp,2', 1700000000, 21.5)", nil, nil},
+		{"key with the record delimiter", "INSERT INTO series VALUES ('temp|2', 1700000000, 21.5)", nil, nil},
+		{"numeric key", "INSERT INTO series VALUES (5, 1700000000, 21.5)", nil, nil},
+		{"one bad row of two", "INSERT INTO series VALUES ('temp', 1700000000, 21.5), ('hum', 1700000001, 'NaN')", nil, nil},
+	}
+	c := &conn{cfg: &config{resolution: gtsdb.Seconds}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, err := parse(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			points, err := c.points(stmt, named(tt.args), now)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("inserted %+v, want an error", points)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(points) != len(tt.want) {
+				t.Fatalf("got %+v, want %+v", points, tt.want)
+			}
+			for i := range points {
+				if points[i].Key != tt.want[i].Key || !points[i].Timestamp.Equal(tt.want[i].Timestamp) || points[i].Value != tt.want[i].Value {
+					t.Errorf("point %d = %+v, want %+v", i, points[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestInsertResolution(t *testing.T) {
+	c := &conn{cfg: &config{resolution: gtsdb.Milliseconds}}
+	stmt, err := parse("INSERT INTO series VALUES ('temp', 1700000000500, 21.5)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	points, err := c.points(stmt, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.UnixMilli(1700000000500); len(points) != 1 || !points[0].Timestamp.Equal(want) {
+		t.Errorf("got %+v, want a point at %v", points, want)
+	}
+}
@@ -0,0 +1,155 @@
+package gtsdb
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// FillMode selects how GetMeasurementHistory fills intervals without a measurement
+type FillMode int
+
+const (
+	// FillNone returns only the intervals the server has measurements for
+	FillNone FillMode = iota
+	// FillNull returns every interval, with NaN as the value of empty ones
+	FillNull
+	// FillPrevious repeats the last measurement in empty intervals
+	FillPrevious
+	// FillLinear interpolates linearly between the measurements around empty intervals
+	FillLinear
+)
+
+// maxHistoryIntervals bounds the intervals of a filled history, which holds one
+// measurement per interval whether or not the server has data for it
+const maxHistoryIntervals = 1_000_000
+
+// HistoryOption configures a GetMeasurementHistory query
+type HistoryOption func(*historyOptions)
+
+type historyOptions struct {
+	align bool
+	fill  FillMode
+}
+
+// WithAlignment aligns the history's intervals to multiples of the interval since the
+// Unix epoch, so that for example hourly buckets start on the hour. Measurements are
+// stamped with the start of their interval.
+func WithAlignment() HistoryOption {
+	return func(o *historyOptions) {
+		o.align = true
+	}
+}
+
+// WithFill returns one measurement per interval of the requested range, filling the
+// empty ones as mode specifies. Intervals before the first or after the last
+// measurement that can't be filled hold NaN.
+func WithFill(mode FillMode) HistoryOption {
+	return func(o *historyOptions) {
+		o.fill = mode
+	}
+}
+
+func newHistoryOptions(opts []HistoryOption) (historyOptions, error) {
+	var o historyOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.fill < FillNone || o.fill > FillLinear {
+		return o, fmt.Errorf("unsupported fill mode %d", o.fill)
+	}
+	return o, nil
+}
+
+// ArrangeHistory applies the alignment and fill options of a history query to the
+// measurements read for it, the way GetMeasurementHistory does. It lets other Client
+// implementations honour the options.
+func ArrangeHistory(history []Measurement, startTime, endTime time.Time, interval time.Duration, opts ...HistoryOption) ([]Measurement, error) {
+	if endTime.Before(startTime) {
+		return nil, fmt.Errorf("%w: start %s is after end %s", ErrInvalidTimeRange, startTime, endTime)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidInterval, interval)
+	}
+	options, err := newHistoryOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if options.align {
+		startTime = alignTime(startTime, interval)
+	}
+	return options.arrange(history, startTime, endTime, interval)
+}
+
+// arrange aligns and fills the measurements of a history starting at startTime
+func (o historyOptions) arrange(history []Measurement, startTime, endTime time.Time, interval time.Duration) ([]Measurement, error) {
+	if o.align {
+		for i := range history {
+			history[i].Timestamp = alignTime(history[i].Timestamp, interval)
+		}
+	}
+	if o.fill != FillNone {
+		return fillHistory(history, startTime, endTime, interval, o.fill)
+	}
+	return history, nil
+}
+
+// alignTime returns the start of the interval t falls in, counted from the Unix epoch
+func alignTime(t time.Time, interval time.Duration) time.Time {
+	offset := time.Duration(t.UnixNano() % int64(interval))
+	if offset < 0 {
+		offset += interval
+	}
+	return t.Add(-offset)
+}
+
+// fillHistory places measurements on a regular grid of intervals from start to end and
+// fills the empty ones. When several measurements fall in one interval the last is kept.
+func fillHistory(history []Measurement, start, end time.Time, interval time.Duration, mode FillMode) ([]Measurement, error) {
+	if end.Before(start) {
+		return nil, fmt.Errorf("%w: start %s is after end %s", ErrInvalidTimeRange, start, end)
+	}
+	// Durations saturate, so a range too long for one still yields a count over the limit
+	count := end.Sub(start) / interval
+	if count >= maxHistoryIntervals {
+		return nil, fmt.Errorf("%w: filling %s in intervals of %s exceeds %d intervals", ErrRangeTooLarge, end.Sub(start), interval, maxHistoryIntervals)
+	}
+	slots := int(count) + 1
+	grid := make([]Measurement, slots)
+	known := make([]bool, slots)
+	for i := range grid {
+		grid[i] = Measurement{Timestamp: start.Add(time.Duration(i) * interval), Value: math.NaN()}
+	}
+	for _, m := range history {
+		if m.Timestamp.Before(start) || m.Timestamp.After(end) {
+			continue
+		}
+		i := int(m.Timestamp.Sub(start) / interval)
+		grid[i].Value = m.Value
+		known[i] = true
+	}
+
+	switch mode {
+	case FillPrevious:
+		for i := 1; i < slots; i++ {
+			if !known[i] {
+				grid[i].Value = grid[i-1].Value
+			}
+		}
+	case FillLinear:
+		prev := -1
+		for i := 0; i < slots; i++ {
+			if !known[i] {
+				continue
+			}
+			if prev >= 0 {
+				step := (grid[i].Value - grid[prev].Value) / float64(i-prev)
+				for j := prev + 1; j < i; j++ {
+					grid[j].Value = grid[prev].Value + step*float64(j-prev)
+				}
+			}
+			prev = i
+		}
+	}
+	return grid, nil
+}
@@ -0,0 +1,121 @@
+package gtsdb
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestFillHistory(t *testing.T) {
+	start := time.Unix(1000, 0)
+	history := []Measurement{
+		{Timestamp: start, Value: 1},
+		{Timestamp: start.Add(3 * time.Second), Value: 4},
+	}
+
+	tests := []struct {
+		name string
+		mode FillMode
+		want []float64
+	}{
+		{"null", FillNull, []float64{1, math.NaN(), math.NaN(), 4, math.NaN()}},
+		{"previous", FillPrevious, []float64{1, 1, 1, 4, 4}},
+		{"linear", FillLinear, []float64{1, 2, 3, 4, math.NaN()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fillHistory(history, start, start.Add(4*time.Second), time.Second, tt.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d intervals, want %d", len(got), len(tt.want))
+			}
+			for i, m := range got {
+				if want := start.Add(time.Duration(i) * time.Second); !m.Timestamp.Equal(want) {
+					t.Errorf("interval %d at %s, want %s", i, m.Timestamp, want)
+				}
+				if m.Value != tt.want[i] && !(math.IsNaN(m.Value) && math.IsNaN(tt.want[i])) {
+					t.Errorf("interval %d = %g, want %g", i, m.Value, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFillHistoryRejectsBadRanges(t *testing.T) {
+	start := time.Unix(1000, 0)
+
+	tests := []struct {
+		name     string
+		end      time.Time
+		interval time.Duration
+		want     error
+	}{
+		{"inverted", start.Add(-time.Second), time.Second, ErrInvalidTimeRange},
+		{"too many intervals", start.Add(365 * 24 * time.Hour), time.Second, ErrRangeTooLarge},
+		{"beyond a duration", time.Unix(1<<40, 0), time.Nanosecond, ErrRangeTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := fillHistory(nil, start, tt.end, tt.interval, FillNull)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestArrangeHistory(t *testing.T) {
+	start := time.Unix(1000, 0)
+	history := []Measurement{
+		{Timestamp: start.Add(1500 * time.Millisecond), Value: 2},
+	}
+
+	got, err := ArrangeHistory(history, start, start.Add(2*time.Second), time.Second, WithAlignment(), WithFill(FillPrevious))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Measurement{
+		{Timestamp: start, Value: math.NaN()},
+		{Timestamp: start.Add(time.Second), Value: 2},
+		{Timestamp: start.Add(2 * time.Second), Value: 2},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d intervals, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !got[i].Timestamp.Equal(want[i].Timestamp) {
+			t.Errorf("interval %d at %s, want %s", i, got[i].Timestamp, want[i].Timestamp)
+		}
+		if got[i].Value != want[i].Value && !(math.IsNaN(got[i].Value) && math.IsNaN(want[i].Value)) {
+			t.Errorf("interval %d = %g, want %g", i, got[i].Value, want[i].Value)
+		}
+	}
+}
+
+func TestArrangeHistoryRejectsBadArguments(t *testing.T) {
+	start := time.Unix(1000, 0)
+
+	tests := []struct {
+		name     string
+		end      time.Time
+		interval time.Duration
+		opts     []HistoryOption
+		want     error
+	}{
+		{"inverted range", start.Add(-time.Minute), time.Second, nil, ErrInvalidTimeRange},
+		{"inverted range with fill", start.Add(-time.Minute), time.Second, []HistoryOption{WithFill(FillNull)}, ErrInvalidTimeRange},
+		{"zero interval", start.Add(time.Minute), 0, nil, ErrInvalidInterval},
+		{"negative interval", start.Add(time.Minute), -time.Second, nil, ErrInvalidInterval},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ArrangeHistory(nil, start, tt.end, tt.interval, tt.opts...)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
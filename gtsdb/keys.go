@@ -0,0 +1,167 @@
+package gtsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ListKeys lists the keys known to the server, optionally restricted to those
+// starting with prefix. Servers that don't support key listing reply with a *ServerError.
+func (c *TSDBClient) ListKeys(prefix string) ([]string, error) {
+	return c.ListKeysContext(context.Background(), prefix)
+}
+
+// ListKeysContext is like ListKeys but honours ctx for cancellation and deadlines
+func (c *TSDBClient) ListKeysContext(ctx context.Context, prefix string) (keys []string, err error) {
+	done := c.observe(ctx, Command{Op: "list keys"})
+	defer func() { done(err) }()
+
+	var records []string
+	if prefix == "" {
+		records, err = c.query(ctx, "list keys", c.cfg.ReadTimeout, "keys\n")
+	} else {
+		records, err = c.query(ctx, "list keys", c.cfg.ReadTimeout, "keys,%s\n", prefix)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys = []string{}
+	for _, record := range records {
+		for _, key := range strings.Split(record, ",") {
+			// Filter locally too in case the server ignores the prefix
+			if key = strings.TrimSpace(key); key != "" && strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// ListKeysMatching lists the keys matching a shell pattern as understood by path.Match,
+// e.g. "sensor-*" or "room?/temp". Only the literal prefix of the pattern is sent to the
+// server; the pattern itself is matched locally.
+func (c *TSDBClient) ListKeysMatching(pattern string) ([]string, error) {
+	return c.ListKeysMatchingContext(context.Background(), pattern)
+}
+
+// ListKeysMatchingContext is like ListKeysMatching but honours ctx for cancellation and deadlines
+func (c *TSDBClient) ListKeysMatchingContext(ctx context.Context, pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("list keys: invalid pattern %q: %w", pattern, err)
+	}
+
+	prefix := pattern
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		prefix = pattern[:i]
+	}
+	candidates, err := c.ListKeysContext(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for _, key := range candidates {
+		if ok, _ := path.Match(pattern, key); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// KeyInfo describes the data stored under a key
+type KeyInfo struct {
+	Key   string
+	First time.Time
+	Last  time.Time
+	// Count is the number of points, or -1 if the server doesn't report it
+	Count int64
+}
+
+// KeyInfo returns the first and last timestamps and the point count of a key, using
+// the server's info command. Servers without it reply with an error without a code;
+// the info is then derived from a read of the key's whole history, which can be costly
+// and isn't bound by WithMaxTimeRange. A key without data yields ErrKeyNotFound.
+func (c *TSDBClient) KeyInfo(key string) (KeyInfo, error) {
+	return c.KeyInfoContext(context.Background(), key)
+}
+
+// KeyInfoContext is like KeyInfo but honours ctx for cancellation and deadlines
+func (c *TSDBClient) KeyInfoContext(ctx context.Context, key string) (info KeyInfo, err error) {
+	done := c.observe(ctx, Command{Op: "key info", Key: key})
+	defer func() { done(err) }()
+
+	response, err := c.roundTrip(ctx, "key info", c.cfg.ReadTimeout, "info,%s\n", key)
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) && serverErr.Code == "" {
+		return c.scanKeyInfo(ctx, key)
+	}
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	return c.parseKeyInfo(key, response)
+}
+
+// parseKeyInfo parses an info reply of the form "key,first,last[,count]"
+func (c *TSDBClient) parseKeyInfo(key, response string) (KeyInfo, error) {
+	parts := strings.Split(strings.TrimSpace(response), ",")
+	if len(parts) != 3 && len(parts) != 4 {
+		return KeyInfo{}, fmt.Errorf("key info: invalid reply %q", strings.TrimSpace(response))
+	}
+
+	var timestamps [2]int64
+	for i, field := range parts[1:3] {
+		ts, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return KeyInfo{}, fmt.Errorf("key info: invalid timestamp %q: %w", field, err)
+		}
+		timestamps[i] = ts
+	}
+
+	info := KeyInfo{
+		Key:   key,
+		First: c.cfg.TimestampResolution.ToTime(timestamps[0]),
+		Last:  c.cfg.TimestampResolution.ToTime(timestamps[1]),
+		Count: -1,
+	}
+	if len(parts) == 4 {
+		count, err := strconv.ParseInt(parts[3], 10, 64)
+		if err != nil {
+			return KeyInfo{}, fmt.Errorf("key info: invalid count %q: %w", parts[3], err)
+		}
+		info.Count = count
+	}
+	return info, nil
+}
+
+// scanKeyInfo derives the info of a key by reading all of its points. The read spans
+// all time, so it skips the query cache and the maximum time range, which would reject it.
+func (c *TSDBClient) scanKeyInfo(ctx context.Context, key string) (KeyInfo, error) {
+	records, err := c.queryRange(ctx, key, 0, c.timestamp(time.Now()), 0, AggregateDefault)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	points, err := c.parsePoints(records)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	if len(points) == 0 {
+		return KeyInfo{}, fmt.Errorf("key info: %w: %s", ErrKeyNotFound, key)
+	}
+
+	info := KeyInfo{Key: key, First: points[0].Timestamp, Last: points[0].Timestamp, Count: int64(len(points))}
+	for _, point := range points[1:] {
+		if point.Timestamp.Before(info.First) {
+			info.First = point.Timestamp
+		}
+		if point.Timestamp.After(info.Last) {
+			info.Last = point.Timestamp
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,39 @@
+package gtsdb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestKeyInfo(t *testing.T) {
+	tests := []struct {
+		name   string
+		noInfo bool
+	}{
+		{"info command", false},
+		{"scan without info command", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, func(s *fakeServer) { s.noInfo = tt.noInfo })
+			// The scan reads all time, far more than the limit
+			client := server.client(t, WithMaxTimeRange(time.Hour))
+			server.write("temp", 1700000000, 1)
+			server.write("temp", 1700086400, 2)
+
+			info, err := client.KeyInfo("temp")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := KeyInfo{Key: "temp", First: time.Unix(1700000000, 0), Last: time.Unix(1700086400, 0), Count: 2}
+			if info.Key != want.Key || !info.First.Equal(want.First) || !info.Last.Equal(want.Last) || info.Count != want.Count {
+				t.Fatalf("got %+v, want %+v", info, want)
+			}
+
+			if _, err := client.KeyInfo("missing"); !errors.Is(err, ErrKeyNotFound) {
+				t.Fatalf("got %v for a key without data, want ErrKeyNotFound", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package gtsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// initialLatestWindow is the first window searched for the last value of a key; each
+// following window reaches twice as far back
+const initialLatestWindow = time.Hour
+
+// lastProbeTimeout bounds the wait for a reply to the last command until the server has
+// answered one, since servers predating the command may not reply to it at all
+const lastProbeTimeout = 2 * time.Second
+
+// latestPoint finds the most recent point of a key, in the query cache when it is
+// enabled, then trying the server's last command before searching backwards. Until the
+// server has answered a last command, the command gets at most lastProbeTimeout and a
+// timeout is taken as the server not knowing it.
+func (c *TSDBClient) latestPoint(ctx context.Context, key string) (point DataPoint, err error) {
+	done := c.observe(ctx, Command{Op: "read latest", Key: key})
+	defer func() { done(err) }()
+
+	if c.cache != nil {
+		if point, ok := c.cache.getLatest(key); ok {
+			return point, nil
+		}
+		defer func() {
+			if err == nil {
+				c.cache.putLatest(point)
+			}
+		}()
+	}
+
+	if !c.noLastCommand.Load() {
+		probing := !c.hasLastCommand.Load()
+		timeout := c.cfg.ReadTimeout
+		if probing && (timeout <= 0 || timeout > lastProbeTimeout) {
+			timeout = lastProbeTimeout
+		}
+
+		var response string
+		err := c.exchange(ctx, "read latest", idempotent, timeout, func(pc *poolConn) error {
+			var err error
+			response, err = pc.readLine()
+			if probing && isTimeout(err) {
+				// The reply may never come, close the connection rather than wait for it
+				pc.broken = true
+			}
+			return err
+		}, "last,%s\n", key)
+		var serverErr *ServerError
+		switch {
+		case errors.Is(err, ErrKeyNotFound):
+			c.hasLastCommand.Store(true)
+			return DataPoint{}, fmt.Errorf("%w for sensor %s", ErrNoData, key)
+		case errors.As(err, &serverErr) && serverErr.Code == "":
+			c.noLastCommand.Store(true)
+		case probing && isTimeout(err) && ctx.Err() == nil:
+			c.noLastCommand.Store(true)
+		case err != nil:
+			return DataPoint{}, err
+		default:
+			c.hasLastCommand.Store(true)
+			return c.parseMeasurement(response)
+		}
+	}
+	return c.searchLatest(ctx, key)
+}
+
+// searchLatest reads ever older, ever larger windows until one holds a point or
+// the maximum lookback is reached
+func (c *TSDBClient) searchLatest(ctx context.Context, key string) (DataPoint, error) {
+	now := time.Now()
+	oldest := now.Add(-c.cfg.LatestLookback)
+	end := now
+	window := initialLatestWindow
+
+	for end.After(oldest) {
+		if limit := c.cfg.MaxTimeRange; limit > 0 && window > limit {
+			window = limit
+		}
+		start := end.Add(-window)
+		if start.Before(oldest) {
+			start = oldest
+		}
+
+		points, err := c.ReadPointsContext(ctx, key, c.timestamp(start), c.timestamp(end), 0)
+		if err != nil {
+			return DataPoint{}, err
+		}
+		if len(points) > 0 {
+			return latest(points), nil
+		}
+
+		// Windows are inclusive, step past the start so it isn't read twice
+		end = start.Add(-c.cfg.TimestampResolution.Unit())
+		window *= 2
+	}
+	return DataPoint{}, fmt.Errorf("%w for sensor %s within %s", ErrNoData, key, c.cfg.LatestLookback)
+}
+
+// latest returns the point with the highest timestamp
+func latest(points []DataPoint) DataPoint {
+	newest := points[0]
+	for _, point := range points[1:] {
+		if point.Timestamp.After(newest.Timestamp) {
+			newest = point
+		}
+	}
+	return newest
+}
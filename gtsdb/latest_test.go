@@ -0,0 +1,60 @@
+package gtsdb
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetLatestMeasurement(t *testing.T) {
+	tests := []struct {
+		name string
+		// fallback is whether the client must search instead of using last
+		fallback bool
+		server   func(*fakeServer)
+	}{
+		{"last command", false, func(*fakeServer) {}},
+		{"last rejected", true, func(s *fakeServer) {
+			s.handle = func(conn net.Conn, line string) bool {
+				if strings.HasPrefix(line, "last,") {
+					conn.Write([]byte("ERR unknown command last\n"))
+					return true
+				}
+				return false
+			}
+		}},
+		{"last unanswered", true, func(s *fakeServer) {
+			s.handle = func(conn net.Conn, line string) bool {
+				return strings.HasPrefix(line, "last,")
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, tt.server)
+			client := server.client(t)
+			reported := time.Now().Add(-50 * time.Hour).Unix()
+			server.write("temp", reported, 21.5)
+
+			for i := 0; i < 2; i++ {
+				value, ts, err := client.GetLatestMeasurement("temp")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if value != 21.5 || ts.Unix() != reported {
+					t.Errorf("latest %g at %d, want 21.5 at %d", value, ts.Unix(), reported)
+				}
+			}
+			// Once the server failed to answer it, the command isn't sent again
+			if tt.fallback && server.count("last,") != 1 {
+				t.Errorf("last sent %d times, want once", server.count("last,"))
+			}
+
+			if _, _, err := client.GetLatestMeasurement("missing"); !errors.Is(err, ErrNoData) {
+				t.Errorf("latest of a missing key returned %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package gtsdb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// ReadMultiple reads the same time range for many keys. Keys are fed to a pool of at
+// most WithMaxConcurrency workers, each issuing one request per key, so even very
+// large key lists never fan out into unbounded goroutines or oversized requests.
+// The first error encountered is returned once all workers have stopped.
+func (c *TSDBClient) ReadMultiple(keys []string, startTime, endTime int64, downsampling int) (map[string][]string, error) {
+	return c.ReadMultipleContext(context.Background(), keys, startTime, endTime, downsampling)
+}
+
+// ReadMultipleContext is like ReadMultiple but honours ctx for cancellation and deadlines
+func (c *TSDBClient) ReadMultipleContext(ctx context.Context, keys []string, startTime, endTime int64, downsampling int) (map[string][]string, error) {
+	if err := c.checkRange(startTime, endTime); err != nil {
+		return nil, err
+	}
+
+	workers := c.cfg.MaxConcurrency
+	if len(keys) < workers {
+		workers = len(keys)
+	}
+
+	var (
+		mu       sync.Mutex
+		results  = make(map[string][]string, len(keys))
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	pending := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range pending {
+				data, err := c.ReadDataContext(ctx, key, startTime, endTime, downsampling)
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				} else if err == nil {
+					results[key] = data
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		pending <- key
+	}
+	close(pending)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return results, nil
+}
+
+// ReadMulti is like ReadMultiple but parses the records of each key into data points.
+// An unparsable record fails the whole read with a *RecordError naming its key.
+func (c *TSDBClient) ReadMulti(keys []string, startTime, endTime int64, downsampling int) (map[string][]DataPoint, error) {
+	return c.ReadMultiContext(context.Background(), keys, startTime, endTime, downsampling)
+}
+
+// ReadMultiContext is like ReadMulti but honours ctx for cancellation and deadlines
+func (c *TSDBClient) ReadMultiContext(ctx context.Context, keys []string, startTime, endTime int64, downsampling int) (map[string][]DataPoint, error) {
+	data, err := c.ReadMultipleContext(ctx, keys, startTime, endTime, downsampling)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string][]DataPoint, len(data))
+	for key, records := range data {
+		points, err := c.parsePoints(records)
+		if err != nil {
+			return nil, fmt.Errorf("read multi: key %s: %w", key, err)
+		}
+		results[key] = points
+	}
+	return results, nil
+}
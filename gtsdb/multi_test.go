@@ -0,0 +1,80 @@
+package gtsdb
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadMultipleBoundsConcurrency(t *testing.T) {
+	const keys, maxConcurrency = 100, 4
+
+	var (
+		mu            sync.Mutex
+		reading, most int
+	)
+	server := newFakeServer(t, func(s *fakeServer) {
+		// Reads take a while, so concurrent ones overlap on the server
+		s.handle = func(conn net.Conn, line string) bool {
+			if strings.Count(line, ",") < 3 {
+				return false
+			}
+			mu.Lock()
+			reading++
+			most = max(most, reading)
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			reading--
+			mu.Unlock()
+			return false
+		}
+	})
+	// The pool would allow more reads at once than the bound
+	client := server.client(t, WithMaxConcurrency(maxConcurrency), WithPoolSize(0, 2*maxConcurrency))
+
+	names := make([]string, keys)
+	for i := range names {
+		names[i] = fmt.Sprintf("sensor%03d", i)
+		server.write(names[i], 1700000000, float64(i))
+	}
+	// Duplicates are read once
+	names = append(names, names[0], names[1])
+
+	results, err := client.ReadMultiple(names, 1700000000, 1700000010, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != keys {
+		t.Fatalf("got %d keys, want %d", len(results), keys)
+	}
+	for _, name := range names {
+		if records := results[name]; len(records) != 1 || !strings.HasPrefix(records[0], name+",") {
+			t.Errorf("%s: got %q", name, records)
+		}
+	}
+	if most > maxConcurrency {
+		t.Fatalf("%d reads at once, want at most %d", most, maxConcurrency)
+	}
+	if most < 2 {
+		t.Fatal("reads never overlapped")
+	}
+	if n := server.count("sensor"); n != keys {
+		t.Fatalf("server got %d reads, want %d", n, keys)
+	}
+}
+
+func TestReadMultipleRejectsOversizedCommands(t *testing.T) {
+	server := newFakeServer(t)
+	client := server.client(t)
+
+	if _, err := client.ReadMultiple([]string{strings.Repeat("k", MaxMessageBytes)}, 1700000000, 1700000010, 0); err == nil {
+		t.Fatal("oversized read accepted")
+	}
+	if n := len(server.received()); n != 0 {
+		t.Fatalf("server got %d commands, want none", n)
+	}
+}
@@ -0,0 +1,110 @@
+package gtsdb
+
+import (
+	"context"
+	"net"
+)
+
+// Command describes an operation reported to observers
+type Command struct {
+	// Op names the operation, e.g. "write data" or "read data"
+	Op string
+	// Key is the key operated on, empty for operations on several keys or none
+	Key string
+	// Points is the number of points written, zero for other operations
+	Points int
+}
+
+// Observer is notified of the client's operations, for example to record metrics or
+// traces. Its methods are called concurrently and should return quickly.
+type Observer interface {
+	// CommandStart is called as an operation starts. The function it returns is called
+	// with the outcome once the operation is done.
+	CommandStart(ctx context.Context, cmd Command) func(err error)
+	// Dialed is called after every attempt to open a connection, reconnects included
+	Dialed(err error)
+}
+
+// AddObserver registers an observer of the operations run from now on
+func (c *TSDBClient) AddObserver(o Observer) {
+	c.observerMu.Lock()
+	defer c.observerMu.Unlock()
+
+	var observers []Observer
+	if current := c.observers.Load(); current != nil {
+		observers = append(observers, *current...)
+	}
+	observers = append(observers, o)
+	c.observers.Store(&observers)
+}
+
+// observe reports the start of an operation to the observers, returning the function
+// that reports its outcome
+func (c *TSDBClient) observe(ctx context.Context, cmd Command) func(err error) {
+	observers := c.observers.Load()
+	if observers == nil {
+		return func(error) {}
+	}
+
+	dones := make([]func(error), len(*observers))
+	for i, o := range *observers {
+		dones[i] = o.CommandStart(ctx, cmd)
+	}
+	return func(err error) {
+		for _, done := range dones {
+			done(err)
+		}
+	}
+}
+
+// dialed reports a connection attempt to the observers
+func (c *TSDBClient) dialed(err error) {
+	if observers := c.observers.Load(); observers != nil {
+		for _, o := range *observers {
+			o.Dialed(err)
+		}
+	}
+}
+
+// Stats is a snapshot of the client's connection and buffering counters
+type Stats struct {
+	// BytesSent and BytesReceived count the traffic of every connection the client
+	// opened, subscriptions included
+	BytesSent     int64
+	BytesReceived int64
+	// OpenConns is the number of pooled connections and InUseConns those lent to a command
+	OpenConns  int
+	InUseConns int
+	// BufferedPoints is the number of points waiting in the client's AsyncWriters
+	BufferedPoints int64
+}
+
+// Stats returns the current counters of the client
+func (c *TSDBClient) Stats() Stats {
+	open, inUse := c.pool.stats()
+	return Stats{
+		BytesSent:      c.bytesSent.Load(),
+		BytesReceived:  c.bytesReceived.Load(),
+		OpenConns:      open,
+		InUseConns:     inUse,
+		BufferedPoints: c.bufferedPoints.Load(),
+	}
+}
+
+// countingConn counts the bytes transferred over a connection into the client's stats
+type countingConn struct {
+	net.Conn
+	c *TSDBClient
+}
+
+func (cc *countingConn) Read(b []byte) (int, error) {
+	n, err := cc.Conn.Read(b)
+	cc.c.bytesReceived.Add(int64(n))
+	return n, err
+}
+
+func (cc *countingConn) Write(b []byte) (int, error) {
+	n, err := cc.Conn.Write(b)
+	cc.c.bytesSent.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,247 @@
+package gtsdb
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// Option configures a TSDBClient
+type Option func(*TSDBClient)
+
+// WithResponseDelimiter sets the separator between records in a read response.
+// It defaults to "|".
+func WithResponseDelimiter(delimiter string) Option {
+	return func(c *TSDBClient) {
+		c.cfg.ResponseDelimiter = delimiter
+	}
+}
+
+// WithMaxConcurrency bounds the number of requests multi-key operations such as
+// ReadMultiple keep in flight at once. It defaults to 8.
+func WithMaxConcurrency(n int) Option {
+	return func(c *TSDBClient) {
+		c.cfg.MaxConcurrency = n
+	}
+}
+
+// WithWriteTimeout bounds how long a write, and the acknowledgement awaited by
+// WriteDataSync, may take. It defaults to 10 seconds; zero disables the timeout.
+// WithCallTimeout overrides it for a single call.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(c *TSDBClient) {
+		c.cfg.WriteTimeout = timeout
+	}
+}
+
+// WithWriteAcks makes WriteData and WriteBatch, and what builds on them such as
+// RecordMeasurement and the flushes of an AsyncWriter, wait for the server to
+// acknowledge every point like WriteDataSync and WriteBatchSync. A write then costs a
+// round trip, and its error tells that points were rejected or not acknowledged
+// within the write timeout. By default writes return once sent.
+func WithWriteAcks() Option {
+	return func(c *TSDBClient) {
+		c.cfg.WriteAcks = true
+	}
+}
+
+// WithProtocolVersion selects the response framing spoken by the server.
+// It defaults to ProtocolV1.
+func WithProtocolVersion(version ProtocolVersion) Option {
+	return func(c *TSDBClient) {
+		c.cfg.ProtocolVersion = version
+	}
+}
+
+// WithReadTimeout bounds how long a read waits for the server's response, so that
+// a hung server can't block it forever. A response that times out is discarded
+// before the next command is sent. It defaults to 30 seconds; zero waits
+// indefinitely. WithCallTimeout overrides it for a single call.
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(c *TSDBClient) {
+		c.cfg.ReadTimeout = timeout
+	}
+}
+
+// WithMaxTimeRange rejects reads spanning more than limit with ErrRangeTooLarge before
+// anything is sent, guarding shared servers against runaway queries. Zero means no limit.
+func WithMaxTimeRange(limit time.Duration) Option {
+	return func(c *TSDBClient) {
+		c.cfg.MaxTimeRange = limit
+	}
+}
+
+// WithZeroAsMissing makes aggregations such as GetAverageMeasurement treat values of
+// exactly zero as missing readings instead of real ones. By default zeros are included.
+func WithZeroAsMissing() Option {
+	return func(c *TSDBClient) {
+		c.cfg.ZeroAsMissing = true
+	}
+}
+
+// WithQueryCache keeps ReadData results and the latest points found by
+// GetLatestMeasurement in memory for ttl, serving identical queries without a round
+// trip. The updates of subscribed keys refresh their latest point and drop the cached
+// queries whose range holds them. Writes made through the client drop both. It is
+// disabled by default.
+func WithQueryCache(ttl time.Duration) Option {
+	return func(c *TSDBClient) {
+		c.cfg.QueryCacheTTL = ttl
+	}
+}
+
+// WithQueryCacheSize bounds the query cache to that many query results and latest
+// points, evicting the least recently used. It is 1024 by default.
+func WithQueryCacheSize(entries int) Option {
+	return func(c *TSDBClient) {
+		c.cfg.QueryCacheSize = entries
+	}
+}
+
+// WithReconnect sets how the client re-establishes a lost connection. It defaults to
+// DefaultReconnectPolicy; a policy with zero MaxRetries dials once without retrying.
+func WithReconnect(policy ReconnectPolicy) Option {
+	return func(c *TSDBClient) {
+		c.cfg.Reconnect = policy
+	}
+}
+
+// WithRetry sets how failed commands are retried. It defaults to DefaultRetryPolicy;
+// a policy with MaxAttempts of one or less disables retries.
+func WithRetry(policy RetryPolicy) Option {
+	return func(c *TSDBClient) {
+		c.cfg.Retry = policy
+	}
+}
+
+// WithCircuitBreaker makes the client fail commands with ErrCircuitOpen for a cool-down
+// period once the server has failed to answer a number of times in a row, rather than
+// having every caller wait for a timeout. It is disabled by default.
+func WithCircuitBreaker(policy BreakerPolicy) Option {
+	return func(c *TSDBClient) {
+		c.cfg.Breaker = policy
+	}
+}
+
+// WithObserver registers an observer of the client's operations, e.g. to record
+// metrics or traces. It may be given more than once.
+func WithObserver(o Observer) Option {
+	return func(c *TSDBClient) {
+		c.AddObserver(o)
+	}
+}
+
+// WithPoolSize sets how many connections the client keeps open. At least min are
+// held even while idle, and at most max requests are in flight at once. It defaults
+// to 1 and 8; subscriptions use a dedicated connection not counted here.
+func WithPoolSize(min, max int) Option {
+	return func(c *TSDBClient) {
+		c.cfg.MinConns = min
+		c.cfg.MaxConns = max
+	}
+}
+
+// WithIdleTimeout closes pooled connections left unused for longer than timeout,
+// down to the pool's minimum size. It defaults to 5 minutes; zero keeps them open.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(c *TSDBClient) {
+		c.cfg.IdleTimeout = timeout
+	}
+}
+
+// WithHealthCheck checks that a pooled connection idle for at least after is still
+// alive before reusing it, replacing it otherwise. It defaults to 30 seconds; zero
+// disables the check.
+func WithHealthCheck(after time.Duration) Option {
+	return func(c *TSDBClient) {
+		c.cfg.HealthCheckAfter = after
+	}
+}
+
+// WithTLS makes the client connect over TLS. Client certificates and custom CA
+// pools are set through cfg's Certificates and RootCAs; when cfg.ServerName is
+// empty it is taken from the address. The config is cloned, so later changes to
+// cfg have no effect.
+func WithTLS(cfg *tls.Config) Option {
+	return func(c *TSDBClient) {
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		c.tlsConfig = cfg.Clone()
+		c.cfg.TLS = true
+	}
+}
+
+// WithDialContext opens the client's connections with dial instead of dialing the
+// address directly, e.g. through an SSH tunnel or a SOCKS proxy, or to an in-process
+// server. dial is called with the network, tcp or unix, and the address, and the dial
+// timeout applies to ctx; net.Dialer's DialContext has its signature. TLS, when
+// enabled, runs over the connections it returns.
+func WithDialContext(dial func(ctx context.Context, network, address string) (net.Conn, error)) Option {
+	return func(c *TSDBClient) {
+		c.dialContext = dial
+		c.cfg.CustomDialer = dial != nil
+	}
+}
+
+// WithDialTimeout bounds how long opening a connection, including the TLS handshake,
+// may take. It defaults to 10 seconds; zero disables the timeout.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(c *TSDBClient) {
+		c.cfg.DialTimeout = timeout
+	}
+}
+
+// WithKeepAlive sets the TCP keep-alive period of connections. Zero uses the
+// system default and a negative period disables keep-alives.
+func WithKeepAlive(period time.Duration) Option {
+	return func(c *TSDBClient) {
+		c.cfg.KeepAlive = period
+	}
+}
+
+// WithBufferSizes sets the socket receive and send buffer sizes of connections,
+// the receive size also sizing the client's read buffer. Zero keeps the defaults.
+func WithBufferSizes(read, write int) Option {
+	return func(c *TSDBClient) {
+		c.cfg.ReadBufferSize = read
+		c.cfg.WriteBufferSize = write
+	}
+}
+
+// WithLogger sets the logger reporting connection events, retries, protocol errors,
+// failed background flushes and dropped points. Nothing is logged by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *TSDBClient) {
+		if logger != nil {
+			c.logger = logger
+		}
+	}
+}
+
+// WithValuePrecision sets the number of decimals values are written with. It defaults
+// to 2 for compatibility with older servers; FullPrecision writes every value exactly.
+func WithValuePrecision(decimals int) Option {
+	return func(c *TSDBClient) {
+		c.cfg.ValuePrecision = decimals
+	}
+}
+
+// WithTimestampResolution sets the unit of the timestamps exchanged with the server,
+// which must match how the server stores them. It defaults to Seconds. The int64
+// timestamps taken by WriteData, ReadData and the other raw methods are in this unit.
+func WithTimestampResolution(resolution TimestampResolution) Option {
+	return func(c *TSDBClient) {
+		c.cfg.TimestampResolution = resolution
+	}
+}
+
+// WithLatestLookback bounds how far back GetLatestMeasurement searches for the last
+// value on servers without a last command. It defaults to 30 days.
+func WithLatestLookback(max time.Duration) Option {
+	return func(c *TSDBClient) {
+		c.cfg.LatestLookback = max
+	}
+}
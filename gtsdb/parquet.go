@@ -0,0 +1,318 @@
+package gtsdb
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// parquetRowGroupSize is the number of points buffered before a row group is written
+const parquetRowGroupSize = 1 << 16
+
+// Values of the Parquet format's Thrift enums
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetRequired        = 0
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+
+	parquetPlain        = 0
+	parquetRLE          = 3
+	parquetUncompressed = 0
+	parquetDataPage     = 0
+)
+
+// parquetEncoder writes a Parquet file with the three columns of points, buffering a
+// row group at a time. Every column is required and plainly encoded without compression,
+// which any reader handles.
+type parquetEncoder struct {
+	w   io.Writer
+	err error
+	// offset is the number of bytes written so far, which the footer refers to
+	offset int64
+
+	keys       []string
+	timestamps []int64
+	values     []float64
+
+	rowGroups []parquetRowGroup
+	rows      int64
+}
+
+// parquetRowGroup is what the footer records of a row group
+type parquetRowGroup struct {
+	rows    int64
+	size    int64
+	columns [3]parquetColumnChunk
+}
+
+type parquetColumnChunk struct {
+	offset, size int64
+}
+
+// parquetColumns are the name, type and annotation of the columns
+var parquetColumns = [3]struct {
+	name      string
+	typ       int32
+	converted int32
+}{
+	{"key", parquetByteArray, parquetUTF8},
+	{"timestamp", parquetInt64, parquetTimestampMillis},
+	{"value", parquetDouble, -1},
+}
+
+func newParquetEncoder(w io.Writer) *parquetEncoder {
+	e := &parquetEncoder{w: w}
+	e.emit([]byte("PAR1"))
+	return e
+}
+
+// emit writes b, remembering the first error
+func (e *parquetEncoder) emit(b []byte) {
+	if e.err != nil {
+		return
+	}
+	var n int
+	n, e.err = e.w.Write(b)
+	e.offset += int64(n)
+}
+
+func (e *parquetEncoder) write(p DataPoint) error {
+	if e.err != nil {
+		return e.err
+	}
+	e.keys = append(e.keys, p.Key)
+	e.timestamps = append(e.timestamps, p.Timestamp.UnixMilli())
+	e.values = append(e.values, p.Value)
+	if len(e.keys) >= parquetRowGroupSize {
+		return e.flush()
+	}
+	return e.err
+}
+
+// flush writes the buffered points as a row group
+func (e *parquetEncoder) flush() error {
+	if len(e.keys) == 0 || e.err != nil {
+		return e.err
+	}
+	rg := parquetRowGroup{rows: int64(len(e.keys))}
+	var page []byte
+	for i := range parquetColumns {
+		page = page[:0]
+		switch i {
+		case 0:
+			for _, key := range e.keys {
+				page = binary.LittleEndian.AppendUint32(page, uint32(len(key)))
+				page = append(page, key...)
+			}
+		case 1:
+			for _, ts := range e.timestamps {
+				page = binary.LittleEndian.AppendUint64(page, uint64(ts))
+			}
+		case 2:
+			for _, v := range e.values {
+				page = binary.LittleEndian.AppendUint64(page, math.Float64bits(v))
+			}
+		}
+		var t thriftWriter
+		t.i32(1, parquetDataPage)
+		t.i32(2, int32(len(page)))
+		t.i32(3, int32(len(page)))
+		t.beginStruct(5)
+		t.i32(1, int32(len(e.keys)))
+		t.i32(2, parquetPlain)
+		t.i32(3, parquetRLE)
+		t.i32(4, parquetRLE)
+		t.endStruct()
+		t.endStruct()
+
+		rg.columns[i] = parquetColumnChunk{offset: e.offset, size: int64(len(t.buf) + len(page))}
+		rg.size += rg.columns[i].size
+		e.emit(t.buf)
+		e.emit(page)
+	}
+	e.rowGroups = append(e.rowGroups, rg)
+	e.rows += rg.rows
+	e.keys, e.timestamps, e.values = e.keys[:0], e.timestamps[:0], e.values[:0]
+	return e.err
+}
+
+// close writes the last row group and the footer describing the file
+func (e *parquetEncoder) close() error {
+	if err := e.flush(); err != nil {
+		return err
+	}
+	var t thriftWriter
+	t.i32(1, 1)
+	t.beginList(2, thriftStruct, len(parquetColumns)+1)
+	t.beginElem()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(parquetColumns)))
+	t.endStruct()
+	for _, col := range parquetColumns {
+		t.beginElem()
+		t.i32(1, col.typ)
+		t.i32(3, parquetRequired)
+		t.binary(4, col.name)
+		if col.converted >= 0 {
+			t.i32(6, col.converted)
+		}
+		switch col.converted {
+		case parquetUTF8:
+			// logicalType STRING
+			t.beginStruct(10)
+			t.beginStruct(1)
+			t.endStruct()
+			t.endStruct()
+		case parquetTimestampMillis:
+			// logicalType TIMESTAMP(isAdjustedToUTC, MILLIS)
+			t.beginStruct(10)
+			t.beginStruct(8)
+			t.bool(1, true)
+			t.beginStruct(2)
+			t.beginStruct(1)
+			t.endStruct()
+			t.endStruct()
+			t.endStruct()
+			t.endStruct()
+		}
+		t.endStruct()
+	}
+	t.i64(3, e.rows)
+	t.beginList(4, thriftStruct, len(e.rowGroups))
+	for _, rg := range e.rowGroups {
+		t.beginElem()
+		t.beginList(1, thriftStruct, len(rg.columns))
+		for i, chunk := range rg.columns {
+			col := parquetColumns[i]
+			t.beginElem()
+			t.i64(2, chunk.offset)
+			t.beginStruct(3)
+			t.i32(1, col.typ)
+			t.beginList(2, thriftI32, 2)
+			t.appendVarint(zigzag(parquetPlain))
+			t.appendVarint(zigzag(parquetRLE))
+			t.beginList(3, thriftBinary, 1)
+			t.appendString(col.name)
+			t.i32(4, parquetUncompressed)
+			t.i64(5, rg.rows)
+			t.i64(6, chunk.size)
+			t.i64(7, chunk.size)
+			t.i64(9, chunk.offset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64(2, rg.size)
+		t.i64(3, rg.rows)
+		t.endStruct()
+	}
+	t.binary(6, "gtsdb-drivers")
+	t.endStruct()
+
+	e.emit(t.buf)
+	e.emit(binary.LittleEndian.AppendUint32(nil, uint32(len(t.buf))))
+	e.emit([]byte("PAR1"))
+	return e.err
+}
+
+// Types of the Thrift compact protocol
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the Thrift compact protocol the Parquet footer and page headers
+// are written in. Fields are written in increasing id order within a struct, and the
+// struct being written is implicitly the outermost one until beginStruct.
+type thriftWriter struct {
+	buf []byte
+	// last holds the id of the last field written in each enclosing struct
+	last []int16
+	id   int16
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.id; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.appendVarint(zigzag(int64(id)))
+	}
+	t.id = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.appendVarint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.appendVarint(zigzag(v))
+}
+
+func (t *thriftWriter) bool(id int16, v bool) {
+	if v {
+		t.field(id, thriftTrue)
+	} else {
+		t.field(id, thriftFalse)
+	}
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.appendString(s)
+}
+
+// beginStruct starts a struct field, ended by endStruct
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.beginElem()
+}
+
+// beginElem starts a struct element of a list, ended by endStruct
+func (t *thriftWriter) beginElem() {
+	t.last = append(t.last, t.id)
+	t.id = 0
+}
+
+// endStruct ends the current struct, or the outermost one
+func (t *thriftWriter) endStruct() {
+	t.buf = append(t.buf, 0)
+	if n := len(t.last); n > 0 {
+		t.id = t.last[n-1]
+		t.last = t.last[:n-1]
+	}
+}
+
+// beginList starts a list field of n elements of typ, which follow it
+func (t *thriftWriter) beginList(id int16, typ byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|typ)
+	} else {
+		t.buf = append(t.buf, 0xf0|typ)
+		t.appendVarint(uint64(n))
+	}
+}
+
+func (t *thriftWriter) appendString(s string) {
+	t.appendVarint(uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+func (t *thriftWriter) appendVarint(v uint64) {
+	t.buf = binary.AppendUvarint(t.buf, v)
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
@@ -0,0 +1,38 @@
+package gtsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// pongReply is the server's answer to a ping
+const pongReply = "PONG"
+
+// Ping checks that the server answers on a pooled connection, dialing one if none
+// is open. Any reply proves the server alive, so servers without a ping command,
+// which answer with an error reply, pass unless they report being unavailable.
+func (c *TSDBClient) Ping() error {
+	return c.PingContext(context.Background())
+}
+
+// PingContext is like Ping but honours ctx for cancellation and deadlines
+func (c *TSDBClient) PingContext(ctx context.Context) (err error) {
+	done := c.observe(ctx, Command{Op: "ping"})
+	defer func() { done(err) }()
+
+	response, err := c.roundTrip(ctx, "ping", c.cfg.ReadTimeout, "ping\n")
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) && !errors.Is(err, ErrServerUnavailable) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if reply := strings.TrimSpace(response); reply != pongReply && reply != ackReply {
+		return fmt.Errorf("ping: unexpected reply %q", reply)
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package gtsdb
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// healthCheckWait is how long a health check waits to rule out a pending close or
+// unsolicited data on an idle connection
+const healthCheckWait = time.Millisecond
+
+// poolConn is a single connection to the TSDB along with its protocol state
+type poolConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// stale is the unread remainder of a response abandoned after a read timeout
+	stale *staleResponse
+	// broken is set after an I/O error so the connection is not reused
+	broken bool
+	// idleSince is when the connection was last returned to the pool
+	idleSince time.Time
+}
+
+// newPoolConn wraps conn with a reader of the given buffer size, where zero picks the default
+func newPoolConn(conn net.Conn, bufferSize int) *poolConn {
+	reader := bufio.NewReader(conn)
+	if bufferSize > 0 {
+		reader = bufio.NewReaderSize(conn, bufferSize)
+	}
+	return &poolConn{conn: conn, reader: reader, idleSince: time.Now()}
+}
+
+// healthy reports whether an idle connection still looks usable, i.e. the server
+// has not closed it and nothing unsolicited is waiting to be read
+func (pc *poolConn) healthy() bool {
+	if pc.stale != nil {
+		// A late reply is expected and will be discarded before the next command
+		return true
+	}
+	pc.conn.SetReadDeadline(time.Now().Add(healthCheckWait))
+	_, err := pc.reader.Peek(1)
+	pc.conn.SetReadDeadline(time.Time{})
+	return isTimeout(err)
+}
+
+// connPool lends each exchange a connection of its own, dialing new ones up to
+// a maximum and closing those left idle for too long
+type connPool struct {
+	dial             func(ctx context.Context) (net.Conn, error)
+	minConns         int
+	idleTimeout      time.Duration
+	healthCheckAfter time.Duration
+	bufferSize       int
+	logger           *slog.Logger
+
+	// slots holds a token per borrowed connection; since idle connections are
+	// reused before dialing, it also bounds the number of open ones. Unlike a
+	// mutex, waiting for a slot can be abandoned when the context is done.
+	slots chan struct{}
+
+	mu sync.Mutex
+	// idle connections, most recently returned last
+	idle []*poolConn
+	// open holds every connection, idle or borrowed, so Close can reach them all
+	open   map[*poolConn]struct{}
+	closed bool
+	done   chan struct{}
+}
+
+func newConnPool(dial func(ctx context.Context) (net.Conn, error), cfg ClientConfig, logger *slog.Logger) *connPool {
+	p := &connPool{
+		dial:             dial,
+		minConns:         cfg.MinConns,
+		idleTimeout:      cfg.IdleTimeout,
+		healthCheckAfter: cfg.HealthCheckAfter,
+		bufferSize:       cfg.ReadBufferSize,
+		logger:           logger,
+		slots:            make(chan struct{}, cfg.MaxConns),
+		open:             make(map[*poolConn]struct{}),
+		done:             make(chan struct{}),
+	}
+	if p.idleTimeout > 0 {
+		go p.reap(p.idleTimeout / 2)
+	}
+	return p
+}
+
+// fill dials until the pool holds its minimum number of connections
+func (p *connPool) fill(ctx context.Context, dial func(ctx context.Context) (net.Conn, error)) error {
+	for {
+		p.mu.Lock()
+		n := len(p.open)
+		p.mu.Unlock()
+		if n >= p.minConns {
+			return nil
+		}
+
+		conn, err := dial(ctx)
+		if err != nil {
+			return err
+		}
+		pc := newPoolConn(conn, p.bufferSize)
+		if err := p.add(pc); err != nil {
+			return err
+		}
+		p.put(pc, false)
+	}
+}
+
+// add registers a freshly dialed connection as open
+func (p *connPool) add(pc *poolConn) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		pc.conn.Close()
+		return ErrClosed
+	}
+	p.open[pc] = struct{}{}
+	return nil
+}
+
+// get borrows a connection, reusing an idle one when it passes its health check
+// and dialing otherwise. It gives up waiting for a free slot when ctx is done.
+func (p *connPool) get(ctx context.Context) (*poolConn, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			<-p.slots
+			return nil, ErrClosed
+		}
+		n := len(p.idle)
+		if n == 0 {
+			p.mu.Unlock()
+			break
+		}
+		pc := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+
+		if p.healthCheckAfter > 0 && time.Since(pc.idleSince) >= p.healthCheckAfter && !pc.healthy() {
+			p.logger.Debug("dropping unhealthy pooled connection", "idle", time.Since(pc.idleSince))
+			p.discard(pc)
+			continue
+		}
+		return pc, nil
+	}
+
+	conn, err := p.dial(ctx)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	pc := newPoolConn(conn, p.bufferSize)
+	if err := p.add(pc); err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return pc, nil
+}
+
+// put returns a borrowed connection, closing it instead if it broke or the pool
+// has been closed
+func (p *connPool) put(pc *poolConn, borrowed bool) {
+	if borrowed {
+		defer func() { <-p.slots }()
+	}
+	if pc.broken {
+		p.discard(pc)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		pc.conn.Close()
+		return
+	}
+	pc.idleSince = time.Now()
+	p.idle = append(p.idle, pc)
+}
+
+// discard closes a connection that will not be reused
+func (p *connPool) discard(pc *poolConn) {
+	p.mu.Lock()
+	delete(p.open, pc)
+	p.mu.Unlock()
+	pc.conn.Close()
+}
+
+// reap periodically closes connections idle for longer than the idle timeout,
+// keeping the minimum number open
+func (p *connPool) reap(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.prune()
+		}
+	}
+}
+
+// prune closes the connections that have been idle for too long
+func (p *connPool) prune() {
+	p.mu.Lock()
+	var expired []*poolConn
+	kept := p.idle[:0]
+	// Oldest first, so the connections kept are the most recently used ones
+	for _, pc := range p.idle {
+		if len(p.open) > p.minConns && time.Since(pc.idleSince) > p.idleTimeout {
+			delete(p.open, pc)
+			expired = append(expired, pc)
+			continue
+		}
+		kept = append(kept, pc)
+	}
+	p.idle = kept
+	p.mu.Unlock()
+
+	if len(expired) > 0 {
+		p.logger.Debug("closing idle pooled connections", "count", len(expired))
+	}
+	for _, pc := range expired {
+		pc.conn.Close()
+	}
+}
+
+// stats returns the number of open connections and of those currently borrowed
+func (p *connPool) stats() (open, inUse int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.open), len(p.slots)
+}
+
+// close closes every connection, interrupting those that are borrowed
+func (p *connPool) close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	open := p.open
+	p.open, p.idle = nil, nil
+	p.mu.Unlock()
+	close(p.done)
+
+	var firstErr error
+	for pc := range open {
+		// Expire the deadline first so blocked readers wake up right away
+		pc.conn.SetDeadline(time.Now())
+		if err := pc.conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,196 @@
+package gtsdb
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProtocolVersion selects how the server frames read responses
+type ProtocolVersion int
+
+const (
+	// ProtocolV1 frames a response as a single line of records separated by
+	// the response delimiter
+	ProtocolV1 ProtocolVersion = iota + 1
+	// ProtocolV2 frames a response as a line holding the byte length of a block,
+	// followed by that block of newline-delimited records
+	ProtocolV2
+)
+
+// TimestampResolution selects the unit of the timestamps exchanged with the server
+type TimestampResolution int
+
+const (
+	// Seconds sends and reads timestamps as whole Unix seconds
+	Seconds TimestampResolution = iota + 1
+	// Milliseconds sends and reads timestamps as Unix milliseconds
+	Milliseconds
+	// Microseconds sends and reads timestamps as Unix microseconds
+	Microseconds
+	// Nanoseconds sends and reads timestamps as Unix nanoseconds
+	Nanoseconds
+)
+
+// Unit returns the duration of one timestamp tick
+func (r TimestampResolution) Unit() time.Duration {
+	switch r {
+	case Milliseconds:
+		return time.Millisecond
+	case Microseconds:
+		return time.Microsecond
+	case Nanoseconds:
+		return time.Nanosecond
+	default:
+		return time.Second
+	}
+}
+
+// FromTime converts t into a timestamp of this resolution, truncating finer detail
+func (r TimestampResolution) FromTime(t time.Time) int64 {
+	switch r {
+	case Milliseconds:
+		return t.UnixMilli()
+	case Microseconds:
+		return t.UnixMicro()
+	case Nanoseconds:
+		return t.UnixNano()
+	default:
+		return t.Unix()
+	}
+}
+
+// ToTime converts a timestamp of this resolution into a time
+func (r TimestampResolution) ToTime(timestamp int64) time.Time {
+	switch r {
+	case Milliseconds:
+		return time.UnixMilli(timestamp)
+	case Microseconds:
+		return time.UnixMicro(timestamp)
+	case Nanoseconds:
+		return time.Unix(0, timestamp)
+	default:
+		return time.Unix(timestamp, 0)
+	}
+}
+
+// FullPrecision makes WithValuePrecision send the shortest decimal that reads
+// back as the exact same float64
+const FullPrecision = -1
+
+// formatWrite builds the command writing a single value
+func (c *TSDBClient) formatWrite(key string, timestamp int64, value float64) string {
+	return key + "," + strconv.FormatInt(timestamp, 10) + "," + strconv.FormatFloat(value, 'f', c.cfg.ValuePrecision, 64) + "\n"
+}
+
+// timestamp converts t into a timestamp of the client's resolution
+func (c *TSDBClient) timestamp(t time.Time) int64 {
+	return c.cfg.TimestampResolution.FromTime(t)
+}
+
+func (c *TSDBClient) timestampResolution() TimestampResolution {
+	return c.cfg.TimestampResolution
+}
+
+// resolutionOf returns the timestamp resolution of client, which is seconds unless it
+// is a *TSDBClient or a composite client built on them
+func resolutionOf(client ReadWriter) TimestampResolution {
+	if r, ok := client.(interface{ timestampResolution() TimestampResolution }); ok {
+		return r.timestampResolution()
+	}
+	return Seconds
+}
+
+// staleResponse is the unread remainder of a response whose read timed out
+type staleResponse struct {
+	// partial is the part of the reply line read so far
+	partial string
+	// framed marks a ProtocolV2 block header, which is followed by its block
+	framed bool
+	// inBlock is set once the header is complete, with remaining block bytes to discard
+	inBlock   bool
+	remaining int64
+}
+
+// readLine reads a single reply line, returning error replies as a *ServerError
+func (pc *poolConn) readLine() (string, error) {
+	response, err := pc.reader.ReadString('\n')
+	if err != nil {
+		if isTimeout(err) {
+			pc.stale = &staleResponse{partial: response}
+		}
+		if err == io.EOF && response != "" {
+			return "", &TruncatedResponseError{Partial: response}
+		}
+		return "", err
+	}
+
+	if err := parseServerError(response); err != nil {
+		return "", err
+	}
+	return response, nil
+}
+
+// readRecords reads the records of a single response framed as cfg specifies
+func (pc *poolConn) readRecords(cfg *ClientConfig) ([]string, error) {
+	header, err := pc.readLine()
+	if err != nil {
+		if pc.stale != nil {
+			pc.stale.framed = cfg.ProtocolVersion == ProtocolV2
+		}
+		return nil, err
+	}
+
+	if cfg.ProtocolVersion == ProtocolV1 {
+		return splitRecords(header, cfg.ResponseDelimiter), nil
+	}
+
+	size, err := strconv.Atoi(strings.TrimSpace(header))
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("invalid response block length %q", strings.TrimSpace(header))
+	}
+
+	block := make([]byte, size)
+	if n, err := io.ReadFull(pc.reader, block); err != nil {
+		if isTimeout(err) {
+			pc.stale = &staleResponse{framed: true, inBlock: true, remaining: int64(size - n)}
+		}
+		if err == io.ErrUnexpectedEOF || (err == io.EOF && size > 0) {
+			return nil, &TruncatedResponseError{Partial: header + string(block[:n])}
+		}
+		return nil, err
+	}
+	return splitRecords(string(block), "\n"), nil
+}
+
+// discardStale consumes the rest of a response abandoned after a read timeout, so that
+// the next command starts on a clean stream. If it times out again the progress is kept
+// and the next command tries once more.
+func (pc *poolConn) discardStale() error {
+	s := pc.stale
+	if !s.inBlock {
+		rest, err := pc.reader.ReadString('\n')
+		s.partial += rest
+		if err != nil {
+			return err
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(s.partial))
+		if !s.framed || err != nil || size < 0 {
+			// A plain reply line, or an error reply in place of a block header
+			pc.stale = nil
+			return nil
+		}
+		s.inBlock, s.remaining = true, int64(size)
+	}
+
+	n, err := io.CopyN(io.Discard, pc.reader, s.remaining)
+	s.remaining -= n
+	if err != nil {
+		return err
+	}
+	pc.stale = nil
+	return nil
+}
@@ -0,0 +1,197 @@
+package gtsdb
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// block frames records as a ProtocolV2 reply
+func block(records string) string {
+	return strconv.Itoa(len(records)) + "\n" + records
+}
+
+func TestFramings(t *testing.T) {
+	tests := []struct {
+		name    string
+		framed  bool
+		version ProtocolVersion
+	}{
+		{"v1 delimited line", false, ProtocolV1},
+		{"v2 length-prefixed block", true, ProtocolV2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, func(s *fakeServer) { s.framed = tt.framed })
+			client := server.client(t, WithProtocolVersion(tt.version))
+			server.write("temp", 1700000000, 1.5)
+			server.write("temp", 1700000001, 2.5)
+			server.write("humidity", 1700000000, 40)
+
+			records, err := client.ReadData("temp", 1700000000, 1700000010, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"temp,1700000000,1.5", "temp,1700000001,2.5"}; !equalStrings(records, want) {
+				t.Fatalf("got %q, want %q", records, want)
+			}
+
+			// An empty result, then a read on the same connection to check nothing was left over
+			records, err = client.ReadData("temp", 0, 10, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 0 {
+				t.Fatalf("got %q for an empty range, want no records", records)
+			}
+
+			keys, err := client.ListKeys("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"humidity", "temp"}; !equalStrings(keys, want) {
+				t.Fatalf("got keys %q, want %q", keys, want)
+			}
+		})
+	}
+}
+
+func TestProtocolV2Blocks(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     []string
+		wantErr  bool
+	}{
+		{"records", block("temp,1,1.5\ntemp,2,2.5\ntemp,3,3"), []string{"temp,1,1.5", "temp,2,2.5", "temp,3,3"}, false},
+		{"trailing newline", block("temp,1,1.5\n"), []string{"temp,1,1.5"}, false},
+		{"pipes are not delimiters", block("temp,1,1|5"), []string{"temp,1,1|5"}, false},
+		{"empty block", block(""), nil, false},
+		{"header with spaces", " 10 \ntemp,1,1.5", []string{"temp,1,1.5"}, false},
+		{"bad header", "ten\ntemp,1,1.5", nil, true},
+		{"negative length", "-1\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, replying("temp", tt.response))
+			client := server.client(t, WithProtocolVersion(ProtocolV2))
+
+			records, err := client.ReadData("temp", 0, 10, 0)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %q, want an error", records)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalStrings(records, tt.want) {
+				t.Fatalf("got %q, want %q", records, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadAfterTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		version ProtocolVersion
+		// first is sent right away in answer to the read that times out, rest later
+		first, rest string
+	}{
+		{"v1 late reply", ProtocolV1, "", "slow,1,1|slow,2,2\n"},
+		{"v1 reply cut in the middle", ProtocolV1, "slow,1,1|sl", "ow,2,2\n"},
+		{"v2 late header", ProtocolV2, "", block("slow,1,1\nslow,2,2")},
+		{"v2 block cut in the middle", ProtocolV2, block("slow,1,1\nslow,2,2")[:8], block("slow,1,1\nslow,2,2")[8:]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, func(s *fakeServer) {
+				s.framed = tt.version == ProtocolV2
+				s.handle = func(conn net.Conn, line string) bool {
+					if !strings.HasPrefix(line, "slow,") {
+						return false
+					}
+					io.WriteString(conn, tt.first)
+					time.Sleep(300 * time.Millisecond)
+					io.WriteString(conn, tt.rest)
+					return true
+				}
+			})
+			// A single connection, so the second read goes where the stale reply is
+			client := server.client(t, WithProtocolVersion(tt.version), WithPoolSize(1, 1), WithReadTimeout(200*time.Millisecond))
+			server.write("temp", 1, 21)
+
+			if _, err := client.ReadData("slow", 0, 10, 0); !isTimeout(err) {
+				t.Fatalf("slow read returned %v, want a timeout", err)
+			}
+			records, err := client.ReadData("temp", 0, 10, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"temp,1,21"}; !equalStrings(records, want) {
+				t.Fatalf("got %q, want %q", records, want)
+			}
+			// And the connection is in sync for the one after
+			records, err = client.ReadData("temp", 0, 10, 0)
+			if err != nil || !equalStrings(records, []string{"temp,1,21"}) {
+				t.Fatalf("got %q, %v on the next read", records, err)
+			}
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			if server.accepted != 1 {
+				t.Fatalf("%d connections dialed, want the stale one kept", server.accepted)
+			}
+		})
+	}
+}
+
+func TestTruncatedResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		version ProtocolVersion
+		partial string
+	}{
+		{"v1 line without its newline", ProtocolV1, "temp,1,1|temp,2,"},
+		{"v2 header without its newline", ProtocolV2, "2"},
+		{"v2 block cut short", ProtocolV2, block("temp,1,1\ntemp,2,2")[:10]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, func(s *fakeServer) {
+				s.handle = func(conn net.Conn, line string) bool {
+					io.WriteString(conn, tt.partial)
+					conn.Close()
+					return true
+				}
+			})
+			client := server.client(t, WithProtocolVersion(tt.version), WithRetry(RetryPolicy{}))
+
+			records, err := client.ReadData("temp", 0, 10, 0)
+			var truncated *TruncatedResponseError
+			if !errors.As(err, &truncated) || !errors.Is(err, ErrTruncatedResponse) {
+				t.Fatalf("got %q, %v, want a *TruncatedResponseError", records, err)
+			}
+			if truncated.Partial != tt.partial {
+				t.Fatalf("partial response %q, want %q", truncated.Partial, tt.partial)
+			}
+		})
+	}
+
+	// A connection closed before any of the response isn't a truncated response
+	server := newFakeServer(t, func(s *fakeServer) {
+		s.handle = func(conn net.Conn, line string) bool {
+			conn.Close()
+			return true
+		}
+	})
+	client := server.client(t, WithRetry(RetryPolicy{}))
+	if _, err := client.ReadData("temp", 0, 10, 0); err == nil || errors.Is(err, ErrTruncatedResponse) {
+		t.Fatalf("got %v for a connection closed before replying, want another error", err)
+	}
+}
@@ -0,0 +1,81 @@
+package gtsdb
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ReconcileResult counts how the points of one key compare between two clients
+type ReconcileResult struct {
+	// Matching points exist on both sides with values within the tolerance
+	Matching int
+	// Differing points exist on both sides with values further apart than the tolerance
+	Differing int
+	// MissingInA and MissingInB count points only present on the other side
+	MissingInA int
+	MissingInB int
+}
+
+// Reconcile reads each key from both clients over the time range, aligns the points by
+// timestamp and reports per key how many match, differ beyond tolerance or are missing on
+// one side. It is meant to verify a migration between two backends.
+func Reconcile(a, b ReadWriter, keys []string, start, end time.Time, tolerance float64) (map[string]ReconcileResult, error) {
+	return ReconcileContext(context.Background(), a, b, keys, start, end, tolerance)
+}
+
+// ReconcileContext is like Reconcile but honours ctx for cancellation and deadlines
+func ReconcileContext(ctx context.Context, a, b ReadWriter, keys []string, start, end time.Time, tolerance float64) (map[string]ReconcileResult, error) {
+	results := make(map[string]ReconcileResult, len(keys))
+	for _, key := range keys {
+		pointsA, err := readByTimestamp(ctx, a, key, start, end)
+		if err != nil {
+			return nil, fmt.Errorf("read %s from a: %w", key, err)
+		}
+		pointsB, err := readByTimestamp(ctx, b, key, start, end)
+		if err != nil {
+			return nil, fmt.Errorf("read %s from b: %w", key, err)
+		}
+
+		var result ReconcileResult
+		for ts, valueA := range pointsA {
+			valueB, ok := pointsB[ts]
+			switch {
+			case !ok:
+				result.MissingInB++
+			case math.Abs(valueA-valueB) <= tolerance:
+				result.Matching++
+			default:
+				result.Differing++
+			}
+		}
+		for ts := range pointsB {
+			if _, ok := pointsA[ts]; !ok {
+				result.MissingInA++
+			}
+		}
+		results[key] = result
+	}
+	return results, nil
+}
+
+// readByTimestamp reads the raw points of key indexed by Unix nanoseconds, so that
+// clients of different timestamp resolutions can be compared
+func readByTimestamp(ctx context.Context, client ReadWriter, key string, start, end time.Time) (map[int64]float64, error) {
+	resolution := resolutionOf(client)
+	data, err := client.ReadDataContext(ctx, key, resolution.FromTime(start), resolution.FromTime(end), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	points := make(map[int64]float64, len(data))
+	for _, record := range data {
+		point, err := parseMeasurement(record, resolution)
+		if err != nil {
+			return nil, err
+		}
+		points[point.Timestamp.UnixNano()] = point.Value
+	}
+	return points, nil
+}
@@ -0,0 +1,78 @@
+package gtsdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReconcileReportsDivergence(t *testing.T) {
+	serverA, serverB := newFakeServer(t), newFakeServer(t)
+	a, b := serverA.client(t), serverB.client(t)
+	start := time.Unix(1700000000, 0)
+	write := func(client *TSDBClient, key string, offset int64, value float64) {
+		t.Helper()
+		if err := client.WriteData(key, start.Unix()+offset, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for offset := int64(0); offset < 60; offset += 10 {
+		write(a, "temp", offset, 20)
+		write(a, "humidity", offset, 40)
+		write(b, "humidity", offset, 40)
+		switch offset {
+		case 10:
+			write(b, "temp", offset, 20.05) // within the tolerance
+		case 20:
+			write(b, "temp", offset, 25) // beyond it
+		case 30:
+			// missing in b
+		default:
+			write(b, "temp", offset, 20)
+		}
+	}
+	write(b, "temp", 35, 20)     // missing in a
+	write(a, "temp", 3600, 99)   // out of the range on one side only
+	write(b, "humidity", -10, 0) // and on the other
+
+	results, err := Reconcile(a, b, []string{"temp", "humidity"}, start, start.Add(time.Minute), 0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]ReconcileResult{
+		"temp":     {Matching: 4, Differing: 1, MissingInA: 1, MissingInB: 1},
+		"humidity": {Matching: 6},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got results for %d keys, want %d", len(results), len(want))
+	}
+	for key, result := range want {
+		if results[key] != result {
+			t.Errorf("%s: got %+v, want %+v", key, results[key], result)
+		}
+	}
+}
+
+func TestReconcileMilliseconds(t *testing.T) {
+	serverA, serverB := newFakeServer(t), newFakeServer(t)
+	a := serverA.client(t, WithTimestampResolution(Milliseconds))
+	b := serverB.client(t, WithTimestampResolution(Milliseconds))
+
+	// Points within one second must not be merged
+	start := time.Unix(1700000000, 0)
+	for i, value := range []float64{1, 2, 3} {
+		ts := start.Add(time.Duration(i) * 300 * time.Millisecond).UnixMilli()
+		serverA.write("temp", ts, value)
+		if i < 2 {
+			serverB.write("temp", ts, value)
+		}
+	}
+
+	results, err := Reconcile(a, b, []string{"temp"}, start, start.Add(time.Second), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := results["temp"], (ReconcileResult{Matching: 2, MissingInB: 1}); got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,149 @@
+package gtsdb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+// ReconnectPolicy controls how the client re-dials after losing its connection
+type ReconnectPolicy struct {
+	// MaxRetries is the number of dial attempts per reconnect; zero makes a single
+	// attempt without backing off
+	MaxRetries int
+	// InitialBackoff is the wait before the second attempt, doubled after each failure
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts
+	MaxBackoff time.Duration
+	// Jitter randomizes each wait by up to this fraction, e.g. 0.2 for ±20%
+	Jitter float64
+}
+
+// DefaultReconnectPolicy is used unless WithReconnect overrides it
+var DefaultReconnectPolicy = ReconnectPolicy{
+	MaxRetries:     5,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	Jitter:         0.2,
+}
+
+// backoff returns the wait before the given attempt, counted from zero
+func (p ReconnectPolicy) backoff(attempt int) time.Duration {
+	return backoff(p.InitialBackoff, p.MaxBackoff, p.Jitter, attempt)
+}
+
+// backoff doubles initial for every attempt after the first, up to maxBackoff, and
+// randomizes the result by the jitter fraction
+func backoff(initial, maxBackoff time.Duration, jitter float64, attempt int) time.Duration {
+	wait := initial
+	for i := 1; i < attempt && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if maxBackoff > 0 && wait > maxBackoff {
+		wait = maxBackoff
+	}
+	if jitter > 0 {
+		wait += time.Duration((rand.Float64()*2 - 1) * jitter * float64(wait))
+	}
+	return wait
+}
+
+// redial opens a connection to replace one that was lost, backing off between
+// failed attempts
+func (c *TSDBClient) redial(ctx context.Context) (net.Conn, error) {
+	policy := c.cfg.Reconnect
+	attempts := policy.MaxRetries
+	if attempts <= 0 {
+		// Reconnecting is disabled, try once and let the operation fail
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(policy.backoff(attempt))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			}
+		}
+		if c.closed.Load() {
+			return nil, ErrClosed
+		}
+
+		var conn net.Conn
+		if conn, err = c.dial(ctx); err == nil {
+			return conn, nil
+		}
+		c.logger.Warn("reconnect attempt failed", "address", c.cfg.Address, "attempt", attempt+1, "error", err)
+	}
+	if attempts == 1 {
+		return nil, err
+	}
+	return nil, fmt.Errorf("giving up after %d attempts: %w", attempts, err)
+}
+
+// reconnectSubscriptions replaces the subscription connection and re-issues the
+// active subscriptions on it. It must be called while holding the connection.
+func (c *TSDBClient) reconnectSubscriptions(ctx context.Context) error {
+	conn, err := c.redial(ctx)
+	if err != nil {
+		return err
+	}
+	if err := c.swapSubConn(conn); err != nil {
+		return err
+	}
+	if err := c.resubscribe(ctx); err != nil {
+		c.subConn.broken = true
+		return err
+	}
+	return nil
+}
+
+// swapSubConn installs a freshly dialed subscription connection in place of the broken one
+func (c *TSDBClient) swapSubConn(conn net.Conn) error {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	if c.closed.Load() {
+		conn.Close()
+		return ErrClosed
+	}
+
+	if c.subConn != nil {
+		c.subConn.conn.Close()
+	}
+	c.subConn = newPoolConn(conn, c.cfg.ReadBufferSize)
+	go c.readUpdates(c.subConn)
+	return nil
+}
+
+// resubscribe re-issues the active subscriptions on the subscription connection
+func (c *TSDBClient) resubscribe(ctx context.Context) error {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	var payload strings.Builder
+	for key := range c.subscriptions {
+		fmt.Fprintf(&payload, "subscribe,%s\n", key)
+	}
+	for key := range c.listeners {
+		if !c.subscriptions[key] {
+			fmt.Fprintf(&payload, "subscribe,%s\n", key)
+		}
+	}
+	if payload.Len() == 0 {
+		return nil
+	}
+
+	conn := c.subConn.conn
+	conn.SetWriteDeadline(deadline(ctx, c.cfg.WriteTimeout))
+	_, err := io.WriteString(conn, payload.String())
+	return err
+}
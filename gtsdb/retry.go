@@ -0,0 +1,116 @@
+package gtsdb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// RetryPolicy controls how failed commands are sent again. Reads and other commands
+// that are safe to repeat are retried whenever the error is retryable. Writes are only
+// retried when the failure happened before any of the command was sent, so they are
+// never applied twice, unless AtLeastOnce accepts duplicates.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts per command; zero or one disables retries
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, doubled after each failure
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts
+	MaxBackoff time.Duration
+	// Jitter randomizes each wait by up to this fraction, e.g. 0.2 for ±20%
+	Jitter float64
+	// Retryable reports whether an error is worth retrying. When nil, lost connections
+	// and an unavailable server are retried, but timeouts are not, since each attempt
+	// would wait out its timeout again.
+	Retryable func(err error) bool
+	// AtLeastOnce also retries writes that may already have been applied
+	AtLeastOnce bool
+}
+
+// DefaultRetryPolicy is used unless WithRetry overrides it. It makes up to three attempts
+// and, like any policy without Retryable, leaves timeouts unretried.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 50 * time.Millisecond,
+	MaxBackoff:     time.Second,
+	Jitter:         0.2,
+}
+
+// retryMode tells whether a command may safely be sent twice
+type retryMode int
+
+const (
+	// idempotent commands, such as reads and deletes, may always be repeated
+	idempotent retryMode = iota
+	// nonIdempotent commands, i.e. writes, are only repeated if certainly not applied
+	nonIdempotent
+)
+
+// unsentError marks a failure that happened before any byte of a command was sent
+type unsentError struct {
+	err error
+}
+
+func (e *unsentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *unsentError) Unwrap() error {
+	return e.err
+}
+
+// retryable is the default classification of errors worth retrying
+func retryable(err error) bool {
+	if errors.Is(err, ErrServerUnavailable) {
+		return true
+	}
+	if isTimeout(err) {
+		return false
+	}
+	var opErr *net.OpError
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, ErrTruncatedResponse) ||
+		errors.As(err, &opErr)
+}
+
+// retry runs attempt until it succeeds, fails with an error that must not be retried
+// or the policy's attempts are used up
+func (c *TSDBClient) retry(ctx context.Context, op string, mode retryMode, attempt func() error) error {
+	policy := c.cfg.Retry
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil || n >= policy.MaxAttempts || !c.shouldRetry(ctx, mode, err) {
+			return err
+		}
+
+		wait := backoff(policy.InitialBackoff, policy.MaxBackoff, policy.Jitter, n)
+		c.logger.Debug("retrying command", "op", op, "attempt", n, "wait", wait, "error", err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// shouldRetry decides whether a failed command is sent again
+func (c *TSDBClient) shouldRetry(ctx context.Context, mode retryMode, err error) bool {
+	if c.closed.Load() || ctx.Err() != nil {
+		return false
+	}
+	classify := c.cfg.Retry.Retryable
+	if classify == nil {
+		classify = retryable
+	}
+	if !classify(err) {
+		return false
+	}
+
+	var unsent *unsentError
+	return mode == idempotent || c.cfg.Retry.AtLeastOnce || errors.As(err, &unsent)
+}
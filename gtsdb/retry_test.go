@@ -0,0 +1,71 @@
+package gtsdb
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	timeout := &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection closed", fmt.Errorf("read data: %w", io.EOF), true},
+		{"connection reset", &net.OpError{Op: "read", Net: "tcp", Err: fmt.Errorf("connection reset by peer")}, true},
+		{"truncated response", &TruncatedResponseError{Partial: "temp,1,"}, true},
+		{"server unavailable", fmt.Errorf("read data: connect: %w: %w", ErrServerUnavailable, timeout), true},
+		{"read timeout", fmt.Errorf("read data: %w", timeout), false},
+		{"server error", &ServerError{Code: "MALFORMED", Message: "bad read"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryReads(t *testing.T) {
+	var dropped atomic.Bool
+	server := newFakeServer(t, func(s *fakeServer) {
+		s.handle = func(conn net.Conn, line string) bool {
+			// Hang up on the first read, as a server restarting would
+			if strings.HasPrefix(line, "temp,") && dropped.CompareAndSwap(false, true) {
+				conn.Close()
+				return true
+			}
+			return false
+		}
+	})
+	client := server.client(t)
+	server.write("temp", 1, 21)
+
+	records, err := client.ReadData("temp", 0, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"temp,1,21"}; !equalStrings(records, want) {
+		t.Fatalf("got %q, want %q", records, want)
+	}
+
+	// A timeout isn't retried, so a hung server costs one read timeout
+	server = newFakeServer(t, func(s *fakeServer) {
+		s.handle = func(conn net.Conn, line string) bool { return true }
+	})
+	client = server.client(t, WithReadTimeout(100*time.Millisecond))
+	if _, err := client.ReadData("temp", 0, 10, 0); !isTimeout(err) {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+	if n := server.count("temp,"); n != 1 {
+		t.Fatalf("read sent %d times, want once", n)
+	}
+}
@@ -0,0 +1,363 @@
+package gtsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultRollupDelay is how long after an interval ends its rollup waits for late points
+	defaultRollupDelay = 30 * time.Second
+	// defaultRollupLookback is how far back rollups reach, the first time and after downtime
+	defaultRollupLookback = 24 * time.Hour
+	// rollupChunk is the span of raw points read at once; it is cut to the client's
+	// maximum time range
+	rollupChunk = 6 * time.Hour
+)
+
+// Rollup is an aggregate of the points of a key per interval, such as the hourly
+// average, kept under a key derived from it
+type Rollup struct {
+	Interval time.Duration
+	// Aggregation combines the points of an interval; AggregateDefault means the average
+	Aggregation Aggregation
+}
+
+// ParseRollup parses a rollup written as the suffix of its keys, an interval followed
+// by an aggregation such as 1h:avg or 5m:max. The interval is a number of seconds,
+// minutes, hours or days, given as s, m, h or d.
+func ParseRollup(s string) (Rollup, error) {
+	interval, aggregation, ok := strings.Cut(s, ":")
+	if !ok {
+		return Rollup{}, fmt.Errorf("rollup %q: expected <interval>:<aggregation>, e.g. 1h:avg", s)
+	}
+	r := Rollup{Aggregation: Aggregation(aggregation)}
+	if r.Aggregation == AggregateDefault {
+		return Rollup{}, fmt.Errorf("rollup %q: no aggregation", s)
+	}
+	if err := r.Aggregation.validate(); err != nil {
+		return Rollup{}, fmt.Errorf("rollup %q: %w", s, err)
+	}
+	units := map[byte]time.Duration{'s': time.Second, 'm': time.Minute, 'h': time.Hour, 'd': 24 * time.Hour}
+	if interval == "" || units[interval[len(interval)-1]] == 0 {
+		return Rollup{}, fmt.Errorf("rollup %q: interval must end in s, m, h or d", s)
+	}
+	n, err := strconv.Atoi(interval[:len(interval)-1])
+	if err != nil || n < 1 {
+		return Rollup{}, fmt.Errorf("rollup %q: %w: %s", s, ErrInvalidInterval, interval)
+	}
+	r.Interval = time.Duration(n) * units[interval[len(interval)-1]]
+	return r, nil
+}
+
+// String returns the rollup as the suffix of its keys, e.g. 1h:avg
+func (r Rollup) String() string {
+	aggregation := r.Aggregation
+	if aggregation == AggregateDefault {
+		aggregation = AggregateAvg
+	}
+	interval := fmt.Sprintf("%ds", int64(r.Interval/time.Second))
+	for _, unit := range []struct {
+		suffix string
+		d      time.Duration
+	}{{"d", 24 * time.Hour}, {"h", time.Hour}, {"m", time.Minute}} {
+		if r.Interval%unit.d == 0 {
+			interval = fmt.Sprintf("%d%s", int64(r.Interval/unit.d), unit.suffix)
+			break
+		}
+	}
+	return interval + ":" + string(aggregation)
+}
+
+// Key returns the key the rollup of key is written under, e.g. sensor1:1h:avg
+func (r Rollup) Key(key string) string {
+	return key + ":" + r.String()
+}
+
+// isRollupKey reports whether key looks like it holds a rollup, so that rollups are
+// never rolled up again
+func isRollupKey(key string) bool {
+	i := strings.LastIndexByte(key, ':')
+	if i < 0 {
+		return false
+	}
+	j := strings.LastIndexByte(key[:i], ':')
+	_, err := ParseRollup(key[j+1:])
+	return err == nil
+}
+
+// RollupOption configures a RollupScheduler
+type RollupOption func(*rollupOptions)
+
+type rollupOptions struct {
+	delay    time.Duration
+	lookback time.Duration
+	every    time.Duration
+}
+
+// WithRollupDelay waits that long after an interval ends before rolling it up, leaving
+// late points time to arrive. It is 30 seconds by default.
+func WithRollupDelay(d time.Duration) RollupOption {
+	return func(o *rollupOptions) {
+		o.delay = d
+	}
+}
+
+// WithRollupLookback bounds how far back rollups are computed: from that long ago for
+// keys never rolled up, and at most that far back when catching up after downtime. It
+// is 24 hours by default.
+func WithRollupLookback(d time.Duration) RollupOption {
+	return func(o *rollupOptions) {
+		o.lookback = d
+	}
+}
+
+// WithRollupEvery sets how often Run catches the rollups up, the shortest rollup
+// interval by default
+func WithRollupEvery(d time.Duration) RollupOption {
+	return func(o *rollupOptions) {
+		o.every = d
+	}
+}
+
+// RollupScheduler keeps rollups of raw series up to date: it reads the points of the
+// intervals that ended since it last ran and writes their aggregates under derived keys,
+// such as sensor1:1h:avg, giving cheap aggregates to keep longer than the raw points.
+// Progress is the last point of each rollup key, so a scheduler started again after
+// downtime catches up from where the rollups stopped. An interval without points gets
+// no rollup point.
+type RollupScheduler struct {
+	client  *TSDBClient
+	pattern string
+	// groups are the rollups sharing an interval, computed from the same reads
+	groups  [][]Rollup
+	options rollupOptions
+	// progress is the start of the next interval to roll up per rollup key, once
+	// known; only Run and RunOnce use it, one at a time
+	progress map[string]time.Time
+}
+
+// NewRollupScheduler creates a scheduler of rollups for the keys matching pattern, a
+// key or a shell pattern as taken by ListKeysMatching. Keys that hold rollups
+// themselves are skipped. Intervals are whole numbers of seconds.
+func NewRollupScheduler(client *TSDBClient, pattern string, rollups []Rollup, opts ...RollupOption) (*RollupScheduler, error) {
+	if len(rollups) == 0 {
+		return nil, fmt.Errorf("rollup: no rollups")
+	}
+	s := &RollupScheduler{
+		client:   client,
+		pattern:  pattern,
+		options:  rollupOptions{delay: defaultRollupDelay, lookback: defaultRollupLookback},
+		progress: make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(&s.options)
+	}
+	if s.options.delay < 0 || s.options.lookback <= 0 || s.options.every < 0 {
+		return nil, fmt.Errorf("rollup: %w: the delay and period must not be negative, nor the lookback zero", ErrInvalidInterval)
+	}
+
+	seen := make(map[string]bool, len(rollups))
+	byInterval := make(map[time.Duration]int)
+	for _, r := range rollups {
+		if r.Interval < time.Second || r.Interval%time.Second != 0 {
+			return nil, fmt.Errorf("rollup: %w: %s is not a whole number of seconds", ErrInvalidInterval, r.Interval)
+		}
+		if err := r.Aggregation.validate(); err != nil {
+			return nil, fmt.Errorf("rollup: %w", err)
+		}
+		if r.Aggregation == AggregateDefault {
+			r.Aggregation = AggregateAvg
+		}
+		if seen[r.String()] {
+			return nil, fmt.Errorf("rollup: %s given twice", r)
+		}
+		seen[r.String()] = true
+		if limit := client.cfg.MaxTimeRange; limit > 0 && r.Interval > limit {
+			return nil, fmt.Errorf("rollup: %w: %s exceeds the maximum time range of %s", ErrRangeTooLarge, r, limit)
+		}
+		i, ok := byInterval[r.Interval]
+		if !ok {
+			i = len(s.groups)
+			byInterval[r.Interval] = i
+			s.groups = append(s.groups, nil)
+		}
+		s.groups[i] = append(s.groups[i], r)
+	}
+	if s.options.every == 0 {
+		s.options.every = s.groups[0][0].Interval
+		for _, group := range s.groups[1:] {
+			s.options.every = min(s.options.every, group[0].Interval)
+		}
+	}
+	return s, nil
+}
+
+// Run catches the rollups up every period until ctx is done, logging the failures of
+// a run rather than stopping; it returns ctx's error
+func (s *RollupScheduler) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.options.every)
+	defer ticker.Stop()
+	for {
+		written, err := s.RunOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			s.client.logger.Warn("rollup run failed", "pattern", s.pattern, "written", written, "error", err)
+		} else if written > 0 {
+			s.client.logger.Debug("rollups written", "pattern", s.pattern, "points", written)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// RunOnce rolls up the intervals of every matching key that ended since the last run,
+// returning the number of rollup points written. A key failing doesn't stop the others;
+// the errors are joined.
+func (s *RollupScheduler) RunOnce(ctx context.Context) (int, error) {
+	keys := []string{s.pattern}
+	if strings.ContainsAny(s.pattern, `*?[\`) {
+		var err error
+		if keys, err = s.client.ListKeysMatchingContext(ctx, s.pattern); err != nil {
+			return 0, fmt.Errorf("rollup: %w", err)
+		}
+	}
+	now := time.Now()
+	written := 0
+	var errs []error
+	for _, key := range keys {
+		if isRollupKey(key) {
+			continue
+		}
+		for _, group := range s.groups {
+			n, err := s.rollUp(ctx, key, group, now)
+			written += n
+			if err != nil {
+				if ctx.Err() != nil {
+					return written, ctx.Err()
+				}
+				errs = append(errs, fmt.Errorf("rollup %s: %w", key, err))
+			}
+		}
+	}
+	return written, errors.Join(errs...)
+}
+
+// rollUp computes the rollups of key sharing an interval over the intervals that
+// ended by now and weren't rolled up yet, reading the raw points once for all of them
+func (s *RollupScheduler) rollUp(ctx context.Context, key string, group []Rollup, now time.Time) (int, error) {
+	interval := group[0].Interval
+	oldest := now.Add(-s.options.lookback).Truncate(interval)
+	end := now.Add(-s.options.delay).Truncate(interval)
+
+	next := make([]time.Time, len(group))
+	from := end
+	for i, r := range group {
+		start, ok := s.progress[r.Key(key)]
+		if !ok {
+			last, err := s.client.latestPoint(ctx, r.Key(key))
+			switch {
+			case errors.Is(err, ErrNoData):
+				start = oldest
+			case err != nil:
+				return 0, err
+			default:
+				start = last.Timestamp.Truncate(interval).Add(interval)
+			}
+		}
+		if start.Before(oldest) {
+			start = oldest
+		}
+		next[i] = start
+		if start.Before(from) {
+			from = start
+		}
+	}
+
+	chunk := rollupChunk.Truncate(interval)
+	if limit := s.client.cfg.MaxTimeRange; limit > 0 && limit < chunk {
+		chunk = limit.Truncate(interval)
+	}
+	chunk = max(chunk, interval)
+	unit := s.client.cfg.TimestampResolution.Unit()
+	written := 0
+	for from.Before(end) {
+		to := from.Add(chunk)
+		if to.After(end) {
+			to = end
+		}
+		points, err := s.client.readSorted(ctx, key, from, to.Add(-unit))
+		if err != nil && !errors.Is(err, ErrNoData) && !errors.Is(err, ErrKeyNotFound) {
+			return written, err
+		}
+
+		var out []DataPoint
+		for _, b := range summarize(s.client.aggregatable(points), interval) {
+			for i, r := range group {
+				if !b.start.Before(next[i]) {
+					out = append(out, DataPoint{Key: r.Key(key), Timestamp: b.start, Value: b.value(r.Aggregation)})
+				}
+			}
+		}
+		if len(out) > 0 {
+			// Progress is read back from the rollup keys, so a chunk must be stored
+			// before the next one is computed
+			if err := s.client.WriteBatchSyncContext(ctx, out); err != nil {
+				return written, err
+			}
+			written += len(out)
+		}
+		for i, r := range group {
+			if next[i].Before(to) {
+				next[i] = to
+			}
+			s.progress[r.Key(key)] = next[i]
+		}
+		from = to
+	}
+	return written, nil
+}
+
+// rollupBucket accumulates the points of an interval
+type rollupBucket struct {
+	start         time.Time
+	min, max, sum float64
+	count         int
+}
+
+func (b rollupBucket) value(aggregation Aggregation) float64 {
+	switch aggregation {
+	case AggregateMin:
+		return b.min
+	case AggregateMax:
+		return b.max
+	case AggregateSum:
+		return b.sum
+	case AggregateCount:
+		return float64(b.count)
+	}
+	return b.sum / float64(b.count)
+}
+
+// summarize accumulates points, sorted by timestamp, into the intervals holding them
+func summarize(points []DataPoint, interval time.Duration) []rollupBucket {
+	var buckets []rollupBucket
+	for _, p := range points {
+		start := p.Timestamp.Truncate(interval)
+		if len(buckets) == 0 || !buckets[len(buckets)-1].start.Equal(start) {
+			buckets = append(buckets, rollupBucket{start: start, min: math.Inf(1), max: math.Inf(-1)})
+		}
+		b := &buckets[len(buckets)-1]
+		b.min, b.max = min(b.min, p.Value), max(b.max, p.Value)
+		b.sum += p.Value
+		b.count++
+	}
+	return buckets
+}
@@ -0,0 +1,230 @@
+package gtsdb
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PointIterator yields the points of a read response as they arrive off the wire.
+// Like sql.Rows it is advanced with Next, and Err reports why iteration stopped.
+// It holds a pooled connection until Close is called or the response is exhausted.
+type PointIterator struct {
+	c    *TSDBClient
+	ctx  context.Context
+	pc   *poolConn
+	stop func() bool
+	// observed reports the outcome of the read to the client's observers
+	observed func(err error)
+	// interrupted is closed once a cancellation has expired the deadline
+	interrupted chan struct{}
+
+	framed bool
+	// remaining is the number of ProtocolV2 block bytes left to read
+	remaining int64
+	record    strings.Builder
+
+	point  DataPoint
+	index  int
+	err    error
+	done   bool
+	closed bool
+}
+
+// ReadStream reads the points of key between startTime and endTime without holding the
+// whole response in memory. The per-read timeout applies between the chunks received
+// rather than to the whole response. The query cache is bypassed.
+func (c *TSDBClient) ReadStream(key string, startTime, endTime int64, downsampling int) (*PointIterator, error) {
+	return c.ReadStreamContext(context.Background(), key, startTime, endTime, downsampling)
+}
+
+// ReadStreamContext is like ReadStream but honours ctx for cancellation and deadlines
+// for as long as the iterator is in use
+func (c *TSDBClient) ReadStreamContext(ctx context.Context, key string, startTime, endTime int64, downsampling int) (*PointIterator, error) {
+	const op = "read stream"
+	if err := c.checkRange(startTime, endTime); err != nil {
+		return nil, err
+	}
+	line, err := formatCommand(op, "%s,%d,%d,%d\n", key, startTime, endTime, downsampling)
+	if err != nil {
+		return nil, err
+	}
+	if c.closed.Load() {
+		return nil, fmt.Errorf("%s: %w", op, ErrClosed)
+	}
+
+	it := &PointIterator{c: c, ctx: ctx, framed: c.cfg.ProtocolVersion == ProtocolV2}
+	it.observed = c.observe(ctx, Command{Op: op, Key: key})
+	err = c.guard(ctx, op, func() error {
+		pc, err := c.pool.get(ctx)
+		if err != nil {
+			return c.connErr(ctx, op, fmt.Errorf("%s: connect: %w: %w", op, ErrServerUnavailable, err))
+		}
+		if err := c.converse(ctx, op, pc, c.cfg.ReadTimeout, line, it.readHeader); err != nil {
+			c.pool.put(pc, true)
+			return err
+		}
+		it.pc = pc
+		return nil
+	})
+	if err != nil {
+		it.observed(err)
+		return nil, err
+	}
+
+	conn := it.pc.conn
+	it.interrupted = make(chan struct{})
+	it.stop = context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+		close(it.interrupted)
+	})
+	return it, nil
+}
+
+// readHeader consumes what precedes the records: the block length under ProtocolV2, or
+// an error reply in place of the records under ProtocolV1
+func (it *PointIterator) readHeader(pc *poolConn) error {
+	if !it.framed {
+		// Peek only as far as the reply still looks like an error, so that a short
+		// response doesn't block waiting for bytes that never come
+		const errPrefix = "ERR "
+		for n := 1; n <= len(errPrefix); n++ {
+			prefix, err := pc.reader.Peek(n)
+			if err != nil {
+				if isTimeout(err) {
+					pc.stale = &staleResponse{}
+				}
+				return err
+			}
+			if prefix[n-1] != errPrefix[n-1] {
+				return nil
+			}
+		}
+		_, err := pc.readLine()
+		return err
+	}
+
+	header, err := pc.readLine()
+	if err != nil {
+		if pc.stale != nil {
+			pc.stale.framed = true
+		}
+		return err
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(header), 10, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid response block length %q", strings.TrimSpace(header))
+	}
+	it.remaining = size
+	return nil
+}
+
+// Next advances to the next point, returning false at the end of the response or
+// when reading fails
+func (it *PointIterator) Next() bool {
+	if it.done || it.closed {
+		return false
+	}
+
+	for {
+		record, last, err := it.readRecord()
+		if err != nil {
+			it.fail(it.c.connErr(it.ctx, "read stream", err))
+			return false
+		}
+		if record = strings.TrimSpace(record); record != "" {
+			point, err := it.c.parseMeasurement(record)
+			if err != nil {
+				it.fail(&RecordError{Index: it.index, Record: record, Err: err})
+				return false
+			}
+			it.point = point
+			it.index++
+			if last {
+				it.finish()
+			}
+			return true
+		}
+		if last {
+			it.finish()
+			return false
+		}
+	}
+}
+
+// readRecord reads up to the next record delimiter, reporting whether the response ended
+func (it *PointIterator) readRecord() (string, bool, error) {
+	pc := it.pc
+	pc.conn.SetReadDeadline(deadline(it.ctx, it.c.cfg.ReadTimeout))
+	if err := it.ctx.Err(); err != nil {
+		// Checked after the deadline is set, so a cancellation can't be overridden by it
+		return "", false, err
+	}
+
+	delimiter := it.c.cfg.ResponseDelimiter
+	if it.framed {
+		delimiter = "\n"
+	}
+	it.record.Reset()
+	for {
+		if it.framed && it.remaining == 0 {
+			return it.record.String(), true, nil
+		}
+		b, err := pc.reader.ReadByte()
+		if err != nil {
+			return "", false, err
+		}
+		if it.framed {
+			it.remaining--
+		} else if b == '\n' {
+			return it.record.String(), true, nil
+		}
+		it.record.WriteByte(b)
+		if record := it.record.String(); strings.HasSuffix(record, delimiter) {
+			return strings.TrimSuffix(record, delimiter), false, nil
+		}
+	}
+}
+
+// Point returns the point Next advanced to
+func (it *PointIterator) Point() DataPoint {
+	return it.point
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *PointIterator) Err() error {
+	return it.err
+}
+
+// Close releases the connection. Closing before the end of the response drops the
+// connection rather than reading out the rest. It is safe to call more than once.
+func (it *PointIterator) Close() error {
+	if !it.done {
+		it.pc.broken = true
+		it.finish()
+	}
+	it.closed = true
+	return nil
+}
+
+func (it *PointIterator) fail(err error) {
+	it.err = err
+	it.pc.broken = true
+	it.finish()
+}
+
+// finish returns the connection to the pool once the iteration is over
+func (it *PointIterator) finish() {
+	if it.done {
+		return
+	}
+	it.done = true
+	if !it.stop() {
+		// Don't let the interruption leak into the next exchange
+		<-it.interrupted
+	}
+	it.c.pool.put(it.pc, true)
+	it.observed(it.err)
+}
@@ -0,0 +1,372 @@
+package gtsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// subscriptionBuffer is the number of updates buffered per channel before the oldest is dropped
+const subscriptionBuffer = 64
+
+// listener receives the pushed updates of the keys it is registered for
+type listener struct {
+	deliver   func(DataPoint)
+	close     func()
+	closeOnce sync.Once
+}
+
+func (l *listener) stop() {
+	l.closeOnce.Do(l.close)
+}
+
+// Subscribe subscribes to updates for a given key. Subscriptions are re-issued
+// automatically when the client reconnects.
+func (c *TSDBClient) Subscribe(key string) error {
+	return c.SubscribeContext(context.Background(), key)
+}
+
+// SubscribeContext is like Subscribe but honours ctx for cancellation and deadlines
+func (c *TSDBClient) SubscribeContext(ctx context.Context, key string) error {
+	line, err := formatCommand("subscribe", "subscribe,%s\n", key)
+	if err != nil {
+		return err
+	}
+
+	return c.updateSubscriptions(ctx, "subscribe", func() ([]string, func()) {
+		if c.subscriptions[key] {
+			return nil, nil
+		}
+		watched := c.watched(key)
+		c.subscriptions[key] = true
+		undo := func() { delete(c.subscriptions, key) }
+		if watched {
+			return nil, undo
+		}
+		return []string{line}, undo
+	})
+}
+
+// Unsubscribe unsubscribes from updates for a given key
+func (c *TSDBClient) Unsubscribe(key string) error {
+	return c.UnsubscribeContext(context.Background(), key)
+}
+
+// UnsubscribeContext is like Unsubscribe but honours ctx for cancellation and deadlines
+func (c *TSDBClient) UnsubscribeContext(ctx context.Context, key string) error {
+	line, err := formatCommand("unsubscribe", "unsubscribe,%s\n", key)
+	if err != nil {
+		return err
+	}
+
+	return c.updateSubscriptions(ctx, "unsubscribe", func() ([]string, func()) {
+		delete(c.subscriptions, key)
+		if c.watched(key) {
+			// A stream still wants the updates of this key
+			return nil, nil
+		}
+		return []string{line}, nil
+	})
+}
+
+// Subscription delivers the updates pushed for a set of keys on a single channel
+type Subscription struct {
+	c         *TSDBClient
+	listeners map[string]*listener
+	updates   chan DataPoint
+}
+
+// SubscribeStream subscribes to keys and delivers their updates on a single channel.
+// Updates arrive on the client's subscription connection, which is re-dialed with its
+// subscriptions after it is lost. A slow consumer loses the oldest buffered updates
+// rather than stalling other subscriptions. The channel is closed by Close, on the
+// subscription or the client.
+func (c *TSDBClient) SubscribeStream(keys ...string) (*Subscription, error) {
+	return c.SubscribeStreamContext(context.Background(), keys...)
+}
+
+// SubscribeStreamContext is like SubscribeStream but honours ctx for cancellation and deadlines
+func (c *TSDBClient) SubscribeStreamContext(ctx context.Context, keys ...string) (*Subscription, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("subscribe stream: no keys to subscribe to")
+	}
+
+	updates := make(chan DataPoint, subscriptionBuffer)
+	l := &listener{
+		deliver: func(point DataPoint) {
+			if deliverDropOldest(updates, point) {
+				c.logger.Debug("subscription buffer full, dropped oldest update", "key", point.Key)
+			}
+		},
+		close: func() { close(updates) },
+	}
+	s := &Subscription{c: c, listeners: make(map[string]*listener, len(keys)), updates: updates}
+	for _, key := range keys {
+		s.listeners[key] = l
+	}
+
+	if err := c.listen(ctx, "subscribe stream", s.listeners); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Updates returns the channel on which the subscription's updates are delivered
+func (s *Subscription) Updates() <-chan DataPoint {
+	return s.updates
+}
+
+// Close unsubscribes from the keys no other subscription wants and closes the
+// updates channel. It is safe to call more than once.
+func (s *Subscription) Close() error {
+	return s.c.unlisten(context.Background(), "close subscription", s.listeners)
+}
+
+// SubscribeChannels subscribes to all keys and routes the pushed updates into one
+// channel per key. A slow consumer loses the oldest buffered updates rather than
+// stalling the others. The returned cancel function unsubscribes and closes every channel.
+func (c *TSDBClient) SubscribeChannels(keys []string) (map[string]<-chan Measurement, func(), error) {
+	return c.SubscribeChannelsContext(context.Background(), keys)
+}
+
+// SubscribeChannelsContext is like SubscribeChannels but honours ctx for cancellation and deadlines
+func (c *TSDBClient) SubscribeChannelsContext(ctx context.Context, keys []string) (map[string]<-chan Measurement, func(), error) {
+	if len(keys) == 0 {
+		return nil, nil, fmt.Errorf("no keys to subscribe to")
+	}
+
+	listeners := make(map[string]*listener, len(keys))
+	result := make(map[string]<-chan Measurement, len(keys))
+	for _, key := range keys {
+		ch := make(chan Measurement, subscriptionBuffer)
+		listeners[key] = &listener{
+			deliver: func(point DataPoint) {
+				if deliverDropOldest(ch, Measurement{Timestamp: point.Timestamp, Value: point.Value}) {
+					c.logger.Debug("subscription buffer full, dropped oldest update", "key", point.Key)
+				}
+			},
+			close: func() { close(ch) },
+		}
+		result[key] = ch
+	}
+
+	if err := c.listen(ctx, "subscribe channels", listeners); err != nil {
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			c.unlisten(context.Background(), "cancel subscription", listeners)
+		})
+	}
+	return result, cancel, nil
+}
+
+// listen registers listeners per key and subscribes to the keys nobody watched yet
+func (c *TSDBClient) listen(ctx context.Context, op string, listeners map[string]*listener) error {
+	lines := make(map[string]string, len(listeners))
+	for key := range listeners {
+		line, err := formatCommand(op, "subscribe,%s\n", key)
+		if err != nil {
+			return err
+		}
+		lines[key] = line
+	}
+
+	return c.updateSubscriptions(ctx, op, func() ([]string, func()) {
+		var commands []string
+		for key, l := range listeners {
+			if !c.watched(key) {
+				commands = append(commands, lines[key])
+			}
+			if c.listeners[key] == nil {
+				c.listeners[key] = make(map[*listener]struct{})
+			}
+			c.listeners[key][l] = struct{}{}
+		}
+		return commands, func() { c.removeListeners(listeners) }
+	})
+}
+
+// unlisten removes listeners, closes them and unsubscribes from the keys nobody watches any more
+func (c *TSDBClient) unlisten(ctx context.Context, op string, listeners map[string]*listener) error {
+	err := c.updateSubscriptions(ctx, op, func() ([]string, func()) {
+		var commands []string
+		for _, key := range c.removeListeners(listeners) {
+			commands = append(commands, "unsubscribe,"+key+"\n")
+		}
+		return commands, nil
+	})
+	if errors.Is(err, ErrClosed) {
+		// Closing the client already closed every listener
+		return nil
+	}
+
+	// Stop delivering even if the server could not be told
+	c.subMu.Lock()
+	c.removeListeners(listeners)
+	for _, l := range listeners {
+		l.stop()
+	}
+	c.subMu.Unlock()
+	return err
+}
+
+// removeListeners unregisters listeners, returning the keys nobody watches any more.
+// It must be called while holding subMu.
+func (c *TSDBClient) removeListeners(listeners map[string]*listener) []string {
+	var unwatched []string
+	for key, l := range listeners {
+		registered, ok := c.listeners[key]
+		if !ok {
+			continue
+		}
+		delete(registered, l)
+		if len(registered) == 0 {
+			delete(c.listeners, key)
+			if !c.subscriptions[key] {
+				unwatched = append(unwatched, key)
+			}
+		}
+	}
+	return unwatched
+}
+
+// watched reports whether the server is subscribed to key. It must be called while holding subMu.
+func (c *TSDBClient) watched(key string) bool {
+	return c.subscriptions[key] || len(c.listeners[key]) > 0
+}
+
+// closeListeners closes every listener when the client is closed
+func (c *TSDBClient) closeListeners() {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	for key, registered := range c.listeners {
+		for l := range registered {
+			l.stop()
+		}
+		delete(c.listeners, key)
+	}
+}
+
+// updateSubscriptions lets update change the subscription state while holding a live
+// subscription connection, then sends the commands it returns. The connection is dialed
+// on first use and re-dialed after it broke. If the commands can't be sent, undo, when
+// given, reverts the change.
+func (c *TSDBClient) updateSubscriptions(ctx context.Context, op string, update func() (commands []string, undo func())) (err error) {
+	done := c.observe(ctx, Command{Op: op})
+	defer func() { done(err) }()
+
+	if c.closed.Load() {
+		return fmt.Errorf("%s: %w", op, ErrClosed)
+	}
+
+	if err := c.lock(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer c.unlock()
+
+	if c.subConn == nil || c.subConn.broken {
+		if err := c.reconnectSubscriptions(ctx); err != nil {
+			return c.connErr(ctx, op, fmt.Errorf("%s: reconnect: %w: %w", op, ErrServerUnavailable, err))
+		}
+	}
+
+	c.subMu.Lock()
+	if c.closed.Load() {
+		// Close may already have closed the listeners, don't register new ones
+		c.subMu.Unlock()
+		return fmt.Errorf("%s: %w", op, ErrClosed)
+	}
+	commands, undo := update()
+	c.subMu.Unlock()
+	if len(commands) == 0 {
+		return nil
+	}
+
+	if err := c.converse(ctx, op, c.subConn, 0, strings.Join(commands, ""), nil); err != nil {
+		if undo != nil {
+			c.subMu.Lock()
+			undo()
+			c.subMu.Unlock()
+		}
+		return err
+	}
+	return nil
+}
+
+// readUpdates dispatches the updates pushed on a subscription connection to the listeners
+// of their keys. When the connection fails it is replaced, unless the client was closed or
+// a command already replaced it.
+func (c *TSDBClient) readUpdates(pc *poolConn) {
+	var partial string
+	for {
+		line, err := pc.reader.ReadString('\n')
+		if err != nil {
+			if isTimeout(err) && !c.closed.Load() {
+				// A command interrupted by its context expired the read deadline too
+				partial += line
+				pc.conn.SetReadDeadline(time.Time{})
+				continue
+			}
+			break
+		}
+		line, partial = partial+line, ""
+
+		point, err := c.parseMeasurement(line)
+		if err != nil {
+			// Not an update, e.g. the reply to a rejected command
+			c.logger.Debug("ignoring unexpected line on subscription connection", "line", strings.TrimSpace(line))
+			continue
+		}
+		if c.cache != nil {
+			c.cache.update(point, c.timestamp(point.Timestamp))
+		}
+		c.subMu.Lock()
+		for l := range c.listeners[point.Key] {
+			l.deliver(point)
+		}
+		c.subMu.Unlock()
+	}
+
+	if c.closed.Load() {
+		return
+	}
+	c.logger.Warn("subscription connection lost", "address", c.cfg.Address)
+	c.lock(context.Background())
+	defer c.unlock()
+	if c.subConn == pc {
+		pc.broken = true
+		// If this fails the next subscription command tries again
+		c.reconnectSubscriptions(context.Background())
+	}
+}
+
+// deliverDropOldest sends v on ch, discarding the oldest buffered value when ch is full,
+// and reports whether one was discarded. Callers must not deliver to the same channel
+// concurrently.
+func deliverDropOldest[T any](ch chan T, v T) bool {
+	select {
+	case ch <- v:
+		return false
+	default:
+	}
+
+	dropped := false
+	select {
+	case <-ch:
+		dropped = true
+	default:
+	}
+
+	select {
+	case ch <- v:
+	default:
+	}
+	return dropped
+}
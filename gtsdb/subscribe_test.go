@@ -0,0 +1,72 @@
+package gtsdb
+
+import (
+	"testing"
+	"time"
+)
+
+// subscribed reports whether some connection is subscribed to key
+func (s *fakeServer) subscribed(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subscribers[key]) > 0
+}
+
+func TestSubscribeChannels(t *testing.T) {
+	server := newFakeServer(t)
+	client := server.client(t)
+
+	channels, cancel, err := client.SubscribeChannels([]string{"temp", "humidity"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "both keys were subscribed", func() bool { return server.subscribed("temp") && server.subscribed("humidity") })
+
+	server.write("temp", 1700000000, 21.5)
+	server.write("pressure", 1700000000, 1013)
+	server.write("humidity", 1700000000, 40)
+	server.write("temp", 1700000001, 22)
+
+	receive := func(key string) Measurement {
+		t.Helper()
+		select {
+		case m, ok := <-channels[key]:
+			if !ok {
+				t.Fatalf("%s channel closed", key)
+			}
+			return m
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no update for %s", key)
+		}
+		return Measurement{}
+	}
+	for _, want := range []struct {
+		key   string
+		ts    int64
+		value float64
+	}{
+		{"temp", 1700000000, 21.5},
+		{"temp", 1700000001, 22},
+		{"humidity", 1700000000, 40},
+	} {
+		if m := receive(want.key); m.Value != want.value || !m.Timestamp.Equal(time.Unix(want.ts, 0)) {
+			t.Fatalf("%s got %g at %s, want %g at %s", want.key, m.Value, m.Timestamp, want.value, time.Unix(want.ts, 0))
+		}
+	}
+	if len(channels) != 2 {
+		t.Fatalf("got channels for %d keys, want 2", len(channels))
+	}
+
+	cancel()
+	for key, ch := range channels {
+		select {
+		case m, ok := <-ch:
+			if ok {
+				t.Fatalf("%s got %+v after the last update", key, m)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s channel not closed by cancel", key)
+		}
+	}
+	eventually(t, "the keys were unsubscribed", func() bool { return !server.subscribed("temp") && !server.subscribed("humidity") })
+}
@@ -0,0 +1,83 @@
+package gtsdb
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// TieredClient presents a hot and a cold backend as a single TSDB. Points younger
+// than the recency window are written to and read from the hot client, older ones
+// go to the cold client, and reads spanning the boundary merge both tiers. Timestamps
+// are passed to the tiers as they are, so both must use the same timestamp resolution.
+type TieredClient struct {
+	hot        ReadWriter
+	cold       ReadWriter
+	recency    time.Duration
+	resolution TimestampResolution
+}
+
+var _ ReadWriter = (*TieredClient)(nil)
+
+// NewTieredClient creates a TieredClient keeping the last recency worth of data on hot
+func NewTieredClient(hot, cold ReadWriter, recency time.Duration) *TieredClient {
+	return &TieredClient{hot: hot, cold: cold, recency: recency, resolution: resolutionOf(hot)}
+}
+
+// cutoff returns the first timestamp served by the hot tier
+func (t *TieredClient) cutoff() int64 {
+	return t.resolution.FromTime(time.Now().Add(-t.recency))
+}
+
+func (t *TieredClient) timestampResolution() TimestampResolution {
+	return t.resolution
+}
+
+// WriteData writes a data point to the tier owning its timestamp
+func (t *TieredClient) WriteData(key string, timestamp int64, value float64) error {
+	return t.WriteDataContext(context.Background(), key, timestamp, value)
+}
+
+// WriteDataContext is like WriteData but honours ctx for cancellation and deadlines
+func (t *TieredClient) WriteDataContext(ctx context.Context, key string, timestamp int64, value float64) error {
+	if timestamp >= t.cutoff() {
+		return t.hot.WriteDataContext(ctx, key, timestamp, value)
+	}
+	return t.cold.WriteDataContext(ctx, key, timestamp, value)
+}
+
+// ReadData reads from the tiers covering the time range. Records of a spanning read
+// come back in time order, the cold ones before the hot ones.
+func (t *TieredClient) ReadData(key string, startTime, endTime int64, downsampling int) ([]string, error) {
+	return t.ReadDataContext(context.Background(), key, startTime, endTime, downsampling)
+}
+
+// ReadDataContext is like ReadData but honours ctx for cancellation and deadlines
+func (t *TieredClient) ReadDataContext(ctx context.Context, key string, startTime, endTime int64, downsampling int) ([]string, error) {
+	if err := validateRange(startTime, endTime); err != nil {
+		return nil, err
+	}
+
+	cutoff := t.cutoff()
+	switch {
+	case startTime >= cutoff:
+		return t.hot.ReadDataContext(ctx, key, startTime, endTime, downsampling)
+	case endTime < cutoff:
+		return t.cold.ReadDataContext(ctx, key, startTime, endTime, downsampling)
+	}
+
+	cold, err := t.cold.ReadDataContext(ctx, key, startTime, cutoff-1, downsampling)
+	if err != nil {
+		return nil, err
+	}
+	hot, err := t.hot.ReadDataContext(ctx, key, cutoff, endTime, downsampling)
+	if err != nil {
+		return nil, err
+	}
+	return append(cold, hot...), nil
+}
+
+// Close closes both tiers
+func (t *TieredClient) Close() error {
+	return errors.Join(t.hot.Close(), t.cold.Close())
+}
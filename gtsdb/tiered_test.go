@@ -0,0 +1,118 @@
+package gtsdb
+
+import (
+	"testing"
+	"time"
+)
+
+// values parses records into their values, failing the test on a bad one
+func values(t *testing.T, records []string) []float64 {
+	t.Helper()
+	var values []float64
+	for _, record := range records {
+		point, err := ParseMeasurement(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, point.Value)
+	}
+	return values
+}
+
+func equalValues(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTieredClientMergesTiers(t *testing.T) {
+	hotServer, coldServer := newFakeServer(t), newFakeServer(t)
+	hot, cold := hotServer.client(t), coldServer.client(t)
+	tiered := NewTieredClient(hot, cold, time.Hour)
+
+	now := time.Now().Unix()
+	points := []struct {
+		ts    int64
+		value float64
+	}{
+		{now - 3*3600, 1}, // cold
+		{now - 2*3600, 2}, // cold
+		{now - 600, 3},    // hot
+		{now - 60, 4},     // hot
+	}
+	for _, p := range points {
+		if err := tiered.WriteData("temp", p.ts, p.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each point was written to the tier owning it only
+	records, err := cold.ReadData("temp", 0, now, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := values(t, records); !equalValues(got, []float64{1, 2}) {
+		t.Fatalf("cold tier holds %v, want [1 2]", got)
+	}
+	records, err = hot.ReadData("temp", 0, now, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := values(t, records); !equalValues(got, []float64{3, 4}) {
+		t.Fatalf("hot tier holds %v, want [3 4]", got)
+	}
+
+	tests := []struct {
+		name       string
+		start, end int64
+		want       []float64
+	}{
+		{"spanning", now - 4*3600, now, []float64{1, 2, 3, 4}},
+		{"spanning from the middle of the cold tier", now - 2*3600, now - 300, []float64{2, 3}},
+		{"cold only", now - 4*3600, now - 2*3600, []float64{1, 2}},
+		{"hot only", now - 900, now, []float64{3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := tiered.ReadData("temp", tt.start, tt.end, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := values(t, records); !equalValues(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTieredClientMilliseconds(t *testing.T) {
+	hotServer, coldServer := newFakeServer(t), newFakeServer(t)
+	hot := hotServer.client(t, WithTimestampResolution(Milliseconds))
+	cold := coldServer.client(t, WithTimestampResolution(Milliseconds))
+	tiered := NewTieredClient(hot, cold, time.Hour)
+
+	recent := time.Now().Add(-time.Minute).UnixMilli()
+	old := time.Now().Add(-2 * time.Hour).UnixMilli()
+	if err := tiered.WriteData("temp", recent, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := tiered.WriteData("temp", old, 2); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the recent point reached the hot tier", func() bool { return hotServer.count("temp,") == 1 })
+	eventually(t, "the old point reached the cold tier", func() bool { return coldServer.count("temp,") == 1 })
+
+	records, err := tiered.ReadData("temp", old, recent, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %q, want both points", records)
+	}
+}
@@ -0,0 +1,87 @@
+package gtsdb
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WatermarkReader pulls the points of each key incrementally. The timestamp of the
+// last synced point per key is kept through caller-supplied load and save callbacks,
+// so repeated pulls are idempotent across restarts.
+type WatermarkReader struct {
+	client *TSDBClient
+	load   func(key string) (watermark int64, ok bool, err error)
+	save   func(key string, watermark int64) error
+
+	// lookback is how far back the first-ever read of a key goes
+	lookback time.Duration
+}
+
+// NewWatermarkReader creates a WatermarkReader. load reports the stored watermark of a
+// key (ok is false if it was never synced) and save persists a new one.
+func NewWatermarkReader(client *TSDBClient, load func(key string) (int64, bool, error), save func(key string, watermark int64) error, lookback time.Duration) *WatermarkReader {
+	return &WatermarkReader{
+		client:   client,
+		load:     load,
+		save:     save,
+		lookback: lookback,
+	}
+}
+
+// ReadSince returns the points of key recorded after its stored watermark and advances
+// the watermark to the newest one. A key without a watermark is read from the configured
+// lookback. Watermarks are timestamps in the client's resolution, so points that arrive
+// late for an already synced timestamp are not picked up.
+func (w *WatermarkReader) ReadSince(key string) ([]DataPoint, error) {
+	return w.ReadSinceContext(context.Background(), key)
+}
+
+// ReadSinceContext is like ReadSince but honours ctx for cancellation and deadlines
+func (w *WatermarkReader) ReadSinceContext(ctx context.Context, key string) ([]DataPoint, error) {
+	watermark, ok, err := w.load(key)
+	if err != nil {
+		return nil, fmt.Errorf("load watermark for %s: %w", key, err)
+	}
+
+	now := time.Now()
+	endTime := w.client.timestamp(now)
+	startTime := w.client.timestamp(now.Add(-w.lookback))
+	if ok {
+		startTime = watermark + 1
+	}
+	if startTime > endTime {
+		return nil, nil
+	}
+
+	data, err := w.client.ReadDataContext(ctx, key, startTime, endTime, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var points []DataPoint
+	var newest int64
+	for _, record := range data {
+		point, err := w.client.parseMeasurement(record)
+		if err != nil {
+			return nil, err
+		}
+		ts := w.client.timestamp(point.Timestamp)
+		if ts < startTime {
+			continue
+		}
+
+		points = append(points, point)
+		if ts > newest {
+			newest = ts
+		}
+	}
+
+	if len(points) > 0 {
+		if err := w.save(key, newest); err != nil {
+			return nil, fmt.Errorf("save watermark for %s: %w", key, err)
+		}
+	}
+
+	return points, nil
+}
@@ -0,0 +1,97 @@
+package gtsdb
+
+import (
+	"testing"
+	"time"
+)
+
+// memoryWatermarks keeps the watermarks of a WatermarkReader in a map
+type memoryWatermarks map[string]int64
+
+func (m memoryWatermarks) load(key string) (int64, bool, error) {
+	watermark, ok := m[key]
+	return watermark, ok, nil
+}
+
+func (m memoryWatermarks) save(key string, watermark int64) error {
+	m[key] = watermark
+	return nil
+}
+
+func TestWatermarkReader(t *testing.T) {
+	server := newFakeServer(t)
+	client := server.client(t)
+	watermarks := memoryWatermarks{}
+	reader := NewWatermarkReader(client, watermarks.load, watermarks.save, time.Hour)
+
+	now := time.Now().Add(-time.Minute).Unix()
+	// Beyond the lookback, so the first sync doesn't see it
+	server.write("temp", now-2*3600, 0)
+	server.write("temp", now-10, 1)
+	server.write("temp", now, 2)
+
+	points, err := reader.ReadSince("temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || points[0].Value != 1 || points[1].Value != 2 {
+		t.Fatalf("first sync got %+v, want the points at %d and %d", points, now-10, now)
+	}
+	if watermarks["temp"] != now {
+		t.Fatalf("watermark is %d, want %d", watermarks["temp"], now)
+	}
+
+	server.write("temp", now+5, 3)
+	server.write("temp", now+10, 4)
+	points, err = reader.ReadSince("temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || points[0].Value != 3 || points[1].Value != 4 {
+		t.Fatalf("second sync got %+v, want only the points at %d and %d", points, now+5, now+10)
+	}
+	if watermarks["temp"] != now+10 {
+		t.Fatalf("watermark is %d, want %d", watermarks["temp"], now+10)
+	}
+
+	// Nothing new leaves the watermark where it was
+	points, err = reader.ReadSince("temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 0 || watermarks["temp"] != now+10 {
+		t.Fatalf("third sync got %+v and watermark %d, want nothing at %d", points, watermarks["temp"], now+10)
+	}
+}
+
+func TestWatermarkReaderMilliseconds(t *testing.T) {
+	server := newFakeServer(t)
+	client := server.client(t, WithTimestampResolution(Milliseconds))
+	watermarks := memoryWatermarks{}
+	reader := NewWatermarkReader(client, watermarks.load, watermarks.save, time.Hour)
+
+	now := time.Now().Add(-time.Second).UnixMilli()
+	server.write("temp", now, 1)
+	server.write("temp", now+250, 2)
+
+	points, err := reader.ReadSince("temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("got %d points, want 2", len(points))
+	}
+	if watermarks["temp"] != now+250 {
+		t.Fatalf("watermark is %d, want %d", watermarks["temp"], now+250)
+	}
+
+	// A point later within the same second is new
+	server.write("temp", now+500, 3)
+	points, err = reader.ReadSince("temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 || points[0].Value != 3 || !points[0].Timestamp.Equal(time.UnixMilli(now+500)) {
+		t.Fatalf("got %+v, want the point at %d", points, now+500)
+	}
+}